@@ -0,0 +1,231 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/spf13/cobra"
+
+	"istio.io/istio/istioctl/pkg/clioptions"
+	"istio.io/istio/pilot/pkg/xds"
+	"istio.io/istio/pilot/pkg/xds/dryrun"
+	"istio.io/istio/pkg/kube"
+)
+
+var (
+	// Function that posts the candidate configs to each istiod instance; making
+	// it a variable lets us mock the requests
+	dryRunPost = postToAllDiscovery
+)
+
+// postToAllDiscovery posts a body to the debug endpoint of each istiod
+// instance, returning the responses by pod name.
+func postToAllDiscovery(client kube.ExtendedClient, istiodNamespace, path string, params url.Values, body []byte) (map[string][]byte, error) {
+	istiods, err := client.GetIstioPods(context.TODO(), istiodNamespace, map[string]string{
+		"labelSelector": "app=istiod",
+		"fieldSelector": "status.phase=Running",
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(istiods) == 0 {
+		return nil, errors.New("unable to find any Istiod instances")
+	}
+	var errs error
+	result := map[string][]byte{}
+	for _, istiod := range istiods {
+		req := client.Kube().CoreV1().RESTClient().Post().
+			Namespace(istiod.Namespace).
+			Resource("pods").
+			SubResource("proxy").
+			Name(istiod.Name+":15014").
+			Suffix(path).
+			SetHeader("Content-Type", "application/yaml").
+			Body(body)
+		for k, v := range params {
+			for _, value := range v {
+				req = req.Param(k, value)
+			}
+		}
+		res, err := req.DoRaw(context.TODO())
+		if err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("error posting to %s.%s: %v: %s", istiod.Name, istiod.Namespace, err, res))
+			continue
+		}
+		result[istiod.Name] = res
+	}
+	return result, errs
+}
+
+func dryRunCmd() *cobra.Command {
+	var opts clioptions.ControlPlaneOptions
+	var filename, proxyID, proxyNamespace, outputFormat string
+	var maxProxies int
+	cmd := &cobra.Command{
+		Use:   "dryrun",
+		Short: "Shows the proxies whose configuration would change if the given configs were applied",
+		Long: `Sends the configs specified using --filename to each istiod instance, which evaluates them against the
+configuration it currently pushes, without applying them. The clusters, listeners and routes of the proxies
+connected to istiod are generated with and without the configs, and the proxies whose configuration would change
+are listed along with the number of resources added (+), removed (-) and modified (~). Proxies sharing the same
+configuration are evaluated once and listed together.
+
+VirtualServices, DestinationRules, Gateways, Sidecars, EnvoyFilters and security policies can be evaluated.`,
+		Example: `  # show the proxies affected by a VirtualService
+  istioctl experimental dryrun -f reviews-vs.yaml -n bookinfo
+
+  # fail if a DestinationRule changes the configuration of more than 10 proxies
+  istioctl experimental dryrun -f reviews-dr.yaml --max-proxies 10
+
+  # show the changed resources of the proxies of a namespace
+  istioctl experimental dryrun -f reviews-dr.yaml --proxy-namespace bookinfo -o json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if filename == "" {
+				return errors.New("the configs to evaluate must be specified using --filename")
+			}
+			if outputFormat != "short" && outputFormat != "json" {
+				return fmt.Errorf("output format %q not supported", outputFormat)
+			}
+			var body []byte
+			var err error
+			if filename == "-" {
+				body, err = ioutil.ReadAll(cmd.InOrStdin())
+			} else {
+				body, err = ioutil.ReadFile(filename)
+			}
+			if err != nil {
+				return fmt.Errorf("unable to read %s: %v", filename, err)
+			}
+
+			kubeClient, err := kubeClientWithRevision(kubeconfig, configContext, opts.Revision)
+			if err != nil {
+				return err
+			}
+			params := url.Values{}
+			if namespace != "" {
+				params.Set("namespace", namespace)
+			}
+			if proxyID != "" {
+				params.Set("proxyID", proxyID)
+			}
+			if proxyNamespace != "" {
+				params.Set("proxyNamespace", proxyNamespace)
+			}
+			results, err := dryRunPost(kubeClient, istioNamespace, "debug/dryrunz", params, body)
+			if err != nil {
+				return err
+			}
+			merged, err := mergeDryRunResults(results)
+			if err != nil {
+				return err
+			}
+
+			if outputFormat == "json" {
+				out, err := json.MarshalIndent(merged, "", "  ")
+				if err != nil {
+					return err
+				}
+				_, _ = fmt.Fprintln(cmd.OutOrStdout(), string(out))
+			} else {
+				printDryRunResult(cmd.OutOrStdout(), merged)
+			}
+			if changed := changedProxies(merged); maxProxies >= 0 && changed > maxProxies {
+				return fmt.Errorf("the configuration of %d proxies would change, more than the maximum of %d",
+					changed, maxProxies)
+			}
+			return nil
+		},
+	}
+	opts.AttachControlPlaneFlags(cmd)
+	cmd.PersistentFlags().StringVarP(&filename, "filename", "f", "",
+		"The configs to evaluate, or - to read from stdin")
+	cmd.PersistentFlags().StringVar(&proxyID, "proxy", "",
+		"Only evaluate the proxy with this ID (name.namespace), or the proxies with this name")
+	cmd.PersistentFlags().StringVar(&proxyNamespace, "proxy-namespace", "",
+		"Only evaluate the proxies of this namespace")
+	cmd.PersistentFlags().IntVar(&maxProxies, "max-proxies", -1,
+		"Fail if the configuration of more than this number of proxies would change; negative values disable the check")
+	cmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "short",
+		"Output format: one of json|short")
+	return cmd
+}
+
+// mergeDryRunResults merges the results of the istiod instances, each of which
+// evaluates the proxies connected to it.
+func mergeDryRunResults(results map[string][]byte) (*xds.DryRunResponse, error) {
+	pods := make([]string, 0, len(results))
+	for pod := range results {
+		pods = append(pods, pod)
+	}
+	sort.Strings(pods)
+	merged := &xds.DryRunResponse{Changes: []xds.ProxyConfigDiff{}}
+	for _, pod := range pods {
+		result := &xds.DryRunResponse{}
+		if err := json.Unmarshal(results[pod], result); err != nil {
+			return nil, fmt.Errorf("unable to parse dry run result from %s: %v: %s", pod, err, results[pod])
+		}
+		merged.Configs = result.Configs
+		merged.Proxies += result.Proxies
+		merged.Changes = append(merged.Changes, result.Changes...)
+	}
+	sort.Slice(merged.Changes, func(i, j int) bool { return merged.Changes[i].Proxies[0] < merged.Changes[j].Proxies[0] })
+	return merged, nil
+}
+
+// changedProxies returns the number of proxies whose configuration would change.
+func changedProxies(result *xds.DryRunResponse) int {
+	changed := 0
+	for _, change := range result.Changes {
+		changed += len(change.Proxies)
+	}
+	return changed
+}
+
+func printDryRunResult(writer io.Writer, result *xds.DryRunResponse) {
+	if len(result.Changes) == 0 {
+		_, _ = fmt.Fprintf(writer, "No changes to the configuration of %d proxies\n", result.Proxies)
+		return
+	}
+	w := new(tabwriter.Writer).Init(writer, 0, 8, 3, ' ', 0)
+	_, _ = fmt.Fprintln(w, "PROXY\tSIDECAR SCOPE\tCLUSTERS\tLISTENERS\tROUTES")
+	for _, change := range result.Changes {
+		proxy := change.Proxies[0]
+		if len(change.Proxies) > 1 {
+			proxy = fmt.Sprintf("%s (+%d more)", proxy, len(change.Proxies)-1)
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", proxy, valueOrDefault(change.SidecarScope, "-"),
+			formatResourceDiff(change.Clusters), formatResourceDiff(change.Listeners), formatResourceDiff(change.Routes))
+	}
+	_ = w.Flush()
+	_, _ = fmt.Fprintf(writer, "\nThe configuration of %d of %d proxies would change\n", changedProxies(result), result.Proxies)
+}
+
+func formatResourceDiff(diff *dryrun.ResourceDiff) string {
+	if diff == nil {
+		return "-"
+	}
+	return fmt.Sprintf("+%d -%d ~%d", len(diff.Added), len(diff.Removed), len(diff.Modified))
+}
@@ -0,0 +1,142 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+
+	"istio.io/istio/pilot/pkg/xds"
+	"istio.io/istio/pilot/pkg/xds/dryrun"
+	"istio.io/istio/pkg/kube"
+)
+
+func newDryRunTestResult(t *testing.T, proxies int, changes ...xds.ProxyConfigDiff) []byte {
+	t.Helper()
+	out, err := json.Marshal(&xds.DryRunResponse{
+		Configs: []string{"DestinationRule/bookinfo/reviews"},
+		Proxies: proxies,
+		Changes: changes,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestDryRun(t *testing.T) {
+	kubeClientWithRevision = func(_, _, _ string) (kube.ExtendedClient, error) {
+		return &kube.MockClient{}, nil
+	}
+	results := map[string][]byte{
+		"istiod-1": newDryRunTestResult(t, 3, xds.ProxyConfigDiff{
+			Proxies:      []string{"reviews-v1-a.bookinfo", "reviews-v1-b.bookinfo"},
+			SidecarScope: "bookinfo/default",
+			ProxyConfigDiff: dryrun.ProxyConfigDiff{
+				Clusters: &dryrun.ResourceDiff{Added: []string{"outbound|9080|v1|reviews.bookinfo.svc.cluster.local"}},
+				Routes:   &dryrun.ResourceDiff{Modified: []string{"9080"}},
+			},
+		}),
+		"istiod-2": newDryRunTestResult(t, 1, xds.ProxyConfigDiff{
+			Proxies: []string{"productpage-v1.bookinfo"},
+			ProxyConfigDiff: dryrun.ProxyConfigDiff{
+				Clusters: &dryrun.ResourceDiff{Added: []string{"outbound|9080|v1|reviews.bookinfo.svc.cluster.local"}},
+			},
+		}),
+	}
+	var params url.Values
+	dryRunPost = func(_ kube.ExtendedClient, _, _ string, p url.Values, _ []byte) (map[string][]byte, error) {
+		params = p
+		return results, nil
+	}
+	defer func() {
+		dryRunPost = postToAllDiscovery
+	}()
+
+	configFile := writeFederationTestFile(t, `apiVersion: networking.istio.io/v1alpha3
+kind: DestinationRule
+metadata:
+  name: reviews
+spec:
+  host: reviews
+  subsets:
+  - name: v1
+    labels:
+      version: v1
+`)
+
+	cases := []struct {
+		name           string
+		args           string
+		expectedOutput string
+		expectedParams url.Values
+		expectedError  string
+	}{
+		{
+			name: "changes",
+			args: "x dryrun -n bookinfo -f " + configFile,
+			expectedOutput: `PROXY                             SIDECAR SCOPE      CLUSTERS   LISTENERS   ROUTES
+productpage-v1.bookinfo           -                  +1 -0 ~0   -           -
+reviews-v1-a.bookinfo (+1 more)   bookinfo/default   +1 -0 ~0   -           +0 -0 ~1
+
+The configuration of 3 of 4 proxies would change
+`,
+			expectedParams: url.Values{"namespace": []string{"bookinfo"}},
+		},
+		{
+			name:           "proxy filter",
+			args:           "x dryrun -f " + configFile + " --proxy reviews --proxy-namespace bookinfo --max-proxies 3",
+			expectedParams: url.Values{"proxyID": []string{"reviews"}, "proxyNamespace": []string{"bookinfo"}},
+		},
+		{
+			name:          "too many proxies",
+			args:          "x dryrun -f " + configFile + " --max-proxies 2",
+			expectedError: "the configuration of 3 proxies would change, more than the maximum of 2",
+		},
+		{
+			name:          "no file",
+			args:          "x dryrun",
+			expectedError: "the configs to evaluate must be specified using --filename",
+		},
+		{
+			name:          "unknown output format",
+			args:          "x dryrun -f " + configFile + " -o yaml",
+			expectedError: `output format "yaml" not supported`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			params = nil
+			out, err := runTestCmd(t, strings.Split(tc.args, " "))
+			if tc.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tc.expectedOutput != "" && out != tc.expectedOutput {
+				t.Errorf("unexpected output\n got: %q\nwant: %q", out, tc.expectedOutput)
+			}
+			if params.Encode() != tc.expectedParams.Encode() {
+				t.Errorf("unexpected params: got %v, want %v", params, tc.expectedParams)
+			}
+		})
+	}
+}
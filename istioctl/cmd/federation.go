@@ -0,0 +1,530 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	maistraclient "maistra.io/api/client/versioned"
+	v1 "maistra.io/api/federation/v1"
+	"sigs.k8s.io/yaml"
+
+	"istio.io/istio/istioctl/pkg/clioptions"
+	"istio.io/istio/istioctl/pkg/util/handlers"
+	federationregistry "istio.io/istio/pilot/pkg/serviceregistry/federation"
+	kube_registry "istio.io/istio/pilot/pkg/serviceregistry/kube"
+	"istio.io/istio/pkg/config/constants"
+	"istio.io/istio/pkg/kube"
+	federationmodel "istio.io/istio/pkg/servicemesh/federation/model"
+	federationserver "istio.io/istio/pkg/servicemesh/federation/server"
+)
+
+var (
+	// Function that creates the client for federation resources; making it a
+	// variable lets us mock the client
+	federationClientFactory = createFederationClient
+)
+
+func createFederationClient(kubeconfig, configContext string) (maistraclient.Interface, error) {
+	restConfig, err := kube.BuildClientConfig(kubeconfig, configContext)
+	if err != nil {
+		return nil, err
+	}
+	return maistraclient.NewForConfig(restConfig)
+}
+
+func federationCmd() *cobra.Command {
+	var opts clioptions.ControlPlaneOptions
+	federationCmd := &cobra.Command{
+		Use:     "federation",
+		Aliases: []string{"fed"},
+		Short:   "Commands to inspect and debug federation with other meshes",
+		Example: `  # list the peers of this mesh and the state of their connections
+  istioctl experimental federation peers -n istio-system
+
+  # show the services exported to a peer
+  istioctl experimental federation exports other-mesh.istio-system
+
+  # show the services imported from a peer if the ImportedServiceSet in imports.yaml were applied
+  istioctl experimental federation imports other-mesh.istio-system --dry-run -f imports.yaml
+
+  # show the services advertised by a peer that have not been imported
+  istioctl experimental federation diff other-mesh.istio-system`,
+	}
+	opts.AttachControlPlaneFlags(federationCmd)
+	federationCmd.AddCommand(federationPeersCmd())
+	federationCmd.AddCommand(federationExportsCmd(&opts))
+	federationCmd.AddCommand(federationImportsCmd(&opts))
+	federationCmd.AddCommand(federationDiffCmd(&opts))
+	return federationCmd
+}
+
+func federationPeersCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "peers",
+		Short: "Lists the peers of the mesh and the state of their connections",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := federationClientFactory(kubeconfig, configContext)
+			if err != nil {
+				return err
+			}
+			ns := handlers.HandleNamespace(namespace, defaultNamespace)
+			peers, err := client.FederationV1().ServiceMeshPeers(ns).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				return fmt.Errorf("unable to list ServiceMeshPeers in namespace %s: %v", ns, err)
+			}
+			if len(peers.Items) == 0 {
+				_, _ = fmt.Fprintf(cmd.OutOrStdout(), "No ServiceMeshPeers found in namespace %s\n", ns)
+				return nil
+			}
+			sort.Slice(peers.Items, func(i, j int) bool { return peers.Items[i].Name < peers.Items[j].Name })
+			printPeers(cmd.OutOrStdout(), peers.Items)
+			return nil
+		},
+	}
+}
+
+func printPeers(writer io.Writer, peers []v1.ServiceMeshPeer) {
+	w := new(tabwriter.Writer).Init(writer, 0, 8, 3, ' ', 0)
+	_, _ = fmt.Fprintln(w, "NAME\tWATCHING\tLAST CONNECTED\tSTATUS")
+	for _, peer := range peers {
+		discoveryStatus := peer.Status.DiscoveryStatus
+		var lastConnected time.Time
+		status := "Disconnected"
+		var lastDisconnect time.Time
+		for _, pod := range append(append([]v1.PodPeerDiscoveryStatus{}, discoveryStatus.Active...), discoveryStatus.Inactive...) {
+			if pod.Watch.LastConnected.Time.After(lastConnected) {
+				lastConnected = pod.Watch.LastConnected.Time
+			}
+		}
+		if len(discoveryStatus.Active) > 0 {
+			status = "Connected"
+		} else {
+			for _, pod := range discoveryStatus.Inactive {
+				if pod.Watch.LastDisconnectStatus != "" && !pod.Watch.LastDisconnect.Time.Before(lastDisconnect) {
+					lastDisconnect = pod.Watch.LastDisconnect.Time
+					status = pod.Watch.LastDisconnectStatus
+				}
+			}
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%d/%d\t%s\t%s\n", peer.Name,
+			len(discoveryStatus.Active), len(discoveryStatus.Active)+len(discoveryStatus.Inactive),
+			formatFederationTime(lastConnected), status)
+	}
+	_ = w.Flush()
+}
+
+func formatFederationTime(t time.Time) string {
+	if t.IsZero() {
+		return "Never"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func valueOrDefault(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// federationMapping is a service exported to or imported from a peer.
+type federationMapping struct {
+	// local is the hostname of the service in this mesh
+	local string
+	// exported is the hostname of the service as seen by the exporting mesh's
+	// peer
+	exported string
+	// principals allowed to call an exported service, nil if unrestricted
+	principals []string
+}
+
+func (m federationMapping) principalsString() string {
+	if m.principals == nil {
+		return "*"
+	}
+	if len(m.principals) == 0 {
+		return "<none>"
+	}
+	return strings.Join(m.principals, ",")
+}
+
+func mappingsFromStatus(status []v1.PeerServiceMapping) []federationMapping {
+	mappings := make([]federationMapping, 0, len(status))
+	for _, mapping := range status {
+		if mapping.LocalService.Hostname == "" {
+			// services that didn't match an import rule are listed without
+			// a local name
+			continue
+		}
+		mappings = append(mappings, federationMapping{local: mapping.LocalService.Hostname, exported: mapping.ExportedName})
+	}
+	return mappings
+}
+
+func printMappings(writer io.Writer, mappings []federationMapping, localHeader, exportedHeader string, withPrincipals bool) {
+	sort.Slice(mappings, func(i, j int) bool { return mappings[i].local < mappings[j].local })
+	w := new(tabwriter.Writer).Init(writer, 0, 8, 3, ' ', 0)
+	if withPrincipals {
+		_, _ = fmt.Fprintf(w, "%s\t%s\tPRINCIPALS\n", localHeader, exportedHeader)
+	} else {
+		_, _ = fmt.Fprintf(w, "%s\t%s\n", localHeader, exportedHeader)
+	}
+	for _, mapping := range mappings {
+		if withPrincipals {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", mapping.local, mapping.exported, mapping.principalsString())
+		} else {
+			_, _ = fmt.Fprintf(w, "%s\t%s\n", mapping.local, mapping.exported)
+		}
+	}
+	_ = w.Flush()
+}
+
+// printMappingChanges prints the differences between the current and the
+// proposed mappings, keyed by keyFn.
+func printMappingChanges(writer io.Writer, current, proposed []federationMapping, keyFn, valueFn func(federationMapping) string) {
+	currentByKey := map[string]federationMapping{}
+	for _, mapping := range current {
+		currentByKey[keyFn(mapping)] = mapping
+	}
+	proposedByKey := map[string]federationMapping{}
+	for _, mapping := range proposed {
+		proposedByKey[keyFn(mapping)] = mapping
+	}
+	var changes []string
+	for key, mapping := range proposedByKey {
+		if existing, ok := currentByKey[key]; !ok {
+			changes = append(changes, fmt.Sprintf("+ %s -> %s", key, valueFn(mapping)))
+		} else if valueFn(existing) != valueFn(mapping) {
+			changes = append(changes, fmt.Sprintf("~ %s -> %s (was %s)", key, valueFn(mapping), valueFn(existing)))
+		}
+	}
+	for key, mapping := range currentByKey {
+		if _, ok := proposedByKey[key]; !ok {
+			changes = append(changes, fmt.Sprintf("- %s -> %s", key, valueFn(mapping)))
+		}
+	}
+	if len(changes) == 0 {
+		_, _ = fmt.Fprintln(writer, "\nNo changes")
+		return
+	}
+	// sort by key, rather than by the change marker
+	sort.Slice(changes, func(i, j int) bool { return changes[i][2:] < changes[j][2:] })
+	_, _ = fmt.Fprintln(writer, "\nChanges:")
+	for _, change := range changes {
+		_, _ = fmt.Fprintln(writer, change)
+	}
+}
+
+// readFederationResource reads the resource to be evaluated from filename,
+// defaulting the name and namespace to those of the peer, which must match.
+func readFederationResource(cmd *cobra.Command, filename string, obj metav1.Object, kind, name, ns string) error {
+	if filename == "" {
+		return fmt.Errorf("--dry-run requires the %s to be specified using --filename", kind)
+	}
+	var data []byte
+	var err error
+	if filename == "-" {
+		data, err = ioutil.ReadAll(cmd.InOrStdin())
+	} else {
+		data, err = ioutil.ReadFile(filename)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %v", filename, err)
+	}
+	if err := yaml.UnmarshalStrict(data, obj); err != nil {
+		return fmt.Errorf("unable to parse %s from %s: %v", kind, filename, err)
+	}
+	if obj.GetName() == "" {
+		obj.SetName(name)
+	}
+	if obj.GetNamespace() == "" {
+		obj.SetNamespace(ns)
+	}
+	if obj.GetName() != name || obj.GetNamespace() != ns {
+		return fmt.Errorf("%s %s/%s does not apply to ServiceMeshPeer %s/%s: the names must match",
+			kind, obj.GetNamespace(), obj.GetName(), ns, name)
+	}
+	return nil
+}
+
+func attachFederationDryRunFlags(cmd *cobra.Command, dryRun *bool, filename *string, kind string) {
+	cmd.PersistentFlags().BoolVar(dryRun, "dry-run", false,
+		fmt.Sprintf("Show the effect of applying the %s specified using --filename, without applying it", kind))
+	cmd.PersistentFlags().StringVarP(filename, "filename", "f", "",
+		fmt.Sprintf("The %s to evaluate with --dry-run, or - to read from stdin", kind))
+}
+
+func federationExportsCmd(opts *clioptions.ControlPlaneOptions) *cobra.Command {
+	var dryRun bool
+	var filename string
+	cmd := &cobra.Command{
+		Use:   "exports <peer>[.<namespace>]",
+		Short: "Shows the services exported to a peer",
+		Long: `Shows the services exported to a peer, as recorded in the status of its ExportedServiceSet.
+With --dry-run, the ExportedServiceSet specified using --filename is evaluated against the services
+in the cluster, showing the services that would be exported and how they would be named.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, ns := handlers.InferPodInfo(args[0], handlers.HandleNamespace(namespace, defaultNamespace))
+			client, err := federationClientFactory(kubeconfig, configContext)
+			if err != nil {
+				return err
+			}
+			var current []federationMapping
+			exports, err := client.FederationV1().ExportedServiceSets(ns).Get(context.TODO(), name, metav1.GetOptions{})
+			if err == nil {
+				current = mappingsFromStatus(exports.Status.ExportedServices)
+			} else if !dryRun {
+				return fmt.Errorf("unable to retrieve ExportedServiceSet %s/%s: %v", ns, name, err)
+			}
+			if !dryRun {
+				printMappings(cmd.OutOrStdout(), current, "SERVICE", "EXPORTED AS", false)
+				return nil
+			}
+
+			proposed := &v1.ExportedServiceSet{}
+			if err := readFederationResource(cmd, filename, proposed, "ExportedServiceSet", name, ns); err != nil {
+				return err
+			}
+			peer, err := client.FederationV1().ServiceMeshPeers(ns).Get(context.TODO(), name, metav1.GetOptions{})
+			if err != nil {
+				return fmt.Errorf("unable to retrieve ServiceMeshPeer %s/%s: %v", ns, name, err)
+			}
+			status, err := getPeerImportStatus(opts, name, ns)
+			if err != nil {
+				return err
+			}
+			domainSuffix := meshDomainSuffix(status)
+			kubeClient, err := kubeClientWithRevision(kubeconfig, configContext, opts.Revision)
+			if err != nil {
+				return err
+			}
+			services, err := kubeClient.Kube().CoreV1().Services(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				return fmt.Errorf("unable to list services: %v", err)
+			}
+			exporter := federationserver.NewServiceExporter(name, proposed)
+			ingressService := federationserver.IngressServiceName(peer, domainSuffix)
+			var mappings []federationMapping
+			for _, svc := range services.Items {
+				service := kube_registry.ConvertService(svc, domainSuffix, "")
+				if !federationserver.IsExportable(service, ingressService) {
+					continue
+				}
+				exportedName, principals := exporter.ExportForService(service)
+				if exportedName == nil {
+					continue
+				}
+				mappings = append(mappings, federationMapping{
+					local:      string(service.Hostname),
+					exported:   exportedName.Hostname,
+					principals: principals,
+				})
+			}
+			printMappings(cmd.OutOrStdout(), mappings, "SERVICE", "EXPORTED AS", true)
+			printMappingChanges(cmd.OutOrStdout(), current, mappings,
+				func(m federationMapping) string { return m.local },
+				func(m federationMapping) string { return m.exported })
+			return nil
+		},
+	}
+	attachFederationDryRunFlags(cmd, &dryRun, &filename, "ExportedServiceSet")
+	return cmd
+}
+
+func federationImportsCmd(opts *clioptions.ControlPlaneOptions) *cobra.Command {
+	var dryRun bool
+	var filename string
+	cmd := &cobra.Command{
+		Use:   "imports <peer>[.<namespace>]",
+		Short: "Shows the services imported from a peer",
+		Long: `Shows the services imported from a peer, as recorded in the status of its ImportedServiceSet.
+With --dry-run, the ImportedServiceSet specified using --filename is evaluated against the services
+currently advertised by the peer, showing the services that would be imported and how they would be named.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, ns := handlers.InferPodInfo(args[0], handlers.HandleNamespace(namespace, defaultNamespace))
+			if !dryRun {
+				client, err := federationClientFactory(kubeconfig, configContext)
+				if err != nil {
+					return err
+				}
+				imports, err := client.FederationV1().ImportedServiceSets(ns).Get(context.TODO(), name, metav1.GetOptions{})
+				if err != nil {
+					return fmt.Errorf("unable to retrieve ImportedServiceSet %s/%s: %v", ns, name, err)
+				}
+				printMappings(cmd.OutOrStdout(), mappingsFromStatus(imports.Status.ImportedServices), "SERVICE", "IMPORTED FROM", false)
+				return nil
+			}
+
+			proposed := &v1.ImportedServiceSet{}
+			if err := readFederationResource(cmd, filename, proposed, "ImportedServiceSet", name, ns); err != nil {
+				return err
+			}
+			client, err := federationClientFactory(kubeconfig, configContext)
+			if err != nil {
+				return err
+			}
+			peer, err := client.FederationV1().ServiceMeshPeers(ns).Get(context.TODO(), name, metav1.GetOptions{})
+			if err != nil {
+				return fmt.Errorf("unable to retrieve ServiceMeshPeer %s/%s: %v", ns, name, err)
+			}
+			status, err := getPeerImportStatus(opts, name, ns)
+			if err != nil {
+				return err
+			}
+			importer := federationregistry.NewServiceImporter(peer, proposed, meshDomainSuffix(status))
+			current := make([]federationMapping, 0, len(status.Imported))
+			for exported, local := range status.Imported {
+				current = append(current, federationMapping{local: local, exported: exported})
+			}
+			var mappings []federationMapping
+			for _, svc := range status.Advertised {
+				importedName := federationregistry.ImportNameForService(importer, svc)
+				if importedName == nil {
+					continue
+				}
+				mappings = append(mappings, federationMapping{local: importedName.Hostname, exported: svc.Hostname})
+			}
+			printMappings(cmd.OutOrStdout(), mappings, "SERVICE", "IMPORTED FROM", false)
+			printMappingChanges(cmd.OutOrStdout(), current, mappings,
+				func(m federationMapping) string { return m.exported },
+				func(m federationMapping) string { return m.local })
+			return nil
+		},
+	}
+	attachFederationDryRunFlags(cmd, &dryRun, &filename, "ImportedServiceSet")
+	return cmd
+}
+
+func federationDiffCmd(opts *clioptions.ControlPlaneOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "diff <peer>[.<namespace>]",
+		Short: "Compares the services advertised by a peer with the services imported from it",
+		Long: `Compares the services most recently advertised by a peer with the services imported from it,
+listing advertised services that were not imported, e.g. because they do not match any rule in the
+ImportedServiceSet, and services that are still imported, but are no longer advertised.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, ns := handlers.InferPodInfo(args[0], handlers.HandleNamespace(namespace, defaultNamespace))
+			status, err := getPeerImportStatus(opts, name, ns)
+			if err != nil {
+				return err
+			}
+			client, err := federationClientFactory(kubeconfig, configContext)
+			if err != nil {
+				return err
+			}
+			var recorded []v1.PeerServiceMapping
+			imports, err := client.FederationV1().ImportedServiceSets(ns).Get(context.TODO(), name, metav1.GetOptions{})
+			if err == nil {
+				recorded = imports.Status.ImportedServices
+			}
+			printImportDiff(cmd.OutOrStdout(), status, recorded)
+			return nil
+		},
+	}
+}
+
+// printImportDiff prints the services advertised by the peer along with the
+// name under which each was imported, followed by the services recorded as
+// imported in the ImportedServiceSet status, but no longer advertised.
+func printImportDiff(writer io.Writer, status *federationmodel.PeerImportStatus, recorded []v1.PeerServiceMapping) {
+	advertised := map[string]bool{}
+	w := new(tabwriter.Writer).Init(writer, 0, 8, 3, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ADVERTISED\tIMPORTED AS")
+	services := append([]*federationmodel.ServiceMessage{}, status.Advertised...)
+	sort.Slice(services, func(i, j int) bool { return services[i].Hostname < services[j].Hostname })
+	notImported := 0
+	for _, svc := range services {
+		advertised[svc.Hostname] = true
+		local, ok := status.Imported[svc.Hostname]
+		if !ok {
+			local = "<not imported>"
+			notImported++
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\n", svc.Hostname, local)
+	}
+	_ = w.Flush()
+
+	var stale []string
+	for _, mapping := range recorded {
+		if mapping.LocalService.Hostname != "" && !advertised[mapping.ExportedName] {
+			stale = append(stale, fmt.Sprintf("%s (imported as %s)", mapping.ExportedName, mapping.LocalService.Hostname))
+		}
+	}
+	sort.Strings(stale)
+	_, _ = fmt.Fprintf(writer, "\n%d of %d advertised services not imported\n", notImported, len(services))
+	if len(stale) > 0 {
+		_, _ = fmt.Fprintln(writer, "Imported, but no longer advertised:")
+		for _, s := range stale {
+			_, _ = fmt.Fprintf(writer, "  %s\n", s)
+		}
+	}
+}
+
+// meshDomainSuffix returns the domain suffix of this mesh, as reported by
+// istiod along with the status of a peer.
+func meshDomainSuffix(status *federationmodel.PeerImportStatus) string {
+	if status.DomainSuffix == "" {
+		// reported by an older istiod
+		return constants.DefaultKubernetesDomain
+	}
+	return status.DomainSuffix
+}
+
+// getPeerImportStatus retrieves the services discovered from the peer from
+// istiod.  If multiple istiod instances are running, the status reported by
+// the first instance that knows about the peer is used.
+func getPeerImportStatus(opts *clioptions.ControlPlaneOptions, name, ns string) (*federationmodel.PeerImportStatus, error) {
+	kubeClient, err := kubeClientWithRevision(kubeconfig, configContext, opts.Revision)
+	if err != nil {
+		return nil, err
+	}
+	peer := ns + "/" + name
+	results, err := kubeClient.AllDiscoveryDo(context.TODO(), istioNamespace, "/debug/federationz?peer="+peer)
+	if err != nil {
+		return nil, err
+	}
+	pods := make([]string, 0, len(results))
+	for pod := range results {
+		pods = append(pods, pod)
+	}
+	sort.Strings(pods)
+	for _, pod := range pods {
+		var statuses []*federationmodel.PeerImportStatus
+		if err := json.Unmarshal(results[pod], &statuses); err != nil {
+			return nil, fmt.Errorf("unable to parse federation status from %s: %v", pod, err)
+		}
+		for _, status := range statuses {
+			if status.Peer == peer {
+				return status, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no istiod instance reports services discovered from ServiceMeshPeer %s", peer)
+}
@@ -0,0 +1,286 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	maistraclient "maistra.io/api/client/versioned"
+	maistrafake "maistra.io/api/client/versioned/fake"
+	v1 "maistra.io/api/federation/v1"
+
+	"istio.io/istio/pkg/kube"
+	federationmodel "istio.io/istio/pkg/servicemesh/federation/model"
+)
+
+var federationTestTime = metav1.NewTime(time.Date(2021, 7, 1, 12, 0, 0, 0, time.UTC))
+
+func newFederationTestPeers() []runtime.Object {
+	return []runtime.Object{
+		&v1.ServiceMeshPeer{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "mesh-a",
+				Namespace: "istio-system",
+			},
+			Status: v1.ServiceMeshPeerStatus{
+				DiscoveryStatus: v1.ServiceMeshPeerDiscoveryStatus{
+					Active: []v1.PodPeerDiscoveryStatus{{
+						Pod: "istiod-1",
+						PeerDiscoveryStatus: v1.PeerDiscoveryStatus{Watch: v1.DiscoveryWatchStatus{
+							DiscoveryConnectionStatus: v1.DiscoveryConnectionStatus{Connected: true, LastConnected: federationTestTime},
+						}},
+					}},
+					Inactive: []v1.PodPeerDiscoveryStatus{{Pod: "istiod-2"}},
+				},
+			},
+		},
+		&v1.ServiceMeshPeer{
+			ObjectMeta: metav1.ObjectMeta{Name: "mesh-b", Namespace: "istio-system"},
+			Status: v1.ServiceMeshPeerStatus{
+				DiscoveryStatus: v1.ServiceMeshPeerDiscoveryStatus{
+					Inactive: []v1.PodPeerDiscoveryStatus{{
+						Pod: "istiod-1",
+						PeerDiscoveryStatus: v1.PeerDiscoveryStatus{Watch: v1.DiscoveryWatchStatus{
+							DiscoveryConnectionStatus: v1.DiscoveryConnectionStatus{
+								LastDisconnect:       federationTestTime,
+								LastDisconnectStatus: "connection refused",
+							},
+						}},
+					}},
+				},
+			},
+		},
+		&v1.ExportedServiceSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "mesh-a", Namespace: "istio-system"},
+			Status: v1.ExportedServiceSetStatus{
+				ExportedServices: []v1.PeerServiceMapping{{
+					LocalService: v1.ServiceKey{Name: "ratings", Namespace: "bookinfo", Hostname: "ratings.bookinfo.svc.cluster.local"},
+					ExportedName: "ratings.bookinfo.svc.mesh-a-exports.local",
+				}},
+			},
+		},
+		&v1.ImportedServiceSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "mesh-a", Namespace: "istio-system"},
+			Status: v1.ImportedServiceSetStatus{
+				ImportedServices: []v1.PeerServiceMapping{
+					{
+						LocalService: v1.ServiceKey{Name: "reviews", Namespace: "bookinfo", Hostname: "reviews.bookinfo.svc.mesh-a-imports.local"},
+						ExportedName: "reviews.bookinfo.svc.local-exports.local",
+					},
+					{
+						LocalService: v1.ServiceKey{Name: "details", Namespace: "bookinfo", Hostname: "details.bookinfo.svc.mesh-a-imports.local"},
+						ExportedName: "details.bookinfo.svc.local-exports.local",
+					},
+				},
+			},
+		},
+	}
+}
+
+func newFederationTestImportStatus(t *testing.T) []byte {
+	t.Helper()
+	out, err := json.Marshal([]*federationmodel.PeerImportStatus{{
+		Peer:         "istio-system/mesh-a",
+		DomainSuffix: "cluster.local",
+		Advertised: []*federationmodel.ServiceMessage{
+			{ServiceKey: federationmodel.ServiceKey{Name: "reviews", Namespace: "bookinfo", Hostname: "reviews.bookinfo.svc.local-exports.local"}},
+			{ServiceKey: federationmodel.ServiceKey{Name: "productpage", Namespace: "bookinfo", Hostname: "productpage.bookinfo.svc.local-exports.local"}},
+		},
+		Imported: map[string]string{
+			"reviews.bookinfo.svc.local-exports.local": "reviews.bookinfo.svc.mesh-a-imports.local",
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func writeFederationTestFile(t *testing.T, contents string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "resource.yaml")
+	if err := ioutil.WriteFile(file, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestFederation(t *testing.T) {
+	federationClientFactory = func(_, _ string) (maistraclient.Interface, error) {
+		return maistrafake.NewSimpleClientset(newFederationTestPeers()...), nil
+	}
+	importStatus := newFederationTestImportStatus(t)
+	kubeClientWithRevision = func(_, _, _ string) (kube.ExtendedClient, error) {
+		return &kube.MockClient{
+			Interface: fake.NewSimpleClientset(
+				&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "ratings", Namespace: "bookinfo"}},
+				&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "details", Namespace: "bookinfo", Labels: map[string]string{"export": "true"}}},
+				// external services are never exported
+				&corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{Name: "payments", Namespace: "bookinfo", Labels: map[string]string{"export": "true"}},
+					Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeExternalName, ExternalName: "payments.example.com"},
+				},
+			),
+			Results: map[string][]byte{"istiod-1": importStatus},
+		}, nil
+	}
+
+	exportsFile := writeFederationTestFile(t, `apiVersion: federation.maistra.io/v1
+kind: ExportedServiceSet
+metadata:
+  name: mesh-a
+  namespace: istio-system
+spec:
+  exportRules:
+  - type: LabelSelector
+    labelSelector:
+      namespace: bookinfo
+      selector:
+        matchLabels:
+          export: "true"
+`)
+	importsFile := writeFederationTestFile(t, `apiVersion: federation.maistra.io/v1
+kind: ImportedServiceSet
+metadata:
+  name: mesh-a
+spec:
+  importRules:
+  - type: NameSelector
+    nameSelector:
+      namespace: bookinfo
+      name: productpage
+`)
+	wrongPeerFile := writeFederationTestFile(t, `apiVersion: federation.maistra.io/v1
+kind: ImportedServiceSet
+metadata:
+  name: mesh-b
+`)
+
+	cases := []struct {
+		name           string
+		args           string
+		expectedOutput string
+		expectedError  string
+	}{
+		{
+			name: "peers",
+			args: "x federation peers -n istio-system",
+			expectedOutput: `NAME     WATCHING   LAST CONNECTED         STATUS
+mesh-a   1/2        2021-07-01T12:00:00Z   Connected
+mesh-b   0/1        Never                  connection refused
+`,
+		},
+		{
+			name:           "no peers",
+			args:           "x fed peers -n other",
+			expectedOutput: "No ServiceMeshPeers found in namespace other\n",
+		},
+		{
+			name: "exports",
+			args: "x federation exports mesh-a.istio-system",
+			expectedOutput: `SERVICE                              EXPORTED AS
+ratings.bookinfo.svc.cluster.local   ratings.bookinfo.svc.mesh-a-exports.local
+`,
+		},
+		{
+			name: "exports dry run",
+			args: "x federation exports mesh-a -n istio-system --dry-run -f " + exportsFile,
+			expectedOutput: `SERVICE                              EXPORTED AS                                 PRINCIPALS
+details.bookinfo.svc.cluster.local   details.bookinfo.svc.mesh-a-exports.local   *
+
+Changes:
++ details.bookinfo.svc.cluster.local -> details.bookinfo.svc.mesh-a-exports.local
+- ratings.bookinfo.svc.cluster.local -> ratings.bookinfo.svc.mesh-a-exports.local
+`,
+		},
+		{
+			name:          "exports dry run without file",
+			args:          "x federation exports mesh-a.istio-system --dry-run",
+			expectedError: "--dry-run requires the ExportedServiceSet to be specified using --filename",
+		},
+		{
+			name:          "exports missing",
+			args:          "x federation exports mesh-b.istio-system",
+			expectedError: "unable to retrieve ExportedServiceSet istio-system/mesh-b",
+		},
+		{
+			name: "imports",
+			args: "x federation imports mesh-a.istio-system",
+			expectedOutput: `SERVICE                                     IMPORTED FROM
+details.bookinfo.svc.mesh-a-imports.local   details.bookinfo.svc.local-exports.local
+reviews.bookinfo.svc.mesh-a-imports.local   reviews.bookinfo.svc.local-exports.local
+`,
+		},
+		{
+			name: "imports dry run",
+			args: "x federation imports mesh-a.istio-system --dry-run -f " + importsFile,
+			expectedOutput: `SERVICE                                         IMPORTED FROM
+productpage.bookinfo.svc.mesh-a-imports.local   productpage.bookinfo.svc.local-exports.local
+
+Changes:
++ productpage.bookinfo.svc.local-exports.local -> productpage.bookinfo.svc.mesh-a-imports.local
+- reviews.bookinfo.svc.local-exports.local -> reviews.bookinfo.svc.mesh-a-imports.local
+`,
+		},
+		{
+			name:          "imports dry run for other peer",
+			args:          "x federation imports mesh-a.istio-system --dry-run -f " + wrongPeerFile,
+			expectedError: "ImportedServiceSet istio-system/mesh-b does not apply to ServiceMeshPeer istio-system/mesh-a",
+		},
+		{
+			name: "diff",
+			args: "x federation diff mesh-a.istio-system",
+			expectedOutput: `ADVERTISED                                     IMPORTED AS
+productpage.bookinfo.svc.local-exports.local   <not imported>
+reviews.bookinfo.svc.local-exports.local       reviews.bookinfo.svc.mesh-a-imports.local
+
+1 of 2 advertised services not imported
+Imported, but no longer advertised:
+  details.bookinfo.svc.local-exports.local (imported as details.bookinfo.svc.mesh-a-imports.local)
+`,
+		},
+		{
+			name:          "diff unknown peer",
+			args:          "x federation diff mesh-b.istio-system",
+			expectedError: "no istiod instance reports services discovered from ServiceMeshPeer istio-system/mesh-b",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := runTestCmd(t, strings.Split(tc.args, " "))
+			if tc.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if out != tc.expectedOutput {
+				t.Errorf("unexpected output\n got: %q\nwant: %q", out, tc.expectedOutput)
+			}
+		})
+	}
+}
@@ -15,6 +15,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -23,21 +24,30 @@ import (
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 	v1 "maistra.io/api/core/v1"
 
+	"istio.io/istio/mec/pkg/model"
+	"istio.io/istio/mec/pkg/pullstrategy/oci"
 	"istio.io/istio/mec/pkg/pullstrategy/ossm"
 	"istio.io/istio/mec/pkg/server"
+	"istio.io/istio/mec/pkg/signature"
+	"istio.io/istio/pilot/pkg/leaderelection"
 	"istio.io/istio/pkg/cmd"
 	"istio.io/istio/pkg/kube"
+	"istio.io/istio/pkg/security"
 	memberroll "istio.io/istio/pkg/servicemesh/controller"
 	"istio.io/istio/pkg/servicemesh/controller/extension"
+	"istio.io/istio/pkg/spiffe"
+	caclient "istio.io/istio/security/pkg/nodeagent/caclient/providers/citadel"
 	"istio.io/pkg/filewatcher"
 	"istio.io/pkg/log"
 )
 
 const (
-	defaultResyncPeriod = time.Minute * 5
+	defaultResyncPeriod   = time.Minute * 5
+	defaultCertificateTTL = time.Hour * 24
 )
 
 var (
@@ -47,6 +57,22 @@ var (
 	registryURL    string
 	resyncPeriod   string
 	namespace      string
+	pullStrategy   string
+	cacheDirectory string
+	publicKeys     string
+	podName        string
+
+	leaderElection bool
+
+	serveTLS       bool
+	caAddress      string
+	caRootCert     string
+	caTokenPath    string
+	clusterID      string
+	trustDomain    string
+	serviceAccount string
+
+	insecureRegistries []string
 
 	mainlog        = log.RegisterScope("main", "Main function", 0)
 	loggingOptions = log.DefaultOptions()
@@ -64,6 +90,29 @@ func configureLogging(_ *cobra.Command, _ []string) error {
 	return nil
 }
 
+// newWorkloadCertificate creates the certificate identifying MEC in the mesh,
+// which is issued by istiod.
+func newWorkloadCertificate() (*server.WorkloadCertificate, error) {
+	rootCert, err := ioutil.ReadFile(caRootCert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read root certificate of the CA: %v", err)
+	}
+	client, err := caclient.NewCitadelClient(security.Options{
+		CAEndpoint: caAddress,
+		ClusterID:  clusterID,
+		JWTPath:    caTokenPath,
+	}, true, rootCert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA client: %v", err)
+	}
+	spiffe.SetTrustDomain(trustDomain)
+	identity, err := spiffe.GenSpiffeURI(namespace, serviceAccount)
+	if err != nil {
+		return nil, err
+	}
+	return server.NewWorkloadCertificate(client, identity, defaultCertificateTTL), nil
+}
+
 func createCommand(args []string) *cobra.Command {
 	rootCmd := &cobra.Command{
 		Use:               "mec [flags]",
@@ -100,16 +149,52 @@ func createCommand(args []string) *cobra.Command {
 				return fmt.Errorf("failed to create Extension Controller: %v", err)
 			}
 
-			p, err := ossm.NewOSSMPullStrategy(config)
+			var p model.ImagePullStrategy
+			switch pullStrategy {
+			case "ossm":
+				p, err = ossm.NewOSSMPullStrategy(config)
+				if err != nil {
+					return fmt.Errorf("failed to create OSSMPullStrategy: %v", err)
+				}
+			case "oci":
+				p, err = oci.NewOCIPullStrategy(config, cacheDirectory, insecureRegistries)
+				if err != nil {
+					return fmt.Errorf("failed to create OCIPullStrategy: %v", err)
+				}
+			default:
+				return fmt.Errorf("unknown pull strategy %q, must be one of ossm, oci", pullStrategy)
+			}
+
+			var verifier *signature.Verifier
+			if publicKeys != "" {
+				verifier, err = signature.NewVerifierFromPath(publicKeys)
+				if err != nil {
+					return fmt.Errorf("failed to load public keys for signature verification: %v", err)
+				}
+				mainlog.Infof("Extension images must be signed by a key in %s", publicKeys)
+			}
+
+			store, err := server.NewModuleStore(serveDirectory)
 			if err != nil {
-				return fmt.Errorf("failed to create OSSMPullStrategy: %v", err)
+				return err
 			}
 
-			w, err := server.NewWorker(config, p, baseURL, serveDirectory, nil)
+			w, err := server.NewWorker(config, p, verifier, baseURL, store, nil)
 			if err != nil {
 				return fmt.Errorf("failed to create worker: %v", err)
 			}
 
+			// only the leader pulls images and updates the status of extensions,
+			// the other replicas restore the modules it stored
+			var le *leaderelection.LeaderElection
+			if leaderElection {
+				kubeClient, err := kubernetes.NewForConfig(config)
+				if err != nil {
+					return fmt.Errorf("failed to create kubernetes client: %v", err)
+				}
+				le = leaderelection.NewLeaderElection(namespace, podName, leaderelection.MECController, kubeClient)
+			}
+
 			ec.RegisterEventHandler(cache.ResourceEventHandlerFuncs{
 				AddFunc: func(obj interface{}) {
 					w.Queue <- server.ExtensionEvent{
@@ -131,8 +216,6 @@ func createCommand(args []string) *cobra.Command {
 				},
 			})
 
-			ws := server.NewHTTPServer(8080, serveDirectory)
-
 			fw := filewatcher.NewWatcher()
 			defer fw.Close()
 			err = fw.Add(tokenPath)
@@ -169,10 +252,32 @@ func createCommand(args []string) *cobra.Command {
 			}()
 			fw.Events(tokenPath) <- fsnotify.Event{}
 
+			var tlsConfig *tls.Config
+			if serveTLS {
+				cert, err := newWorkloadCertificate()
+				if err != nil {
+					return err
+				}
+				if err := cert.Start(stopChan); err != nil {
+					return fmt.Errorf("failed to obtain workload certificate: %v", err)
+				}
+				tlsConfig = cert.TLSConfig()
+			}
+			ws := server.NewHTTPServer(8080, store, tlsConfig)
+
 			mrc.Start(stopChan)
 			ec.Start(stopChan)
 			w.Start(stopChan)
 			ws.Start(stopChan)
+			go w.SweepModules(stopChan, ec.HasSynced, ec.GetExtensions)
+			if le != nil {
+				le.AddRunFunction(func(stop <-chan struct{}) {
+					w.Lead(stop, ec.GetExtensions)
+				})
+				go le.Run(stopChan)
+			} else {
+				go w.Lead(stopChan, ec.GetExtensions)
+			}
 
 			cmd.WaitSignal(stopChan)
 
@@ -186,10 +291,36 @@ func createCommand(args []string) *cobra.Command {
 	rootCmd.PersistentFlags().StringVar(&tokenPath, "tokenPath", "/var/run/secrets/kubernetes.io/serviceaccount/token",
 		"File containing to the ServiceAccount token to be used for communication with the K8s API server")
 	rootCmd.PersistentFlags().StringVar(&serveDirectory, "serveDirectory", "/srv",
-		"Directory form where WASM modules are served")
+		"Directory form where WASM modules are served. Modules are stored by their SHA256, so the directory may be shared by replicas")
 	rootCmd.PersistentFlags().StringVar(&registryURL, "registryURL", "image-registry.openshift-image-registry.svc:5000",
 		"Registry from which to pull images by default")
 	rootCmd.PersistentFlags().StringVar(&namespace, "namespace", "istio-system", "The namespace that MEC is running in")
+	rootCmd.PersistentFlags().StringVar(&pullStrategy, "pullStrategy", "ossm",
+		"Strategy used to pull extension images: ossm uses podman and ImageStreams, oci pulls images directly from their registries")
+	rootCmd.PersistentFlags().StringVar(&cacheDirectory, "cacheDirectory", "/var/cache/mec",
+		"Directory where images pulled by the oci pull strategy are stored")
+	rootCmd.PersistentFlags().StringSliceVar(&insecureRegistries, "insecureRegistries", nil,
+		"Registries whose certificates are not verified by the oci pull strategy, which also falls back to plain HTTP for them")
+	rootCmd.PersistentFlags().BoolVar(&leaderElection, "leaderElection", true,
+		"Elect a leader among the replicas to pull images and update the status of extensions. "+
+			"If disabled, only a single replica may run")
+	rootCmd.PersistentFlags().StringVar(&podName, "podName", os.Getenv("POD_NAME"),
+		"Name of this pod, identifying it in leader elections")
+	rootCmd.PersistentFlags().BoolVar(&serveTLS, "tls", false,
+		"Serve modules over mutual TLS, using a workload certificate issued by istiod. "+
+			"Clients must present a certificate issued by the mesh's CA")
+	rootCmd.PersistentFlags().StringVar(&caAddress, "caAddress", "istiod.istio-system.svc:15012",
+		"Address of the CA issuing the workload certificate")
+	rootCmd.PersistentFlags().StringVar(&caRootCert, "caRootCert", "/var/run/secrets/istio/root-cert.pem",
+		"File containing the root certificate of the CA")
+	rootCmd.PersistentFlags().StringVar(&caTokenPath, "caTokenPath", "/var/run/secrets/tokens/istio-token",
+		"File containing the ServiceAccount token used to authenticate with the CA")
+	rootCmd.PersistentFlags().StringVar(&clusterID, "clusterID", "Kubernetes", "ID of the cluster MEC is running in")
+	rootCmd.PersistentFlags().StringVar(&trustDomain, "trustDomain", "cluster.local", "Trust domain of the mesh")
+	rootCmd.PersistentFlags().StringVar(&serviceAccount, "serviceAccount", "mec", "ServiceAccount MEC is running as")
+	rootCmd.PersistentFlags().StringVar(&publicKeys, "signaturePublicKeys", "",
+		"PEM encoded public key file, or directory of them, used to verify the cosign signatures of extension images. "+
+			"If set, images that are not signed by one of the keys are rejected")
 
 	loggingOptions.AttachCobraFlags(rootCmd)
 	return rootCmd
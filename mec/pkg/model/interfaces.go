@@ -34,6 +34,18 @@ type ImagePullStrategy interface {
 	Login(registryURL, token string) (string, error)
 }
 
+// SignatureFetcher is implemented by ImagePullStrategies that can retrieve the
+// signatures of the images they pull.
+type SignatureFetcher interface {
+	// GetSignatures returns the signatures stored in the registry for the
+	// image with the given digest, e.g. sha256:...
+	GetSignatures(
+		image *ImageRef,
+		digest string,
+		namespace string,
+		pullSecrets []corev1.LocalObjectReference) ([]Signature, error)
+}
+
 type Image interface {
 	CopyWasmModule(outputFile string) error
 	GetManifest() *Manifest
@@ -40,6 +40,14 @@ type Manifest struct {
 
 type ManifestSchemaVersion string
 
+// Signature is a detached signature for an image, as created by cosign.
+type Signature struct {
+	// Payload is the signed document, identifying the image
+	Payload []byte
+	// Signature is the raw signature of the payload
+	Signature []byte
+}
+
 const (
 	ManifestSchemaVersion1 = "1"
 
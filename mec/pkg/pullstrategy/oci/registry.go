@@ -0,0 +1,327 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"istio.io/istio/mec/pkg/model"
+)
+
+const (
+	mediaTypeOCIManifest    = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex       = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+	// mediaTypeWasmLayer is used by images containing the wasm module as a
+	// plain layer, rather than in a tar archive
+	mediaTypeWasmLayer = "application/vnd.module.wasm.content.layer.v1+wasm"
+
+	dockerHubRegistry = "docker.io"
+	dockerHubEndpoint = "registry-1.docker.io"
+
+	// manifests larger than this are rejected
+	maxManifestSize = 4 * 1024 * 1024
+)
+
+var acceptedManifestTypes = []string{mediaTypeOCIManifest, mediaTypeDockerManifest, mediaTypeOCIIndex, mediaTypeDockerList}
+
+// descriptor references content in a registry.
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// manifest is an OCI image manifest, image index, or the equivalent Docker
+// manifest or manifest list.
+type manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        descriptor   `json:"config"`
+	Layers        []descriptor `json:"layers"`
+	Manifests     []descriptor `json:"manifests"`
+}
+
+func (m *manifest) isIndex() bool {
+	return m.MediaType == mediaTypeOCIIndex || m.MediaType == mediaTypeDockerList || (len(m.Manifests) > 0 && len(m.Layers) == 0)
+}
+
+// statusError is returned when the registry responds with an unexpected
+// status code.
+type statusError struct {
+	statusCode int
+	message    string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+func isNotFound(err error) bool {
+	se, ok := err.(*statusError)
+	return ok && se.statusCode == http.StatusNotFound
+}
+
+// credentials used to authenticate with a registry.
+type credentials struct {
+	username string
+	password string
+}
+
+// repository returns the registry host and the name of the repository for
+// the image, e.g. quay.io and maistra/example for quay.io/maistra/example.
+func repository(image *model.ImageRef) (string, string) {
+	hubSplit := strings.SplitN(image.Hub, "/", 2)
+	registry := hubSplit[0]
+	if !(strings.ContainsAny(registry, ".:") || registry == "localhost") {
+		// images on Docker Hub may omit the registry, e.g. user/image:tag
+		return dockerHubRegistry, image.Hub + "/" + image.Repository
+	}
+	if len(hubSplit) == 1 {
+		return registry, image.Repository
+	}
+	return registry, hubSplit[1] + "/" + image.Repository
+}
+
+// registryClient fetches content from a single repository.  It is not safe
+// for concurrent use.
+type registryClient struct {
+	client      *http.Client
+	registry    string
+	repository  string
+	insecure    bool
+	scheme      string
+	credentials *credentials
+	// authorization is the value of the Authorization header sent with each
+	// request, once the registry has challenged us
+	authorization string
+}
+
+func newRegistryClient(transport http.RoundTripper, registry, repository string, insecure bool, creds *credentials) *registryClient {
+	if insecure {
+		if t, ok := transport.(*http.Transport); ok {
+			t = t.Clone()
+			t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} // nolint: gosec
+			transport = t
+		}
+	}
+	endpoint := registry
+	if registry == dockerHubRegistry {
+		endpoint = dockerHubEndpoint
+	}
+	return &registryClient{
+		client:      &http.Client{Transport: transport},
+		registry:    endpoint,
+		repository:  repository,
+		insecure:    insecure,
+		scheme:      "https",
+		credentials: creds,
+	}
+}
+
+// get retrieves the specified manifest or blob, authenticating if the
+// registry requires it.  The caller must close the body of the response.
+func (c *registryClient) get(kind, reference string, accept []string) (*http.Response, error) {
+	resp, err := c.do(kind, reference, accept)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := c.authorize(challenge); err != nil {
+			return nil, err
+		}
+		if resp, err = c.do(kind, reference, accept); err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &statusError{
+			statusCode: resp.StatusCode,
+			message:    fmt.Sprintf("failed to retrieve %s %s from %s/%s: %s", strings.TrimSuffix(kind, "s"), reference, c.registry, c.repository, resp.Status),
+		}
+	}
+	return resp, nil
+}
+
+func (c *registryClient) do(kind, reference string, accept []string) (*http.Response, error) {
+	for {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s://%s/v2/%s/%s/%s", c.scheme, c.registry, c.repository, kind, reference), nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(accept) > 0 {
+			req.Header.Set("Accept", strings.Join(accept, ", "))
+		}
+		if c.authorization != "" {
+			req.Header.Set("Authorization", c.authorization)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil && c.insecure && c.scheme == "https" {
+			// insecure registries may not support TLS at all
+			c.scheme = "http"
+			continue
+		}
+		return resp, err
+	}
+}
+
+// authorize handles the challenge returned by the registry, obtaining a
+// bearer token from the authorization service if necessary.
+func (c *registryClient) authorize(challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.credentials == nil {
+			return fmt.Errorf("registry %s requires authentication, but no credentials are available", c.registry)
+		}
+		req := &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(c.credentials.username, c.credentials.password)
+		c.authorization = req.Header.Get("Authorization")
+		return nil
+	case "bearer":
+		realm := params["realm"]
+		if realm == "" {
+			return fmt.Errorf("registry %s returned a bearer challenge without a realm", c.registry)
+		}
+		tokenURL, err := url.Parse(realm)
+		if err != nil {
+			return fmt.Errorf("invalid realm in challenge from registry %s: %v", c.registry, err)
+		}
+		query := tokenURL.Query()
+		if service := params["service"]; service != "" {
+			query.Set("service", service)
+		}
+		scope := params["scope"]
+		if scope == "" {
+			scope = fmt.Sprintf("repository:%s:pull", c.repository)
+		}
+		query.Set("scope", scope)
+		tokenURL.RawQuery = query.Encode()
+		req, err := http.NewRequest(http.MethodGet, tokenURL.String(), nil)
+		if err != nil {
+			return err
+		}
+		if c.credentials != nil {
+			req.SetBasicAuth(c.credentials.username, c.credentials.password)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve token for registry %s: %v", c.registry, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("failed to retrieve token for registry %s: %s", c.registry, resp.Status)
+		}
+		var token struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}
+		if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&token); err != nil {
+			return fmt.Errorf("failed to decode token for registry %s: %v", c.registry, err)
+		}
+		if token.Token == "" {
+			token.Token = token.AccessToken
+		}
+		if token.Token == "" {
+			return fmt.Errorf("registry %s did not return a token", c.registry)
+		}
+		c.authorization = "Bearer " + token.Token
+		return nil
+	default:
+		return fmt.Errorf("registry %s requires unsupported authentication scheme %q", c.registry, scheme)
+	}
+}
+
+// parseChallenge parses the value of a WWW-Authenticate header, e.g.
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := map[string]string{}
+	challenge = strings.TrimSpace(challenge)
+	index := strings.Index(challenge, " ")
+	if index < 0 {
+		return challenge, params
+	}
+	scheme, rest := challenge[:index], challenge[index+1:]
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if end := strings.Index(rest, ","); end >= 0 {
+			value, rest = rest[:end], rest[end:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[key] = value
+	}
+	return scheme, params
+}
+
+// fetchManifest retrieves the manifest, returning its contents and digest.
+func (c *registryClient) fetchManifest(reference string) ([]byte, string, error) {
+	resp, err := c.get("manifests", reference, acceptedManifestTypes)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxManifestSize {
+		return nil, "", fmt.Errorf("manifest %s exceeds the maximum size of %d bytes", reference, maxManifestSize)
+	}
+	digest := digestOf(data)
+	if strings.HasPrefix(reference, "sha256:") && reference != digest {
+		return nil, "", fmt.Errorf("manifest digest %s does not match %s", digest, reference)
+	}
+	if header := resp.Header.Get("Docker-Content-Digest"); header != "" && header != digest {
+		return nil, "", fmt.Errorf("manifest digest %s does not match the digest reported by the registry, %s", digest, header)
+	}
+	return data, digest, nil
+}
+
+// fetchBlob retrieves the blob.  The caller must close the reader and verify
+// its contents.
+func (c *registryClient) fetchBlob(digest string) (io.ReadCloser, error) {
+	resp, err := c.get("blobs", digest, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
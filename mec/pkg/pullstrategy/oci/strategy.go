@@ -0,0 +1,607 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"istio.io/istio/mec/pkg/model"
+	"istio.io/pkg/log"
+)
+
+var strategylog = log.RegisterScope("strategy", "Strategy", 0)
+
+const (
+	manifestFile = "manifest.yaml"
+
+	// usernames are ignored when authenticating with a token
+	tokenUsername = "mec"
+
+	// cosignSignatureAnnotation holds the base64 encoded signature of the
+	// layer containing the signed payload
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+)
+
+// ociPullStrategy pulls images using the OCI distribution protocol.  Pulled
+// manifests and layers are stored in a content addressed cache, so images
+// pinned by digest are never pulled twice.
+type ociPullStrategy struct {
+	client             kubernetes.Interface
+	transport          http.RoundTripper
+	cacheDir           string
+	insecureRegistries map[string]bool
+
+	mu sync.Mutex
+	// credentials provided using Login(), by registry
+	loginCredentials map[string]*credentials
+}
+
+// NewOCIPullStrategy returns an ImagePullStrategy that pulls images directly
+// from their registries, storing them in cacheDir.  Images are pulled from
+// the insecureRegistries without verifying their certificates, falling back
+// to plain HTTP if the registry does not support TLS.
+func NewOCIPullStrategy(config *rest.Config, cacheDir string, insecureRegistries []string) (model.ImagePullStrategy, error) {
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return newOCIPullStrategy(client, http.DefaultTransport, cacheDir, insecureRegistries)
+}
+
+func newOCIPullStrategy(client kubernetes.Interface, transport http.RoundTripper,
+	cacheDir string, insecureRegistries []string) (*ociPullStrategy, error) {
+	for _, dir := range []string{blobsDir(cacheDir), tagsDir(cacheDir)} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create cache directory: %v", err)
+		}
+	}
+	insecure := map[string]bool{}
+	for _, registry := range insecureRegistries {
+		insecure[registry] = true
+	}
+	return &ociPullStrategy{
+		client:             client,
+		transport:          transport,
+		cacheDir:           cacheDir,
+		insecureRegistries: insecure,
+		loginCredentials:   map[string]*credentials{},
+	}, nil
+}
+
+func blobsDir(cacheDir string) string {
+	return filepath.Join(cacheDir, "blobs", "sha256")
+}
+
+func tagsDir(cacheDir string) string {
+	return filepath.Join(cacheDir, "tags")
+}
+
+func (p *ociPullStrategy) blobPath(digest string) string {
+	return filepath.Join(blobsDir(p.cacheDir), strings.TrimPrefix(digest, "sha256:"))
+}
+
+func (p *ociPullStrategy) tagPath(image *model.ImageRef) string {
+	return filepath.Join(tagsDir(p.cacheDir), fmt.Sprintf("%x", sha256.Sum256([]byte(image.String()))))
+}
+
+func digestOf(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+func validDigest(digest string) bool {
+	if !strings.HasPrefix(digest, "sha256:") {
+		return false
+	}
+	hexDigest := strings.TrimPrefix(digest, "sha256:")
+	_, err := hex.DecodeString(hexDigest)
+	return err == nil && len(hexDigest) == sha256.Size*2
+}
+
+// Login stores the token used to authenticate with the registry when the
+// image's pull secrets do not contain credentials for it.
+func (p *ociPullStrategy) Login(registryURL, token string) (string, error) {
+	registry := normalizeRegistry(registryURL)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.loginCredentials[registry] = &credentials{username: tokenUsername, password: token}
+	return fmt.Sprintf("Stored credentials for registry %s", registry), nil
+}
+
+// GetImage returns an image that has been pulled previously
+func (p *ociPullStrategy) GetImage(image *model.ImageRef) (model.Image, error) {
+	// only works with imageRefs that come with a SHA256 value
+	if image.SHA256 == "" {
+		return nil, fmt.Errorf("getImage() only works for pinned images")
+	}
+	digest := "sha256:" + image.SHA256
+	if _, err := os.Stat(p.blobPath(digest)); os.IsNotExist(err) {
+		return nil, nil
+	}
+	img, err := p.loadImage(digest)
+	if err != nil {
+		strategylog.Errorf("failed to load image %s from cache: %s", image, err)
+		return nil, err
+	}
+	return img, nil
+}
+
+// PullImage retrieves an image from a remote registry
+func (p *ociPullStrategy) PullImage(image *model.ImageRef,
+	namespace string,
+	pullPolicy corev1.PullPolicy,
+	pullSecrets []corev1.LocalObjectReference,
+	smeName string,
+	smeUID types.UID) (model.Image, error) {
+
+	reference := image.Tag
+	if image.SHA256 != "" {
+		reference = "sha256:" + image.SHA256
+	}
+
+	if pullPolicy != corev1.PullAlways && !(pullPolicy == "" && image.Tag == "latest") {
+		if digest := p.cachedDigest(image); digest != "" {
+			if img, err := p.loadImage(digest); err == nil {
+				strategylog.Debugf("Using cached image %s@%s", image, digest)
+				return img, nil
+			} else if pullPolicy == corev1.PullNever {
+				return nil, err
+			}
+		} else if pullPolicy == corev1.PullNever {
+			return nil, fmt.Errorf("image %s is not present and pull policy is %s", image, pullPolicy)
+		}
+	}
+
+	registry, repo := repository(image)
+	creds, err := p.credentialsFor(registry, namespace, pullSecrets)
+	if err != nil {
+		return nil, err
+	}
+	client := newRegistryClient(p.transport, registry, repo, p.insecureRegistries[registry], creds)
+
+	strategylog.Infof("Pulling image %s", image)
+	data, digest, err := client.fetchManifest(reference)
+	if err != nil {
+		return nil, err
+	}
+	m, err := parseManifest(data)
+	if err != nil {
+		return nil, err
+	}
+	// manifests are stored once all the content they reference is present,
+	// so GetImage() never returns partially pulled images
+	manifests := map[string][]byte{digest: data}
+	topDigest := digest
+	if m.isIndex() {
+		// wasm modules are platform independent, so any of the images will do
+		if len(m.Manifests) == 0 {
+			return nil, fmt.Errorf("image index %s does not reference any images", digest)
+		}
+		if data, digest, err = client.fetchManifest(m.Manifests[0].Digest); err != nil {
+			return nil, err
+		}
+		if m, err = parseManifest(data); err != nil {
+			return nil, err
+		}
+		manifests[digest] = data
+	}
+	for _, layer := range m.Layers {
+		if err := p.pullBlob(client, layer); err != nil {
+			return nil, err
+		}
+	}
+	for _, d := range []string{digest, topDigest} {
+		if err := p.storeBlob(d, manifests[d]); err != nil {
+			return nil, err
+		}
+	}
+	if err := ioutil.WriteFile(p.tagPath(image), []byte(topDigest), 0o644); err != nil {
+		return nil, fmt.Errorf("failed to record digest of image %s: %v", image, err)
+	}
+	strategylog.Infof("Pulled image %s@%s", image, topDigest)
+	return p.loadImage(topDigest)
+}
+
+// GetSignatures retrieves the cosign signatures of the image, which are stored
+// in the same repository, tagged sha256-<hex>.sig.  Signatures are not cached,
+// so revoked signatures take effect the next time an image is verified.
+func (p *ociPullStrategy) GetSignatures(image *model.ImageRef,
+	digest string,
+	namespace string,
+	pullSecrets []corev1.LocalObjectReference) ([]model.Signature, error) {
+
+	if !validDigest(digest) {
+		return nil, fmt.Errorf("invalid digest %q", digest)
+	}
+	registry, repo := repository(image)
+	creds, err := p.credentialsFor(registry, namespace, pullSecrets)
+	if err != nil {
+		return nil, err
+	}
+	client := newRegistryClient(p.transport, registry, repo, p.insecureRegistries[registry], creds)
+
+	data, _, err := client.fetchManifest(strings.Replace(digest, ":", "-", 1) + ".sig")
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	m, err := parseManifest(data)
+	if err != nil {
+		return nil, err
+	}
+	var signatures []model.Signature
+	for _, layer := range m.Layers {
+		encoded, ok := layer.Annotations[cosignSignatureAnnotation]
+		if !ok {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signature in layer %s: %v", layer.Digest, err)
+		}
+		body, err := client.fetchBlob(layer.Digest)
+		if err != nil {
+			return nil, err
+		}
+		payload, err := ioutil.ReadAll(io.LimitReader(body, maxManifestSize+1))
+		body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to download signature payload %s: %v", layer.Digest, err)
+		}
+		if len(payload) > maxManifestSize || digestOf(payload) != layer.Digest {
+			return nil, fmt.Errorf("signature payload does not match digest %s", layer.Digest)
+		}
+		signatures = append(signatures, model.Signature{Payload: payload, Signature: sig})
+	}
+	return signatures, nil
+}
+
+func parseManifest(data []byte) (*manifest, error) {
+	m := &manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed to parse image manifest: %v", err)
+	}
+	if m.SchemaVersion != 2 {
+		return nil, fmt.Errorf("unsupported manifest schema version %d", m.SchemaVersion)
+	}
+	for _, d := range append(append([]descriptor{}, m.Layers...), m.Manifests...) {
+		if !validDigest(d.Digest) {
+			return nil, fmt.Errorf("unsupported digest %q in image manifest", d.Digest)
+		}
+	}
+	return m, nil
+}
+
+// cachedDigest returns the digest of the image if it has been pulled before.
+func (p *ociPullStrategy) cachedDigest(image *model.ImageRef) string {
+	if image.SHA256 != "" {
+		digest := "sha256:" + image.SHA256
+		if _, err := os.Stat(p.blobPath(digest)); err == nil {
+			return digest
+		}
+		return ""
+	}
+	data, err := ioutil.ReadFile(p.tagPath(image))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// storeBlob adds the data to the cache, after verifying its digest.
+func (p *ociPullStrategy) storeBlob(digest string, data []byte) error {
+	if digestOf(data) != digest {
+		return fmt.Errorf("content does not match digest %s", digest)
+	}
+	return p.writeBlob(digest, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// pullBlob downloads the blob into the cache, unless it's already present.
+func (p *ociPullStrategy) pullBlob(client *registryClient, blob descriptor) error {
+	if _, err := os.Stat(p.blobPath(blob.Digest)); err == nil {
+		return nil
+	}
+	body, err := client.fetchBlob(blob.Digest)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return p.writeBlob(blob.Digest, func(w io.Writer) error {
+		hash := sha256.New()
+		size, err := io.Copy(io.MultiWriter(w, hash), body)
+		if err != nil {
+			return fmt.Errorf("failed to download blob %s: %v", blob.Digest, err)
+		}
+		if digest := fmt.Sprintf("sha256:%x", hash.Sum(nil)); digest != blob.Digest {
+			return fmt.Errorf("blob digest %s does not match %s", digest, blob.Digest)
+		}
+		if blob.Size > 0 && size != blob.Size {
+			return fmt.Errorf("blob %s has size %d, expected %d", blob.Digest, size, blob.Size)
+		}
+		return nil
+	})
+}
+
+// writeBlob writes the blob to a temporary file, which is moved into place
+// if write succeeds, so the cache never contains partial content.
+func (p *ociPullStrategy) writeBlob(digest string, write func(w io.Writer) error) error {
+	tmp, err := ioutil.TempFile(blobsDir(p.cacheDir), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p.blobPath(digest))
+}
+
+// loadImage creates an image from the cached manifest with the given digest.
+func (p *ociPullStrategy) loadImage(digest string) (*ociImage, error) {
+	data, err := ioutil.ReadFile(p.blobPath(digest))
+	if err != nil {
+		return nil, err
+	}
+	m, err := parseManifest(data)
+	if err != nil {
+		return nil, err
+	}
+	if m.isIndex() {
+		if len(m.Manifests) == 0 {
+			return nil, fmt.Errorf("image index %s does not reference any images", digest)
+		}
+		if data, err = ioutil.ReadFile(p.blobPath(m.Manifests[0].Digest)); err != nil {
+			return nil, err
+		}
+		if m, err = parseManifest(data); err != nil {
+			return nil, err
+		}
+	}
+	img := &ociImage{
+		sha256: strings.TrimPrefix(digest, "sha256:"),
+	}
+	for _, layer := range m.Layers {
+		if _, err := os.Stat(p.blobPath(layer.Digest)); err != nil {
+			return nil, fmt.Errorf("layer %s of image %s is not present", layer.Digest, digest)
+		}
+		img.layers = append(img.layers, cachedLayer{mediaType: layer.MediaType, path: p.blobPath(layer.Digest)})
+	}
+	manifestBytes, err := img.readFile(manifestFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", manifestFile, err)
+	}
+	img.manifest = &model.Manifest{}
+	if err := yaml.Unmarshal(manifestBytes, img.manifest); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %s", manifestFile, err)
+	}
+	return img, nil
+}
+
+// credentialsFor returns the credentials for the registry from the pull
+// secrets, falling back to the credentials provided using Login().
+func (p *ociPullStrategy) credentialsFor(registry, namespace string, pullSecrets []corev1.LocalObjectReference) (*credentials, error) {
+	for _, ref := range pullSecrets {
+		secret, err := p.client.CoreV1().Secrets(namespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pull secret %s/%s: %v", namespace, ref.Name, err)
+		}
+		creds, err := credentialsFromSecret(secret, registry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse pull secret %s/%s: %v", namespace, ref.Name, err)
+		}
+		if creds != nil {
+			return creds, nil
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.loginCredentials[registry], nil
+}
+
+type dockerConfigEntry struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Auth     string `json:"auth"`
+}
+
+// credentialsFromSecret returns the credentials for the registry from a
+// kubernetes.io/dockerconfigjson or kubernetes.io/dockercfg secret, or nil if
+// the secret contains no credentials for the registry.
+func credentialsFromSecret(secret *corev1.Secret, registry string) (*credentials, error) {
+	var entries map[string]dockerConfigEntry
+	if data, ok := secret.Data[corev1.DockerConfigJsonKey]; ok {
+		var config struct {
+			Auths map[string]dockerConfigEntry `json:"auths"`
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, err
+		}
+		entries = config.Auths
+	} else if data, ok := secret.Data[corev1.DockerConfigKey]; ok {
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, err
+		}
+	}
+	for key, entry := range entries {
+		if normalizeRegistry(key) != registry {
+			continue
+		}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("invalid auth for registry %s: %v", key, err)
+			}
+			userAndPassword := strings.SplitN(string(decoded), ":", 2)
+			if len(userAndPassword) != 2 {
+				return nil, fmt.Errorf("invalid auth for registry %s", key)
+			}
+			return &credentials{username: userAndPassword[0], password: userAndPassword[1]}, nil
+		}
+		return &credentials{username: entry.Username, password: entry.Password}, nil
+	}
+	return nil, nil
+}
+
+// normalizeRegistry strips the scheme and path from registry URLs, e.g.
+// https://index.docker.io/v1/, as used in docker config files.
+func normalizeRegistry(registryURL string) string {
+	registry := registryURL
+	if index := strings.Index(registry, "://"); index >= 0 {
+		registry = registry[index+3:]
+	}
+	if index := strings.Index(registry, "/"); index >= 0 {
+		registry = registry[:index]
+	}
+	switch registry {
+	case "index.docker.io", dockerHubEndpoint:
+		return dockerHubRegistry
+	}
+	return registry
+}
+
+type cachedLayer struct {
+	mediaType string
+	path      string
+}
+
+type ociImage struct {
+	sha256   string
+	manifest *model.Manifest
+	layers   []cachedLayer
+}
+
+func (i *ociImage) CopyWasmModule(outputFile string) error {
+	data, err := i.readFile(i.manifest.Module)
+	if err != nil {
+		// the module may be stored as a plain layer
+		for index := len(i.layers) - 1; index >= 0; index-- {
+			if i.layers[index].mediaType == mediaTypeWasmLayer {
+				data, err = ioutil.ReadFile(i.layers[index].path)
+				break
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to extract wasm module %q: %v", i.manifest.Module, err)
+	}
+	return ioutil.WriteFile(outputFile, data, os.ModePerm)
+}
+
+func (i *ociImage) GetManifest() *model.Manifest {
+	return i.manifest
+}
+
+func (i *ociImage) SHA256() string {
+	return i.sha256
+}
+
+// readFile returns the contents of the file from the image's filesystem.
+// Layers are searched from the top, so files in later layers take
+// precedence, and files deleted by a later layer are not found.
+func (i *ociImage) readFile(name string) ([]byte, error) {
+	name = cleanPath(name)
+	if name == "" {
+		return nil, fmt.Errorf("file name is empty")
+	}
+	whiteout := path.Join(path.Dir(name), ".wh."+path.Base(name))
+	for index := len(i.layers) - 1; index >= 0; index-- {
+		if i.layers[index].mediaType == mediaTypeWasmLayer {
+			continue
+		}
+		data, deleted, err := readFileFromLayer(i.layers[index].path, name, whiteout)
+		if err != nil {
+			return nil, err
+		} else if deleted {
+			break
+		} else if data != nil {
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("file %s not found in image", name)
+}
+
+func cleanPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// readFileFromLayer returns the contents of the file from the tar archive,
+// which may be compressed using gzip, or whether the file was deleted.
+func readFileFromLayer(layerPath, name, whiteout string) ([]byte, bool, error) {
+	f, err := os.Open(layerPath)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	buffered := bufio.NewReader(f)
+	var reader io.Reader = buffered
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, false, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, false, nil
+		} else if err != nil {
+			return nil, false, fmt.Errorf("failed to read layer: %v", err)
+		}
+		switch cleanPath(header.Name) {
+		case whiteout:
+			return nil, true, nil
+		case name:
+			if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+				return nil, false, fmt.Errorf("%s is not a regular file", name)
+			}
+			data, err := ioutil.ReadAll(tr)
+			return data, false, err
+		}
+	}
+}
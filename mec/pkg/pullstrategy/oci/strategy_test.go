@@ -0,0 +1,505 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"istio.io/istio/mec/pkg/model"
+	fakestrategy "istio.io/istio/mec/pkg/pullstrategy/fake"
+)
+
+const (
+	testRepository = "maistra/example"
+	testUsername   = "user"
+	testPassword   = "secret"
+	testToken      = "token"
+)
+
+// testRegistry is a minimal in-process implementation of the OCI
+// distribution API.  If requireAuth is set, clients must obtain a token from
+// /token using basic authentication.
+type testRegistry struct {
+	*httptest.Server
+	requireAuth bool
+
+	mu        sync.Mutex
+	manifests map[string]testManifest
+	blobs     map[string][]byte
+	requests  []string
+}
+
+type testManifest struct {
+	mediaType string
+	data      []byte
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	r := &testRegistry{
+		manifests: map[string]testManifest{},
+		blobs:     map[string][]byte{},
+	}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *testRegistry) host() string {
+	return strings.TrimPrefix(r.URL, "http://")
+}
+
+func (r *testRegistry) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req.URL.Path)
+	if req.URL.Path == "/token" {
+		if user, password, ok := req.BasicAuth(); !ok || user != testUsername || password != testPassword {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if req.URL.Query().Get("scope") != "repository:"+testRepository+":pull" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": testToken})
+		return
+	}
+	if r.requireAuth && req.Header.Get("Authorization") != "Bearer "+testToken {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, r.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	prefix := "/v2/" + testRepository + "/"
+	if !strings.HasPrefix(req.URL.Path, prefix) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, prefix), "/", 2)
+	if len(parts) != 2 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch parts[0] {
+	case "manifests":
+		m, ok := r.manifests[parts[1]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", m.mediaType)
+		w.Header().Set("Docker-Content-Digest", digestOf(m.data))
+		_, _ = w.Write(m.data)
+	case "blobs":
+		blob, ok := r.blobs[parts[1]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(blob)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (r *testRegistry) requestCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+// addBlob adds the blob, returning a descriptor for it.
+func (r *testRegistry) addBlob(mediaType string, data []byte) descriptor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	digest := digestOf(data)
+	r.blobs[digest] = data
+	return descriptor{MediaType: mediaType, Digest: digest, Size: int64(len(data))}
+}
+
+// addManifest adds the manifest under the tag and its digest, returning its
+// digest.
+func (r *testRegistry) addManifest(t *testing.T, tag string, m *manifest) string {
+	t.Helper()
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	digest := digestOf(data)
+	r.manifests[digest] = testManifest{mediaType: m.MediaType, data: data}
+	if tag != "" {
+		r.manifests[tag] = r.manifests[digest]
+	}
+	return digest
+}
+
+// addImage adds an image with a single layer containing the files.
+func (r *testRegistry) addImage(t *testing.T, tag string, files map[string]string) string {
+	t.Helper()
+	return r.addManifest(t, tag, &manifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIManifest,
+		Config:        r.addBlob("application/vnd.oci.image.config.v1+json", []byte("{}")),
+		Layers:        []descriptor{r.addBlob("application/vnd.oci.image.layer.v1.tar+gzip", newLayer(t, files, true))},
+	})
+}
+
+func newLayer(t *testing.T, files map[string]string, compress bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, contents := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(contents)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !compress {
+		return buf.Bytes()
+	}
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	if _, err := gw.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return gzipped.Bytes()
+}
+
+func newTestStrategy(t *testing.T, registry *testRegistry, objects ...*corev1.Secret) *ociPullStrategy {
+	t.Helper()
+	client := fake.NewSimpleClientset()
+	for _, secret := range objects {
+		if _, err := client.CoreV1().Secrets(secret.Namespace).Create(context.TODO(), secret, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	strategy, err := newOCIPullStrategy(client, http.DefaultTransport, t.TempDir(), []string{registry.host()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strategy
+}
+
+var testManifestYAML = fakestrategy.FakeManifestYAML + "module: extension.wasm\n"
+
+func expectModule(t *testing.T, img model.Image, expected string) {
+	t.Helper()
+	out := filepath.Join(t.TempDir(), "module.wasm")
+	if err := img.CopyWasmModule(out); err != nil {
+		t.Fatalf("failed to copy wasm module: %s", err)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != expected {
+		t.Errorf("unexpected wasm module: got %q, want %q", data, expected)
+	}
+}
+
+func TestPullImage(t *testing.T) {
+	registry := newTestRegistry(t)
+	digest := registry.addImage(t, "v1", map[string]string{
+		"manifest.yaml":     testManifestYAML,
+		"./extension.wasm":  fakestrategy.FakeModule,
+		"unrelated/content": "ignored",
+	})
+	strategy := newTestStrategy(t, registry)
+	imageRef := model.StringToImageRef(registry.host() + "/" + testRepository + ":v1")
+
+	img, err := strategy.PullImage(imageRef, "test", corev1.PullIfNotPresent, nil, "sme", "uid")
+	if err != nil {
+		t.Fatalf("failed to pull image: %s", err)
+	}
+	if img.SHA256() != strings.TrimPrefix(digest, "sha256:") {
+		t.Errorf("unexpected SHA256: got %s, want %s", img.SHA256(), digest)
+	}
+	expectedManifest := fakestrategy.FakeManifest
+	expectedManifest.Module = "extension.wasm"
+	if diff := cmp.Diff(&expectedManifest, img.GetManifest()); diff != "" {
+		t.Errorf("unexpected manifest: %s", diff)
+	}
+	expectModule(t, img, fakestrategy.FakeModule)
+
+	// the image is cached
+	requests := registry.requestCount()
+	if img, err = strategy.PullImage(imageRef, "test", corev1.PullIfNotPresent, nil, "sme", "uid"); err != nil || img.SHA256() != strings.TrimPrefix(digest, "sha256:") {
+		t.Errorf("failed to retrieve cached image: %v", err)
+	}
+	pinnedRef := model.StringToImageRef(registry.host() + "/" + testRepository + "@" + digest)
+	if img, err = strategy.GetImage(pinnedRef); err != nil || img == nil {
+		t.Fatalf("failed to get pulled image: %v", err)
+	}
+	expectModule(t, img, fakestrategy.FakeModule)
+	if registry.requestCount() != requests {
+		t.Errorf("expected cached image to be used")
+	}
+
+	// a new image is pushed under the same tag
+	newDigest := registry.addImage(t, "v1", map[string]string{
+		"manifest.yaml":  testManifestYAML,
+		"extension.wasm": fakestrategy.FakeModule2,
+	})
+	if img, err = strategy.PullImage(imageRef, "test", corev1.PullAlways, nil, "sme", "uid"); err != nil {
+		t.Fatalf("failed to pull image: %s", err)
+	}
+	if img.SHA256() != strings.TrimPrefix(newDigest, "sha256:") {
+		t.Errorf("unexpected SHA256: got %s, want %s", img.SHA256(), newDigest)
+	}
+	expectModule(t, img, fakestrategy.FakeModule2)
+
+	unknownRef := model.StringToImageRef(registry.host() + "/" + testRepository + ":unknown")
+	if _, err := strategy.PullImage(unknownRef, "test", corev1.PullNever, nil, "sme", "uid"); err == nil {
+		t.Errorf("expected image that isn't present not to be pulled")
+	}
+	if img, err := strategy.GetImage(model.StringToImageRef(registry.host() + "/" + testRepository + "@sha256:" +
+		"41af286dc0b172ed2f1ca934fd2278de4a1192302ffa07087cea2682e7d372e3")); err != nil || img != nil {
+		t.Errorf("expected no image to be returned for image that hasn't been pulled, got %v, %v", img, err)
+	}
+}
+
+func TestPullImageIndex(t *testing.T) {
+	registry := newTestRegistry(t)
+	imageDigest := registry.addManifest(t, "", &manifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeDockerManifest,
+		Config:        registry.addBlob("application/vnd.docker.container.image.v1+json", []byte("{}")),
+		Layers: []descriptor{
+			registry.addBlob("application/vnd.docker.image.rootfs.diff.tar", newLayer(t, map[string]string{
+				"manifest.yaml": fakestrategy.FakeManifestYAML,
+			}, false)),
+			registry.addBlob(mediaTypeWasmLayer, []byte(fakestrategy.FakeModule)),
+		},
+	})
+	imageDescriptor := descriptor{MediaType: mediaTypeDockerManifest, Digest: imageDigest}
+	indexDigest := registry.addManifest(t, "latest", &manifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeDockerList,
+		Manifests:     []descriptor{imageDescriptor},
+	})
+	strategy := newTestStrategy(t, registry)
+
+	img, err := strategy.PullImage(model.StringToImageRef(registry.host()+"/"+testRepository+"@"+indexDigest),
+		"test", corev1.PullIfNotPresent, nil, "sme", "uid")
+	if err != nil {
+		t.Fatalf("failed to pull image: %s", err)
+	}
+	if img.SHA256() != strings.TrimPrefix(indexDigest, "sha256:") {
+		t.Errorf("expected the digest of the index to be used, got %s", img.SHA256())
+	}
+	if diff := cmp.Diff(&fakestrategy.FakeManifest, img.GetManifest()); diff != "" {
+		t.Errorf("unexpected manifest: %s", diff)
+	}
+	expectModule(t, img, fakestrategy.FakeModule)
+}
+
+func TestPullImageVerifiesDigests(t *testing.T) {
+	registry := newTestRegistry(t)
+	digest := registry.addImage(t, "v1", map[string]string{
+		"manifest.yaml":  testManifestYAML,
+		"extension.wasm": fakestrategy.FakeModule,
+	})
+	strategy := newTestStrategy(t, registry)
+
+	// pinned to a different digest
+	registry.manifests["sha256:41af286dc0b172ed2f1ca934fd2278de4a1192302ffa07087cea2682e7d372e3"] = registry.manifests[digest]
+	wrongRef := model.StringToImageRef(registry.host() + "/" + testRepository +
+		"@sha256:41af286dc0b172ed2f1ca934fd2278de4a1192302ffa07087cea2682e7d372e3")
+	if _, err := strategy.PullImage(wrongRef, "test", corev1.PullIfNotPresent, nil, "sme", "uid"); err == nil {
+		t.Errorf("expected manifest with unexpected digest to be rejected")
+	}
+
+	// tampered layer
+	m, err := parseManifest(registry.manifests[digest].data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.blobs[m.Layers[0].Digest] = newLayer(t, map[string]string{"manifest.yaml": testManifestYAML, "extension.wasm": "evil"}, true)
+	imageRef := model.StringToImageRef(registry.host() + "/" + testRepository + ":v1")
+	if _, err := strategy.PullImage(imageRef, "test", corev1.PullIfNotPresent, nil, "sme", "uid"); err == nil {
+		t.Errorf("expected layer with unexpected digest to be rejected")
+	}
+	if img, err := strategy.GetImage(model.StringToImageRef(registry.host() + "/" + testRepository + "@" + digest)); err != nil || img != nil {
+		t.Errorf("expected partially pulled image not to be returned, got %v, %v", img, err)
+	}
+}
+
+func TestPullImageAuthentication(t *testing.T) {
+	registry := newTestRegistry(t)
+	registry.requireAuth = true
+	registry.addImage(t, "v1", map[string]string{
+		"manifest.yaml":  testManifestYAML,
+		"extension.wasm": fakestrategy.FakeModule,
+	})
+	imageRef := model.StringToImageRef(registry.host() + "/" + testRepository + ":v1")
+	dockerConfig := fmt.Sprintf(`{"auths":{"https://%s/v1/":{"auth":"dXNlcjpzZWNyZXQ="}}}`, registry.host())
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "pull-secret", Namespace: "test"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(dockerConfig)},
+	}
+
+	strategy := newTestStrategy(t, registry, secret)
+	if _, err := strategy.PullImage(imageRef, "test", corev1.PullAlways, nil, "sme", "uid"); err == nil {
+		t.Errorf("expected pull without credentials to fail")
+	}
+	if _, err := strategy.PullImage(imageRef, "test", corev1.PullAlways,
+		[]corev1.LocalObjectReference{{Name: "missing"}}, "sme", "uid"); err == nil {
+		t.Errorf("expected pull with missing pull secret to fail")
+	}
+	img, err := strategy.PullImage(imageRef, "test", corev1.PullAlways, []corev1.LocalObjectReference{{Name: "pull-secret"}}, "sme", "uid")
+	if err != nil {
+		t.Fatalf("failed to pull image using pull secret: %s", err)
+	}
+	expectModule(t, img, fakestrategy.FakeModule)
+
+	// credentials provided using Login() are used if there's no pull secret
+	strategy = newTestStrategy(t, registry)
+	if _, err := strategy.Login(registry.host(), "wrong"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := strategy.PullImage(imageRef, "test", corev1.PullAlways, nil, "sme", "uid"); err == nil {
+		t.Errorf("expected pull with wrong credentials to fail")
+	}
+	strategy.loginCredentials[registry.host()] = &credentials{username: testUsername, password: testPassword}
+	if _, err := strategy.PullImage(imageRef, "test", corev1.PullAlways, nil, "sme", "uid"); err != nil {
+		t.Errorf("failed to pull image using login credentials: %s", err)
+	}
+}
+
+func TestGetSignatures(t *testing.T) {
+	registry := newTestRegistry(t)
+	digest := registry.addImage(t, "v1", map[string]string{
+		"manifest.yaml":  testManifestYAML,
+		"extension.wasm": fakestrategy.FakeModule,
+	})
+	strategy := newTestStrategy(t, registry)
+	imageRef := model.StringToImageRef(registry.host() + "/" + testRepository + ":v1")
+
+	signatures, err := strategy.GetSignatures(imageRef, digest, "test", nil)
+	if err != nil || len(signatures) != 0 {
+		t.Fatalf("expected no signatures for unsigned image, got %v, %v", signatures, err)
+	}
+
+	payload := []byte(`{"critical":{"image":{"docker-manifest-digest":"` + digest + `"}}}`)
+	layer := registry.addBlob("application/vnd.dev.cosign.simplesigning.v1+json", payload)
+	layer.Annotations = map[string]string{cosignSignatureAnnotation: base64.StdEncoding.EncodeToString([]byte("signature"))}
+	unsigned := registry.addBlob("application/vnd.dev.cosign.simplesigning.v1+json", []byte("{}"))
+	registry.addManifest(t, strings.Replace(digest, ":", "-", 1)+".sig", &manifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIManifest,
+		Config:        registry.addBlob("application/vnd.oci.image.config.v1+json", []byte("{}")),
+		Layers:        []descriptor{layer, unsigned},
+	})
+	signatures, err = strategy.GetSignatures(imageRef, digest, "test", nil)
+	if err != nil {
+		t.Fatalf("failed to get signatures: %s", err)
+	}
+	expected := []model.Signature{{Payload: payload, Signature: []byte("signature")}}
+	if diff := cmp.Diff(expected, signatures); diff != "" {
+		t.Errorf("unexpected signatures: %s", diff)
+	}
+
+	if _, err := strategy.GetSignatures(imageRef, "latest", "test", nil); err == nil {
+		t.Errorf("expected invalid digest to be rejected")
+	}
+}
+
+func TestReadFileFromLayers(t *testing.T) {
+	dir := t.TempDir()
+	var layers []cachedLayer
+	for index, files := range []map[string]string{
+		{"manifest.yaml": "old", "extension.wasm": "module", "other": "content"},
+		{"/manifest.yaml": "new", ".wh.extension.wasm": ""},
+	} {
+		layerPath := filepath.Join(dir, fmt.Sprint(index))
+		if err := ioutil.WriteFile(layerPath, newLayer(t, files, index%2 == 0), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		layers = append(layers, cachedLayer{mediaType: "application/vnd.oci.image.layer.v1.tar", path: layerPath})
+	}
+	img := &ociImage{layers: layers}
+	if data, err := img.readFile("./manifest.yaml"); err != nil || string(data) != "new" {
+		t.Errorf("expected file from the top layer, got %q, %v", data, err)
+	}
+	if data, err := img.readFile("other"); err != nil || string(data) != "content" {
+		t.Errorf("expected file from the bottom layer, got %q, %v", data, err)
+	}
+	if _, err := img.readFile("extension.wasm"); err == nil {
+		t.Errorf("expected deleted file not to be found")
+	}
+}
+
+func TestRepository(t *testing.T) {
+	testCases := []struct {
+		image              string
+		expectedRegistry   string
+		expectedRepository string
+	}{
+		{"quay.io/maistra/example:latest", "quay.io", "maistra/example"},
+		{"localhost:5000/a/b/example:1.0", "localhost:5000", "a/b/example"},
+		{"localhost/example:1.0", "localhost", "example"},
+		{"maistra/example:1.0", "docker.io", "maistra/example"},
+	}
+	for _, tc := range testCases {
+		registry, repo := repository(model.StringToImageRef(tc.image))
+		if registry != tc.expectedRegistry || repo != tc.expectedRepository {
+			t.Errorf("unexpected repository for %s: got %s %s, want %s %s",
+				tc.image, registry, repo, tc.expectedRegistry, tc.expectedRepository)
+		}
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:a/b:pull"`)
+	expected := map[string]string{
+		"realm":   "https://auth.docker.io/token",
+		"service": "registry.docker.io",
+		"scope":   "repository:a/b:pull",
+	}
+	if scheme != "Bearer" || !cmp.Equal(params, expected) {
+		t.Errorf("unexpected challenge: %s %v", scheme, params)
+	}
+	if scheme, params = parseChallenge(`Basic realm=registry`); scheme != "Basic" || params["realm"] != "registry" {
+		t.Errorf("unexpected challenge: %s %v", scheme, params)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"istio.io/istio/security/pkg/pki/util"
+	"istio.io/pkg/log"
+)
+
+var certlog = log.RegisterScope("certificate", "Workload certificate", 0)
+
+const (
+	// certificateRetryInterval is the time to wait before retrying a failed
+	// certificate request
+	certificateRetryInterval = 10 * time.Second
+)
+
+// alpnProtocols are negotiated with clients.  Sidecars using ISTIO_MUTUAL
+// offer istio, after which they speak plain HTTP/1.1.  istio-peer-exchange
+// is deliberately not included, as it requires metadata exchange.
+var alpnProtocols = []string{"http/1.1", "istio"}
+
+// CSRSigner signs certificate signing requests, returning the certificate
+// chain, which ends with the root certificate.  It's implemented by the
+// client for istiod's CA.
+type CSRSigner interface {
+	CSRSign(csrPEM []byte, certValidTTLInSec int64) ([]string, error)
+}
+
+// WorkloadCertificate is the certificate identifying MEC in the mesh, which
+// is issued by istiod and renewed once half of its lifetime has passed.
+type WorkloadCertificate struct {
+	signer   CSRSigner
+	identity string
+	ttl      time.Duration
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	roots   *x509.CertPool
+	renewAt time.Time
+}
+
+// NewWorkloadCertificate creates a WorkloadCertificate for the identity, e.g.
+// spiffe://cluster.local/ns/istio-system/sa/mec.
+func NewWorkloadCertificate(signer CSRSigner, identity string, ttl time.Duration) *WorkloadCertificate {
+	return &WorkloadCertificate{
+		signer:   signer,
+		identity: identity,
+		ttl:      ttl,
+	}
+}
+
+// rotate requests a new certificate.
+func (c *WorkloadCertificate) rotate() error {
+	csrPEM, keyPEM, err := util.GenCSR(util.CertOptions{
+		Host:     c.identity,
+		ECSigAlg: util.EcdsaSigAlg,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to generate CSR: %v", err)
+	}
+	chain, err := c.signer.CSRSign(csrPEM, int64(c.ttl.Seconds()))
+	if err != nil {
+		return fmt.Errorf("failed to sign CSR: %v", err)
+	}
+	if len(chain) < 2 {
+		return fmt.Errorf("certificate chain does not include the root certificate")
+	}
+	cert, err := tls.X509KeyPair([]byte(strings.Join(chain[:len(chain)-1], "\n")), keyPEM)
+	if err != nil {
+		return fmt.Errorf("invalid certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("invalid certificate: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(chain[len(chain)-1])) {
+		return fmt.Errorf("invalid root certificate")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cert = &cert
+	c.roots = roots
+	c.renewAt = leaf.NotBefore.Add(leaf.NotAfter.Sub(leaf.NotBefore) / 2)
+	certlog.Infof("Obtained certificate for %s, valid until %s", c.identity, leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// Start obtains the certificate, returning an error if it can't, and renews
+// it until stopChan is closed.
+func (c *WorkloadCertificate) Start(stopChan <-chan struct{}) error {
+	if err := c.rotate(); err != nil {
+		return err
+	}
+	go func() {
+		for {
+			c.mu.RLock()
+			wait := time.Until(c.renewAt)
+			c.mu.RUnlock()
+			select {
+			case <-time.After(wait):
+				if err := c.rotate(); err != nil {
+					certlog.Errorf("failed to renew certificate, retrying in %s: %v", certificateRetryInterval, err)
+					c.mu.Lock()
+					c.renewAt = time.Now().Add(certificateRetryInterval)
+					c.mu.Unlock()
+				}
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// TLSConfig returns the configuration of a server presenting the current
+// certificate, which requires clients to present a certificate issued by the
+// mesh's CA.
+func (c *WorkloadCertificate) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: alpnProtocols,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			c.mu.RLock()
+			defer c.mu.RUnlock()
+			return c.cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c.mu.RLock()
+			defer c.mu.RUnlock()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   alpnProtocols,
+				Certificates: []tls.Certificate{*c.cert},
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				ClientCAs:    c.roots,
+			}, nil
+		},
+	}
+}
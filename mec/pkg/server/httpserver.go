@@ -15,14 +15,14 @@
 package server
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"path"
-	"path/filepath"
-
-	"github.com/google/uuid"
+	"sync"
 
 	"istio.io/pkg/log"
 )
@@ -30,55 +30,126 @@ import (
 var httplog = log.RegisterScope("http", "HTTP Server", 0)
 
 type HTTPServer struct {
-	serveDirectory string
-	mux            *http.ServeMux
-	srv            *http.Server
+	store *ModuleStore
+	mux   *http.ServeMux
+	srv   *http.Server
 }
 
 func (s *HTTPServer) handleRequest(res http.ResponseWriter, req *http.Request) {
-	uuid, err := uuid.Parse(filepath.Base(req.URL.Path))
-	if err != nil {
-		httplog.Errorf("Could not parse request path '%s' as UUID: %s", req.URL.Path, err)
+	sha := path.Base(req.URL.Path)
+	filename := s.store.Path(sha)
+	if filename == "" {
+		httplog.Errorf("Could not parse request path '%s' as SHA256", req.URL.Path)
 		res.WriteHeader(404)
 		return
 	}
-	filename := path.Join(s.serveDirectory, uuid.String())
-	if _, err := os.Stat(filename); err != nil {
-
+	f, err := os.Open(filename)
+	if err != nil {
 		httplog.Errorf("Failed to open file %s: %s", filename, err)
 		res.WriteHeader(404)
 		return
 	}
-	data, err := ioutil.ReadFile(filename)
+	defer f.Close()
+	info, err := f.Stat()
 	if err != nil {
-		httplog.Errorf("Failed to read file %s: %s", filename, err)
+		httplog.Errorf("Failed to stat file %s: %s", filename, err)
 		res.WriteHeader(404)
 		return
 	}
-	res.WriteHeader(200)
-	if _, err := res.Write(data); err != nil {
-		httplog.Errorf("error writing response: %s", err)
-	}
+	// modules are addressed by their contents, so they never change
+	res.Header().Set("ETag", `"`+sha+`"`)
+	res.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	res.Header().Set("Content-Type", "application/wasm")
+	http.ServeContent(res, req, "", info.ModTime(), f)
 }
 
+// Start serves modules until stopChan is closed, using TLS if the server was
+// given a TLS configuration.
 func (s *HTTPServer) Start(stopChan <-chan struct{}) {
 	go func() {
-		if err := s.srv.ListenAndServe(); err != nil {
+		var err error
+		if s.srv.TLSConfig != nil {
+			err = s.srv.ListenAndServeTLS("", "")
+		} else {
+			err = s.srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			httplog.Errorf("error listening and serving: %s", err)
 		}
 	}()
+	go func() {
+		<-stopChan
+		_ = s.srv.Close()
+	}()
 }
 
-func NewHTTPServer(port uint, serveDirectory string) *HTTPServer {
+// NewHTTPServer creates a server for the modules in the store.  If tlsConfig
+// is not nil, modules are served over TLS.
+func NewHTTPServer(port uint, store *ModuleStore, tlsConfig *tls.Config) *HTTPServer {
 	s := &HTTPServer{
-		serveDirectory: serveDirectory,
+		store: store,
 	}
 	s.mux = http.NewServeMux()
 	s.mux.HandleFunc("/", s.handleRequest)
 
 	s.srv = &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: s.mux,
+		Addr:      fmt.Sprintf(":%d", port),
+		Handler:   s.mux,
+		TLSConfig: tlsConfig,
+	}
+	if tlsConfig != nil {
+		s.srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){
+			"istio": serveHTTP1,
+		}
 	}
 	return s
 }
+
+// serveHTTP1 serves HTTP/1.1 on a connection that negotiated a protocol the
+// http.Server doesn't know, which it would otherwise close.
+func serveHTTP1(_ *http.Server, conn *tls.Conn, handler http.Handler) {
+	l := &connListener{conn: plainConn{conn}, closed: make(chan struct{})}
+	srv := &http.Server{
+		Handler: handler,
+		ConnState: func(_ net.Conn, state http.ConnState) {
+			if state == http.StateClosed || state == http.StateHijacked {
+				l.Close()
+			}
+		},
+	}
+	_ = srv.Serve(l)
+}
+
+var errListenerClosed = errors.New("listener closed")
+
+// plainConn hides the *tls.Conn, so the connection isn't checked for a
+// negotiated protocol again.
+type plainConn struct {
+	net.Conn
+}
+
+// connListener returns a single connection, blocking until it's closed.
+type connListener struct {
+	conn      net.Conn
+	accepted  bool
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	if !l.accepted {
+		l.accepted = true
+		return l.conn, nil
+	}
+	<-l.closed
+	return nil, errListenerClosed
+}
+
+func (l *connListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
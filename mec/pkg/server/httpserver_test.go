@@ -15,8 +15,14 @@
 package server
 
 import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"path/filepath"
@@ -24,6 +30,9 @@ import (
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+
+	fakestrategy "istio.io/istio/mec/pkg/pullstrategy/fake"
+	"istio.io/istio/security/pkg/pki/util"
 )
 
 func TestHTTPServer(t *testing.T) {
@@ -35,31 +44,31 @@ func TestHTTPServer(t *testing.T) {
 		expectedStatus int
 	}{
 		{
-			name:           "fail_validUUIDNotFound",
-			requestPath:    "71e7c506-24df-11eb-89f5-482ae3492105",
+			name:           "fail_validSHA256NotFound",
+			requestPath:    "71e7c50624df11eb89f5482ae349210571e7c50624df11eb89f5482ae3492105",
 			expectedStatus: 404,
 		},
 		{
-			name:           "pass_validUUIDFound",
-			requestPath:    "72e7c506-24df-11eb-89f5-482ae3492105",
+			name:           "pass_validSHA256Found",
+			requestPath:    "72e7c50624df11eb89f5482ae349210572e7c50624df11eb89f5482ae3492105",
 			fileContent:    []byte("all good"),
 			expectedStatus: 200,
 		},
 		{
-			name:           "fail_invalidUUID",
+			name:           "fail_invalidSHA256",
 			requestPath:    "73e7c50624df11eb89f5482ae3492105",
 			expectedStatus: 404,
 		},
 		{
 			name:           "fail_ignore_directories",
-			requestPath:    "test/74e7c506-24df-11eb-89f5-482ae3492105",
-			filename:       "test/74e7c506-24df-11eb-89f5-482ae3492105",
+			requestPath:    "test/74e7c50624df11eb89f5482ae349210574e7c50624df11eb89f5482ae3492105",
+			filename:       "test/74e7c50624df11eb89f5482ae349210574e7c50624df11eb89f5482ae3492105",
 			expectedStatus: 404,
 		},
 		{
 			name:           "pass_ignore_directories",
-			requestPath:    "test/asd/75e7c506-24df-11eb-89f5-482ae3492105",
-			filename:       "75e7c506-24df-11eb-89f5-482ae3492105",
+			requestPath:    "test/asd/75e7c50624df11eb89f5482ae349210575e7c50624df11eb89f5482ae3492105",
+			filename:       "75e7c50624df11eb89f5482ae349210575e7c50624df11eb89f5482ae3492105",
 			fileContent:    []byte("test"),
 			expectedStatus: 200,
 		},
@@ -75,7 +84,11 @@ func TestHTTPServer(t *testing.T) {
 		}
 	}()
 
-	server := NewHTTPServer(50505, tmpDir)
+	store, err := NewModuleStore(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewHTTPServer(50505, store, nil)
 	baseURL := "http://127.0.0.1:50505/"
 	stopChan := make(<-chan struct{})
 	server.Start(stopChan)
@@ -122,3 +135,164 @@ func TestHTTPServer(t *testing.T) {
 		})
 	}
 }
+
+func TestHTTPServerCaching(t *testing.T) {
+	store, err := NewModuleStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha, err := store.Add("", writeModule(fakestrategy.FakeModule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(NewHTTPServer(0, store, nil).mux)
+	defer ts.Close()
+
+	get := func(header http.Header) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/"+sha, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+
+	resp, body := get(nil)
+	if resp.StatusCode != http.StatusOK || body != fakestrategy.FakeModule {
+		t.Fatalf("unexpected response: %d %q", resp.StatusCode, body)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag != `"`+sha+`"` {
+		t.Errorf("unexpected ETag: %s", etag)
+	}
+	if resp, _ = get(http.Header{"If-None-Match": {etag}}); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("expected %d for matching ETag, got %d", http.StatusNotModified, resp.StatusCode)
+	}
+	if resp, body = get(http.Header{"Range": {"bytes=1-2"}}); resp.StatusCode != http.StatusPartialContent || body != fakestrategy.FakeModule[1:3] {
+		t.Errorf("unexpected response to range request: %d %q", resp.StatusCode, body)
+	}
+}
+
+// testCA signs CSRs with a self-signed root certificate.
+type testCA struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	key     crypto.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	certPEM, keyPEM, err := util.GenCertKeyFromOptions(util.CertOptions{
+		Org:          "cluster.local",
+		TTL:          time.Hour,
+		IsCA:         true,
+		IsSelfSigned: true,
+		RSAKeySize:   2048,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := util.ParsePemEncodedCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := util.ParsePemEncodedKey(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, certPEM: certPEM, key: key}
+}
+
+func (ca *testCA) CSRSign(csrPEM []byte, ttl int64) ([]string, error) {
+	csr, err := util.ParsePemEncodedCSR(csrPEM)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := util.ExtractIDs(csr.Extensions)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := util.GenCertFromCSR(csr, ca.cert, csr.PublicKey, ca.key, ids, time.Duration(ttl)*time.Second, false)
+	if err != nil {
+		return nil, err
+	}
+	return []string{string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})), string(ca.certPEM)}, nil
+}
+
+func TestHTTPServerTLS(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert := NewWorkloadCertificate(ca, "spiffe://cluster.local/ns/istio-system/sa/mec", time.Hour)
+	clientCert := NewWorkloadCertificate(ca, "spiffe://cluster.local/ns/test/sa/default", time.Hour)
+	stopChan := make(chan struct{})
+	defer close(stopChan)
+	for _, cert := range []*WorkloadCertificate{serverCert, clientCert} {
+		if err := cert.Start(stopChan); err != nil {
+			t.Fatalf("failed to obtain certificate: %s", err)
+		}
+	}
+	if serverCert.renewAt.After(time.Now().Add(31*time.Minute)) || serverCert.renewAt.Before(time.Now().Add(29*time.Minute)) {
+		t.Errorf("expected certificate to be renewed after half of its lifetime, got %s", serverCert.renewAt)
+	}
+
+	store, err := NewModuleStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha, err := store.Add("", writeModule(fakestrategy.FakeModule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = NewHTTPServer(0, store, serverCert.TLSConfig()).srv
+	ts.TLS = ts.Config.TLSConfig
+	ts.StartTLS()
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.certPEM)
+	newClient := func(certs []tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			Certificates: certs,
+			RootCAs:      roots,
+			NextProtos:   []string{"istio-peer-exchange", "istio"},
+			// workload certificates only contain the SPIFFE identity
+			InsecureSkipVerify: true, // nolint: gosec
+			VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+				cert, err := x509.ParseCertificate(rawCerts[0])
+				if err != nil {
+					return err
+				}
+				if _, err := cert.Verify(x509.VerifyOptions{Roots: roots}); err != nil {
+					return err
+				}
+				if len(cert.URIs) != 1 || cert.URIs[0].String() != "spiffe://cluster.local/ns/istio-system/sa/mec" {
+					return fmt.Errorf("unexpected identity %v", cert.URIs)
+				}
+				return nil
+			},
+		}}}
+	}
+
+	resp, err := newClient([]tls.Certificate{*clientCert.cert}).Get(ts.URL + "/" + sha)
+	if err != nil {
+		t.Fatalf("failed to GET module: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.TLS.NegotiatedProtocol != "istio" {
+		t.Errorf("unexpected response: %d, protocol %q", resp.StatusCode, resp.TLS.NegotiatedProtocol)
+	}
+	if _, err := newClient(nil).Get(ts.URL + "/" + sha); err == nil {
+		t.Errorf("expected client without a certificate to be rejected")
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ModuleStore is a content addressed store of wasm modules, named by the hex
+// encoded SHA256 of their contents.  As the name of a module only depends on
+// its contents, replicas sharing the directory, or populating their own from
+// the same images, serve every module under the same URL.
+type ModuleStore struct {
+	dir string
+
+	mu sync.Mutex
+	// modules referenced by each extension, by namespace/name
+	refs map[string]string
+}
+
+func NewModuleStore(dir string) (*ModuleStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create module store directory %s: %v", dir, err)
+	}
+	return &ModuleStore{
+		dir:  dir,
+		refs: map[string]string{},
+	}, nil
+}
+
+// validModuleID returns whether id is a hex encoded SHA256.
+func validModuleID(id string) bool {
+	if len(id) != 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Path returns the file holding the module, or "" if the id is invalid.
+func (s *ModuleStore) Path(sha string) string {
+	if !validModuleID(sha) {
+		return ""
+	}
+	return filepath.Join(s.dir, sha)
+}
+
+// Has returns whether the store contains the module.
+func (s *ModuleStore) Has(sha string) bool {
+	filename := s.Path(sha)
+	if filename == "" {
+		return false
+	}
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
+// Add stores the module written by write to the file it's passed, returning
+// its SHA256.  If expectedSHA256 is set, the module is discarded unless it
+// matches.
+func (s *ModuleStore) Add(expectedSHA256 string, write func(filename string) error) (string, error) {
+	tmp, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp.Name()); err != nil {
+		return "", err
+	}
+	sha, err := generateSHA256(tmp.Name())
+	if err != nil {
+		return "", fmt.Errorf("failed to generate sha256 of wasm module: %v", err)
+	}
+	if expectedSHA256 != "" && sha != expectedSHA256 {
+		return "", fmt.Errorf("wasm module has sha256 %s, expected %s", sha, expectedSHA256)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return "", err
+	}
+	// renaming is atomic, so the module is never served partially written
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, sha)); err != nil {
+		return "", err
+	}
+	return sha, nil
+}
+
+// Reference records that the extension uses the module, releasing the module
+// it used previously.
+func (s *ModuleStore) Reference(extension, sha string) {
+	s.mu.Lock()
+	previous := s.refs[extension]
+	s.refs[extension] = sha
+	s.mu.Unlock()
+	if previous != sha {
+		s.release(previous)
+	}
+}
+
+// Release records that the extension no longer uses a module.  Modules are
+// removed once no extension uses them.
+func (s *ModuleStore) Release(extension string) {
+	s.mu.Lock()
+	sha, ok := s.refs[extension]
+	delete(s.refs, extension)
+	s.mu.Unlock()
+	if ok {
+		s.release(sha)
+	}
+}
+
+func (s *ModuleStore) release(sha string) {
+	if sha == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ref := range s.refs {
+		if ref == sha {
+			return
+		}
+	}
+	if filename := s.Path(sha); filename != "" {
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			workerlog.Errorf("failed to delete wasm module %s: %v", sha, err)
+		}
+	}
+}
+
+// Sweep removes the modules that are neither referenced by an extension nor
+// in inUse.  References are only kept in memory, so the modules of extensions
+// deleted while no worker was running would otherwise never be removed.
+func (s *ModuleStore) Sweep(inUse map[string]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	referenced := map[string]bool{}
+	for _, sha := range s.refs {
+		referenced[sha] = true
+	}
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		workerlog.Errorf("failed to list wasm modules: %v", err)
+		return
+	}
+	for _, file := range files {
+		sha := file.Name()
+		if !validModuleID(sha) || referenced[sha] || inUse[sha] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, sha)); err != nil && !os.IsNotExist(err) {
+			workerlog.Errorf("failed to delete wasm module %s: %v", sha, err)
+			continue
+		}
+		workerlog.Infof("Removed unused wasm module %s", sha)
+	}
+}
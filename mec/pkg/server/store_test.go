@@ -0,0 +1,114 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	fakestrategy "istio.io/istio/mec/pkg/pullstrategy/fake"
+)
+
+func writeModule(content string) func(string) error {
+	return func(filename string) error {
+		return ioutil.WriteFile(filename, []byte(content), 0o600)
+	}
+}
+
+func TestModuleStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "modules")
+	store, err := NewModuleStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sha, err := store.Add("", writeModule(fakestrategy.FakeModule))
+	if err != nil {
+		t.Fatalf("failed to add module: %s", err)
+	}
+	if sha != fakestrategy.FakeModuleSHA256 || !store.Has(sha) {
+		t.Fatalf("expected module to be stored as %s, got %s", fakestrategy.FakeModuleSHA256, sha)
+	}
+	if _, err := store.Add(fakestrategy.FakeModuleSHA256, writeModule(fakestrategy.FakeModule2)); err == nil || store.Has(fakestrategy.FakeModule2SHA256) {
+		t.Fatalf("expected module not matching the expected SHA256 to be discarded")
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected temporary files to be removed, found %d files", len(files))
+	}
+
+	for _, id := range []string{"", "../" + sha, sha[1:], "G" + sha[1:]} {
+		if store.Path(id) != "" {
+			t.Errorf("expected %q to be rejected", id)
+		}
+	}
+
+	// modules are removed when the last extension using them releases them
+	store.Reference("test/a", sha)
+	store.Reference("test/b", sha)
+	store.Release("test/a")
+	if !store.Has(sha) {
+		t.Fatalf("expected module used by test/b to be kept")
+	}
+	if _, err := store.Add("", writeModule(fakestrategy.FakeModule2)); err != nil {
+		t.Fatal(err)
+	}
+	store.Reference("test/b", fakestrategy.FakeModule2SHA256)
+	if store.Has(sha) {
+		t.Errorf("expected module replaced by test/b to be removed")
+	}
+	store.Release("test/b")
+	if store.Has(fakestrategy.FakeModule2SHA256) {
+		t.Errorf("expected module released by test/b to be removed")
+	}
+}
+
+func TestModuleStoreSweep(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "modules")
+	store, err := NewModuleStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Add("", writeModule(fakestrategy.FakeModule)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Add("", writeModule(fakestrategy.FakeModule2)); err != nil {
+		t.Fatal(err)
+	}
+
+	// a restarted store has no references, so modules in use must be kept
+	restarted, err := NewModuleStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted.Sweep(map[string]bool{fakestrategy.FakeModuleSHA256: true})
+	if !restarted.Has(fakestrategy.FakeModuleSHA256) {
+		t.Errorf("expected module in use to be kept")
+	}
+	if restarted.Has(fakestrategy.FakeModule2SHA256) {
+		t.Errorf("expected unused module to be removed")
+	}
+
+	// referenced modules are kept, even if not in use yet
+	restarted.Reference("test/a", fakestrategy.FakeModuleSHA256)
+	restarted.Sweep(nil)
+	if !restarted.Has(fakestrategy.FakeModuleSHA256) {
+		t.Errorf("expected referenced module to be kept")
+	}
+}
@@ -17,22 +17,26 @@ package server
 import (
 	"context"
 	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/url"
 	"os"
-	"path"
+	"reflect"
 	"strings"
 	"sync"
 
-	"github.com/google/uuid"
+	"go.uber.org/atomic"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	v1client "maistra.io/api/client/versioned/typed/core/v1"
 	v1 "maistra.io/api/core/v1"
 
 	"istio.io/istio/mec/pkg/model"
+	"istio.io/istio/mec/pkg/signature"
+	maistramodel "istio.io/istio/pkg/servicemesh/model"
 	"istio.io/pkg/log"
 )
 
@@ -65,32 +69,44 @@ func (op ExtensionEventOperation) String() string {
 }
 
 type Worker struct {
-	baseURL        string
-	serveDirectory string
+	baseURL string
+	store   *ModuleStore
 
 	pullStrategy model.ImagePullStrategy
+	// verifier, if set, requires images to be signed by a trusted key
+	verifier *signature.Verifier
+
+	// leading is set while this replica is the leader, which pulls images and
+	// updates the status of extensions
+	leading atomic.Bool
 
 	client       v1client.CoreV1Interface
 	errorChannel chan error
 	stopChan     <-chan struct{}
 	Queue        chan ExtensionEvent
+	// sweep receives the extensions whose modules are kept when sweeping the
+	// store
+	sweep chan []*v1.ServiceMeshExtension
 
 	mut sync.Mutex
 }
 
-func NewWorker(config *rest.Config, pullStrategy model.ImagePullStrategy, baseURL, serveDirectory string, errorChannel chan error) (*Worker, error) {
+func NewWorker(config *rest.Config, pullStrategy model.ImagePullStrategy, verifier *signature.Verifier,
+	baseURL string, store *ModuleStore, errorChannel chan error) (*Worker, error) {
 	client, err := v1client.NewForConfig(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create client from config: %v", err)
 	}
 
 	return &Worker{
-		client:         client,
-		Queue:          make(chan ExtensionEvent, 100),
-		pullStrategy:   pullStrategy,
-		baseURL:        baseURL,
-		serveDirectory: serveDirectory,
-		errorChannel:   errorChannel,
+		client:       client,
+		Queue:        make(chan ExtensionEvent, 100),
+		sweep:        make(chan []*v1.ServiceMeshExtension),
+		pullStrategy: pullStrategy,
+		verifier:     verifier,
+		baseURL:      baseURL,
+		store:        store,
+		errorChannel: errorChannel,
 	}, nil
 }
 
@@ -99,18 +115,24 @@ func (w *Worker) processEvent(event ExtensionEvent) error {
 	workerlog.Debugf("Event %s arrived for %s/%s", event.Operation, extension.Namespace, extension.Name)
 
 	if event.Operation == ExtensionEventOperationDelete {
-		if len(extension.Status.Deployment.URL) > len(w.baseURL) {
-			id := extension.Status.Deployment.URL[len(w.baseURL):]
-			filename := path.Join(w.serveDirectory, id)
-			os.Remove(filename)
-		}
+		w.store.Release(extensionKey(extension))
+		w.store.Release(extensionKey(extension) + stableModuleSuffix)
 		return nil
 	}
 
+	if !w.leading.Load() {
+		return w.hydrate(extension)
+	}
+
 	if event.Operation == ExtensionEventOperationUpdate &&
 		extension.Status.Deployment.Ready &&
-		extension.Status.ObservedGeneration == extension.Generation {
+		extension.Status.ObservedGeneration == extension.Generation &&
+		w.store.Has(extension.Status.Deployment.SHA256) {
 		workerlog.Debug("Skipping update, current extension is up to date.")
+		if err := w.reconcileRollout(extension, extension.Status.Deployment.SHA256); err != nil {
+			return err
+		}
+		w.store.Reference(extensionKey(extension), extension.Status.Deployment.SHA256)
 		return nil
 	}
 
@@ -158,51 +180,24 @@ func (w *Worker) processEvent(event ExtensionEvent) error {
 			return fmt.Errorf(message)
 		}
 	}
-	var id string
-	containerImageChanged := false
 
-	if strings.HasPrefix(extension.Status.Deployment.URL, w.baseURL) {
-		workerlog.Debug("Image is already in the http server, retrieving its ID")
-		url, err := url.Parse(extension.Status.Deployment.URL)
-		if err != nil {
-			message := fmt.Sprintf("failed to parse status.deployment.url: %v", err)
-			if err := w.updateStatusNotReady(extension, message); err != nil {
-				workerlog.Error(err)
-			}
-			return fmt.Errorf(message)
+	verification, err := w.verifyImage(imageRef, img, extension)
+	if err != nil {
+		message := fmt.Sprintf("image signature verification failed: %v", err)
+		w.store.Release(extensionKey(extension))
+		if err := w.updateStatusNotReady(extension, message); err != nil {
+			workerlog.Error(err)
 		}
-		id = path.Base(url.Path)
-		workerlog.Debugf("Got ID = %s", id)
+		return fmt.Errorf(message)
 	}
 
 	workerlog.Debugf("Checking if SHA's match: %s - %s", img.SHA256(), extension.Status.Deployment.ContainerSHA256)
-	if img.SHA256() != extension.Status.Deployment.ContainerSHA256 {
-		workerlog.Debug("They differ, setting containerImageChanged = true")
-		// if container sha changed, re-generate UUID
-		containerImageChanged = true
-		if id != "" {
-			err := os.Remove(path.Join(w.serveDirectory, id))
-			if err != nil {
-				workerlog.Errorf("failed to delete existing wasm module: %s", err)
-			}
-		}
+	containerImageChanged := img.SHA256() != extension.Status.Deployment.ContainerSHA256
 
-		wasmUUID, err := uuid.NewRandom()
-		if err != nil {
-			message := fmt.Sprintf("failed to generate new UUID: %v", err)
-			if err := w.updateStatusNotReady(extension, message); err != nil {
-				workerlog.Error(err)
-			}
-			return fmt.Errorf(message)
-		}
-		id = wasmUUID.String()
-		workerlog.Debugf("Created a new id for this image: %s", id)
-	}
-
-	filename := path.Join(w.serveDirectory, id)
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		workerlog.Debugf("Copying the extension to the web server location: %s", filename)
-		err = img.CopyWasmModule(filename)
+	sha := extension.Status.Deployment.SHA256
+	if containerImageChanged || !w.store.Has(sha) {
+		workerlog.Debugf("Copying the extension of image %s to the module store", imageRef.String())
+		sha, err = w.store.Add("", img.CopyWasmModule)
 		if err != nil {
 			message := fmt.Sprintf("failed to extract wasm module: %v", err)
 			if err := w.updateStatusNotReady(extension, message); err != nil {
@@ -211,20 +206,15 @@ func (w *Worker) processEvent(event ExtensionEvent) error {
 			return fmt.Errorf(message)
 		}
 	}
-
-	sha, err := generateSHA256(filename)
-	if err != nil {
-		message := fmt.Sprintf("failed to generate sha256 of wasm module: %v", err)
-		if err := w.updateStatusNotReady(extension, message); err != nil {
-			workerlog.Error(err)
-		}
-		return fmt.Errorf(message)
+	if err := w.reconcileRollout(extension, sha); err != nil {
+		return err
 	}
+	w.store.Reference(extensionKey(extension), sha)
 	workerlog.Debugf("WASM module SHA256 is %s", sha)
 
-	filePath, err := url.Parse(id)
+	filePath, err := url.Parse(sha)
 	if err != nil {
-		message := fmt.Sprintf("failed to parse new UUID %q as URL path: %v", id, err)
+		message := fmt.Sprintf("failed to parse module SHA256 %q as URL path: %v", sha, err)
 		if err := w.updateStatusNotReady(extension, message); err != nil {
 			workerlog.Error(err)
 		}
@@ -238,12 +228,15 @@ func (w *Worker) processEvent(event ExtensionEvent) error {
 		}
 		return fmt.Errorf(message)
 	}
+	moduleURL := baseURL.ResolveReference(filePath).String()
+	// status written by previous versions refers to modules by a random UUID
+	urlChanged := moduleURL != extension.Status.Deployment.URL
 
 	extension.Status.Deployment.SHA256 = sha
 	extension.Status.Deployment.ContainerSHA256 = img.SHA256()
-	extension.Status.Deployment.URL = baseURL.ResolveReference(filePath).String()
+	extension.Status.Deployment.URL = moduleURL
 	extension.Status.Deployment.Ready = true
-	extension.Status.Deployment.Message = ""
+	extension.Status.Deployment.Message = verification
 
 	manifest := img.GetManifest()
 
@@ -260,7 +253,7 @@ func (w *Worker) processEvent(event ExtensionEvent) error {
 	}
 
 	// TODO(jwendell): Is this necessary?
-	if !containerImageChanged && extension.Generation > 0 && extension.Status.ObservedGeneration == extension.Generation {
+	if !containerImageChanged && !urlChanged && extension.Generation > 0 && extension.Status.ObservedGeneration == extension.Generation {
 		workerlog.Debug("Skipping status update")
 		return nil
 	}
@@ -269,6 +262,217 @@ func (w *Worker) processEvent(event ExtensionEvent) error {
 	return w.updateStatus(extension)
 }
 
+// hydrate adds the module of a ready extension to the store, if it's
+// missing.  Only the leader updates the status of extensions, so the other
+// replicas restore the module recorded in the status, pulling the image if
+// necessary, so they can serve it if the leader is unavailable.
+func (w *Worker) hydrate(extension *v1.ServiceMeshExtension) error {
+	deployment := extension.Status.Deployment
+	if !deployment.Ready || deployment.SHA256 == "" {
+		return nil
+	}
+	key := extensionKey(extension)
+	if stable, err := maistramodel.StableModule(extension); err != nil || stable == nil {
+		w.store.Release(key + stableModuleSuffix)
+	} else if err := w.restoreModule(extension, key+stableModuleSuffix, stable.SHA256, stable.ContainerSHA256); err != nil {
+		workerlog.Error(err)
+	}
+	return w.restoreModule(extension, key, deployment.SHA256, deployment.ContainerSHA256)
+}
+
+// restoreModule adds the module with the given SHA256, which was extracted
+// from the extension's image with the given digest, to the store if it's
+// missing, and records that key uses it.
+func (w *Worker) restoreModule(extension *v1.ServiceMeshExtension, key, sha, containerSHA256 string) error {
+	if w.store.Has(sha) {
+		w.store.Reference(key, sha)
+		return nil
+	}
+	imageRef := model.StringToImageRef(extension.Spec.Image)
+	if imageRef == nil {
+		return fmt.Errorf("failed to parse spec.image of extension %s: %q", key, extension.Spec.Image)
+	}
+
+	var img model.Image
+	if containerSHA := strings.TrimPrefix(containerSHA256, "sha256:"); containerSHA != "" {
+		pinned := *imageRef
+		pinned.Tag, pinned.SHA256 = "", containerSHA
+		img, _ = w.pullStrategy.GetImage(&pinned)
+	}
+	if img == nil {
+		var err error
+		img, err = w.pullStrategy.PullImage(
+			imageRef,
+			extension.Namespace,
+			extension.Spec.ImagePullPolicy,
+			extension.Spec.ImagePullSecrets,
+			extension.Name,
+			extension.UID)
+		if err != nil {
+			return fmt.Errorf("failed to pull image %q to restore module of extension %s: %v", imageRef.String(), key, err)
+		}
+	}
+	// the module is only stored if it's the one the leader verified
+	if _, err := w.store.Add(sha, img.CopyWasmModule); err != nil {
+		return fmt.Errorf("failed to restore module of extension %s: %v", key, err)
+	}
+	w.store.Reference(key, sha)
+	workerlog.Infof("Restored wasm module %s of extension %s", sha, key)
+	return nil
+}
+
+// reconcileRollout updates the annotations tracking the staged rollout of
+// the extension's module, which is replaced by the one with the given
+// SHA256, and keeps the stable module in the store until the rollout
+// completes.  A module replacing a ready one is rolled out if the extension
+// has a RolloutPercentageAnnotation below 100.
+func (w *Worker) reconcileRollout(extension *v1.ServiceMeshExtension, sha string) error {
+	key := extensionKey(extension)
+	percentage, err := maistramodel.RolloutPercentage(extension)
+	if err != nil {
+		workerlog.Warnf("rolling out module of extension %s to all proxies: %v", key, err)
+		percentage = 100
+	}
+	stable, err := maistramodel.StableModule(extension)
+	if err != nil {
+		workerlog.Warnf("discarding stable module of extension %s: %v", key, err)
+		stable = nil
+	}
+	_, halted := extension.Annotations[maistramodel.RolloutHaltedAnnotation]
+
+	previous := extension.Status.Deployment
+	if previous.Ready && previous.SHA256 != "" && previous.SHA256 != sha {
+		// a new module is rolled out afresh, still against the stable module
+		// if the previous one was being rolled out
+		halted = false
+		if stable == nil && percentage < 100 {
+			stable = &maistramodel.Module{URL: previous.URL, SHA256: previous.SHA256, ContainerSHA256: previous.ContainerSHA256}
+		}
+	}
+	if stable != nil && (stable.SHA256 == sha || percentage == 100 && !halted) {
+		// the rollout completed, or the stable module was restored
+		stable = nil
+	}
+
+	annotations := map[string]string{}
+	for name, value := range extension.Annotations {
+		annotations[name] = value
+	}
+	delete(annotations, maistramodel.StableModuleAnnotation)
+	if stable == nil {
+		w.store.Release(key + stableModuleSuffix)
+		delete(annotations, maistramodel.RolloutHaltedAnnotation)
+	} else {
+		value, err := json.Marshal(stable)
+		if err != nil {
+			return fmt.Errorf("failed to record stable module of extension %s: %v", key, err)
+		}
+		annotations[maistramodel.StableModuleAnnotation] = string(value)
+		if !halted {
+			delete(annotations, maistramodel.RolloutHaltedAnnotation)
+		}
+		if err := w.restoreModule(extension, key+stableModuleSuffix, stable.SHA256, stable.ContainerSHA256); err != nil {
+			workerlog.Error(err)
+		}
+	}
+	if reflect.DeepEqual(annotations, extension.Annotations) || len(annotations) == 0 && len(extension.Annotations) == 0 {
+		return nil
+	}
+
+	workerlog.Debugf("Updating rollout annotations of extension %s", key)
+	updated := extension.DeepCopy()
+	updated.Annotations = annotations
+	updated, err = w.client.ServiceMeshExtensions(extension.Namespace).Update(context.TODO(), updated, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to update rollout annotations of extension %s: %v", key, err)
+	}
+	extension.Annotations = updated.Annotations
+	extension.ResourceVersion = updated.ResourceVersion
+	return nil
+}
+
+// Lead makes the worker responsible for pulling images and updating the
+// status of extensions until stop is closed.  Existing extensions are
+// reconciled first, as the status they were given by the previous leader may
+// refer to modules this replica doesn't have.
+func (w *Worker) Lead(stop <-chan struct{}, extensions func() []*v1.ServiceMeshExtension) {
+	workerlog.Info("Leading, reconciling existing extensions")
+	w.leading.Store(true)
+	defer w.leading.Store(false)
+	for _, extension := range extensions() {
+		select {
+		case w.Queue <- ExtensionEvent{Extension: extension.DeepCopy(), Operation: ExtensionEventOperationAdd}:
+		case <-stop:
+			return
+		}
+	}
+	<-stop
+	workerlog.Info("No longer leading")
+}
+
+// SweepModules removes the modules that are not used by any extension from the
+// store, once hasSynced returns true.  The modules in use are those recorded in
+// the status of the extensions, so modules this replica doesn't reference yet
+// are kept.
+func (w *Worker) SweepModules(stop <-chan struct{}, hasSynced cache.InformerSynced, extensions func() []*v1.ServiceMeshExtension) {
+	if !cache.WaitForCacheSync(stop, hasSynced) {
+		return
+	}
+	select {
+	case w.sweep <- extensions():
+	case <-stop:
+	}
+}
+
+// modulesInUse returns the modules recorded in the status of the extensions,
+// including the stable modules of the extensions being rolled out.
+func modulesInUse(extensions []*v1.ServiceMeshExtension) map[string]bool {
+	inUse := map[string]bool{}
+	for _, extension := range extensions {
+		if sha := extension.Status.Deployment.SHA256; sha != "" {
+			inUse[sha] = true
+		}
+		if stable, err := maistramodel.StableModule(extension); err == nil && stable != nil {
+			inUse[stable.SHA256] = true
+		}
+	}
+	return inUse
+}
+
+// stableModuleSuffix is appended to the key of an extension to reference the
+// module replaced by the one being rolled out
+const stableModuleSuffix = "#stable"
+
+func extensionKey(extension *v1.ServiceMeshExtension) string {
+	return extension.Namespace + "/" + extension.Name
+}
+
+// verifyImage checks the signatures of the image, if the worker requires
+// images to be signed, returning a description of the result.
+func (w *Worker) verifyImage(imageRef *model.ImageRef, img model.Image, extension *v1.ServiceMeshExtension) (string, error) {
+	if w.verifier == nil {
+		return "", nil
+	}
+	fetcher, ok := w.pullStrategy.(model.SignatureFetcher)
+	if !ok {
+		return "", fmt.Errorf("the pull strategy does not support retrieving signatures")
+	}
+	digest := img.SHA256()
+	if !strings.HasPrefix(digest, "sha256:") {
+		digest = "sha256:" + digest
+	}
+	signatures, err := fetcher.GetSignatures(imageRef, digest, extension.Namespace, extension.Spec.ImagePullSecrets)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve signatures: %v", err)
+	}
+	key, err := w.verifier.Verify(imageRef.Hub+"/"+imageRef.Repository, digest, signatures)
+	if err != nil {
+		return "", err
+	}
+	workerlog.Debugf("Image %s@%s is signed with key %s", imageRef, digest, key)
+	return fmt.Sprintf("image signature verified using key %s", key), nil
+}
+
 func (w *Worker) updateStatus(extension *v1.ServiceMeshExtension) error {
 	workerlog.Debugf("Updating extension status with: %+v", extension.Status)
 	if _, err := w.client.ServiceMeshExtensions(extension.Namespace).UpdateStatus(context.TODO(), extension, metav1.UpdateOptions{}); err != nil {
@@ -305,6 +509,10 @@ func (w *Worker) Start(stopChan <-chan struct{}) {
 						go func() { w.errorChannel <- err }()
 					}
 				}
+			case extensions := <-w.sweep:
+				// modules are only added by processEvent(), so none is
+				// removed between being added and being referenced
+				w.store.Sweep(modulesInUse(extensions))
 			case <-w.stopChan:
 				workerlog.Info("Stopping worker")
 				return
@@ -16,6 +16,11 @@ package server
 
 import (
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
 	"fmt"
 	"io/ioutil"
 	"net/url"
@@ -27,12 +32,14 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
-	"github.com/google/uuid"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"maistra.io/api/client/versioned/fake"
 	v1 "maistra.io/api/core/v1"
 
+	"istio.io/istio/mec/pkg/model"
 	fakestrategy "istio.io/istio/mec/pkg/pullstrategy/fake"
+	"istio.io/istio/mec/pkg/signature"
+	maistramodel "istio.io/istio/pkg/servicemesh/model"
 )
 
 const (
@@ -260,7 +267,7 @@ func TestWorker(t *testing.T) {
 				if fmt.Sprintf("%s://%s", url.Scheme, url.Host) != baseURL {
 					t.Fatalf("generated base URL path is invalid: %s", updatedExtension.Status.Deployment.URL)
 				}
-				if _, err := uuid.Parse(strings.TrimLeft(url.Path, "/")); err != nil {
+				if strings.TrimLeft(url.Path, "/") != updatedExtension.Status.Deployment.SHA256 {
 					t.Fatalf("generated URL path is invalid: %s", updatedExtension.Status.Deployment.URL)
 				}
 			}
@@ -268,16 +275,343 @@ func TestWorker(t *testing.T) {
 	}
 }
 
+func TestWorkerSignatureVerification(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := signature.NewVerifier(map[string]crypto.PublicKey{"cosign.pub": &key.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte(`{"critical":{"identity":{"docker-reference":"quay.io/maistra/example"},` +
+		`"image":{"docker-manifest-digest":"` + fakestrategy.FakeContainerSHA256 + `"},"type":"cosign container image signature"}}`)
+	hash := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name           string
+		signatures     map[string][]model.Signature
+		expectedStatus v1.ServiceMeshExtensionStatus
+		expectedError  bool
+	}{
+		{
+			name:       "signed",
+			signatures: map[string][]model.Signature{fakestrategy.FakeContainerSHA256: {{Payload: payload, Signature: sig}}},
+			expectedStatus: v1.ServiceMeshExtensionStatus{
+				Phase:    fakestrategy.FakeManifest.Phase,
+				Priority: fakestrategy.FakeManifest.Priority,
+				Deployment: v1.DeploymentStatus{
+					Ready:           true,
+					ContainerSHA256: fakestrategy.FakeContainerSHA256,
+					SHA256:          fakestrategy.FakeModuleSHA256,
+					Message:         "image signature verified using key cosign.pub",
+				},
+				ObservedGeneration: 1,
+			},
+		},
+		{
+			name: "unsigned",
+			expectedStatus: v1.ServiceMeshExtensionStatus{
+				Deployment: v1.DeploymentStatus{
+					Message: "image signature verification failed: image quay.io/maistra/example@" + fakestrategy.FakeContainerSHA256 + " is not signed",
+				},
+			},
+			expectedError: true,
+		},
+		{
+			name:       "invalid signature",
+			signatures: map[string][]model.Signature{fakestrategy.FakeContainerSHA256: {{Payload: payload, Signature: []byte("invalid")}}},
+			expectedStatus: v1.ServiceMeshExtensionStatus{
+				Deployment: v1.DeploymentStatus{
+					Message: "image signature verification failed: no valid signature found for image quay.io/maistra/example@" +
+						fakestrategy.FakeContainerSHA256 + ": signature does not match any trusted key",
+				},
+			},
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			w := createWorker(t.TempDir(), clientset)
+			w.pullStrategy = &fakestrategy.PullStrategy{Signatures: tc.signatures}
+			w.verifier = verifier
+			extension := &v1.ServiceMeshExtension{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "test",
+					Namespace:  "test",
+					Generation: 1,
+				},
+				Spec: v1.ServiceMeshExtensionSpec{
+					Image: "quay.io/maistra/example:latest",
+				},
+			}
+			w.client.ServiceMeshExtensions(extension.Namespace).Create(context.TODO(), extension, metav1.CreateOptions{})
+
+			err := w.processEvent(ExtensionEvent{Extension: extension, Operation: ExtensionEventOperationAdd})
+			if tc.expectedError && err == nil {
+				t.Fatalf("expected error but got success")
+			}
+			if !tc.expectedError && err != nil {
+				t.Fatalf("expected success but got error: %v", err)
+			}
+			updatedExtension, err := w.client.ServiceMeshExtensions(extension.Namespace).Get(context.TODO(), extension.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to Get() extension: %s", err)
+			}
+			if diff := cmp.Diff(tc.expectedStatus, updatedExtension.Status, cmpopts.IgnoreFields(v1.DeploymentStatus{}, "URL")); diff != "" {
+				t.Fatalf("comparison failed -got +want: %s", diff)
+			}
+			files, err := ioutil.ReadDir(w.store.dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.expectedError && len(files) > 0 {
+				t.Errorf("expected module of unverified image not to be served")
+			}
+		})
+	}
+}
+
+func TestWorkerHydrate(t *testing.T) {
+	testCases := []struct {
+		name          string
+		status        v1.DeploymentStatus
+		expectedError bool
+		expectStored  bool
+	}{
+		{
+			name: "not ready",
+		},
+		{
+			name: "ready",
+			status: v1.DeploymentStatus{
+				Ready:           true,
+				ContainerSHA256: fakestrategy.FakeContainerSHA256,
+				SHA256:          fakestrategy.FakeModuleSHA256,
+				URL:             baseURL + "/" + fakestrategy.FakeModuleSHA256,
+			},
+			expectStored: true,
+		},
+		{
+			name: "image changed",
+			status: v1.DeploymentStatus{
+				Ready:           true,
+				ContainerSHA256: fakestrategy.FakeContainer2SHA256,
+				SHA256:          fakestrategy.FakeModule2SHA256,
+				URL:             baseURL + "/" + fakestrategy.FakeModule2SHA256,
+			},
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			w := createWorker(t.TempDir(), clientset)
+			w.leading.Store(false)
+			extension := &v1.ServiceMeshExtension{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "test",
+					Namespace:  "test",
+					Generation: 1,
+				},
+				Spec: v1.ServiceMeshExtensionSpec{
+					Image: "docker.io/test/test:latest",
+				},
+				Status: v1.ServiceMeshExtensionStatus{
+					Deployment:         tc.status,
+					ObservedGeneration: 1,
+				},
+			}
+			w.client.ServiceMeshExtensions(extension.Namespace).Create(context.TODO(), extension, metav1.CreateOptions{})
+
+			err := w.processEvent(ExtensionEvent{Extension: extension.DeepCopy(), Operation: ExtensionEventOperationAdd})
+			if tc.expectedError && err == nil {
+				t.Fatalf("expected error but got success")
+			}
+			if !tc.expectedError && err != nil {
+				t.Fatalf("expected success but got error: %v", err)
+			}
+			if tc.expectStored != w.store.Has(tc.status.SHA256) {
+				t.Errorf("expected module to be stored: %t", tc.expectStored)
+			}
+			// followers never update the status
+			updatedExtension, err := w.client.ServiceMeshExtensions(extension.Namespace).Get(context.TODO(), extension.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to Get() extension: %s", err)
+			}
+			if diff := cmp.Diff(extension.Status, updatedExtension.Status); diff != "" {
+				t.Errorf("unexpected status update: %s", diff)
+			}
+
+			err = w.processEvent(ExtensionEvent{Extension: extension.DeepCopy(), Operation: ExtensionEventOperationDelete})
+			if err != nil || w.store.Has(tc.status.SHA256) {
+				t.Errorf("expected module to be removed with the extension: %v", err)
+			}
+		})
+	}
+}
+
+func TestWorkerRollout(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	w := createWorker(t.TempDir(), clientset)
+	extension := &v1.ServiceMeshExtension{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Namespace:   "test",
+			Generation:  1,
+			Annotations: map[string]string{maistramodel.RolloutPercentageAnnotation: "10"},
+		},
+		Spec: v1.ServiceMeshExtensionSpec{
+			Image: "docker.io/test/test:latest",
+		},
+	}
+	w.client.ServiceMeshExtensions(extension.Namespace).Create(context.TODO(), extension, metav1.CreateOptions{})
+
+	update := func(modify func(*v1.ServiceMeshExtension)) *v1.ServiceMeshExtension {
+		t.Helper()
+		current, err := w.client.ServiceMeshExtensions(extension.Namespace).Get(context.TODO(), extension.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to Get() extension: %s", err)
+		}
+		modify(current)
+		if current, err = w.client.ServiceMeshExtensions(extension.Namespace).Update(context.TODO(), current, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("failed to Update() extension: %s", err)
+		}
+		if err := w.processEvent(ExtensionEvent{Extension: current.DeepCopy(), Operation: ExtensionEventOperationUpdate}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		updated, err := w.client.ServiceMeshExtensions(extension.Namespace).Get(context.TODO(), extension.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to Get() extension: %s", err)
+		}
+		return updated
+	}
+
+	// the first module has nothing to be rolled out against
+	updated := update(func(*v1.ServiceMeshExtension) {})
+	if _, ok := updated.Annotations[maistramodel.StableModuleAnnotation]; ok || !updated.Status.Deployment.Ready {
+		t.Fatalf("expected first module to be deployed without rollout, got %v", updated)
+	}
+
+	updated = update(func(e *v1.ServiceMeshExtension) {
+		e.Generation = 2
+		e.Spec.Image = "docker.io/other/test:latest"
+	})
+	stable, err := maistramodel.StableModule(updated)
+	if err != nil || stable == nil || stable.SHA256 != fakestrategy.FakeModuleSHA256 || stable.ContainerSHA256 != fakestrategy.FakeContainerSHA256 {
+		t.Fatalf("expected replaced module to be recorded as stable, got %v, %v", stable, err)
+	}
+	if updated.Status.Deployment.SHA256 != fakestrategy.FakeModule2SHA256 {
+		t.Fatalf("expected new module to be deployed, got %s", updated.Status.Deployment.SHA256)
+	}
+	if !w.store.Has(fakestrategy.FakeModuleSHA256) || !w.store.Has(fakestrategy.FakeModule2SHA256) {
+		t.Fatalf("expected both modules to be served during the rollout")
+	}
+
+	// a halted rollout keeps the stable module even when completed
+	updated = update(func(e *v1.ServiceMeshExtension) {
+		e.Annotations[maistramodel.RolloutHaltedAnnotation] = "failed to load wasm module"
+		e.Annotations[maistramodel.RolloutPercentageAnnotation] = "100"
+	})
+	if _, ok := updated.Annotations[maistramodel.StableModuleAnnotation]; !ok || !w.store.Has(fakestrategy.FakeModuleSHA256) {
+		t.Fatalf("expected stable module to be kept while the rollout is halted")
+	}
+
+	updated = update(func(e *v1.ServiceMeshExtension) {
+		delete(e.Annotations, maistramodel.RolloutHaltedAnnotation)
+	})
+	if _, ok := updated.Annotations[maistramodel.StableModuleAnnotation]; ok || w.store.Has(fakestrategy.FakeModuleSHA256) {
+		t.Errorf("expected stable module to be released once the rollout completed")
+	}
+	if !w.store.Has(fakestrategy.FakeModule2SHA256) {
+		t.Errorf("expected new module to be kept")
+	}
+}
+
+func TestWorkerLead(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	w := createWorker(t.TempDir(), clientset)
+	w.leading.Store(false)
+	// the status was written by a previous leader, whose module store is gone
+	extension := &v1.ServiceMeshExtension{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "test",
+			Namespace:  "test",
+			Generation: 1,
+		},
+		Spec: v1.ServiceMeshExtensionSpec{
+			Image: "docker.io/test/test:latest",
+		},
+		Status: v1.ServiceMeshExtensionStatus{
+			Phase:    fakestrategy.FakeManifest.Phase,
+			Priority: fakestrategy.FakeManifest.Priority,
+			Deployment: v1.DeploymentStatus{
+				Ready:           true,
+				ContainerSHA256: fakestrategy.FakeContainerSHA256,
+				SHA256:          fakestrategy.FakeModuleSHA256,
+				URL:             baseURL + "/71e7c506-24df-11eb-89f5-482ae3492105",
+			},
+			ObservedGeneration: 1,
+		},
+	}
+	w.client.ServiceMeshExtensions(extension.Namespace).Create(context.TODO(), extension, metav1.CreateOptions{})
+
+	stopChan := make(chan struct{})
+	w.Start(stopChan)
+	leaderStop := make(chan struct{})
+	leading := make(chan struct{})
+	go func() {
+		w.Lead(leaderStop, func() []*v1.ServiceMeshExtension { return []*v1.ServiceMeshExtension{extension} })
+		close(leading)
+	}()
+
+	expected := extension.Status.DeepCopy()
+	expected.Deployment.URL = baseURL + "/" + fakestrategy.FakeModuleSHA256
+	var status v1.ServiceMeshExtensionStatus
+	for i := 0; i < 50; i++ {
+		updatedExtension, err := w.client.ServiceMeshExtensions(extension.Namespace).Get(context.TODO(), extension.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to Get() extension: %s", err)
+		}
+		status = updatedExtension.Status
+		if cmp.Equal(*expected, status) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if diff := cmp.Diff(*expected, status); diff != "" {
+		t.Fatalf("extension was not reconciled -want +got: %s", diff)
+	}
+	if !w.store.Has(fakestrategy.FakeModuleSHA256) {
+		t.Errorf("expected module to be restored")
+	}
+
+	close(leaderStop)
+	<-leading
+	if w.leading.Load() {
+		t.Errorf("expected worker to stop leading")
+	}
+	close(stopChan)
+}
+
 func createWorker(tmpDir string, clientset *fake.Clientset) *Worker {
-	return &Worker{
-		baseURL:        baseURL,
-		client:         clientset.CoreV1(),
-		mut:            sync.Mutex{},
-		pullStrategy:   &fakestrategy.PullStrategy{},
-		serveDirectory: tmpDir,
-		Queue:          make(chan ExtensionEvent),
-		errorChannel:   make(chan error),
+	w := &Worker{
+		baseURL:      baseURL,
+		client:       clientset.CoreV1(),
+		mut:          sync.Mutex{},
+		pullStrategy: &fakestrategy.PullStrategy{},
+		store:        &ModuleStore{dir: tmpDir, refs: map[string]string{}},
+		Queue:        make(chan ExtensionEvent),
+		sweep:        make(chan []*v1.ServiceMeshExtension),
+		errorChannel: make(chan error),
 	}
+	w.leading.Store(true)
+	return w
 }
 
 // getError tries to read an error from the error channel.
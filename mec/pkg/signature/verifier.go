@@ -0,0 +1,188 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"istio.io/istio/mec/pkg/model"
+)
+
+// simpleSigningType is the type of the payloads signed by cosign
+const simpleSigningType = "cosign container image signature"
+
+// payload is the document signed by cosign, known as simple signing.
+type payload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+type publicKey struct {
+	name string
+	key  crypto.PublicKey
+}
+
+// Verifier checks that images have been signed by one of a set of trusted
+// keys.
+type Verifier struct {
+	keys []publicKey
+}
+
+// NewVerifier creates a Verifier trusting the given keys, by name.
+func NewVerifier(keys map[string]crypto.PublicKey) (*Verifier, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("at least one public key is required")
+	}
+	v := &Verifier{}
+	for name, key := range keys {
+		switch key.(type) {
+		case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		default:
+			return nil, fmt.Errorf("unsupported type of public key %s: %T", name, key)
+		}
+		v.keys = append(v.keys, publicKey{name: name, key: key})
+	}
+	sort.Slice(v.keys, func(i, j int) bool { return v.keys[i].name < v.keys[j].name })
+	return v, nil
+}
+
+// NewVerifierFromPath creates a Verifier trusting the PEM encoded public keys
+// in the file, or in all the files of the directory, at path.
+func NewVerifierFromPath(path string) (*Verifier, error) {
+	files := []string{path}
+	if entries, err := ioutil.ReadDir(path); err == nil {
+		files = nil
+		for _, entry := range entries {
+			// skip hidden files, e.g. ..data in mounted secrets and configmaps
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			files = append(files, filepath.Join(path, entry.Name()))
+		}
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read public keys: %v", err)
+		}
+		for index := 0; ; index++ {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			if block.Type != "PUBLIC KEY" {
+				continue
+			}
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse public key in %s: %v", file, err)
+			}
+			name := filepath.Base(file)
+			if index > 0 {
+				name = fmt.Sprintf("%s#%d", name, index)
+			}
+			keys[name] = key
+		}
+	}
+	return NewVerifier(keys)
+}
+
+// Verify checks that at least one of the signatures was created by a trusted
+// key for the image with the given digest, returning the name of the key.
+// repository is compared with the identity in the signed payload, if it has
+// one.
+func (v *Verifier) Verify(repository, digest string, signatures []model.Signature) (string, error) {
+	if len(signatures) == 0 {
+		return "", fmt.Errorf("image %s@%s is not signed", repository, digest)
+	}
+	var errs []string
+	for _, sig := range signatures {
+		if err := checkPayload(sig.Payload, repository, digest); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		for _, key := range v.keys {
+			if verifySignature(key.key, sig.Payload, sig.Signature) {
+				return key.name, nil
+			}
+		}
+		errs = append(errs, "signature does not match any trusted key")
+	}
+	return "", fmt.Errorf("no valid signature found for image %s@%s: %s", repository, digest, strings.Join(errs, "; "))
+}
+
+// checkPayload verifies that the payload identifies the image, so signatures
+// cannot be copied from other images.
+func checkPayload(data []byte, repository, digest string) error {
+	var p payload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("invalid signature payload: %v", err)
+	}
+	if p.Critical.Type != simpleSigningType {
+		return fmt.Errorf("unsupported signature type %q", p.Critical.Type)
+	}
+	if p.Critical.Image.DockerManifestDigest != digest {
+		return fmt.Errorf("signature is for digest %s", p.Critical.Image.DockerManifestDigest)
+	}
+	if ref := p.Critical.Identity.DockerReference; ref != "" && normalizeReference(ref) != normalizeReference(repository) {
+		return fmt.Errorf("signature is for image %s", ref)
+	}
+	return nil
+}
+
+// normalizeReference strips the registry from references to Docker Hub, which
+// may or may not be included, e.g. index.docker.io/user/image and user/image.
+func normalizeReference(ref string) string {
+	for _, prefix := range []string{"docker.io/", "index.docker.io/", "registry-1.docker.io/"} {
+		if strings.HasPrefix(ref, prefix) {
+			return strings.TrimPrefix(strings.TrimPrefix(ref, prefix), "library/")
+		}
+	}
+	return ref
+}
+
+func verifySignature(key crypto.PublicKey, data, sig []byte) bool {
+	hash := sha256.Sum256(data)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(k, hash[:], sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, hash[:], sig) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, data, sig)
+	}
+	return false
+}
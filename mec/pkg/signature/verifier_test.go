@@ -0,0 +1,209 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"istio.io/istio/mec/pkg/model"
+)
+
+const (
+	testRepository = "quay.io/maistra/example"
+	testDigest     = "sha256:997890bc85c5796408ceb20b0ca75dabe6fe868136e926d24ad0f36aa424f99d"
+)
+
+func newPayload(repository, digest string) []byte {
+	return []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":%q},"image":{"docker-manifest-digest":%q},"type":%q},"optional":null}`,
+		repository, digest, simpleSigningType))
+}
+
+func signECDSA(t *testing.T, key *ecdsa.PrivateKey, data []byte) []byte {
+	t.Helper()
+	hash := sha256.Sum256(data)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func writePublicKey(t *testing.T, file string, keys ...crypto.PublicKey) {
+	t.Helper()
+	var data []byte
+	for _, key := range keys {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})...)
+	}
+	if err := ioutil.WriteFile(file, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	trusted, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	untrusted, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := NewVerifier(map[string]crypto.PublicKey{"trusted": &trusted.PublicKey, "ed25519": edPublic})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := newPayload(testRepository, testDigest)
+	otherDigest := newPayload(testRepository, "sha256:04a68a66858919123dafb8b8f8d7b7c80f7f14f129bfd12930339c879acfce2a")
+	otherImage := newPayload("quay.io/maistra/other", testDigest)
+	testCases := []struct {
+		name          string
+		signatures    []model.Signature
+		expectedKey   string
+		expectedError string
+	}{
+		{
+			name:          "unsigned",
+			expectedError: "is not signed",
+		},
+		{
+			name:        "ecdsa",
+			signatures:  []model.Signature{{Payload: payload, Signature: signECDSA(t, trusted, payload)}},
+			expectedKey: "trusted",
+		},
+		{
+			name:        "ed25519",
+			signatures:  []model.Signature{{Payload: payload, Signature: ed25519.Sign(edPrivate, payload)}},
+			expectedKey: "ed25519",
+		},
+		{
+			name: "one valid signature",
+			signatures: []model.Signature{
+				{Payload: payload, Signature: signECDSA(t, untrusted, payload)},
+				{Payload: payload, Signature: signECDSA(t, trusted, payload)},
+			},
+			expectedKey: "trusted",
+		},
+		{
+			name:          "untrusted key",
+			signatures:    []model.Signature{{Payload: payload, Signature: signECDSA(t, untrusted, payload)}},
+			expectedError: "signature does not match any trusted key",
+		},
+		{
+			name:          "tampered payload",
+			signatures:    []model.Signature{{Payload: otherDigest, Signature: signECDSA(t, trusted, payload)}},
+			expectedError: "signature is for digest",
+		},
+		{
+			name:          "signature for other digest",
+			signatures:    []model.Signature{{Payload: otherDigest, Signature: signECDSA(t, trusted, otherDigest)}},
+			expectedError: "signature is for digest",
+		},
+		{
+			name:          "signature for other image",
+			signatures:    []model.Signature{{Payload: otherImage, Signature: signECDSA(t, trusted, otherImage)}},
+			expectedError: "signature is for image quay.io/maistra/other",
+		},
+		{
+			name:          "invalid payload",
+			signatures:    []model.Signature{{Payload: []byte("invalid"), Signature: signECDSA(t, trusted, []byte("invalid"))}},
+			expectedError: "invalid signature payload",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			key, err := verifier.Verify(testRepository, testDigest, tc.signatures)
+			if tc.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if key != tc.expectedKey {
+				t.Errorf("expected signature to be verified by key %s, got %s", tc.expectedKey, key)
+			}
+		})
+	}
+}
+
+func TestVerifyDockerHubReference(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := NewVerifier(map[string]crypto.PublicKey{"key": &key.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := newPayload("index.docker.io/maistra/example", testDigest)
+	if _, err := verifier.Verify("maistra/example", testDigest, []model.Signature{{Payload: payload, Signature: signECDSA(t, key, payload)}}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestNewVerifierFromPath(t *testing.T) {
+	dir := t.TempDir()
+	first, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePublicKey(t, filepath.Join(dir, "keys.pem"), &first.PublicKey, &second.PublicKey)
+	if err := ioutil.WriteFile(filepath.Join(dir, "..data"), []byte("ignored"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{dir, filepath.Join(dir, "keys.pem")} {
+		verifier, err := NewVerifierFromPath(path)
+		if err != nil {
+			t.Fatalf("failed to load keys from %s: %s", path, err)
+		}
+		payload := newPayload(testRepository, testDigest)
+		key, err := verifier.Verify(testRepository, testDigest, []model.Signature{{Payload: payload, Signature: signECDSA(t, second, payload)}})
+		if err != nil || key != "keys.pem#1" {
+			t.Errorf("expected signature to be verified by keys.pem#1, got %s, %v", key, err)
+		}
+	}
+
+	if _, err := NewVerifierFromPath(t.TempDir()); err == nil {
+		t.Errorf("expected error for directory without keys")
+	}
+}
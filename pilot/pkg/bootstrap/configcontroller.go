@@ -148,7 +148,7 @@ func (s *Server) startIOR(args *PilotArgs) {
 		return
 	}
 
-	iorKubeClient := ior.NewKubeClient(s.kubeClient)
+	iorKubeClient := ior.NewKubeClient(s.kubeClient, s.kubeClient.Istio())
 
 	s.addStartFunc(func(stop <-chan struct{}) error {
 		go leaderelection.
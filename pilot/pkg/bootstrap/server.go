@@ -72,6 +72,7 @@ import (
 	"istio.io/istio/pkg/kube/inject"
 	"istio.io/istio/pkg/servicemesh/controller/extension"
 	"istio.io/istio/pkg/servicemesh/federation"
+	maistramodel "istio.io/istio/pkg/servicemesh/model"
 	"istio.io/istio/pkg/spiffe"
 	"istio.io/istio/security/pkg/k8s/chiron"
 	"istio.io/istio/security/pkg/pki/ca"
@@ -282,11 +283,26 @@ func NewServer(args *PilotArgs) (*Server, error) {
 				BindAddress:         args.ServerOptions.FederationAddr,
 				Env:                 s.environment,
 				XDSUpdater:          s.XDSServer,
+				ADSServer:           s.XDSServer,
 				ServiceController:   s.ServiceController(),
 				IstiodNamespace:     args.Namespace,
 				IstiodPodName:       args.PodName,
+				RootCerts: func() []byte {
+					// the CA is not created until later, so look it up when serving
+					var rootCertBytes []byte
+					if s.RA != nil {
+						rootCertBytes = append(rootCertBytes, s.RA.GetCAKeyCertBundle().GetRootCertPem()...)
+					}
+					if s.CA != nil {
+						rootCertBytes = append(rootCertBytes, s.CA.GetCAKeyCertBundle().GetRootCertPem()...)
+					}
+					return rootCertBytes
+				},
 			})
 			s.XDSServer.Generators[v3.TrustBundleType] = &xds.TbdsGenerator{TrustBundleProvider: s.federation}
+			fdsGenerator := &xds.FdsGenerator{ServiceListProvider: s.federation}
+			s.XDSServer.Generators[v3.FederationServiceType] = fdsGenerator
+			s.XDSServer.Generators[v3.FederationGatewayType] = fdsGenerator
 
 			if err != nil {
 				return nil, fmt.Errorf("error initializing federation: %v", err)
@@ -991,10 +1007,13 @@ func (s *Server) initRegistryEventHandlers() {
 	if s.environment.ExtensionStore != nil {
 		s.environment.ExtensionStore.RegisterEventHandler(cache.ResourceEventHandlerFuncs{
 			AddFunc: func(obj interface{}) {
-				s.XDSServer.Push(&model.PushRequest{Full: true})
+				s.pushExtension(obj, maistramodel.ServiceMeshExtensionGVK)
 			},
 			DeleteFunc: func(obj interface{}) {
-				s.XDSServer.Push(&model.PushRequest{Full: true})
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				s.pushExtension(obj, maistramodel.ServiceMeshExtensionGVK)
 			},
 			UpdateFunc: func(oldObj, newObj interface{}) {
 				oldExtension, ok := oldObj.(*smv1.ServiceMeshExtension)
@@ -1013,12 +1032,33 @@ func (s *Server) initRegistryEventHandlers() {
 					return
 				}
 
-				s.XDSServer.Push(&model.PushRequest{Full: true})
+				s.pushExtension(newExtension, maistramodel.ExtensionUpdateGVK(oldExtension, newExtension))
 			},
 		})
 	}
 }
 
+// pushExtension triggers a push for a change to a ServiceMeshExtension.
+// Changes that only affect the configuration of its filter are delivered over
+// ECDS, without updating listeners.
+func (s *Server) pushExtension(obj interface{}, kind config.GroupVersionKind) {
+	extension, ok := obj.(*smv1.ServiceMeshExtension)
+	if !ok {
+		log.Errorf("object could not be decoded into a ServiceMeshExtension: %+v", obj)
+		s.XDSServer.Push(&model.PushRequest{Full: true})
+		return
+	}
+	s.XDSServer.Push(&model.PushRequest{
+		Full: true,
+		ConfigsUpdated: map[model.ConfigKey]struct{}{{
+			Kind:      kind,
+			Name:      extension.Name,
+			Namespace: extension.Namespace,
+		}: {}},
+		Reason: []model.TriggerReason{model.ConfigUpdate},
+	})
+}
+
 // onlyStatusUpdated returns false if changes are observed in labels, annotations, or spec, and otherwise returns true.
 func onlyStatusUpdated(old config.Config, curr config.Config) bool {
 	return labels.Equals(old.Labels, curr.Labels) &&
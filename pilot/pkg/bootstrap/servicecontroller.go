@@ -74,6 +74,10 @@ func (s *Server) initKubeRegistry(args *PilotArgs) (err error) {
 	args.RegistryOptions.KubeOptions.ClusterID = s.clusterID
 	args.RegistryOptions.KubeOptions.Metrics = s.environment
 	args.RegistryOptions.KubeOptions.XDSUpdater = s.XDSServer
+	if s.federation != nil {
+		// the endpoint summaries exported to peers follow the endpoints
+		args.RegistryOptions.KubeOptions.XDSUpdater = s.federation.WrapXDSUpdater(s.XDSServer)
+	}
 	args.RegistryOptions.KubeOptions.NetworksWatcher = s.environment.NetworksWatcher
 	args.RegistryOptions.KubeOptions.SystemNamespace = args.Namespace
 
@@ -19,22 +19,26 @@ import (
 	"time"
 
 	"k8s.io/client-go/kubernetes"
+
+	istioclient "istio.io/client-go/pkg/clientset/versioned"
 )
 
 // KubeClient is an extension of `kubernetes.Interface` with auxiliary functions for IOR
 type KubeClient interface {
 	IsRouteSupported() bool
 	GetActualClient() kubernetes.Interface
+	GetIstioClient() istioclient.Interface
 	GetHandleEventTimeout() time.Duration
 }
 
 type kubeClient struct {
-	client kubernetes.Interface
+	client      kubernetes.Interface
+	istioClient istioclient.Interface
 }
 
 // NewKubeClient creates the IOR version of KubeClient
-func NewKubeClient(client kubernetes.Interface) KubeClient {
-	return &kubeClient{client: client}
+func NewKubeClient(client kubernetes.Interface, istioClient istioclient.Interface) KubeClient {
+	return &kubeClient{client: client, istioClient: istioClient}
 }
 
 func (c *kubeClient) IsRouteSupported() bool {
@@ -54,6 +58,10 @@ func (c *kubeClient) GetActualClient() kubernetes.Interface {
 	return c.client
 }
 
+func (c *kubeClient) GetIstioClient() istioclient.Interface {
+	return c.istioClient
+}
+
 func (c *kubeClient) GetHandleEventTimeout() time.Duration {
 	return 10 * time.Second
 }
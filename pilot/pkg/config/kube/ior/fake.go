@@ -16,18 +16,22 @@ package ior
 
 import (
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
 	v1 "github.com/openshift/api/route/v1"
 	routev1 "github.com/openshift/client-go/route/clientset/versioned/typed/route/v1"
 	"golang.org/x/net/context"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 
+	istioclient "istio.io/client-go/pkg/clientset/versioned"
 	"istio.io/istio/pkg/servicemesh/controller"
 )
 
@@ -35,6 +39,11 @@ import (
 type FakeRouter struct {
 	routes     map[string]*v1.Route
 	routesLock sync.Mutex
+
+	// events are replayed to watches started from an older resource version
+	resourceVersion int
+	events          []watch.Event
+	broadcaster     *watch.Broadcaster
 }
 
 // FakeRouterClient implements routev1.RouteV1Interface
@@ -44,12 +53,13 @@ type FakeRouterClient struct {
 }
 
 type fakeKubeClient struct {
-	client kubernetes.Interface
+	client      kubernetes.Interface
+	istioClient istioclient.Interface
 }
 
 // NewFakeKubeClient creates a new FakeKubeClient
-func NewFakeKubeClient(client kubernetes.Interface) KubeClient {
-	return &fakeKubeClient{client: client}
+func NewFakeKubeClient(client kubernetes.Interface, istioClient istioclient.Interface) KubeClient {
+	return &fakeKubeClient{client: client, istioClient: istioClient}
 }
 
 func (c *fakeKubeClient) IsRouteSupported() bool {
@@ -60,6 +70,10 @@ func (c *fakeKubeClient) GetActualClient() kubernetes.Interface {
 	return c.client
 }
 
+func (c *fakeKubeClient) GetIstioClient() istioclient.Interface {
+	return c.istioClient
+}
+
 func (c *fakeKubeClient) GetHandleEventTimeout() time.Duration {
 	return time.Millisecond
 }
@@ -74,7 +88,8 @@ func NewFakeRouterClient() routev1.RouteV1Interface {
 // NewFakeRouter creates a new FakeRouter
 func NewFakeRouter() routev1.RouteInterface {
 	return &FakeRouter{
-		routes: make(map[string]*v1.Route),
+		routes:      make(map[string]*v1.Route),
+		broadcaster: watch.NewBroadcaster(1000, watch.DropIfChannelFull),
 	}
 }
 
@@ -98,30 +113,71 @@ func (rc *FakeRouterClient) Routes(namespace string) routev1.RouteInterface {
 
 var generatedHostNumber int
 
+// notify records a change to a route and sends it to the watches.  Must be
+// called with routesLock locked.
+func (fk *FakeRouter) notify(eventType watch.EventType, route *v1.Route) {
+	fk.resourceVersion++
+	route.ResourceVersion = strconv.Itoa(fk.resourceVersion)
+	event := watch.Event{Type: eventType, Object: route.DeepCopy()}
+	fk.events = append(fk.events, event)
+	fk.broadcaster.Action(event.Type, event.Object)
+}
+
 // Create implements routev1.RouteInterface
 func (fk *FakeRouter) Create(ctx context.Context, route *v1.Route, opts metav1.CreateOptions) (*v1.Route, error) {
 	fk.routesLock.Lock()
 	defer fk.routesLock.Unlock()
 
+	if _, ok := fk.routes[route.Name]; ok {
+		return nil, apierrors.NewAlreadyExists(v1.Resource("routes"), route.Name)
+	}
+
+	route = route.DeepCopy()
 	if route.Spec.Host == "" {
 		generatedHostNumber++
 		route.Spec.Host = fmt.Sprintf("generated-host%d.com", generatedHostNumber)
 	}
 
+	fk.notify(watch.Added, route)
 	fk.routes[route.Name] = route
 
 	countCallsIncrement("create")
-	return route, nil
+	return route.DeepCopy(), nil
 }
 
 // Update implements routev1.RouteInterface
 func (fk *FakeRouter) Update(ctx context.Context, route *v1.Route, opts metav1.UpdateOptions) (*v1.Route, error) {
-	panic("not implemented")
+	fk.routesLock.Lock()
+	defer fk.routesLock.Unlock()
+
+	if _, ok := fk.routes[route.Name]; !ok {
+		return nil, apierrors.NewNotFound(v1.Resource("routes"), route.Name)
+	}
+
+	route = route.DeepCopy()
+	fk.notify(watch.Modified, route)
+	fk.routes[route.Name] = route
+
+	countCallsIncrement("update")
+	return route.DeepCopy(), nil
 }
 
 // UpdateStatus implements routev1.RouteInterface
 func (fk *FakeRouter) UpdateStatus(ctx context.Context, route *v1.Route, opts metav1.UpdateOptions) (*v1.Route, error) {
-	panic("not implemented")
+	fk.routesLock.Lock()
+	defer fk.routesLock.Unlock()
+
+	current, ok := fk.routes[route.Name]
+	if !ok {
+		return nil, apierrors.NewNotFound(v1.Resource("routes"), route.Name)
+	}
+
+	current = current.DeepCopy()
+	current.Status = *route.Status.DeepCopy()
+	fk.notify(watch.Modified, current)
+	fk.routes[route.Name] = current
+
+	return current.DeepCopy(), nil
 }
 
 // Delete implements routev1.RouteInterface
@@ -129,11 +185,13 @@ func (fk *FakeRouter) Delete(ctx context.Context, name string, opts metav1.Delet
 	fk.routesLock.Lock()
 	defer fk.routesLock.Unlock()
 
-	if _, ok := fk.routes[name]; !ok {
-		return fmt.Errorf("route %s not found", name)
+	route, ok := fk.routes[name]
+	if !ok {
+		return apierrors.NewNotFound(v1.Resource("routes"), name)
 	}
 
 	delete(fk.routes, name)
+	fk.notify(watch.Deleted, route.DeepCopy())
 
 	countCallsIncrement("delete")
 	return nil
@@ -146,7 +204,15 @@ func (fk *FakeRouter) DeleteCollection(ctx context.Context, opts metav1.DeleteOp
 
 // Get implements routev1.RouteInterface
 func (fk *FakeRouter) Get(ctx context.Context, name string, opts metav1.GetOptions) (*v1.Route, error) {
-	panic("not implemented")
+	fk.routesLock.Lock()
+	defer fk.routesLock.Unlock()
+
+	route, ok := fk.routes[name]
+	if !ok {
+		return nil, apierrors.NewNotFound(v1.Resource("routes"), name)
+	}
+
+	return route.DeepCopy(), nil
 }
 
 // List implements routev1.RouteInterface
@@ -154,11 +220,19 @@ func (fk *FakeRouter) List(ctx context.Context, opts metav1.ListOptions) (*v1.Ro
 	fk.routesLock.Lock()
 	defer fk.routesLock.Unlock()
 
+	selector, err := labels.Parse(opts.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+
 	var items []v1.Route
 	for _, route := range fk.routes {
-		items = append(items, *route)
+		if selector.Matches(labels.Set(route.Labels)) {
+			items = append(items, *route.DeepCopy())
+		}
 	}
 	result := &v1.RouteList{Items: items}
+	result.ResourceVersion = strconv.Itoa(fk.resourceVersion)
 
 	countCallsIncrement("list")
 	return result, nil
@@ -166,7 +240,31 @@ func (fk *FakeRouter) List(ctx context.Context, opts metav1.ListOptions) (*v1.Ro
 
 // Watch Create implements routev1.RouteInterface
 func (fk *FakeRouter) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
-	panic("not implemented")
+	fk.routesLock.Lock()
+	defer fk.routesLock.Unlock()
+
+	selector, err := labels.Parse(opts.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	var since int
+	if opts.ResourceVersion != "" {
+		if since, err = strconv.Atoi(opts.ResourceVersion); err != nil {
+			return nil, apierrors.NewBadRequest(err.Error())
+		}
+	}
+	var missed []watch.Event
+	for _, event := range fk.events {
+		if resourceVersion, _ := strconv.Atoi(event.Object.(*v1.Route).ResourceVersion); resourceVersion > since {
+			missed = append(missed, event)
+		}
+	}
+
+	return watch.Filter(fk.broadcaster.WatchWithPrefix(missed), func(in watch.Event) (watch.Event, bool) {
+		route, ok := in.Object.(*v1.Route)
+		return in, ok && selector.Matches(labels.Set(route.Labels))
+	}), nil
 }
 
 // Patch implements routev1.RouteInterface
@@ -0,0 +1,198 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ior
+
+import (
+	xnsinformers "github.com/maistra/xns-informer/pkg/informers"
+	v1 "github.com/openshift/api/route/v1"
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+
+	networkingv1alpha3 "istio.io/client-go/pkg/apis/networking/v1alpha3"
+)
+
+// newMemberInformer returns an informer of the objects of the member
+// namespaces, which are added to and removed from the namespace set by
+// SetNamespaces.
+func newMemberInformer(namespaces xnsinformers.NamespaceSet, objType runtime.Object,
+	listerWatcher func(namespace string) cache.ListerWatcher) xnsinformers.MultiNamespaceInformer {
+	return xnsinformers.NewMultiNamespaceInformer(namespaces, 0, func(namespace string) cache.SharedIndexInformer {
+		return cache.NewSharedIndexInformer(listerWatcher(namespace), objType, 0,
+			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	})
+}
+
+// startInformers starts the informers of the pods and services, which are
+// searched for the gateway services, of the routes generated by IOR and of
+// the Gateways they belong to.
+func (r *route) startInformers() {
+	r.podInformer = newMemberInformer(r.namespaceSet, &corev1.Pod{}, func(namespace string) cache.ListerWatcher {
+		return &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return r.kubeClient.CoreV1().Pods(namespace).List(context.TODO(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return r.kubeClient.CoreV1().Pods(namespace).Watch(context.TODO(), options)
+			},
+		}
+	})
+	r.serviceInformer = newMemberInformer(r.namespaceSet, &corev1.Service{}, func(namespace string) cache.ListerWatcher {
+		return &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return r.kubeClient.CoreV1().Services(namespace).List(context.TODO(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return r.kubeClient.CoreV1().Services(namespace).Watch(context.TODO(), options)
+			},
+		}
+	})
+	r.routeInformer = newMemberInformer(r.namespaceSet, &v1.Route{}, func(namespace string) cache.ListerWatcher {
+		generatedByIOR := func(options *metav1.ListOptions) {
+			options.LabelSelector = generatedByLabel + "=" + generatedByValue
+		}
+		return &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				generatedByIOR(&options)
+				return r.routerClient.Routes(namespace).List(context.TODO(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				generatedByIOR(&options)
+				return r.routerClient.Routes(namespace).Watch(context.TODO(), options)
+			},
+		}
+	})
+	r.gatewayInformer = newMemberInformer(r.namespaceSet, &networkingv1alpha3.Gateway{}, func(namespace string) cache.ListerWatcher {
+		return &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return r.istioClient.NetworkingV1alpha3().Gateways(namespace).List(context.TODO(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return r.istioClient.NetworkingV1alpha3().Gateways(namespace).Watch(context.TODO(), options)
+			},
+		}
+	})
+
+	// Changes to the routes, including their admission by the routers, are
+	// reconciled and reported in the status of their gateways
+	r.routeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			r.observeRoute(obj, false)
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			r.observeRoute(obj, false)
+		},
+		DeleteFunc: func(obj interface{}) {
+			r.observeRoute(obj, true)
+		},
+	})
+
+	go r.podInformer.Run(r.stop)
+	go r.serviceInformer.Run(r.stop)
+	go r.routeInformer.Run(r.stop)
+	go r.gatewayInformer.Run(r.stop)
+}
+
+// informersSynced returns whether the informers of all member namespaces
+// have synced.
+func (r *route) informersSynced() bool {
+	return r.podInformer.HasSynced() && r.serviceInformer.HasSynced() && r.routeInformer.HasSynced() &&
+		r.gatewayInformer.HasSynced() && (r.namespaceInformer == nil || r.namespaceInformer.HasSynced())
+}
+
+// observeRoute clears the pending write of a route once the informer
+// observes it, and requests a reconciliation.
+func (r *route) observeRoute(obj interface{}, deleted bool) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	route, ok := obj.(*v1.Route)
+	if !ok {
+		return
+	}
+
+	key := routeKey(route.Namespace, route.Name)
+	r.pendingLock.Lock()
+	if resourceVersion, ok := r.pendingWrites[key]; ok && (deleted && resourceVersion == "" || !deleted && resourceVersion == route.ResourceVersion) {
+		delete(r.pendingWrites, key)
+	}
+	r.pendingLock.Unlock()
+
+	r.requestReconcile()
+}
+
+// recordWrite marks a route as written by IOR, or deleted if route is nil,
+// until the informer observes the change.
+func (r *route) recordWrite(key string, route *v1.Route) {
+	resourceVersion := ""
+	if route != nil {
+		resourceVersion = route.ResourceVersion
+	}
+	r.pendingLock.Lock()
+	defer r.pendingLock.Unlock()
+
+	// The informer may have been faster than the response to the write
+	obj, exists, _ := r.routeInformer.GetStore().GetByKey(key)
+	if cached, ok := obj.(*v1.Route); exists && ok && cached.ResourceVersion == resourceVersion || route == nil && !exists {
+		return
+	}
+	r.pendingWrites[key] = resourceVersion
+}
+
+// pendingKeys returns the routes written by IOR whose changes the informer
+// hasn't observed yet.
+func (r *route) pendingKeys() map[string]bool {
+	r.pendingLock.Lock()
+	defer r.pendingLock.Unlock()
+	keys := make(map[string]bool, len(r.pendingWrites))
+	for key := range r.pendingWrites {
+		keys[key] = true
+	}
+	return keys
+}
+
+// forgetPendingWrites drops the pending writes, in case the informer missed
+// any of them, e.g. because it relisted the routes.
+func (r *route) forgetPendingWrites() {
+	r.pendingLock.Lock()
+	r.pendingWrites = map[string]string{}
+	r.pendingLock.Unlock()
+}
+
+// gatewaysSynced returns whether the Gateway informer watches the namespace
+// and has synced.
+func (r *route) gatewaysSynced(namespace string) bool {
+	return r.gatewayInformer.GetIndexers()[namespace] != nil && r.gatewayInformer.HasSynced()
+}
+
+// gatewayExists returns whether the Gateway informer has observed the
+// gateway, or the Gateways of its member namespace have not synced yet.
+func (r *route) gatewayExists(namespace, name string) bool {
+	if !r.namespaceSet.Contains(namespace) {
+		return false
+	}
+	if !r.gatewaysSynced(namespace) {
+		return true
+	}
+	_, exists, _ := r.gatewayInformer.GetStore().GetByKey(routeKey(namespace, name))
+	return exists
+}
+
+func routeKey(namespace, name string) string {
+	return namespace + "/" + name
+}
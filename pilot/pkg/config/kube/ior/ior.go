@@ -15,8 +15,10 @@
 package ior
 
 import (
+	"reflect"
 	"sync"
 
+	"github.com/gogo/protobuf/proto"
 	routev1 "github.com/openshift/client-go/route/clientset/versioned/typed/route/v1"
 
 	networking "istio.io/api/networking/v1alpha3"
@@ -30,7 +32,8 @@ import (
 // IORLog is IOR-scoped log
 var IORLog = log.RegisterScope("ior", "IOR logging", 0)
 
-// Register configures IOR component to respond to Gateway creations and removals
+// Register configures IOR component to respond to Gateway creations and removals, including the Gateways istiod
+// translates from Gateway API and Ingress resources
 func Register(
 	k8sClient KubeClient,
 	routerClient routev1.RouteV1Interface,
@@ -63,13 +66,25 @@ func Register(
 
 	IORLog.Debugf("Registering IOR into Istio's Gateway broadcast")
 	kind := collections.IstioNetworkingV1Alpha3Gateways.Resource().GroupVersionKind()
-	store.RegisterEventHandler(kind, func(_, curr config.Config, event model.Event) {
+	store.RegisterEventHandler(kind, func(old, curr config.Config, event model.Event) {
 		aliveLock.Lock()
 		defer aliveLock.Unlock()
 		if !alive {
 			return
 		}
 
+		// The ingress controller notifies the changes of Ingresses as changes
+		// of unnamed Gateways
+		if curr.Name == "" || isTranslatedGateway(curr.Name) {
+			r.translatedGatewaysChanged()
+			return
+		}
+
+		// Updates of the status, e.g. the admission of the routes, don't change the routes
+		if event == model.EventUpdate && !gatewayChanged(old, curr) {
+			return
+		}
+
 		// encapsulate in goroutine to not slow down processing because of waiting for mutex
 		go func() {
 			_, ok := curr.Spec.(*networking.Gateway)
@@ -88,5 +103,29 @@ func Register(
 		}()
 	})
 
+	// The hostnames of Gateway API Gateways and Routes are exposed through the
+	// Gateways they are translated into
+	for _, kind := range translatedKinds {
+		if _, ok := store.Schemas().FindByGroupVersionKind(kind); !ok {
+			continue
+		}
+		IORLog.Debugf("Registering IOR into the %s broadcast", kind.Kind)
+		store.RegisterEventHandler(kind, func(_, _ config.Config, _ model.Event) {
+			aliveLock.Lock()
+			defer aliveLock.Unlock()
+			if alive {
+				r.translatedGatewaysChanged()
+			}
+		})
+	}
+
 	return nil
 }
+
+func gatewayChanged(old, curr config.Config) bool {
+	oldGateway, _ := old.Spec.(*networking.Gateway)
+	currGateway, _ := curr.Spec.(*networking.Gateway)
+	return !proto.Equal(oldGateway, currGateway) ||
+		!reflect.DeepEqual(old.Labels, curr.Labels) ||
+		!reflect.DeepEqual(old.Annotations, curr.Annotations)
+}
@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -25,14 +26,26 @@ import (
 	routev1 "github.com/openshift/client-go/route/clientset/versioned/typed/route/v1"
 	"github.com/stretchr/testify/assert"
 	k8sioapicorev1 "k8s.io/api/core/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/tools/cache"
+	serviceapis "sigs.k8s.io/service-apis/apis/v1alpha1"
 
+	meta "istio.io/api/meta/v1alpha1"
 	networking "istio.io/api/networking/v1alpha3"
+	configaggregate "istio.io/istio/pilot/pkg/config/aggregate"
 	"istio.io/istio/pilot/pkg/config/kube/crdclient"
+	"istio.io/istio/pilot/pkg/config/kube/gateway"
+	"istio.io/istio/pilot/pkg/config/kube/ingress"
+	"istio.io/istio/pilot/pkg/features"
 	"istio.io/istio/pilot/pkg/model"
+	kubecontroller "istio.io/istio/pilot/pkg/serviceregistry/kube/controller"
 	"istio.io/istio/pkg/config"
+	"istio.io/istio/pkg/config/constants"
+	"istio.io/istio/pkg/config/mesh"
 	"istio.io/istio/pkg/config/schema/collections"
+	"istio.io/istio/pkg/config/schema/gvk"
 	"istio.io/istio/pkg/kube"
 	memberroll "istio.io/istio/pkg/servicemesh/controller"
 	"istio.io/istio/pkg/test/util/retry"
@@ -45,25 +58,40 @@ func initClients(t *testing.T,
 	mrc memberroll.MemberRollController) (model.ConfigStoreCache, kube.Client, routev1.RouteV1Interface) {
 	t.Helper()
 
+	return initClientsWithStore(t, stop, errorChannel, mrc, func(store model.ConfigStoreCache, _ kube.Client) model.ConfigStoreCache {
+		return store
+	})
+}
+
+// initClientsWithStore registers IOR with the store returned by wrapStore
+func initClientsWithStore(t *testing.T,
+	stop <-chan struct{},
+	errorChannel chan error,
+	mrc memberroll.MemberRollController,
+	wrapStore func(model.ConfigStoreCache, kube.Client) model.ConfigStoreCache) (model.ConfigStoreCache, kube.Client, routev1.RouteV1Interface) {
+	t.Helper()
+
 	k8sClient := kube.NewFakeClient()
-	iorKubeClient := NewFakeKubeClient(k8sClient)
+	iorKubeClient := NewFakeKubeClient(k8sClient, k8sClient.Istio())
 	routerClient := NewFakeRouterClient()
 	store, err := crdclient.New(k8sClient, "", "", false)
 	if err != nil {
 		t.Fatal(err)
 	}
+	// the wrapping store may create informers, which must be started with the others
+	wrapped := wrapStore(store, k8sClient)
 
-	go store.Run(stop)
+	go wrapped.Run(stop)
 	k8sClient.RunAndWait(stop)
-	cache.WaitForCacheSync(stop, store.HasSynced)
+	cache.WaitForCacheSync(stop, wrapped.HasSynced)
 	retry.UntilSuccessOrFail(t, func() error {
-		if !store.HasSynced() {
+		if !wrapped.HasSynced() {
 			return fmt.Errorf("store has not synced yet")
 		}
 		return nil
 	}, retry.Timeout(time.Second))
 
-	if err := Register(iorKubeClient, routerClient, store, "istio-system", mrc, stop, errorChannel); err != nil {
+	if err := Register(iorKubeClient, routerClient, wrapped, "istio-system", mrc, stop, errorChannel); err != nil {
 		t.Fatal(err)
 	}
 
@@ -338,8 +366,8 @@ func TestPerf(t *testing.T) {
 	assert.Equal(t, qty, countCallsGet("create"), "wrong number of calls to client.Routes().Create()")
 	assert.Equal(t, 0, countCallsGet("delete"), "wrong number of calls to client.Routes().Delete()")
 	assert.Equal(t, qtyNamespaces, countCallsGet("list")-ignore, "wrong number of calls to client.Routes().List()")
-	// qty=number of Create() calls; qtyNamespaces=number of List() calls
-	assert.Equal(t, qty+qtyNamespaces, countCallsGet("routes")-ignore, "wrong number of calls to client.Routes()")
+	// qty=number of Create() calls; qtyNamespaces=number of List() and Watch() calls of the informer
+	assert.Equal(t, qty+2*qtyNamespaces, countCallsGet("routes")-ignore, "wrong number of calls to client.Routes()")
 
 	// Now we have a lot of routes created, let's create one more gateway. We don't expect a lot of new API calls
 	countCallsReset()
@@ -579,3 +607,818 @@ func deleteGateway(t *testing.T, store model.ConfigStoreCache, ns string, name s
 		t.Fatal(err)
 	}
 }
+
+func TestTLSTermination(t *testing.T) {
+	cases := []struct {
+		testName    string
+		termination string
+		credential  string
+		plainHTTP   bool
+		secretData  map[string][]byte
+		targetPort  string
+		expectedTLS *routeapiv1.TLSConfig
+		expectedErr string
+	}{
+		{
+			testName:    "Edge",
+			termination: "edge",
+			credential:  "edge-cert",
+			plainHTTP:   true,
+			secretData:  map[string][]byte{"tls.crt": []byte("edge-cert"), "tls.key": []byte("edge-key")},
+			targetPort:  "http2",
+			expectedTLS: &routeapiv1.TLSConfig{
+				Termination:                   routeapiv1.TLSTerminationEdge,
+				Certificate:                   "edge-cert",
+				Key:                           "edge-key",
+				InsecureEdgeTerminationPolicy: routeapiv1.InsecureEdgeTerminationPolicyRedirect,
+			},
+		},
+		{
+			testName:    "Reencrypt",
+			termination: "reencrypt",
+			credential:  "reencrypt-cert",
+			secretData: map[string][]byte{
+				"cert": []byte("reencrypt-cert"), "key": []byte("reencrypt-key"),
+				"cacert": []byte("client-ca"), "destination-ca.crt": []byte("reencrypt-ca"),
+			},
+			targetPort: "https",
+			expectedTLS: &routeapiv1.TLSConfig{
+				Termination:                   routeapiv1.TLSTerminationReencrypt,
+				Certificate:                   "reencrypt-cert",
+				Key:                           "reencrypt-key",
+				DestinationCACertificate:      "reencrypt-ca",
+				InsecureEdgeTerminationPolicy: routeapiv1.InsecureEdgeTerminationPolicyRedirect,
+			},
+		},
+		{
+			testName:    "Reencrypt without destination CA",
+			termination: "Reencrypt",
+			credential:  "self-signed",
+			secretData:  map[string][]byte{"tls.crt": []byte("self-signed-cert"), "tls.key": []byte("self-signed-key"), "ca.crt": []byte("client-ca")},
+			expectedErr: "the credential istio-system/self-signed does not contain a destination-ca.crt",
+		},
+		{
+			testName:    "Missing credential",
+			termination: "edge",
+			credential:  "missing",
+			plainHTTP:   true,
+			expectedErr: "could not read the credential istio-system/missing",
+		},
+		{
+			testName:    "Edge without plain HTTP server",
+			termination: "edge",
+			credential:  "edge-no-http",
+			secretData:  map[string][]byte{"tls.crt": []byte("edge-cert"), "tls.key": []byte("edge-key")},
+			expectedErr: "edge termination requires a plain HTTP server for the host",
+		},
+		{
+			testName:    "Invalid termination",
+			termination: "offload",
+			expectedErr: "invalid maistra.io/tls-termination annotation",
+		},
+	}
+
+	stop := make(chan struct{})
+	defer func() { close(stop) }()
+	errorChannel := make(chan error)
+	mrc := newFakeMemberRollController()
+	store, k8sClient, routerClient := initClients(t, stop, errorChannel, mrc)
+	mrc.setNamespaces("istio-system")
+
+	controlPlane := "istio-system"
+	createIngressGateway(t, k8sClient, controlPlane, map[string]string{"istio": "ingressgateway"})
+
+	for i, c := range cases {
+		t.Run(c.testName, func(t *testing.T) {
+			if c.secretData != nil {
+				createSecret(t, k8sClient, controlPlane, c.credential, c.secretData)
+			}
+			gatewayName := fmt.Sprintf("tls%d", i)
+			host := gatewayName + ".org"
+			createTLSGateway(t, store, controlPlane, gatewayName, host, c.termination, c.credential, c.plainHTTP)
+
+			if c.expectedErr != "" {
+				var err error
+				retry.UntilSuccessOrFail(t, func() error {
+					if err = getError(errorChannel); err == nil {
+						return fmt.Errorf("expected error")
+					}
+					return nil
+				}, retry.Timeout(time.Second))
+				if !strings.Contains(err.Error(), c.expectedErr) {
+					t.Fatalf("expected error message containing `%s', got: %s", c.expectedErr, err.Error())
+				}
+				deleteGateway(t, store, controlPlane, gatewayName)
+				_ = getError(errorChannel)
+				return
+			}
+
+			list, _ := getRoutes(t, routerClient, controlPlane, 1, time.Second)
+			route := findRouteByHost(list, host)
+			if route == nil {
+				t.Fatalf("could not find a route with hostname %s", host)
+			}
+			assert.Equal(t, c.expectedTLS, route.Spec.TLS)
+			assert.Equal(t, c.targetPort, route.Spec.Port.TargetPort.StrVal)
+			assert.Equal(t, c.credential, route.Annotations[credentialNameAnnotation])
+
+			// certificates are updated when the secret is rotated
+			rotated := map[string][]byte{}
+			for key, value := range c.secretData {
+				rotated[key] = append([]byte("rotated-"), value...)
+			}
+			updateSecret(t, k8sClient, controlPlane, c.credential, rotated)
+			retry.UntilSuccessOrFail(t, func() error {
+				list, err := routerClient.Routes(controlPlane).List(context.TODO(), v1.ListOptions{})
+				if err != nil {
+					return err
+				}
+				if route := findRouteByHost(list, host); route == nil || !strings.HasPrefix(route.Spec.TLS.Certificate, "rotated-") ||
+					!strings.HasPrefix(route.Spec.TLS.Key, "rotated-") {
+					return fmt.Errorf("route certificates were not updated")
+				}
+				return nil
+			}, retry.Timeout(time.Second))
+
+			deleteGateway(t, store, controlPlane, gatewayName)
+			_, _ = getRoutes(t, routerClient, controlPlane, 0, time.Second)
+			if err := getError(errorChannel); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestSecretWatcher(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+	client := kube.NewFakeClient()
+	changed := make(chan string, 10)
+	watcher := newSecretWatcher(client, func(namespace, name string) {
+		changed <- namespace + "/" + name
+	}, stop)
+	watcher.watch("istio-system")
+
+	expectChange := func(event string) {
+		t.Helper()
+		select {
+		case name := <-changed:
+			if name != "istio-system/credential" {
+				t.Fatalf("expected a change of istio-system/credential, got %s", name)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("the %s of the secret was not notified", event)
+		}
+	}
+
+	createSecret(t, client, "istio-system", "credential", map[string][]byte{"tls.crt": []byte("cert")})
+	expectChange("creation")
+	updateSecret(t, client, "istio-system", "credential", map[string][]byte{"tls.crt": []byte("rotated-cert")})
+	expectChange("update")
+	if err := client.CoreV1().Secrets("istio-system").Delete(context.TODO(), "credential", v1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expectChange("deletion")
+}
+
+func createSecret(t *testing.T, client kube.Client, ns, name string, data map[string][]byte) {
+	t.Helper()
+
+	_, err := client.CoreV1().Secrets(ns).Create(context.TODO(), &k8sioapicorev1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+		},
+		Data: data,
+	}, v1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func updateSecret(t *testing.T, client kube.Client, ns, name string, data map[string][]byte) {
+	t.Helper()
+
+	_, err := client.CoreV1().Secrets(ns).Update(context.TODO(), &k8sioapicorev1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+		},
+		Data: data,
+	}, v1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func createTLSGateway(t *testing.T, store model.ConfigStoreCache, ns, name, host, termination, credentialName string, plainHTTP bool) {
+	t.Helper()
+
+	servers := []*networking.Server{
+		{
+			Hosts: []string{host},
+			Tls:   &networking.ServerTLSSettings{HttpsRedirect: true, CredentialName: credentialName},
+		},
+	}
+	if plainHTTP {
+		servers = append(servers, &networking.Server{
+			Hosts: []string{host},
+			Port:  &networking.Port{Number: 80, Name: "http", Protocol: "HTTP"},
+		})
+	}
+	_, err := store.Create(config.Config{
+		Meta: config.Meta{
+			GroupVersionKind: collections.IstioNetworkingV1Alpha3Gateways.Resource().GroupVersionKind(),
+			Namespace:        ns,
+			Name:             name,
+			Annotations:      map[string]string{tlsTerminationAnnotation: termination},
+			ResourceVersion:  "1",
+		},
+		Spec: &networking.Gateway{
+			Selector: map[string]string{"istio": "ingressgateway"},
+			Servers:  servers,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestDriftRepair makes sure routes changed or deleted by others are restored
+func TestDriftRepair(t *testing.T) {
+	stop := make(chan struct{})
+	defer func() { close(stop) }()
+	errorChannel := make(chan error)
+	mrc := newFakeMemberRollController()
+	store, k8sClient, routerClient := initClients(t, stop, errorChannel, mrc)
+	mrc.setNamespaces("istio-system")
+
+	controlPlane := "istio-system"
+	createIngressGateway(t, k8sClient, controlPlane, map[string]string{"istio": "ingressgateway"})
+	createGateway(t, store, controlPlane, "gw", []string{"drift.com"}, map[string]string{"istio": "ingressgateway"}, false)
+	list, _ := getRoutes(t, routerClient, controlPlane, 1, time.Second)
+	if err := getError(errorChannel); err != nil {
+		t.Fatal(err)
+	}
+	route := list.Items[0]
+	routes := routerClient.Routes(controlPlane)
+
+	// deleted routes are recreated
+	if err := routes.Delete(context.TODO(), route.Name, v1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	retry.UntilSuccessOrFail(t, func() error {
+		_, err := routes.Get(context.TODO(), route.Name, v1.GetOptions{})
+		return err
+	}, retry.Timeout(time.Second))
+
+	// edited routes are corrected, keeping the changes of others
+	edited, err := routes.Get(context.TODO(), route.Name, v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited.Spec.To.Name = "other"
+	edited.Spec.Host = "other.com"
+	edited.Annotations["openshift.io/host.generated"] = "false"
+	if _, err := routes.Update(context.TODO(), edited, v1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	retry.UntilSuccessOrFail(t, func() error {
+		current, err := routes.Get(context.TODO(), route.Name, v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if current.Spec.To.Name != route.Spec.To.Name || current.Spec.Host != "drift.com" {
+			return fmt.Errorf("route was not corrected: %v", current.Spec)
+		}
+		if current.Annotations["openshift.io/host.generated"] != "false" {
+			return fmt.Errorf("annotations of others were not kept: %v", current.Annotations)
+		}
+		return nil
+	}, retry.Timeout(time.Second))
+
+	// routes of gateways that don't exist are removed, other routes are left alone
+	orphan := route.DeepCopy()
+	orphan.ResourceVersion = ""
+	orphan.Name = "orphan"
+	orphan.Labels[gatewayNameLabel] = "deleted"
+	if _, err := routes.Create(context.TODO(), orphan, v1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := routes.Create(context.TODO(), &routeapiv1.Route{ObjectMeta: v1.ObjectMeta{Name: "user", Namespace: controlPlane}}, v1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	retry.UntilSuccessOrFail(t, func() error {
+		if _, err := routes.Get(context.TODO(), orphan.Name, v1.GetOptions{}); err == nil {
+			return fmt.Errorf("orphan route was not deleted")
+		}
+		return nil
+	}, retry.Timeout(time.Second))
+	_, _ = getRoutes(t, routerClient, controlPlane, 2, time.Second)
+}
+
+// TestAddNamespace makes sure the routes of the gateways of a namespace that joins the mesh are kept until the store
+// notifies their Gateways
+func TestAddNamespace(t *testing.T) {
+	countCallsReset()
+
+	stop := make(chan struct{})
+	defer func() { close(stop) }()
+	errorChannel := make(chan error)
+	mrc := newFakeMemberRollController()
+	lagStore := &laggingStore{lagging: map[string]bool{}}
+	store, k8sClient, routerClient := initClientsWithStore(t, stop, errorChannel, mrc,
+		func(store model.ConfigStoreCache, _ kube.Client) model.ConfigStoreCache {
+			lagStore.ConfigStoreCache = store
+			return lagStore
+		})
+	mrc.setNamespaces("istio-system")
+
+	controlPlane := "istio-system"
+	createIngressGateway(t, k8sClient, controlPlane, map[string]string{"istio": "ingressgateway"})
+	createGateway(t, store, controlPlane, "gw", []string{"a.com"}, map[string]string{"istio": "ingressgateway"}, false)
+	list, _ := getRoutes(t, routerClient, controlPlane, 1, time.Second)
+	if err := getError(errorChannel); err != nil {
+		t.Fatal(err)
+	}
+	routes := routerClient.Routes(controlPlane)
+
+	// the route of a gateway of the namespace joining the mesh, which the store notifies after IOR observes them
+	lagStore.setLagging("bookinfo", true)
+	mrc.addNamespaces("bookinfo")
+	createGateway(t, store, "bookinfo", "gw", []string{"b.com"}, map[string]string{"istio": "ingressgateway"}, false)
+	existing := list.Items[0].DeepCopy()
+	existing.ResourceVersion = ""
+	existing.Name = getRouteName("bookinfo", "gw", "b.com", "")
+	existing.Spec.Host = "b.com"
+	existing.Labels[gatewayNamespaceLabel] = "bookinfo"
+	existing.Annotations[originalHostAnnotation] = "b.com"
+	if _, err := routes.Create(context.TODO(), existing, v1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if _, err := routes.Get(context.TODO(), existing.Name, v1.GetOptions{}); err != nil {
+		t.Fatalf("route of a gateway the store has not notified was deleted: %v", err)
+	}
+
+	// the route is kept for its gateway once the store notifies it
+	lagStore.setLagging("bookinfo", false)
+	_, _ = getRoutes(t, routerClient, controlPlane, 2, time.Second)
+	if err := getError(errorChannel); err != nil {
+		t.Fatal(err)
+	}
+
+	// routes of gateways that don't exist in the namespace are deleted
+	orphan := existing.DeepCopy()
+	orphan.Name = "orphan"
+	orphan.Labels[gatewayNameLabel] = "deleted"
+	if _, err := routes.Create(context.TODO(), orphan, v1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	retry.UntilSuccessOrFail(t, func() error {
+		if _, err := routes.Get(context.TODO(), orphan.Name, v1.GetOptions{}); err == nil {
+			return fmt.Errorf("orphan route was not deleted")
+		}
+		return nil
+	}, retry.Timeout(time.Second))
+
+	// the existing route was not recreated
+	assert.Equal(t, 3, countCallsGet("create"), "wrong number of calls to client.Routes().Create()")
+	assert.Equal(t, 1, countCallsGet("delete"), "wrong number of calls to client.Routes().Delete()")
+}
+
+// laggingStore holds back the events of the configs of some namespaces, like a store whose informers are adding the
+// namespaces that joined the mesh
+type laggingStore struct {
+	model.ConfigStoreCache
+	lock     sync.Mutex
+	lagging  map[string]bool
+	handlers []func()
+}
+
+func (s *laggingStore) RegisterEventHandler(kind config.GroupVersionKind, handler func(config.Config, config.Config, model.Event)) {
+	s.ConfigStoreCache.RegisterEventHandler(kind, func(old, curr config.Config, event model.Event) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.lagging[curr.Namespace] {
+			s.handlers = append(s.handlers, func() { handler(old, curr, event) })
+			return
+		}
+		handler(old, curr, event)
+	})
+}
+
+func (s *laggingStore) setLagging(namespace string, lagging bool) {
+	s.lock.Lock()
+	s.lagging[namespace] = lagging
+	handlers := s.handlers
+	if !lagging {
+		s.handlers = nil
+	}
+	s.lock.Unlock()
+	if !lagging {
+		for _, handler := range handlers {
+			handler()
+		}
+	}
+}
+
+// TestGatewayStatus makes sure the admission of the routes is reported in the status of their gateway
+func TestGatewayStatus(t *testing.T) {
+	enableStatus := features.EnableStatus
+	features.EnableStatus = true
+	defer func() { features.EnableStatus = enableStatus }()
+	countCallsReset()
+
+	stop := make(chan struct{})
+	defer func() { close(stop) }()
+	errorChannel := make(chan error)
+	mrc := newFakeMemberRollController()
+	store, k8sClient, routerClient := initClientsWithStore(t, stop, errorChannel, mrc,
+		func(store model.ConfigStoreCache, client kube.Client) model.ConfigStoreCache {
+			return statusStore{ConfigStoreCache: store, client: client}
+		})
+	mrc.setNamespaces("istio-system")
+
+	controlPlane := "istio-system"
+	createIngressGateway(t, k8sClient, controlPlane, map[string]string{"istio": "ingressgateway"})
+	createGateway(t, store, controlPlane, "gw", []string{"a.com", "b.com"}, map[string]string{"istio": "ingressgateway"}, false)
+	list, _ := getRoutes(t, routerClient, controlPlane, 2, time.Second)
+	if err := getError(errorChannel); err != nil {
+		t.Fatal(err)
+	}
+	expectCondition(t, store, controlPlane, "gw", "Unknown", "0/2 hosts admitted. a.com: pending admission; b.com: pending admission")
+
+	setAdmission(t, routerClient, findRouteByHost(list, "a.com"), k8sioapicorev1.ConditionTrue, "", "")
+	setAdmission(t, routerClient, findRouteByHost(list, "b.com"), k8sioapicorev1.ConditionFalse, "HostAlreadyClaimed", "route b already exposes b.com")
+	expectCondition(t, store, controlPlane, "gw", "False",
+		"1/2 hosts admitted. a.com: admitted by router default; b.com: rejected by router default: HostAlreadyClaimed: route b already exposes b.com")
+
+	setAdmission(t, routerClient, findRouteByHost(list, "b.com"), k8sioapicorev1.ConditionTrue, "", "")
+	expectCondition(t, store, controlPlane, "gw", "True", "2/2 hosts admitted. a.com: admitted by router default; b.com: admitted by router default")
+
+	// writing the status doesn't recreate the routes
+	assert.Equal(t, 2, countCallsGet("create"), "wrong number of calls to client.Routes().Create()")
+	assert.Equal(t, 0, countCallsGet("delete"), "wrong number of calls to client.Routes().Delete()")
+}
+
+// statusStore updates the status of gateways like the API server, keeping their spec, which the fake client doesn't
+type statusStore struct {
+	model.ConfigStoreCache
+	client kube.Client
+}
+
+func (s statusStore) UpdateStatus(cfg config.Config) (string, error) {
+	gateways := s.client.Istio().NetworkingV1alpha3().Gateways(cfg.Namespace)
+	gateway, err := gateways.Get(context.TODO(), cfg.Name, v1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	gateway.Status = *cfg.Status.(*meta.IstioStatus)
+	updated, err := gateways.Update(context.TODO(), gateway, v1.UpdateOptions{})
+	if err != nil {
+		return "", err
+	}
+	return updated.ResourceVersion, nil
+}
+
+func setAdmission(t *testing.T, routerClient routev1.RouteV1Interface, route *routeapiv1.Route, status k8sioapicorev1.ConditionStatus, reason, message string) {
+	t.Helper()
+
+	route.Status.Ingress = []routeapiv1.RouteIngress{{
+		Host:       route.Spec.Host,
+		RouterName: "default",
+		Conditions: []routeapiv1.RouteIngressCondition{{
+			Type:    routeapiv1.RouteAdmitted,
+			Status:  status,
+			Reason:  reason,
+			Message: message,
+		}},
+	}}
+	if _, err := routerClient.Routes(route.Namespace).UpdateStatus(context.TODO(), route, v1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectCondition(t *testing.T, store model.ConfigStoreCache, ns, name, status, message string) {
+	t.Helper()
+
+	retry.UntilSuccessOrFail(t, func() error {
+		cfg := store.Get(collections.IstioNetworkingV1Alpha3Gateways.Resource().GroupVersionKind(), name, ns)
+		if cfg == nil {
+			return fmt.Errorf("gateway %s/%s not found", ns, name)
+		}
+		istioStatus, ok := cfg.Status.(*meta.IstioStatus)
+		if !ok {
+			return fmt.Errorf("gateway %s/%s has no status", ns, name)
+		}
+		for _, condition := range istioStatus.Conditions {
+			if condition.Type != routesAdmittedCondition {
+				continue
+			}
+			if condition.Status != status || condition.Message != message {
+				return fmt.Errorf("unexpected condition %v", condition)
+			}
+			return nil
+		}
+		return fmt.Errorf("condition %s not found in %v", routesAdmittedCondition, istioStatus)
+	}, retry.Timeout(time.Second))
+}
+
+// TestGatewayAPI makes sure the hostnames of the routes bound to Gateway API Gateways are exposed
+func TestGatewayAPI(t *testing.T) {
+	enableServiceApis := features.EnableServiceApis
+	features.EnableServiceApis = true
+	defer func() { features.EnableServiceApis = enableServiceApis }()
+
+	stop := make(chan struct{})
+	defer func() { close(stop) }()
+	errorChannel := make(chan error)
+	mrc := newFakeMemberRollController()
+	store, k8sClient, routerClient := initClientsWithStore(t, stop, errorChannel, mrc,
+		func(store model.ConfigStoreCache, client kube.Client) model.ConfigStoreCache {
+			return aggregateStore(t, store, gateway.NewController(client, store, kubecontroller.Options{}))
+		})
+	mrc.setNamespaces("istio-system")
+
+	controlPlane := "istio-system"
+	createIngressGateway(t, k8sClient, controlPlane, map[string]string{"istio": "ingressgateway"})
+	createConfig(t, store, gvk.GatewayClass, "", "istio", &serviceapis.GatewayClassSpec{Controller: gateway.ControllerName})
+	domain := serviceapis.Hostname("*.gwapi.com")
+	createConfig(t, store, gvk.ServiceApisGateway, controlPlane, "gw", &serviceapis.GatewaySpec{
+		GatewayClassName: "istio",
+		Listeners: []serviceapis.Listener{{
+			Hostname: &domain,
+			Port:     80,
+			Protocol: serviceapis.HTTPProtocolType,
+			Routes:   serviceapis.RouteBindingSelector{Kind: gvk.HTTPRoute.Kind},
+		}},
+	})
+	createConfig(t, store, gvk.HTTPRoute, controlPlane, "http", httpRoute("a.gwapi.com", "b.gwapi.com"))
+
+	list, _ := getRoutes(t, routerClient, controlPlane, 2, time.Second)
+	expectRoutes(t, list, "gw-"+constants.KubernetesGatewayName, "a.gwapi.com", "b.gwapi.com")
+
+	// the routes follow the hostnames of the HTTPRoute
+	updateConfig(t, store, gvk.HTTPRoute, controlPlane, "http", httpRoute("a.gwapi.com", "c.gwapi.com"))
+	retry.UntilSuccessOrFail(t, func() error {
+		list, _ := getRoutes(t, routerClient, controlPlane, 2, time.Second)
+		if findRouteByHost(list, "c.gwapi.com") == nil || findRouteByHost(list, "b.gwapi.com") != nil {
+			return fmt.Errorf("routes were not updated: %v", list.Items)
+		}
+		return nil
+	}, retry.Timeout(time.Second))
+
+	if err := store.Delete(gvk.ServiceApisGateway, "gw", controlPlane, nil); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = getRoutes(t, routerClient, controlPlane, 0, time.Second)
+}
+
+// TestIngress makes sure the hostnames of the rules of Ingresses handled by istiod are exposed
+func TestIngress(t *testing.T) {
+	stop := make(chan struct{})
+	defer func() { close(stop) }()
+	errorChannel := make(chan error)
+	mrc := newFakeMemberRollController()
+	_, k8sClient, routerClient := initClientsWithStore(t, stop, errorChannel, mrc,
+		func(store model.ConfigStoreCache, client kube.Client) model.ConfigStoreCache {
+			meshConfig := mesh.DefaultMeshConfig()
+			return aggregateStore(t, store, ingress.NewController(client, mesh.NewFixedWatcher(&meshConfig), kubecontroller.Options{}))
+		})
+	mrc.setNamespaces("istio-system")
+
+	controlPlane := "istio-system"
+	createIngressGateway(t, k8sClient, controlPlane, map[string]string{"istio": "ingressgateway"})
+
+	ingresses := k8sClient.NetworkingV1beta1().Ingresses(controlPlane)
+	created, err := ingresses.Create(context.TODO(), &networkingv1beta1.Ingress{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        "ingress",
+			Annotations: map[string]string{"kubernetes.io/ingress.class": "istio"},
+		},
+		Spec: networkingv1beta1.IngressSpec{
+			Rules: []networkingv1beta1.IngressRule{ingressRule("a.ingress.com"), ingressRule("b.ingress.com")},
+		},
+	}, v1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, _ := getRoutes(t, routerClient, controlPlane, 2, time.Second)
+	expectRoutes(t, list, "ingress-"+constants.IstioIngressGatewayName, "a.ingress.com", "b.ingress.com")
+
+	// ingresses of other controllers are ignored
+	created.Annotations["kubernetes.io/ingress.class"] = "openshift-default"
+	if _, err := ingresses.Update(context.TODO(), created, v1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = getRoutes(t, routerClient, controlPlane, 0, time.Second)
+}
+
+// expectRoutes checks the routes of the hosts of a translated gateway, which has no annotations to copy
+func expectRoutes(t *testing.T, list *routeapiv1.RouteList, gatewayName string, hosts ...string) {
+	t.Helper()
+
+	for _, host := range hosts {
+		route := findRouteByHost(list, host)
+		if route == nil {
+			t.Fatalf("could not find a route with hostname %s", host)
+		}
+		if route.Labels[gatewayNameLabel] != gatewayName {
+			t.Fatalf("wrong label, expecting %s, got %s", gatewayName, route.Labels[gatewayNameLabel])
+		}
+	}
+}
+
+func aggregateStore(t *testing.T, stores ...model.ConfigStoreCache) model.ConfigStoreCache {
+	t.Helper()
+
+	store, err := configaggregate.MakeCache(stores)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func createConfig(t *testing.T, store model.ConfigStoreCache, kind config.GroupVersionKind, ns, name string, spec config.Spec) {
+	t.Helper()
+
+	_, err := store.Create(config.Config{
+		Meta: config.Meta{GroupVersionKind: kind, Namespace: ns, Name: name},
+		Spec: spec,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func updateConfig(t *testing.T, store model.ConfigStoreCache, kind config.GroupVersionKind, ns, name string, spec config.Spec) {
+	t.Helper()
+
+	cfg := store.Get(kind, name, ns)
+	if cfg == nil {
+		t.Fatalf("%s %s/%s not found", kind.Kind, ns, name)
+	}
+	cfg.Spec = spec
+	if _, err := store.Update(*cfg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func httpRoute(hostnames ...string) *serviceapis.HTTPRouteSpec {
+	service := "httpbin"
+	spec := &serviceapis.HTTPRouteSpec{
+		Rules: []serviceapis.HTTPRouteRule{{
+			ForwardTo: []serviceapis.HTTPRouteForwardTo{{ServiceName: &service, Port: 80}},
+		}},
+	}
+	for _, hostname := range hostnames {
+		spec.Hostnames = append(spec.Hostnames, serviceapis.Hostname(hostname))
+	}
+	return spec
+}
+
+func ingressRule(host string) networkingv1beta1.IngressRule {
+	return networkingv1beta1.IngressRule{
+		Host: host,
+		IngressRuleValue: networkingv1beta1.IngressRuleValue{
+			HTTP: &networkingv1beta1.HTTPIngressRuleValue{
+				Paths: []networkingv1beta1.HTTPIngressPath{{
+					Path:    "/",
+					Backend: networkingv1beta1.IngressBackend{ServiceName: "httpbin", ServicePort: intstr.FromInt(80)},
+				}},
+			},
+		},
+	}
+}
+
+// TestRouterShards makes sure gateways get a route for each router shard exposing them
+func TestRouterShards(t *testing.T) {
+	routerShards := features.IORRouterShards
+	features.IORRouterShards = `{
+		"internal": {"routeLabels": {"router": "internal"}},
+		"public": {"routeLabels": {"router": "public"}, "namespaceSelector": {"exposure": "public"}, "gatewaySelector": {"expose": "public"}}
+	}`
+	defer func() { features.IORRouterShards = routerShards }()
+
+	stop := make(chan struct{})
+	defer func() { close(stop) }()
+	errorChannel := make(chan error)
+	mrc := newFakeMemberRollController()
+	store, k8sClient, routerClient := initClients(t, stop, errorChannel, mrc)
+
+	controlPlane, public := "istio-system", "public-gateways"
+	createNamespace(t, k8sClient, controlPlane, nil)
+	createNamespace(t, k8sClient, public, map[string]string{"exposure": "public"})
+	mrc.setNamespaces(controlPlane, public)
+	createIngressGateway(t, k8sClient, controlPlane, map[string]string{"istio": "ingressgateway"})
+	createIngressGateway(t, k8sClient, public, map[string]string{"istio": "ingressgateway"})
+
+	// the internal shard is named by the gateway, the public shard selects it
+	gateway := config.Config{
+		Meta: config.Meta{
+			GroupVersionKind: collections.IstioNetworkingV1Alpha3Gateways.Resource().GroupVersionKind(),
+			Namespace:        controlPlane,
+			Name:             "gw",
+			Labels:           map[string]string{"expose": "public"},
+			Annotations:      map[string]string{routerShardsAnnotation: "internal"},
+		},
+		Spec: &networking.Gateway{
+			Selector: map[string]string{"istio": "ingressgateway"},
+			Servers:  []*networking.Server{{Hosts: []string{"shard.com"}}},
+		},
+	}
+	if _, err := store.Create(gateway); err != nil {
+		t.Fatal(err)
+	}
+
+	// the routes of the public shard are created in the namespaces it admits
+	list, _ := getRoutes(t, routerClient, controlPlane, 1, time.Second)
+	expectShard(t, list.Items[0], "internal")
+	list, _ = getRoutes(t, routerClient, public, 1, time.Second)
+	expectShard(t, list.Items[0], "public")
+	if err := getError(errorChannel); err != nil {
+		t.Fatal(err)
+	}
+
+	// unknown shards leave the routes as they are
+	updated := store.Get(gateway.GroupVersionKind, gateway.Name, gateway.Namespace)
+	updated.Annotations = map[string]string{routerShardsAnnotation: "internal,external"}
+	if _, err := store.Update(*updated); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errorChannel; err == nil || !strings.Contains(err.Error(), `unknown router shard "external"`) {
+		t.Fatalf("expected an unknown shard error, got %v", err)
+	}
+	_, _ = getRoutes(t, routerClient, controlPlane, 1, time.Second)
+	_, _ = getRoutes(t, routerClient, public, 1, time.Second)
+
+	// the routes of the shards that no longer expose the gateway are deleted
+	updated = store.Get(gateway.GroupVersionKind, gateway.Name, gateway.Namespace)
+	updated.Labels = nil
+	updated.Annotations = nil
+	if _, err := store.Update(*updated); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = getRoutes(t, routerClient, public, 0, time.Second)
+	list, _ = getRoutes(t, routerClient, controlPlane, 1, time.Second)
+	expectShard(t, list.Items[0], "")
+}
+
+func TestParseRouterShards(t *testing.T) {
+	cases := []struct {
+		value  string
+		shards []string
+		err    string
+	}{
+		{value: "", shards: []string{}},
+		{value: `{"internal": {}, "public": {"routeLabels": {"router": "public"}}}`, shards: []string{"internal", "public"}},
+		{value: `{"internal": null}`, shards: []string{"internal"}},
+		{value: `["internal"]`, err: "invalid router shards"},
+		{value: `{"Internal": {}}`, err: `invalid router shard name "Internal"`},
+	}
+	for _, c := range cases {
+		shards, err := parseRouterShards(c.value)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: expected error %q, got %v", c.value, c.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", c.value, err)
+			continue
+		}
+		names := []string{}
+		for name, shard := range shards {
+			assert.Equal(t, name, shard.Name())
+			names = append(names, name)
+		}
+		assert.ElementsMatch(t, c.shards, names)
+	}
+}
+
+func createNamespace(t *testing.T, client kube.Client, name string, labels map[string]string) {
+	t.Helper()
+
+	_, err := client.CoreV1().Namespaces().Create(context.TODO(), &k8sioapicorev1.Namespace{
+		ObjectMeta: v1.ObjectMeta{Name: name, Labels: labels},
+	}, v1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectShard(t *testing.T, route routeapiv1.Route, shard string) {
+	t.Helper()
+
+	if route.Labels[routerShardLabel] != shard {
+		t.Fatalf("expected route %s/%s for router shard %q, got %q", route.Namespace, route.Name, shard, route.Labels[routerShardLabel])
+	}
+	if shard != "" && route.Labels["router"] != shard {
+		t.Fatalf("labels of router shard %s missing in route %s/%s: %v", shard, route.Namespace, route.Name, route.Labels)
+	}
+	if shard == "" && route.Labels["router"] != "" {
+		t.Fatalf("route %s/%s of the default routers has router shard labels: %v", route.Namespace, route.Name, route.Labels)
+	}
+}
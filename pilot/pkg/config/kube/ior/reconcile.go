@@ -0,0 +1,381 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ior
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/types"
+	"github.com/hashicorp/go-multierror"
+	v1 "github.com/openshift/api/route/v1"
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/api/meta/v1alpha1"
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/status"
+	"istio.io/istio/pkg/config"
+)
+
+const (
+	// reconcileInterval is the period of the reconciliation of all routes,
+	// which catches up with changes the informers may have missed
+	reconcileInterval = time.Minute
+
+	// routesAdmittedCondition is the condition of the status of a Gateway
+	// reporting whether the routers admitted the routes of all its hosts
+	routesAdmittedCondition = "RoutesAdmitted"
+)
+
+// hostStatus is the outcome of exposing a host of a gateway
+type hostStatus struct {
+	host  string
+	route *v1.Route
+	err   error
+}
+
+// requestReconcile schedules a reconciliation of the routes, unless one is
+// already pending.
+func (r *route) requestReconcile() {
+	select {
+	case r.reconcileRequests <- struct{}{}:
+	default:
+	}
+}
+
+// runReconciler reconciles the routes when requested, e.g. when a route
+// changes, and periodically until IOR stops.
+func (r *route) runReconciler() {
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.forgetPendingWrites()
+			r.translatedChanged.Store(true)
+		case <-r.reconcileRequests:
+		}
+
+		if err := r.reconcile(); err != nil {
+			IORLog.Warnf("could not reconcile routes: %v", err)
+		}
+	}
+}
+
+func (r *route) reconcile() error {
+	r.gatewaysLock.Lock()
+	defer r.gatewaysLock.Unlock()
+
+	return r.reconcileLocked()
+}
+
+// reconcileLocked makes the routes labelled generatedByLabel match the
+// gateways: missing routes are recreated, drifted routes are corrected and
+// routes not belonging to any gateway are deleted.
+// Must be called with gatewaysLock locked
+func (r *route) reconcileLocked() error {
+	var result *multierror.Error
+
+	if r.translatedChanged.CAS(true, false) {
+		if err := r.updateTranslatedGateways(); err != nil {
+			r.translatedChanged.Store(true)
+			result = multierror.Append(result, err)
+		}
+	}
+
+	actual := r.cachedRoutes()
+	for _, syncRoute := range r.gatewaysMap {
+		result = multierror.Append(result, r.syncGateway(syncRoute, actual))
+	}
+
+	// The routes left don't belong to any gateway, unless the store has not
+	// notified their gateway yet, e.g. after its namespace joined the mesh
+	for key, route := range actual.routes {
+		gatewayNamespace, gatewayName := route.Labels[gatewayNamespaceLabel], route.Labels[gatewayNameLabel]
+		if actual.pending[key] || !isTranslatedGateway(gatewayName) && r.gatewayExists(gatewayNamespace, gatewayName) {
+			continue
+		}
+		result = multierror.Append(result, r.deleteRoute(route))
+	}
+
+	return result.ErrorOrNil()
+}
+
+// cachedRoutes are the routes generated by IOR in the informer cache, by
+// namespace/name, and those whose last write the informer had not observed
+// before they were read, whose cached state is stale
+type cachedRoutes struct {
+	routes  map[string]*v1.Route
+	pending map[string]bool
+}
+
+func (r *route) cachedRoutes() cachedRoutes {
+	// The pending writes are read first, so the other routes are up to date
+	pending := r.pendingKeys()
+	routes := map[string]*v1.Route{}
+	for _, obj := range r.routeInformer.GetStore().List() {
+		if route, ok := obj.(*v1.Route); ok {
+			routes[routeKey(route.Namespace, route.Name)] = route
+		}
+	}
+	return cachedRoutes{routes: routes, pending: pending}
+}
+
+// syncGateway creates or corrects the routes of the hosts of a gateway, one
+// for each router shard exposing it, which are removed from the actual
+// routes, and reports their admission in the status of the gateway.
+// Must be called with gatewaysLock locked
+func (r *route) syncGateway(syncRoute *syncRoutes, actual cachedRoutes) error {
+	var result *multierror.Error
+	var hosts []hostStatus
+	seen := map[string]bool{}
+
+	syncRoute.routes = nil
+	shards, err := r.gatewayShards(syncRoute.metadata)
+	if err != nil {
+		// Leave the routes as they are until the shards of the gateway are fixed
+		for key, route := range actual.routes {
+			if route.Labels[gatewayNamespaceLabel] == syncRoute.metadata.Namespace && route.Labels[gatewayNameLabel] == syncRoute.metadata.Name {
+				delete(actual.routes, key)
+				syncRoute.routes = append(syncRoute.routes, route)
+			}
+		}
+		for _, server := range syncRoute.gateway.Servers {
+			for _, host := range server.Hosts {
+				hosts = append(hosts, hostStatus{host: host, err: err})
+			}
+		}
+		r.reportStatus(syncRoute.metadata, hosts)
+		return err
+	}
+
+	for _, server := range syncRoute.gateway.Servers {
+		for _, host := range server.Hosts {
+			actualHost, _ := getActualHost(host, false)
+			for _, shard := range shards {
+				name := getRouteName(syncRoute.metadata.Namespace, syncRoute.metadata.Name, actualHost, shard.Name())
+				if seen[name] {
+					continue
+				}
+				seen[name] = true
+
+				status := r.syncRoute(syncRoute, server, host, shard, name, actual)
+				if status.err != nil {
+					result = multierror.Append(result, status.err)
+				}
+				hosts = append(hosts, status)
+			}
+		}
+	}
+
+	r.reportStatus(syncRoute.metadata, hosts)
+	return result.ErrorOrNil()
+}
+
+// syncRoute creates or corrects the route, named name, of a host of a gateway
+// on a router shard, and adds it to the routes of the gateway.
+// Must be called with gatewaysLock locked
+func (r *route) syncRoute(syncRoute *syncRoutes, server *networking.Server, host string, shard *routerShard, name string,
+	actual cachedRoutes) hostStatus {
+	described := shard.describe(host)
+	desired, err := r.buildRoute(syncRoute.metadata, syncRoute.gateway, host, server.Tls, shard)
+	if err != nil {
+		// Leave the route as it is until the gateway can be exposed again
+		for key, route := range actual.routes {
+			if route.Name == name {
+				delete(actual.routes, key)
+				syncRoute.routes = append(syncRoute.routes, route)
+			}
+		}
+		return hostStatus{host: described, err: err}
+	}
+
+	key := routeKey(desired.Namespace, desired.Name)
+	current, ok := actual.routes[key]
+	delete(actual.routes, key)
+	switch {
+	case actual.pending[key]:
+		// The informer has not observed the last write of IOR yet
+		if current == nil {
+			current = desired
+		}
+	case !ok:
+		current, err = r.createRoute(desired)
+	case drifted(current, desired):
+		current, err = r.correctRoute(current, desired)
+	}
+	if err != nil {
+		return hostStatus{host: described, err: err}
+	}
+
+	syncRoute.routes = append(syncRoute.routes, current)
+	return hostStatus{host: described, route: current}
+}
+
+// drifted returns whether a route differs from the one IOR would create for
+// its host, ignoring the fields set by the API server and the routers.
+func drifted(current, desired *v1.Route) bool {
+	// the host of routes for * is generated by the router
+	if desired.Spec.Host != "" && current.Spec.Host != desired.Spec.Host {
+		return true
+	}
+	if current.Spec.Port == nil || current.Spec.Port.TargetPort != desired.Spec.Port.TargetPort ||
+		current.Spec.To.Name != desired.Spec.To.Name ||
+		current.Spec.WildcardPolicy != desired.Spec.WildcardPolicy ||
+		!reflect.DeepEqual(current.Spec.TLS, desired.Spec.TLS) {
+		return true
+	}
+	for key, value := range desired.Labels {
+		// the routes are only updated when their hosts change
+		if key != gatewayResourceVersionLabel && current.Labels[key] != value {
+			return true
+		}
+	}
+	for key, value := range desired.Annotations {
+		if current.Annotations[key] != value {
+			return true
+		}
+	}
+	return false
+}
+
+// correctRoute updates a drifted route, keeping the labels and annotations
+// added by others.
+func (r *route) correctRoute(current, desired *v1.Route) (*v1.Route, error) {
+	updated := current.DeepCopy()
+	if desired.Spec.Host != "" {
+		updated.Spec.Host = desired.Spec.Host
+	}
+	updated.Spec.Port = desired.Spec.Port
+	updated.Spec.To.Name = desired.Spec.To.Name
+	updated.Spec.WildcardPolicy = desired.Spec.WildcardPolicy
+	updated.Spec.TLS = desired.Spec.TLS
+	if updated.Labels == nil {
+		updated.Labels = map[string]string{}
+	}
+	for key, value := range desired.Labels {
+		updated.Labels[key] = value
+	}
+	if updated.Annotations == nil {
+		updated.Annotations = map[string]string{}
+	}
+	delete(updated.Annotations, credentialNameAnnotation)
+	for key, value := range desired.Annotations {
+		updated.Annotations[key] = value
+	}
+
+	nr, err := r.routerClient.Routes(updated.Namespace).Update(context.TODO(), updated, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error correcting the route %s/%s for the host %s: %s", updated.Namespace, updated.Name, getHost(*updated), err)
+	}
+	r.recordWrite(routeKey(nr.Namespace, nr.Name), nr)
+
+	IORLog.Infof("Corrected drifted route %s/%s for hostname %s (gateway: %s/%s)",
+		nr.Namespace, nr.Name, nr.Spec.Host, desired.Labels[gatewayNamespaceLabel], desired.Labels[gatewayNameLabel])
+	return nr, nil
+}
+
+// reportStatus writes the admission of the routes of the hosts of a gateway
+// to its routesAdmittedCondition, if it changed.  Translated gateways have
+// no status of their own.
+func (r *route) reportStatus(metadata config.Meta, hosts []hostStatus) {
+	if !r.writeStatus || isTranslatedGateway(metadata.Name) {
+		return
+	}
+
+	current := r.store.Get(metadata.GroupVersionKind, metadata.Name, metadata.Namespace)
+	if current == nil {
+		return
+	}
+	changed, desired := status.SetCondition(current, admissionCondition(hosts))
+	if !changed {
+		return
+	}
+	current.Status = desired
+	if _, err := r.store.UpdateStatus(*current); err != nil {
+		IORLog.Warnf("could not update the status of gateway %s/%s, will try again later: %v", metadata.Namespace, metadata.Name, err)
+	}
+}
+
+// admissionCondition summarizes the admission of the routes of the hosts of
+// a gateway, which is true once all of them were admitted by the routers.
+func admissionCondition(hosts []hostStatus) *v1alpha1.IstioCondition {
+	var admitted, rejected int
+	results := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		result, ok := host.admission()
+		switch {
+		case ok:
+			admitted++
+		case result != "":
+			rejected++
+		default:
+			result = "pending admission"
+		}
+		results = append(results, host.host+": "+result)
+	}
+
+	condition := &v1alpha1.IstioCondition{
+		Type:               routesAdmittedCondition,
+		Status:             string(corev1.ConditionUnknown),
+		Reason:             "Pending",
+		LastProbeTime:      types.TimestampNow(),
+		LastTransitionTime: types.TimestampNow(),
+		Message:            fmt.Sprintf("%d/%d hosts admitted. %s", admitted, len(hosts), strings.Join(results, "; ")),
+	}
+	if rejected > 0 {
+		condition.Status = string(corev1.ConditionFalse)
+		condition.Reason = "Rejected"
+	} else if admitted == len(hosts) {
+		condition.Status = string(corev1.ConditionTrue)
+		condition.Reason = "Admitted"
+	}
+	return condition
+}
+
+// admission returns whether the route of the host was admitted by a router,
+// or why it was not, which is empty while no router considered it.  A
+// rejection by any router takes precedence.
+func (h hostStatus) admission() (string, bool) {
+	if h.err != nil {
+		return "not exposed: " + h.err.Error(), false
+	}
+
+	var result string
+	var admitted bool
+	for _, ingress := range h.route.Status.Ingress {
+		for _, condition := range ingress.Conditions {
+			if condition.Type != v1.RouteAdmitted {
+				continue
+			}
+			switch condition.Status {
+			case corev1.ConditionTrue:
+				if result == "" {
+					result, admitted = "admitted by router "+ingress.RouterName, true
+				}
+			case corev1.ConditionFalse:
+				return fmt.Sprintf("rejected by router %s: %s: %s", ingress.RouterName, condition.Reason, condition.Message), false
+			}
+		}
+	}
+	return result, admitted
+}
@@ -18,14 +18,19 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/hashicorp/go-multierror"
+	xnsinformers "github.com/maistra/xns-informer/pkg/informers"
 	v1 "github.com/openshift/api/route/v1"
 	routev1 "github.com/openshift/client-go/route/clientset/versioned/typed/route/v1"
+	"go.uber.org/atomic"
 	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -33,8 +38,11 @@ import (
 	"k8s.io/client-go/tools/cache"
 
 	networking "istio.io/api/networking/v1alpha3"
+	istioclient "istio.io/client-go/pkg/clientset/versioned"
+	"istio.io/istio/pilot/pkg/features"
 	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pkg/config"
+	"istio.io/istio/pkg/config/protocol"
 	"istio.io/istio/pkg/config/schema/collections"
 	"istio.io/istio/pkg/kube"
 	"istio.io/istio/pkg/servicemesh/controller"
@@ -48,6 +56,24 @@ const (
 	gatewayNameLabel            = maistraPrefix + "gateway-name"
 	gatewayNamespaceLabel       = maistraPrefix + "gateway-namespace"
 	gatewayResourceVersionLabel = maistraPrefix + "gateway-resourceVersion"
+	// tlsTerminationAnnotation selects the termination of the routes created
+	// for the TLS servers of a Gateway: passthrough (the default), edge or
+	// reencrypt.  Edge and reencrypt routes are given the certificate of the
+	// server's credentialName.  Edge routes forward plain HTTP to the http2
+	// port of the gateway service, so the Gateway must also have a plain HTTP
+	// server for the host.  The credential of reencrypt routes must contain
+	// the CA certificate the router verifies the gateway with, see
+	// destinationCACertKey.
+	tlsTerminationAnnotation = maistraPrefix + "tls-termination"
+	// credentialNameAnnotation records the secret the certificates of a route
+	// are copied from, so they are updated when it changes
+	credentialNameAnnotation = maistraPrefix + "credential-name"
+	// routerShardsAnnotation names the router shards exposing the hosts of a
+	// Gateway, comma-separated, which get a route each.  The shards are
+	// configured with features.IORRouterShards.
+	routerShardsAnnotation = maistraPrefix + "router-shards"
+	// routerShardLabel records the router shard a route was created for
+	routerShardLabel = maistraPrefix + "router-shard"
 )
 
 type syncRoutes struct {
@@ -68,6 +94,37 @@ type route struct {
 	alive              bool
 	stop               <-chan struct{}
 	handleEventTimeout time.Duration
+	secrets            *secretWatcher
+
+	istioClient istioclient.Interface
+
+	// informers of the member namespaces, in place of listing the pods,
+	// services and routes on every event
+	namespaceSet    xnsinformers.NamespaceSet
+	podInformer     xnsinformers.MultiNamespaceInformer
+	serviceInformer xnsinformers.MultiNamespaceInformer
+	routeInformer   xnsinformers.MultiNamespaceInformer
+	// gatewayInformer tells which Gateways exist in the member namespaces
+	// that have synced, which the Gateway store may not have notified yet,
+	// e.g. after the namespace joined the mesh
+	gatewayInformer xnsinformers.MultiNamespaceInformer
+
+	reconcileRequests chan struct{}
+	// pendingWrites holds the resource versions of the routes written by
+	// IOR, or "" for the deleted ones, until the route informer observes them
+	pendingWrites map[string]string
+	pendingLock   sync.Mutex
+	// writeStatus enables reporting the admission of the routes in the
+	// status of the gateways
+	writeStatus bool
+	// shards are the router shards gateways can be exposed by, by name
+	shards map[string]*routerShard
+	// namespaceInformer watches the labels of the member namespaces, if the
+	// router shards select the namespaces they admit routes from
+	namespaceInformer xnsinformers.MultiNamespaceInformer
+	// translatedChanged is set when the gateways translated from Gateway API
+	// and Ingress resources must be listed again before reconciling
+	translatedChanged *atomic.Bool
 
 	// memberroll functionality
 	mrc              controller.MemberRollController
@@ -104,9 +161,15 @@ func newRoute(
 		return nil, fmt.Errorf("routes are not supported in this cluster")
 	}
 
+	shards, err := parseRouterShards(features.IORRouterShards)
+	if err != nil {
+		return nil, err
+	}
+
 	r := &route{}
 
 	r.kubeClient = kubeClient.GetActualClient()
+	r.istioClient = kubeClient.GetIstioClient()
 	r.routerClient = routerClient
 	r.pilotNamespace = pilotNamespace
 	r.store = store
@@ -115,6 +178,20 @@ func newRoute(
 	r.stop = stop
 	r.initialSyncRun = make(chan struct{})
 	r.handleEventTimeout = kubeClient.GetHandleEventTimeout()
+	r.reconcileRequests = make(chan struct{}, 1)
+	r.pendingWrites = map[string]string{}
+	r.writeStatus = features.EnableStatus
+	r.translatedChanged = atomic.NewBool(true)
+	r.shards = shards
+	r.secrets = newSecretWatcher(r.kubeClient, func(_, _ string) {
+		// the certificates of the routes may have changed
+		r.requestReconcile()
+	}, stop)
+	r.namespaceSet = xnsinformers.NewNamespaceSet(pilotNamespace)
+	r.startInformers()
+	if selectsNamespaces(shards) {
+		r.startNamespaceInformer()
+	}
 
 	if r.mrc != nil {
 		IORLog.Debugf("Registering IOR into SMMR broadcast")
@@ -133,15 +210,14 @@ func newRoute(
 
 // initialSync runs on initialization only.
 //
-// It lists all Istio Gateways (source of truth) and OpenShift Routes, compares them and makes the necessary adjustments
-// (creation and/or removal of routes) so that gateways and routes be in sync.
-func (r *route) initialSync(initialNamespaces []string) error {
-	var result *multierror.Error
-	r.gatewaysMap = make(map[string]*syncRoutes)
-
+// It lists all Istio Gateways (source of truth) and compares them with the OpenShift Routes in the informer cache, making
+// the necessary adjustments (creation, correction and/or removal of routes) so that gateways and routes be in sync.
+func (r *route) initialSync() error {
 	r.gatewaysLock.Lock()
 	defer r.gatewaysLock.Unlock()
 
+	r.gatewaysMap = make(map[string]*syncRoutes)
+
 	// List the gateways and put them into the gatewaysMap
 	// The store must be synced otherwise we might get an empty list
 	// We enforce this before calling this function in UpdateNamespaces()
@@ -152,67 +228,15 @@ func (r *route) initialSync(initialNamespaces []string) error {
 	IORLog.Debugf("initialSync() - Got %d Gateway(s)", len(configs))
 
 	for i, cfg := range configs {
+		// the translated gateways are added when reconciling
+		if isTranslatedGateway(cfg.Name) {
+			continue
+		}
 		IORLog.Debugf("initialSync() - Parsing Gateway [%d] %s/%s", i+1, cfg.Namespace, cfg.Name)
 		r.addNewSyncRoute(cfg)
 	}
 
-	// List the routes and put them into a map. Map key is the route object name
-	routes := map[string]v1.Route{}
-	for _, ns := range initialNamespaces {
-		IORLog.Debugf("initialSync() - Listing routes in ns %s", ns)
-		routeList, err := r.routerClient.Routes(ns).List(context.TODO(), metav1.ListOptions{
-			LabelSelector: fmt.Sprintf("%s=%s", generatedByLabel, generatedByValue),
-		})
-		if err != nil {
-			return fmt.Errorf("could not get list of Routes in namespace %s: %s", ns, err)
-		}
-		for _, route := range routeList.Items {
-			routes[route.Name] = route
-		}
-	}
-	IORLog.Debugf("initialSync() - Got %d route(s) across all %d namespace(s)", len(routes), len(initialNamespaces))
-
-	// Now that we have maps and routes mapped we can compare them (Gateways are the source of truth)
-	for _, syncRoute := range r.gatewaysMap {
-		for _, server := range syncRoute.gateway.Servers {
-			for _, host := range server.Hosts {
-				actualHost, _ := getActualHost(host, false)
-				routeName := getRouteName(syncRoute.metadata.Namespace, syncRoute.metadata.Name, actualHost)
-				route, ok := routes[routeName]
-				if ok {
-					// A route for this host was found, remove its entry in this map so that in the end only orphan routes are left
-					delete(routes, routeName)
-
-					// Route matches, no need to create one. Put it in the gatewaysMap and move to the next one
-					if syncRoute.metadata.ResourceVersion == route.Labels[gatewayResourceVersionLabel] {
-						syncRoute.routes = append(syncRoute.routes, &route)
-						continue
-					}
-
-					// Route does not match, remove it.
-					result = multierror.Append(result, r.deleteRoute(&route))
-				}
-
-				// Route is not found or was removed above because it didn't match. We need to create one now.
-				route2, err := r.createRoute(syncRoute.metadata, syncRoute.gateway, host, server.Tls)
-				if err != nil {
-					result = multierror.Append(result, err)
-				} else {
-					// Put it in the gatewaysMap and move to the next one
-					syncRoute.routes = append(syncRoute.routes, route2)
-				}
-			}
-		}
-	}
-
-	// At this point there are routes for every hostname in every Gateway.
-	// The `routes` map should only contain "orphan" routes, i.e., routes that do not belong to any Gateway
-	//
-	for _, route := range routes {
-		result = multierror.Append(result, r.deleteRoute(&route))
-	}
-
-	return result.ErrorOrNil()
+	return r.reconcileLocked()
 }
 
 func gatewaysMapKey(namespace, name string) string {
@@ -263,8 +287,6 @@ func (r *route) ensureNamespaceExists(cfg config.Config) error {
 }
 
 func (r *route) handleAdd(cfg config.Config) error {
-	var result *multierror.Error
-
 	if err := r.ensureNamespaceExists(cfg); err != nil {
 		return err
 	}
@@ -277,19 +299,44 @@ func (r *route) handleAdd(cfg config.Config) error {
 		return nil
 	}
 
+	syncRoute := r.addNewSyncRoute(cfg)
+	return r.syncGateway(syncRoute, r.cachedRoutes())
+}
+
+// handleUpdate updates the routes of a gateway in place, deleting only the
+// routes of the hosts that were removed from it.
+func (r *route) handleUpdate(cfg config.Config) error {
+	var result *multierror.Error
+
+	if err := r.ensureNamespaceExists(cfg); err != nil {
+		return err
+	}
+
+	r.gatewaysLock.Lock()
+	defer r.gatewaysLock.Unlock()
+
+	previous := r.gatewaysMap[gatewaysMapKey(cfg.Namespace, cfg.Name)]
 	syncRoute := r.addNewSyncRoute(cfg)
 
-	for _, server := range syncRoute.gateway.Servers {
-		for _, host := range server.Hosts {
-			route, err := r.createRoute(cfg.Meta, syncRoute.gateway, host, server.Tls)
-			if err != nil {
-				result = multierror.Append(result, err)
-			} else {
-				syncRoute.routes = append(syncRoute.routes, route)
+	// the routes are kept if the shards of the gateway are invalid, see syncGateway
+	if shards, err := r.gatewayShards(cfg.Meta); previous != nil && err == nil {
+		names := map[string]bool{}
+		for _, server := range syncRoute.gateway.Servers {
+			for _, host := range server.Hosts {
+				actualHost, _ := getActualHost(host, false)
+				for _, shard := range shards {
+					names[getRouteName(cfg.Namespace, cfg.Name, actualHost, shard.Name())] = true
+				}
+			}
+		}
+		for _, route := range previous.routes {
+			if !names[route.Name] {
+				result = multierror.Append(result, r.deleteRoute(route))
 			}
 		}
 	}
 
+	result = multierror.Append(result, r.syncGateway(syncRoute, r.cachedRoutes()))
 	return result.ErrorOrNil()
 }
 
@@ -324,10 +371,7 @@ func (r *route) handleEvent(event model.Event, cfg config.Config) error {
 		return r.handleAdd(cfg)
 
 	case model.EventUpdate:
-		var result *multierror.Error
-		result = multierror.Append(result, r.handleDel(cfg))
-		result = multierror.Append(result, r.handleAdd(cfg))
-		return result.ErrorOrNil()
+		return r.handleUpdate(cfg)
 
 	case model.EventDelete:
 		return r.handleDel(cfg)
@@ -346,30 +390,54 @@ func (r *route) SetNamespaces(namespaces ...string) {
 	r.namespaceLock.Lock()
 	r.namespaces = namespaces
 	r.namespaceLock.Unlock()
+	r.namespaceSet.SetNamespaces(namespaces...)
 
 	if r.gotInitialUpdate {
+		go r.reconcileWhenSynced()
 		return
 	}
 	r.gotInitialUpdate = true
 
 	// In the first update we perform an initial sync
 	go func() {
-		// But only after gateway store cache is synced
-		IORLog.Debug("Waiting for the Gateway store cache to sync before performing our initial sync")
-		if !cache.WaitForNamedCacheSync("Gateways", r.stop, r.store.HasSynced) {
-			IORLog.Infof("Failed to sync Gateway store cache. Not performing initial sync.")
+		// But only after gateway store and informer caches are synced
+		IORLog.Debug("Waiting for the Gateway store and IOR informer caches to sync before performing our initial sync")
+		if !cache.WaitForNamedCacheSync("Gateways", r.stop, r.store.HasSynced, r.informersSynced) {
+			IORLog.Infof("Failed to sync Gateway store and IOR informer caches. Not performing initial sync.")
 			return
 		}
-		IORLog.Debug("Gateway store cache synced. Performing our initial sync now")
+		IORLog.Debug("Gateway store and IOR informer caches synced. Performing our initial sync now")
 
-		if err := r.initialSync(namespaces); err != nil {
+		if err := r.initialSync(); err != nil {
 			IORLog.Errora(err)
 		}
 		IORLog.Debug("Initial sync finished")
 		close(r.initialSyncRun)
+
+		r.runReconciler()
 	}()
 }
 
+// reconcileWhenSynced requests a reconciliation once the Gateways of all member
+// namespaces have synced, which deletes the routes of the gateways that no
+// longer exist in the namespaces that were added.
+func (r *route) reconcileWhenSynced() {
+	synced := func() bool {
+		r.namespaceLock.Lock()
+		namespaces := r.namespaces
+		r.namespaceLock.Unlock()
+		for _, ns := range namespaces {
+			if !r.gatewaysSynced(ns) {
+				return false
+			}
+		}
+		return true
+	}
+	if cache.WaitForNamedCacheSync("Gateways", r.stop, synced) {
+		r.requestReconcile()
+	}
+}
+
 func getHost(route v1.Route) string {
 	if host := route.ObjectMeta.Annotations[originalHostAnnotation]; host != "" {
 		return host
@@ -381,29 +449,46 @@ func (r *route) deleteRoute(route *v1.Route) error {
 	var immediate int64
 	host := getHost(*route)
 	err := r.routerClient.Routes(route.Namespace).Delete(context.TODO(), route.ObjectMeta.Name, metav1.DeleteOptions{GracePeriodSeconds: &immediate})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("error deleting route %s/%s: %s", route.ObjectMeta.Namespace, route.ObjectMeta.Name, err)
 	}
+	r.recordWrite(routeKey(route.Namespace, route.Name), nil)
 
 	IORLog.Infof("Deleted route %s/%s (gateway hostname: %s)", route.ObjectMeta.Namespace, route.ObjectMeta.Name, host)
 	return nil
 }
 
-func (r *route) createRoute(metadata config.Meta, gateway *networking.Gateway, originalHost string, tls *networking.ServerTLSSettings) (*v1.Route, error) {
-	IORLog.Debugf("Creating route for hostname %s", originalHost)
+// buildRoute returns the route exposing a host of a gateway on a router
+// shard, or on the default routers if shard is nil
+func (r *route) buildRoute(metadata config.Meta, gateway *networking.Gateway, originalHost string, tls *networking.ServerTLSSettings,
+	shard *routerShard) (*v1.Route, error) {
 	actualHost, wildcard := getActualHost(originalHost, true)
 
 	var tlsConfig *v1.TLSConfig
 	targetPort := "http2"
 	if tls != nil {
-		tlsConfig = &v1.TLSConfig{Termination: v1.TLSTerminationPassthrough}
-		targetPort = "https"
+		termination, err := tlsTermination(metadata.Annotations)
+		if err != nil {
+			return nil, fmt.Errorf("error creating a route for the host %s (gateway: %s/%s): %s", originalHost, metadata.Namespace, metadata.Name, err)
+		}
+		tlsConfig = &v1.TLSConfig{Termination: termination}
+		// edge routes forward plain HTTP to the http2 port of the gateway,
+		// which needs a plain HTTP server for the host
+		if termination != v1.TLSTerminationEdge {
+			targetPort = "https"
+		} else if !hasPlainHTTPServer(gateway, originalHost) {
+			return nil, fmt.Errorf("error creating a route for the host %s (gateway: %s/%s): edge termination requires a plain HTTP server for the host",
+				originalHost, metadata.Namespace, metadata.Name)
+		}
 		if tls.HttpsRedirect {
 			tlsConfig.InsecureEdgeTerminationPolicy = v1.InsecureEdgeTerminationPolicyRedirect
 		}
 	}
 
-	serviceNamespace, serviceName, err := r.findService(gateway)
+	serviceNamespace, serviceName, err := r.findService(gateway, shard)
 	if err != nil {
 		return nil, err
 	}
@@ -417,16 +502,36 @@ func (r *route) createRoute(metadata config.Meta, gateway *networking.Gateway, o
 		}
 	}
 
-	nr, err := r.routerClient.Routes(serviceNamespace).Create(context.TODO(), &v1.Route{
+	if tlsConfig != nil && tlsConfig.Termination != v1.TLSTerminationPassthrough {
+		if tls.CredentialName == "" {
+			return nil, fmt.Errorf("error creating a route for the host %s (gateway: %s/%s): %s termination requires a credentialName",
+				originalHost, metadata.Namespace, metadata.Name, tlsConfig.Termination)
+		}
+		// the secret is read from the namespace of the gateway workload, like the gateway does
+		if err := r.setCertificates(tlsConfig, serviceNamespace, tls.CredentialName); err != nil {
+			return nil, fmt.Errorf("error creating a route for the host %s (gateway: %s/%s): %s", originalHost, metadata.Namespace, metadata.Name, err)
+		}
+		annotations[credentialNameAnnotation] = tls.CredentialName
+		r.secrets.watch(serviceNamespace)
+	}
+
+	labels := map[string]string{}
+	if shard != nil {
+		for key, value := range shard.RouteLabels {
+			labels[key] = value
+		}
+		labels[routerShardLabel] = shard.name
+	}
+	labels[generatedByLabel] = generatedByValue
+	labels[gatewayNamespaceLabel] = metadata.Namespace
+	labels[gatewayNameLabel] = metadata.Name
+	labels[gatewayResourceVersionLabel] = metadata.ResourceVersion
+
+	return &v1.Route{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      getRouteName(metadata.Namespace, metadata.Name, actualHost),
-			Namespace: serviceNamespace,
-			Labels: map[string]string{
-				generatedByLabel:            generatedByValue,
-				gatewayNamespaceLabel:       metadata.Namespace,
-				gatewayNameLabel:            metadata.Name,
-				gatewayResourceVersionLabel: metadata.ResourceVersion,
-			},
+			Name:        getRouteName(metadata.Namespace, metadata.Name, actualHost, shard.Name()),
+			Namespace:   serviceNamespace,
+			Labels:      labels,
 			Annotations: annotations,
 		},
 		Spec: v1.RouteSpec{
@@ -443,47 +548,84 @@ func (r *route) createRoute(metadata config.Meta, gateway *networking.Gateway, o
 			TLS:            tlsConfig,
 			WildcardPolicy: wildcard,
 		},
-	}, metav1.CreateOptions{})
+	}, nil
+}
+
+// hasPlainHTTPServer returns whether the gateway has a server accepting plain
+// HTTP for the host, which the calls forwarded by edge routes are sent to.
+func hasPlainHTTPServer(gateway *networking.Gateway, host string) bool {
+	for _, server := range gateway.Servers {
+		if server.Tls != nil || server.Port == nil || !protocol.Parse(server.Port.Protocol).IsHTTP() {
+			continue
+		}
+		for _, serverHost := range server.Hosts {
+			if serverHost == host || serverHost == "*" {
+				return true
+			}
+		}
+	}
+	return false
+}
 
+func (r *route) createRoute(route *v1.Route) (*v1.Route, error) {
+	IORLog.Debugf("Creating route for hostname %s", getHost(*route))
+	nr, err := r.routerClient.Routes(route.Namespace).Create(context.TODO(), route, metav1.CreateOptions{})
 	if err != nil {
-		return nil, fmt.Errorf("error creating a route for the host %s (gateway: %s/%s): %s", originalHost, metadata.Namespace, metadata.Name, err)
+		return nil, fmt.Errorf("error creating a route for the host %s (gateway: %s/%s): %s",
+			getHost(*route), route.Labels[gatewayNamespaceLabel], route.Labels[gatewayNameLabel], err)
 	}
+	r.recordWrite(routeKey(nr.Namespace, nr.Name), nr)
 
 	IORLog.Infof("Created route %s/%s for hostname %s (gateway: %s/%s)",
 		nr.ObjectMeta.Namespace, nr.ObjectMeta.Name,
 		nr.Spec.Host,
-		metadata.Namespace, metadata.Name)
+		route.Labels[gatewayNamespaceLabel], route.Labels[gatewayNameLabel])
 
 	return nr, nil
 }
 
-// findService tries to find a service that matches with the given gateway selector
+// findService tries to find a service that matches with the given gateway selector, in the namespaces admitted by the
+// router shard
 // Returns the namespace and service name that is a match, or an error
-func (r *route) findService(gateway *networking.Gateway) (string, string, error) {
+func (r *route) findService(gateway *networking.Gateway, shard *routerShard) (string, string, error) {
 	r.namespaceLock.Lock()
 	namespaces := r.namespaces
 	r.namespaceLock.Unlock()
 
 	gwSelector := labels.SelectorFromSet(gateway.Selector)
+	pods := r.podInformer.GetIndexers()
+	services := r.serviceInformer.GetIndexers()
 
 	for _, ns := range namespaces {
-		// Get the list of pods that match the gateway selector
-		podList, err := r.kubeClient.CoreV1().Pods(ns).List(context.TODO(), metav1.ListOptions{LabelSelector: gwSelector.String()})
-		if err != nil {
-			return "", "", fmt.Errorf("could not get the list of pods in namespace %s: %v", ns, err)
+		podIndexer, svcIndexer := pods[ns], services[ns]
+		if podIndexer == nil || svcIndexer == nil {
+			continue
 		}
-
-		// Get the list of services in this namespace
-		svcList, err := r.kubeClient.CoreV1().Services(ns).List(context.TODO(), metav1.ListOptions{})
-		if err != nil {
-			return "", "", fmt.Errorf("could not get the list of services in namespace %s: %v", ns, err)
+		if r.namespaceInformer != nil && !shard.admits(r.namespaceLabels(ns)) {
+			continue
 		}
 
-		// Look for a service whose selector matches the pod labels
-		for _, pod := range podList.Items {
+		// Look for a service whose selector matches the labels of a pod selected by the gateway.
+		// They are sorted by name, like the API server lists them.
+		podList := podIndexer.List()
+		sortByName(podList)
+		svcList := svcIndexer.List()
+		sortByName(svcList)
+		for _, obj := range podList {
+			pod, ok := obj.(*corev1.Pod)
+			if !ok {
+				continue
+			}
 			podLabels := labels.Set(pod.ObjectMeta.Labels)
+			if !gwSelector.Matches(podLabels) {
+				continue
+			}
 
-			for _, svc := range svcList.Items {
+			for _, obj := range svcList {
+				svc, ok := obj.(*corev1.Service)
+				if !ok {
+					continue
+				}
 				svcSelector := labels.SelectorFromSet(svc.Spec.Selector)
 				if svcSelector.Matches(podLabels) {
 					return ns, svc.Name, nil
@@ -492,11 +634,26 @@ func (r *route) findService(gateway *networking.Gateway) (string, string, error)
 		}
 	}
 
+	if shard != nil && len(shard.NamespaceSelector) > 0 {
+		return "", "", fmt.Errorf("could not find a service that matches the gateway selector `%s' in the namespaces admitted by router shard %s. "+
+			"Namespaces where we looked at: %v", gwSelector.String(), shard.name, namespaces)
+	}
 	return "", "", fmt.Errorf("could not find a service that matches the gateway selector `%s'. Namespaces where we looked at: %v",
 		gwSelector.String(), namespaces)
 }
 
-func getRouteName(namespace, name, actualHost string) string {
+func sortByName(objects []interface{}) {
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].(metav1.Object).GetName() < objects[j].(metav1.Object).GetName()
+	})
+}
+
+// getRouteName returns the name of the route of a host of a gateway, which
+// includes the router shard exposing it, if any
+func getRouteName(namespace, name, actualHost, shard string) string {
+	if shard != "" {
+		return fmt.Sprintf("%s-%s-%s-%s", namespace, name, shard, hostHash(actualHost))
+	}
 	return fmt.Sprintf("%s-%s-%s", namespace, name, hostHash(actualHost))
 }
 
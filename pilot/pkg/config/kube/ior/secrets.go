@@ -0,0 +1,151 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ior
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	v1 "github.com/openshift/api/route/v1"
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	kubesecrets "istio.io/istio/pilot/pkg/secrets/kube"
+)
+
+// tlsTermination returns the termination of the routes created for the TLS
+// servers of a gateway, which is selected by its tlsTerminationAnnotation.
+func tlsTermination(annotations map[string]string) (v1.TLSTerminationType, error) {
+	switch termination := v1.TLSTerminationType(strings.ToLower(annotations[tlsTerminationAnnotation])); termination {
+	case "", v1.TLSTerminationPassthrough:
+		return v1.TLSTerminationPassthrough, nil
+	case v1.TLSTerminationEdge, v1.TLSTerminationReencrypt:
+		return termination, nil
+	default:
+		return "", fmt.Errorf("invalid %s annotation %q: must be one of %s, %s or %s", tlsTerminationAnnotation, termination,
+			v1.TLSTerminationPassthrough, v1.TLSTerminationEdge, v1.TLSTerminationReencrypt)
+	}
+}
+
+// destinationCACertKey is the key of the credential secret of a reencrypt
+// route holding the CA certificate the router verifies the gateway's
+// certificate with.  It is separate from the CA certificate of the credential,
+// which the gateway uses to verify client certificates.
+const destinationCACertKey = "destination-ca.crt"
+
+// setCertificates copies the certificate and key of the gateway, which are
+// read from the secret named credentialName like the gateway does, into the
+// TLS configuration of a route.  The router verifies the gateway's
+// certificate of reencrypt routes with the CA certificate in the
+// destinationCACertKey of the secret, which is required.
+func (r *route) setCertificates(tlsConfig *v1.TLSConfig, namespace, credentialName string) error {
+	secret, err := r.secrets.get(namespace, credentialName)
+	if err != nil {
+		return fmt.Errorf("could not read the credential %s/%s: %v", namespace, credentialName, err)
+	}
+	key, cert := secret.Data[kubesecrets.GenericScrtKey], secret.Data[kubesecrets.GenericScrtCert]
+	if len(cert) == 0 {
+		key, cert = secret.Data[kubesecrets.TLSSecretKey], secret.Data[kubesecrets.TLSSecretCert]
+	}
+	if len(key) == 0 || len(cert) == 0 {
+		return fmt.Errorf("the credential %s/%s does not contain a certificate and key", namespace, credentialName)
+	}
+	tlsConfig.Certificate = string(cert)
+	tlsConfig.Key = string(key)
+	tlsConfig.DestinationCACertificate = ""
+	if tlsConfig.Termination != v1.TLSTerminationReencrypt {
+		return nil
+	}
+
+	ca := secret.Data[destinationCACertKey]
+	if len(ca) == 0 {
+		return fmt.Errorf("the credential %s/%s does not contain a %s for reencrypt termination", namespace, credentialName, destinationCACertKey)
+	}
+	tlsConfig.DestinationCACertificate = string(ca)
+	return nil
+}
+
+// secretWatcher notifies IOR of changes to the secrets of the namespaces
+// hosting gateways whose certificates are copied into routes.  Namespaces
+// are only watched once such a route is created in them.
+type secretWatcher struct {
+	client  kubernetes.Interface
+	handler func(namespace, name string)
+	stop    <-chan struct{}
+
+	mu        sync.Mutex
+	informers map[string]cache.SharedIndexInformer
+}
+
+func newSecretWatcher(client kubernetes.Interface, handler func(namespace, name string), stop <-chan struct{}) *secretWatcher {
+	return &secretWatcher{
+		client:    client,
+		handler:   handler,
+		stop:      stop,
+		informers: map[string]cache.SharedIndexInformer{},
+	}
+}
+
+// watch starts watching the secrets of the namespace, if they aren't yet.
+func (w *secretWatcher) watch(namespace string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.informers[namespace]; ok {
+		return
+	}
+
+	IORLog.Debugf("Watching secrets in namespace %s", namespace)
+	informer := informers.NewSharedInformerFactoryWithOptions(w.client, 0, informers.WithNamespace(namespace)).
+		Core().V1().Secrets().Informer()
+	handle := func(obj interface{}) {
+		if secret, ok := obj.(*corev1.Secret); ok {
+			w.handler(secret.Namespace, secret.Name)
+		}
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: handle,
+		UpdateFunc: func(_, obj interface{}) {
+			handle(obj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			handle(obj)
+		},
+	})
+	w.informers[namespace] = informer
+	go informer.Run(w.stop)
+}
+
+// get returns a secret from the informer of its namespace, or from the API
+// server if the informer doesn't have it (yet).
+func (w *secretWatcher) get(namespace, name string) (*corev1.Secret, error) {
+	w.mu.Lock()
+	informer := w.informers[namespace]
+	w.mu.Unlock()
+
+	if informer != nil {
+		if obj, exists, err := informer.GetStore().GetByKey(namespace + "/" + name); err == nil && exists {
+			return obj.(*corev1.Secret), nil
+		}
+	}
+	return w.client.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+}
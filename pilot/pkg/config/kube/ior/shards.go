@@ -0,0 +1,195 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ior
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+
+	"istio.io/istio/pkg/config"
+)
+
+// routerShard is a shard of the OpenShift routers, i.e. an IngressController
+// admitting the routes matching its route and namespace selectors.  It's
+// configured with features.IORRouterShards.
+type routerShard struct {
+	name string
+
+	// RouteLabels are added to the routes of the shard, to match the
+	// routeSelector of its IngressController
+	RouteLabels map[string]string `json:"routeLabels,omitempty"`
+	// NamespaceSelector must match the labels of the namespace of the routes
+	// of the shard, like the namespaceSelector of its IngressController.
+	// Only the gateway services in these namespaces are exposed by the shard.
+	NamespaceSelector map[string]string `json:"namespaceSelector,omitempty"`
+	// GatewaySelector selects the gateways exposed by the shard by their
+	// labels, in addition to those naming it in their routerShardsAnnotation
+	GatewaySelector map[string]string `json:"gatewaySelector,omitempty"`
+}
+
+// Name returns the name of the shard, which is empty for the default routers
+func (s *routerShard) Name() string {
+	if s == nil {
+		return ""
+	}
+	return s.name
+}
+
+// describe returns the host exposed by the shard, as reported in the status
+// of the gateways
+func (s *routerShard) describe(host string) string {
+	if s == nil {
+		return host
+	}
+	return host + " on router shard " + s.name
+}
+
+// admits returns whether the shard admits routes from a namespace with the
+// given labels.
+func (s *routerShard) admits(namespaceLabels map[string]string) bool {
+	if s == nil || len(s.NamespaceSelector) == 0 {
+		return true
+	}
+	return labels.SelectorFromSet(s.NamespaceSelector).Matches(labels.Set(namespaceLabels))
+}
+
+// parseRouterShards parses the router shards configured in
+// features.IORRouterShards, by name.
+func parseRouterShards(value string) (map[string]*routerShard, error) {
+	shards := map[string]*routerShard{}
+	if strings.TrimSpace(value) == "" {
+		return shards, nil
+	}
+	if err := json.Unmarshal([]byte(value), &shards); err != nil {
+		return nil, fmt.Errorf("invalid router shards: %v", err)
+	}
+	for name, shard := range shards {
+		// the name is part of the names and labels of the routes
+		if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid router shard name %q: %s", name, strings.Join(errs, ", "))
+		}
+		if shard == nil {
+			shard = &routerShard{}
+			shards[name] = shard
+		}
+		shard.name = name
+	}
+	return shards, nil
+}
+
+// gatewayShards returns the router shards exposing the hosts of a gateway,
+// sorted by name: those named in its routerShardsAnnotation and those
+// selecting it.  Gateways without shards are exposed by the default routers,
+// which is represented by a single nil shard.
+func (r *route) gatewayShards(metadata config.Meta) ([]*routerShard, error) {
+	selected := map[string]*routerShard{}
+	if value, ok := metadata.Annotations[routerShardsAnnotation]; ok {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			shard, ok := r.shards[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown router shard %q in the %s annotation of gateway %s/%s",
+					name, routerShardsAnnotation, metadata.Namespace, metadata.Name)
+			}
+			selected[name] = shard
+		}
+	}
+	for name, shard := range r.shards {
+		if len(shard.GatewaySelector) > 0 && labels.SelectorFromSet(shard.GatewaySelector).Matches(labels.Set(metadata.Labels)) {
+			selected[name] = shard
+		}
+	}
+
+	if len(selected) == 0 {
+		return []*routerShard{nil}, nil
+	}
+	out := make([]*routerShard, 0, len(selected))
+	for _, shard := range selected {
+		out = append(out, shard)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].name < out[j].name
+	})
+	return out, nil
+}
+
+// selectsNamespaces returns whether any router shard selects the namespaces
+// it admits routes from, whose labels IOR must then watch.
+func selectsNamespaces(shards map[string]*routerShard) bool {
+	for _, shard := range shards {
+		if len(shard.NamespaceSelector) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// startNamespaceInformer starts the informer of the member namespaces, whose
+// labels are matched against the namespace selectors of the router shards.
+func (r *route) startNamespaceInformer() {
+	r.namespaceInformer = newMemberInformer(r.namespaceSet, &corev1.Namespace{}, func(namespace string) cache.ListerWatcher {
+		byName := func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", namespace).String()
+		}
+		return &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				byName(&options)
+				return r.kubeClient.CoreV1().Namespaces().List(context.TODO(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				byName(&options)
+				return r.kubeClient.CoreV1().Namespaces().Watch(context.TODO(), options)
+			},
+		}
+	})
+	// the routes of the gateways in a namespace change as it's (un)selected by a shard
+	r.namespaceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(_ interface{}) {
+			r.requestReconcile()
+		},
+		UpdateFunc: func(_, _ interface{}) {
+			r.requestReconcile()
+		},
+	})
+	go r.namespaceInformer.Run(r.stop)
+}
+
+// namespaceLabels returns the labels of a member namespace
+func (r *route) namespaceLabels(namespace string) map[string]string {
+	indexer := r.namespaceInformer.GetIndexers()[namespace]
+	if indexer == nil {
+		return nil
+	}
+	obj, exists, _ := indexer.GetByKey(namespace)
+	if ns, ok := obj.(*corev1.Namespace); exists && ok {
+		return ns.Labels
+	}
+	return nil
+}
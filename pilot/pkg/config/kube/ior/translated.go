@@ -0,0 +1,148 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ior
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gogo/protobuf/proto"
+
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pkg/config"
+	"istio.io/istio/pkg/config/constants"
+	"istio.io/istio/pkg/config/host"
+	"istio.io/istio/pkg/config/schema/gvk"
+)
+
+// translatedKinds are the kinds of the Gateway API resources istiod translates
+// into Gateways and VirtualServices.  Ingresses are translated too, but the
+// ingress controller notifies their changes as changes of Gateways.
+var translatedKinds = []config.GroupVersionKind{
+	gvk.GatewayClass,
+	gvk.ServiceApisGateway,
+	gvk.HTTPRoute,
+	gvk.TCPRoute,
+	gvk.TLSRoute,
+}
+
+// isTranslatedGateway returns whether a gateway was translated from a Gateway
+// API Gateway or from an Ingress, rather than created by the user.
+func isTranslatedGateway(name string) bool {
+	return strings.HasSuffix(name, "-"+constants.KubernetesGatewayName) ||
+		strings.HasSuffix(name, "-"+constants.IstioIngressGatewayName)
+}
+
+// translatedGatewaysChanged schedules the update of the gateways translated
+// from Gateway API and Ingress resources, which are reconciled as a whole
+// because the store doesn't notify the changes of the translated gateways
+// themselves.
+func (r *route) translatedGatewaysChanged() {
+	r.translatedChanged.Store(true)
+	r.requestReconcile()
+}
+
+// updateTranslatedGateways replaces the translated gateways in the
+// gatewaysMap with the current ones.
+// Must be called with gatewaysLock locked
+func (r *route) updateTranslatedGateways() error {
+	configs, err := r.translatedGateways()
+	if err != nil {
+		return err
+	}
+
+	for key, syncRoute := range r.gatewaysMap {
+		if isTranslatedGateway(syncRoute.metadata.Name) {
+			delete(r.gatewaysMap, key)
+		}
+	}
+	for _, cfg := range configs {
+		r.addNewSyncRoute(cfg)
+	}
+	return nil
+}
+
+// translatedGateways returns the gateways translated from Gateway API and
+// Ingress resources, with the hosts of their servers replaced by the
+// hostnames of the routes and rules bound to them.  The servers of these
+// gateways accept any host, or any host of a domain, and leave the actual
+// hostnames to their routes.
+func (r *route) translatedGateways() ([]config.Config, error) {
+	gateways, err := r.store.List(gvk.Gateway, "")
+	if err != nil {
+		return nil, fmt.Errorf("could not get list of Gateways: %s", err)
+	}
+	virtualServices, err := r.store.List(gvk.VirtualService, "")
+	if err != nil {
+		return nil, fmt.Errorf("could not get list of VirtualServices: %s", err)
+	}
+
+	// hostnames of the routes bound to each translated gateway, by namespace/name
+	boundHosts := map[string][]string{}
+	for _, cfg := range virtualServices {
+		vs, ok := cfg.Spec.(*networking.VirtualService)
+		if !ok {
+			continue
+		}
+		for _, gw := range vs.Gateways {
+			if !strings.Contains(gw, "/") {
+				gw = cfg.Namespace + "/" + gw
+			}
+			if isTranslatedGateway(gw) {
+				boundHosts[gw] = append(boundHosts[gw], vs.Hosts...)
+			}
+		}
+	}
+
+	var out []config.Config
+	for _, cfg := range gateways {
+		gw, ok := cfg.Spec.(*networking.Gateway)
+		if !ok || !isTranslatedGateway(cfg.Name) {
+			continue
+		}
+		hosts := boundHosts[gatewaysMapKey(cfg.Namespace, cfg.Name)]
+		cfg.Spec = exposedServers(gw, hosts)
+		out = append(out, cfg)
+	}
+	return out, nil
+}
+
+// exposedServers returns a copy of a translated gateway whose servers only
+// hold the hostnames to expose: those of the bound routes that match the
+// hosts of a server, which are given to the first one they match, and the
+// hosts of the servers that aren't wildcards.  Routes for any host ("*")
+// would only expose a hostname generated by the router.
+func exposedServers(gateway *networking.Gateway, boundHosts []string) *networking.Gateway {
+	out := proto.Clone(gateway).(*networking.Gateway)
+	assigned := map[string]bool{}
+	for _, server := range out.Servers {
+		var hosts []string
+		for _, serverHost := range server.Hosts {
+			if !host.Name(serverHost).IsWildCarded() && !assigned[serverHost] {
+				assigned[serverHost] = true
+				hosts = append(hosts, serverHost)
+			}
+			for _, boundHost := range boundHosts {
+				if boundHost == "*" || assigned[boundHost] || !host.Name(boundHost).SubsetOf(host.Name(serverHost)) {
+					continue
+				}
+				assigned[boundHost] = true
+				hosts = append(hosts, boundHost)
+			}
+		}
+		server.Hosts = hosts
+	}
+	return out
+}
@@ -87,6 +87,34 @@ var (
 			" EDS pushes may be delayed, but there will be fewer pushes. By default this is enabled",
 	)
 
+	EnableAdaptiveDebounce = env.RegisterBoolVar(
+		"PILOT_ENABLE_ADAPTIVE_DEBOUNCE",
+		false,
+		"If enabled, Pilot will choose the quiet period of the push debouncing from the rate of the config/registry "+
+			"events and the time taken to compute the last push, between PILOT_ADAPTIVE_DEBOUNCE_MIN and PILOT_ADAPTIVE_DEBOUNCE_FULL_MAX "+
+			"for full pushes or PILOT_ADAPTIVE_DEBOUNCE_EDS_MAX for EDS pushes, instead of using PILOT_DEBOUNCE_AFTER. "+
+			"Pushes are still triggered after PILOT_DEBOUNCE_MAX.",
+	).Get()
+
+	AdaptiveDebounceMin = env.RegisterDurationVar(
+		"PILOT_ADAPTIVE_DEBOUNCE_MIN",
+		10*time.Millisecond,
+		"The quiet period of the adaptive push debouncing when the mesh is quiet. The quiet period is widened by "+
+			"this interval for each event per second.",
+	).Get()
+
+	AdaptiveDebounceFullMax = env.RegisterDurationVar(
+		"PILOT_ADAPTIVE_DEBOUNCE_FULL_MAX",
+		time.Second,
+		"The maximum quiet period of the adaptive push debouncing for full pushes.",
+	).Get()
+
+	AdaptiveDebounceEDSMax = env.RegisterDurationVar(
+		"PILOT_ADAPTIVE_DEBOUNCE_EDS_MAX",
+		200*time.Millisecond,
+		"The maximum quiet period of the adaptive push debouncing for EDS pushes.",
+	).Get()
+
 	// HTTP10 will add "accept_http_10" to http outbound listeners. Can also be set only for specific sidecars via meta.
 	//
 	// Alpha in 1.1, may become the default or be turned into a Sidecar API or mesh setting. Only applies to namespaces
@@ -321,6 +349,10 @@ var (
 	EnableAdminEndpoints = env.RegisterBoolVar("ENABLE_ADMIN_ENDPOINTS", false,
 		"If this is set to true, dangerous admin endpoins will be exposed on the debug interface. Not recommended for production.").Get()
 
+	DryRunMaxProxyGroups = env.RegisterIntVar("PILOT_DRY_RUN_MAX_PROXY_GROUPS", 100,
+		"The maximum number of groups of proxies sharing a SidecarScope and workload labels whose configuration "+
+			"is generated by a single request to /debug/dryrunz. Requests evaluating more groups are rejected.").Get()
+
 	XDSAuth = env.RegisterBoolVar("XDS_AUTH", true,
 		"If true, will authenticate XDS clients.").Get()
 
@@ -351,6 +383,12 @@ var (
 	EnableXDSCaching = env.RegisterBoolVar("PILOT_ENABLE_XDS_CACHE", true,
 		"If true, Pilot will cache XDS responses.").Get()
 
+	EnableCDSCaching = env.RegisterBoolVar("PILOT_ENABLE_CDS_CACHE", true,
+		"If true, Pilot will cache CDS responses. Note: this depends on PILOT_ENABLE_XDS_CACHE.").Get()
+
+	EnableRDSCaching = env.RegisterBoolVar("PILOT_ENABLE_RDS_CACHE", true,
+		"If true, Pilot will cache RDS responses. Note: this depends on PILOT_ENABLE_XDS_CACHE.").Get()
+
 	EnableXDSCacheMetrics = env.RegisterBoolVar("PILOT_XDS_CACHE_STATS", false,
 		"If true, Pilot will collect metrics for XDS cache efficiency.").Get()
 
@@ -452,5 +490,19 @@ var (
 	EnableIOR = env.RegisterBoolVar("ENABLE_IOR", false,
 		"Whether to enable IOR component, which provides integration between Istio Gateways and OpenShift Routes").Get()
 
+	IORRouterShards = env.RegisterStringVar("IOR_ROUTER_SHARDS", "",
+		"The OpenShift router shards IOR creates routes for, as a JSON object mapping the name of each shard to the labels "+
+			"its routes are given (routeLabels), the labels of the namespaces it admits routes from (namespaceSelector) and "+
+			"the labels of the Gateways it exposes (gatewaySelector). Gateways can also name their shards in the "+
+			"maistra.io/router-shards annotation. Gateways without shards are exposed by the default routers.").Get()
+
 	EnableFederation = env.RegisterBoolVar("PILOT_ENABLE_FEDERATION", false, "").Get()
+
+	PushPriorityNamespaces = env.RegisterStringVar("PILOT_PUSH_PRIORITY_NAMESPACES", "",
+		"Comma separated list of namespaces whose sidecars are pushed more often than the other sidecars when "+
+			"the push queue is busy. Gateways are pushed the most often.").Get()
+
+	PushPrioritySelector = env.RegisterStringVar("PILOT_PUSH_PRIORITY_SELECTOR", "",
+		"Comma separated list of key=value labels. The sidecars with all these labels are pushed more often than "+
+			"the other sidecars when the push queue is busy. Gateways are pushed the most often.").Get()
 )
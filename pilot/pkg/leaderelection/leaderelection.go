@@ -37,6 +37,7 @@ const (
 	StatusController  = "istio-status-leader"
 	AnalyzeController = "istio-analyze-leader"
 	IORController     = "ior-leader"
+	MECController     = "mec-leader"
 )
 
 type LeaderElection struct {
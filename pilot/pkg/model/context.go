@@ -288,6 +288,14 @@ type WatchedResource struct {
 	// LastSize tracks the size of the last update
 	LastSize int
 
+	// Wildcard is set for clients of the delta protocol that subscribed to all the resources of the
+	// TypeUrl type, in addition to those in ResourceNames.
+	Wildcard bool
+
+	// ResourceVersions tracks the version of each resource sent to a client of the delta protocol, by
+	// name, so that only changed resources are sent. It is nil for state of the world clients.
+	ResourceVersions map[string]string
+
 	// Last request contains the last DiscoveryRequest received for
 	// this type. Generators are called immediately after each request,
 	// and may use the information in DiscoveryRequest.
@@ -631,12 +639,16 @@ const (
 
 	// Router type is used for standalone proxies acting as L7/L4 routers
 	Router NodeType = "router"
+
+	// Federation type is used for the discovery clients of federated meshes, which
+	// only receive the services exported to their mesh
+	Federation NodeType = "federation"
 )
 
 // IsApplicationNodeType verifies that the NodeType is one of the declared constants in the model
 func IsApplicationNodeType(nType NodeType) bool {
 	switch nType {
-	case SidecarProxy, Router:
+	case SidecarProxy, Router, Federation:
 		return true
 	default:
 		return false
@@ -698,6 +710,34 @@ func (node *Proxy) SetSidecarScope(ps *PushContext) {
 	node.PrevSidecarScope = sidecarScope
 }
 
+// CopyForPush returns a copy of the proxy, with its SidecarScope and merged gateways computed for the given
+// PushContext. The proxy itself is not modified, so this can be used to generate the configuration a proxy would
+// get from another PushContext, e.g. to evaluate config changes before they are applied.
+func (node *Proxy) CopyForPush(ps *PushContext) *Proxy {
+	node.RLock()
+	out := &Proxy{
+		Type:                 node.Type,
+		IPAddresses:          node.IPAddresses,
+		ID:                   node.ID,
+		Locality:             node.Locality,
+		DNSDomain:            node.DNSDomain,
+		ConfigNamespace:      node.ConfigNamespace,
+		Metadata:             node.Metadata,
+		SidecarScope:         node.SidecarScope,
+		ServiceInstances:     node.ServiceInstances,
+		IstioVersion:         node.IstioVersion,
+		VerifiedIdentity:     node.VerifiedIdentity,
+		ipv6Support:          node.ipv6Support,
+		ipv4Support:          node.ipv4Support,
+		GlobalUnicastIP:      node.GlobalUnicastIP,
+		XdsResourceGenerator: node.XdsResourceGenerator,
+	}
+	node.RUnlock()
+	out.SetSidecarScope(ps)
+	out.SetGatewaysForProxy(ps)
+	return out
+}
+
 // SetGatewaysForProxy merges the Gateway objects associated with this
 // proxy and caches the merged object in the proxy Node. This is a convenience hack so that
 // callers can simply call push.MergedGateways(node) instead of having to
@@ -824,7 +864,7 @@ func ParseServiceNodeWithMetadata(nodeID string, metadata *NodeMetadata) (*Proxy
 	}
 
 	if !IsApplicationNodeType(NodeType(parts[0])) {
-		return out, fmt.Errorf("invalid node type (valid types: sidecar, router in the service node %q", nodeID)
+		return out, fmt.Errorf("invalid node type (valid types: sidecar, router, federation in the service node %q", nodeID)
 	}
 	out.Type = NodeType(parts[0])
 
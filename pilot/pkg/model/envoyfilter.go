@@ -15,6 +15,7 @@ package model
 
 import (
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/gogo/protobuf/proto"
@@ -22,6 +23,7 @@ import (
 	networking "istio.io/api/networking/v1alpha3"
 	"istio.io/istio/pkg/config"
 	"istio.io/istio/pkg/config/labels"
+	"istio.io/istio/pkg/config/schema/gvk"
 	"istio.io/istio/pkg/config/xds"
 )
 
@@ -34,6 +36,9 @@ type EnvoyFilterWrapper struct {
 // EnvoyFilterConfigPatchWrapper is a wrapper over the EnvoyFilter ConfigPatch api object
 // fields are ordered such that this struct is aligned
 type EnvoyFilterConfigPatchWrapper struct {
+	// Name and Namespace of the EnvoyFilter the patch comes from
+	Name      string
+	Namespace string
 	Value     proto.Message
 	Match     *networking.EnvoyFilter_EnvoyConfigObjectMatch
 	ApplyTo   networking.EnvoyFilter_ApplyTo
@@ -70,6 +75,8 @@ func convertToEnvoyFilterWrapper(local *config.Config) *EnvoyFilterWrapper {
 	out.Patches = make(map[networking.EnvoyFilter_ApplyTo][]*EnvoyFilterConfigPatchWrapper)
 	for _, cp := range localEnvoyFilter.ConfigPatches {
 		cpw := &EnvoyFilterConfigPatchWrapper{
+			Name:      local.Name,
+			Namespace: local.Namespace,
 			ApplyTo:   cp.ApplyTo,
 			Match:     cp.Match,
 			Operation: cp.Patch.Operation,
@@ -118,6 +125,48 @@ func convertToEnvoyFilterWrapper(local *config.Config) *EnvoyFilterWrapper {
 	return out
 }
 
+// Keys returns the keys, sorted by namespace and name, of the EnvoyFilters which have patches for any
+// of the given types.
+func (efw *EnvoyFilterWrapper) Keys(applyTo ...networking.EnvoyFilter_ApplyTo) []ConfigKey {
+	if efw == nil {
+		return nil
+	}
+	have := map[ConfigKey]struct{}{}
+	out := make([]ConfigKey, 0)
+	for _, at := range applyTo {
+		for _, patch := range efw.Patches[at] {
+			key := ConfigKey{Kind: gvk.EnvoyFilter, Name: patch.Name, Namespace: patch.Namespace}
+			if _, f := have[key]; !f {
+				have[key] = struct{}{}
+				out = append(out, key)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Namespace != out[j].Namespace {
+			return out[i].Namespace < out[j].Namespace
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// MatchesProxyMetadata returns true if any of the patches for the given types match on the proxy
+// metadata, in which case the patched configuration is specific to the proxies with that metadata.
+func (efw *EnvoyFilterWrapper) MatchesProxyMetadata(applyTo ...networking.EnvoyFilter_ApplyTo) bool {
+	if efw == nil {
+		return false
+	}
+	for _, at := range applyTo {
+		for _, patch := range efw.Patches[at] {
+			if patch.Match.Proxy != nil && len(patch.Match.Proxy.Metadata) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func proxyMatch(proxy *Proxy, cp *EnvoyFilterConfigPatchWrapper) bool {
 	if cp.Match.Proxy == nil {
 		return true
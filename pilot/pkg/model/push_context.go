@@ -340,8 +340,15 @@ const (
 	SecretTrigger TriggerReason = "secret"
 	// Describes a push triggered for Networks change
 	NetworksTrigger TriggerReason = "networks"
+	// Describes a push triggered based on proxy request
+	ProxyRequest TriggerReason = "proxyrequest"
 )
 
+// IsRequest returns true if the push is the response to a request of the proxy.
+func (pr *PushRequest) IsRequest() bool {
+	return len(pr.Reason) == 1 && pr.Reason[0] == ProxyRequest
+}
+
 // Merge two update requests together
 func (first *PushRequest) Merge(other *PushRequest) *PushRequest {
 	if first == nil {
@@ -1002,7 +1009,7 @@ func (ps *PushContext) updateContext(
 	pushReq *PushRequest) error {
 
 	var servicesChanged, virtualServicesChanged, destinationRulesChanged, gatewayChanged,
-		authnChanged, authzChanged, envoyFiltersChanged, sidecarsChanged bool
+		authnChanged, authzChanged, envoyFiltersChanged, sidecarsChanged, extensionsChanged bool
 
 	for conf := range pushReq.ConfigsUpdated {
 		switch conf.Kind {
@@ -1026,6 +1033,8 @@ func (ps *PushContext) updateContext(
 		case gvk.HTTPRoute, gvk.TCPRoute, gvk.GatewayClass, gvk.ServiceApisGateway, gvk.TLSRoute:
 			virtualServicesChanged = true
 			gatewayChanged = true
+		case maistramodel.ServiceMeshExtensionGVK, maistramodel.ServiceMeshExtensionConfigGVK:
+			extensionsChanged = true
 		}
 	}
 
@@ -1081,7 +1090,13 @@ func (ps *PushContext) updateContext(
 		ps.envoyFiltersByNamespace = oldPushContext.envoyFiltersByNamespace
 	}
 
-	ps.extensionsByNamespace = oldPushContext.extensionsByNamespace
+	if extensionsChanged && features.EnableMaistraExtensionSupport {
+		if err := ps.initExtensions(env); err != nil {
+			return err
+		}
+	} else {
+		ps.extensionsByNamespace = oldPushContext.extensionsByNamespace
+	}
 
 	if gatewayChanged {
 		if err := ps.initGateways(env); err != nil {
@@ -1657,7 +1672,11 @@ func (ps *PushContext) initExtensions(env *Environment) error {
 			ps.extensionsByNamespace[extension.Namespace] = []*maistramodel.ExtensionWrapper{}
 		}
 		if extension.Status.Deployment.Ready {
-			wrapper := maistramodel.ToWrapper(extension)
+			wrapper, err := maistramodel.ToWrapper(extension)
+			if err != nil {
+				log.Warnf("ignoring ServiceMeshExtension %s/%s: %v", extension.Namespace, extension.Name, err)
+				continue
+			}
 			ps.extensionsByNamespace[extension.Namespace] = append(ps.extensionsByNamespace[extension.Namespace], wrapper)
 		}
 	}
@@ -1665,7 +1684,8 @@ func (ps *PushContext) initExtensions(env *Environment) error {
 	return nil
 }
 
-// Extensions return the merged ExtensionWrappers of a proxy
+// Extensions return the merged ExtensionWrappers of a proxy, with the modules
+// it receives while new ones are being rolled out
 func (ps *PushContext) Extensions(proxy *Proxy) map[v1.FilterPhase][]*maistramodel.ExtensionWrapper {
 	if proxy == nil {
 		return nil
@@ -1682,7 +1702,7 @@ func (ps *PushContext) Extensions(proxy *Proxy) map[v1.FilterPhase][]*maistramod
 				workloadLabels = labels.Collection{proxy.Metadata.Labels}
 			}
 			if ext.WorkloadSelector == nil || workloadLabels.IsSupersetOf(ext.WorkloadSelector) {
-				matchedExtensions[ext.Phase] = append(matchedExtensions[ext.Phase], ext)
+				matchedExtensions[ext.Phase] = append(matchedExtensions[ext.Phase], ext.ForProxy(proxy.ID))
 			}
 		}
 	}
@@ -1695,7 +1715,7 @@ func (ps *PushContext) Extensions(proxy *Proxy) map[v1.FilterPhase][]*maistramod
 				workloadLabels = labels.Collection{proxy.Metadata.Labels}
 			}
 			if ext.WorkloadSelector == nil || workloadLabels.IsSupersetOf(ext.WorkloadSelector) {
-				matchedExtensions[ext.Phase] = append(matchedExtensions[ext.Phase], ext)
+				matchedExtensions[ext.Phase] = append(matchedExtensions[ext.Phase], ext.ForProxy(proxy.ID))
 			}
 		}
 	}
@@ -25,6 +25,7 @@ import (
 	"istio.io/istio/pkg/config/host"
 	"istio.io/istio/pkg/config/protocol"
 	"istio.io/istio/pkg/config/schema/gvk"
+	maistramodel "istio.io/istio/pkg/servicemesh/model"
 )
 
 const (
@@ -47,6 +48,10 @@ var (
 		gvk.EnvoyFilter:           {},
 		gvk.AuthorizationPolicy:   {},
 		gvk.RequestAuthentication: {},
+
+		// ServiceMeshExtensions in the root namespace apply to all workloads
+		maistramodel.ServiceMeshExtensionGVK:       {},
+		maistramodel.ServiceMeshExtensionConfigGVK: {},
 	}
 )
 
@@ -69,7 +69,7 @@ func miss() {
 	}
 }
 
-func evict(k interface{}, v interface{}) {
+func evict() {
 	if features.EnableXDSCacheMetrics {
 		xdsCacheEvictions.Increment()
 	}
@@ -114,10 +114,24 @@ type XdsCacheEntry interface {
 	// Cacheable indicates whether this entry is valid for cache. For example
 	// for EDS to be cacheable, the Endpoint should have corresponding service.
 	Cacheable() bool
+	// TypeURL is the type of the cached XDS resource, which the cache statistics are grouped by.
+	TypeURL() string
 }
 
 type CacheToken uint64
 
+// CacheStats are the statistics of the cache entries of an XDS resource type.
+type CacheStats struct {
+	// Entries is the number of cached resources.
+	Entries int `json:"entries"`
+	// Hits is the number of reads that found a cached resource.
+	Hits uint64 `json:"hits"`
+	// Misses is the number of reads that didn't, after which the resource is usually generated and added.
+	Misses uint64 `json:"misses"`
+	// Evictions is the number of resources evicted because the cache was full.
+	Evictions uint64 `json:"evictions"`
+}
+
 // XdsCache interface defines a store for caching XDS responses.
 // All operations are thread safe.
 type XdsCache interface {
@@ -144,28 +158,30 @@ type XdsCache interface {
 	ClearAll()
 	// Keys returns all currently configured keys. This is for testing/debug only
 	Keys() []string
+	// Stats returns the statistics of the cache, by type of XDS resource. This is for debug only
+	Stats() map[string]CacheStats
 }
 
 // NewXdsCache returns an instance of a cache.
 func NewXdsCache() XdsCache {
-	return &lruCache{
-		enableAssertions: features.EnableUnsafeAssertions,
-		store:            newLru(),
-		configIndex:      map[ConfigKey]sets.Set{},
-		typesIndex:       map[config.GroupVersionKind]sets.Set{},
-		nextToken:        atomic.NewUint64(0),
-	}
+	return newLruCache(features.EnableUnsafeAssertions)
 }
 
 // NewLenientXdsCache returns an instance of a cache that does not validate token based get/set and enable assertions.
 func NewLenientXdsCache() XdsCache {
-	return &lruCache{
-		enableAssertions: false,
-		store:            newLru(),
+	return newLruCache(false)
+}
+
+func newLruCache(enableAssertions bool) *lruCache {
+	l := &lruCache{
+		enableAssertions: enableAssertions,
 		configIndex:      map[ConfigKey]sets.Set{},
 		typesIndex:       map[config.GroupVersionKind]sets.Set{},
 		nextToken:        atomic.NewUint64(0),
+		stats:            map[string]*CacheStats{},
 	}
+	l.store = newLru(l.onEvict)
+	return l
 }
 
 type lruCache struct {
@@ -177,16 +193,20 @@ type lruCache struct {
 	mu          sync.RWMutex
 	configIndex map[ConfigKey]sets.Set
 	typesIndex  map[config.GroupVersionKind]sets.Set
+	// stats holds the hits, misses and evictions of each type. Entries are counted when the stats are read.
+	stats map[string]*CacheStats
+	// clearing is set while entries are removed on purpose, so that they are not counted as evictions.
+	clearing bool
 }
 
 var _ XdsCache = &lruCache{}
 
-func newLru() simplelru.LRUCache {
+func newLru(onEvict simplelru.EvictCallback) simplelru.LRUCache {
 	sz := features.XDSCacheMaxSize
 	if sz <= 0 {
 		sz = 20000
 	}
-	l, err := simplelru.NewLRU(sz, evict)
+	l, err := simplelru.NewLRU(sz, onEvict)
 	if err != nil {
 		panic(fmt.Errorf("invalid lru configuration: %v", err))
 	}
@@ -221,7 +241,7 @@ func (l *lruCache) Add(entry XdsCacheEntry, token CacheToken, value *any.Any) {
 	defer l.mu.Unlock()
 	k := entry.Key()
 	cur, f := l.store.Get(k)
-	toWrite := cacheValue{value: value}
+	toWrite := cacheValue{value: value, typeURL: entry.TypeURL()}
 	if f {
 		if token != cur.(cacheValue).token {
 			// entry may be stale, we need to drop it. This can happen when the cache is invalidated
@@ -249,8 +269,27 @@ func (l *lruCache) Add(entry XdsCacheEntry, token CacheToken, value *any.Any) {
 }
 
 type cacheValue struct {
-	value *any.Any
-	token CacheToken
+	value   *any.Any
+	token   CacheToken
+	typeURL string
+}
+
+// onEvict is called by the store, with the lock held, whenever an entry is removed.
+func (l *lruCache) onEvict(_ interface{}, v interface{}) {
+	evict()
+	if cv := v.(cacheValue); cv.value != nil && !l.clearing {
+		l.typeStats(cv.typeURL).Evictions++
+	}
+}
+
+// typeStats returns the statistics of a type. Must be called with the lock held.
+func (l *lruCache) typeStats(typeURL string) *CacheStats {
+	stats := l.stats[typeURL]
+	if stats == nil {
+		stats = &CacheStats{}
+		l.stats[typeURL] = stats
+	}
+	return stats
 }
 
 func (l *lruCache) Get(entry XdsCacheEntry) (*any.Any, CacheToken, bool) {
@@ -263,6 +302,7 @@ func (l *lruCache) Get(entry XdsCacheEntry) (*any.Any, CacheToken, bool) {
 	val, ok := l.store.Get(k)
 	if !ok {
 		miss()
+		l.typeStats(entry.TypeURL()).Misses++
 		// If the entry is not found at all, this is our first read of it. We will generate and store
 		// a new token. Subsequent writes must include it.
 		tok := CacheToken(l.nextToken.Inc())
@@ -272,17 +312,21 @@ func (l *lruCache) Get(entry XdsCacheEntry) (*any.Any, CacheToken, bool) {
 	cv := val.(cacheValue)
 	if cv.value == nil {
 		miss()
+		l.typeStats(entry.TypeURL()).Misses++
 		// We have generated a token previously, so return that, but this is still a cache miss as
 		// no value is stored.
 		return nil, cv.token, false
 	}
 	hit()
+	l.typeStats(entry.TypeURL()).Hits++
 	return cv.value, cv.token, true
 }
 
 func (l *lruCache) Clear(configs map[ConfigKey]struct{}) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	l.clearing = true
+	defer func() { l.clearing = false }()
 	for ckey := range configs {
 		referenced := l.configIndex[ckey]
 		delete(l.configIndex, ckey)
@@ -301,6 +345,8 @@ func (l *lruCache) Clear(configs map[ConfigKey]struct{}) {
 func (l *lruCache) ClearAll() {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	l.clearing = true
+	defer func() { l.clearing = false }()
 	l.store.Purge()
 	l.configIndex = map[ConfigKey]sets.Set{}
 	size(l.store.Len())
@@ -317,6 +363,25 @@ func (l *lruCache) Keys() []string {
 	return keys
 }
 
+func (l *lruCache) Stats() map[string]CacheStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make(map[string]CacheStats, len(l.stats))
+	for typeURL, stats := range l.stats {
+		out[typeURL] = *stats
+	}
+	for _, k := range l.store.Keys() {
+		// Peek doesn't update the recentness of the entries
+		if v, ok := l.store.Peek(k); ok && v.(cacheValue).value != nil {
+			typeURL := v.(cacheValue).typeURL
+			stats := out[typeURL]
+			stats.Entries++
+			out[typeURL] = stats
+		}
+	}
+	return out
+}
+
 // DisabledCache is a cache that is always empty
 type DisabledCache struct{}
 
@@ -333,3 +398,5 @@ func (d DisabledCache) Clear(configsUpdated map[ConfigKey]struct{}) {}
 func (d DisabledCache) ClearAll() {}
 
 func (d DisabledCache) Keys() []string { return nil }
+
+func (d DisabledCache) Stats() map[string]CacheStats { return nil }
@@ -24,6 +24,7 @@ import (
 	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
 	auth "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	xdstype "github.com/envoyproxy/go-control-plane/envoy/type/v3"
 	"github.com/gogo/protobuf/types"
 	structpb "github.com/golang/protobuf/ptypes/struct"
@@ -88,7 +89,9 @@ func getDefaultCircuitBreakerThresholds() *cluster.CircuitBreakers_Thresholds {
 // For outbound: Cluster for each service/subset hostname or cidr with SNI set to service hostname
 // Cluster type based on resolution
 // For inbound (sidecar only): Cluster for each inbound endpoint port and for each service port
-func (configgen *ConfigGeneratorImpl) BuildClusters(proxy *model.Proxy, push *model.PushContext) []*cluster.Cluster {
+func (configgen *ConfigGeneratorImpl) BuildClusters(proxy *model.Proxy, push *model.PushContext) model.Resources {
+	// Outbound clusters may come from the cache, so they are kept as resources. All the other clusters are built.
+	var resources []*discovery.Resource
 	clusters := make([]*cluster.Cluster, 0)
 	envoyFilterPatches := push.EnvoyFilters(proxy)
 	cb := NewClusterBuilder(proxy, push)
@@ -98,7 +101,7 @@ func (configgen *ConfigGeneratorImpl) BuildClusters(proxy *model.Proxy, push *mo
 	case model.SidecarProxy:
 		// Setup outbound clusters
 		outboundPatcher := clusterPatcher{envoyFilterPatches, networking.EnvoyFilter_SIDECAR_OUTBOUND}
-		clusters = append(clusters, configgen.buildOutboundClusters(cb, outboundPatcher)...)
+		resources = configgen.buildOutboundClusters(cb, outboundPatcher)
 		// Add a blackhole and passthrough cluster for catching traffic to unresolved routes
 		clusters = outboundPatcher.conditionallyAppend(clusters, cb.buildBlackHoleCluster(), cb.buildDefaultPassthroughCluster())
 		clusters = append(clusters, outboundPatcher.insertedClusters()...)
@@ -111,7 +114,7 @@ func (configgen *ConfigGeneratorImpl) BuildClusters(proxy *model.Proxy, push *mo
 		clusters = append(clusters, inboundPatcher.insertedClusters()...)
 	default: // Gateways
 		patcher := clusterPatcher{envoyFilterPatches, networking.EnvoyFilter_GATEWAY}
-		clusters = append(clusters, configgen.buildOutboundClusters(cb, patcher)...)
+		resources = configgen.buildOutboundClusters(cb, patcher)
 		// Gateways do not require the default passthrough cluster as they do not have original dst listeners.
 		clusters = patcher.conditionallyAppend(clusters, cb.buildBlackHoleCluster())
 		if proxy.Type == model.Router && proxy.GetRouterMode() == model.SniDnatRouter {
@@ -120,19 +123,21 @@ func (configgen *ConfigGeneratorImpl) BuildClusters(proxy *model.Proxy, push *mo
 		clusters = append(clusters, patcher.insertedClusters()...)
 	}
 
-	clusters = normalizeClusters(push, proxy, clusters)
+	for _, c := range clusters {
+		resources = append(resources, &discovery.Resource{Name: c.Name, Resource: util.MessageToAny(c)})
+	}
 
-	return clusters
+	return normalizeClusters(push, proxy, resources)
 }
 
 // resolves cluster name conflicts. there can be duplicate cluster names if there are conflicting service definitions.
 // for any clusters that share the same name the first cluster is kept and the others are discarded.
-func normalizeClusters(metrics model.Metrics, proxy *model.Proxy, clusters []*cluster.Cluster) []*cluster.Cluster {
+func normalizeClusters(metrics model.Metrics, proxy *model.Proxy, clusters []*discovery.Resource) model.Resources {
 	have := sets.Set{}
-	out := make([]*cluster.Cluster, 0, len(clusters))
+	out := make(model.Resources, 0, len(clusters))
 	for _, cluster := range clusters {
 		if !have.Contains(cluster.Name) {
-			out = append(out, cluster)
+			out = append(out, cluster.Resource)
 		} else {
 			metrics.AddMetric(model.DuplicatedClusters, cluster.Name, proxy.ID,
 				fmt.Sprintf("Duplicate cluster %s found while pushing CDS", cluster.Name))
@@ -142,9 +147,10 @@ func normalizeClusters(metrics model.Metrics, proxy *model.Proxy, clusters []*cl
 	return out
 }
 
-func (configgen *ConfigGeneratorImpl) buildOutboundClusters(cb *ClusterBuilder, cp clusterPatcher) []*cluster.Cluster {
-	clusters := make([]*cluster.Cluster, 0)
+func (configgen *ConfigGeneratorImpl) buildOutboundClusters(cb *ClusterBuilder, cp clusterPatcher) []*discovery.Resource {
+	resources := make([]*discovery.Resource, 0)
 	networkView := model.GetNetworkView(cb.proxy)
+	cacheEnabled := features.EnableCDSCaching && configgen.Cache != nil
 
 	var services []*model.Service
 	if features.FilterGatewayClusterConfig && cb.proxy.Type == model.Router {
@@ -157,11 +163,22 @@ func (configgen *ConfigGeneratorImpl) buildOutboundClusters(cb *ClusterBuilder,
 			if port.Protocol == protocol.UDP {
 				continue
 			}
+			clusterName := model.BuildSubsetKey(model.TrafficDirectionOutbound, "", service.Hostname, port.Port)
+			var entries map[string]clusterCache
+			var tokens map[string]model.CacheToken
+			if cacheEnabled {
+				var cached []*discovery.Resource
+				var allFound bool
+				entries, tokens, cached, allFound = configgen.getCachedClusters(cb.clusterCache(clusterName, service, port, cp), service, port)
+				if allFound && !features.EnableUnsafeAssertions {
+					resources = append(resources, cached...)
+					continue
+				}
+			}
 			lbEndpoints := cb.buildLocalityLbEndpoints(networkView, service, port.Port, nil)
 
 			// create default cluster
 			discoveryType := convertResolution(cb.proxy, service)
-			clusterName := model.BuildSubsetKey(model.TrafficDirectionOutbound, "", service.Hostname, port.Port)
 			defaultCluster := cb.buildDefaultCluster(clusterName, discoveryType, lbEndpoints, model.TrafficDirectionOutbound, port, service, nil)
 			if defaultCluster == nil {
 				continue
@@ -175,12 +192,45 @@ func (configgen *ConfigGeneratorImpl) buildOutboundClusters(cb *ClusterBuilder,
 
 			subsetClusters := cb.applyDestinationRule(defaultCluster, DefaultClusterMode, service, port, networkView)
 
-			clusters = cp.conditionallyAppend(clusters, defaultCluster)
-			clusters = cp.conditionallyAppend(clusters, subsetClusters...)
+			for _, c := range cp.conditionallyAppend(nil, append([]*cluster.Cluster{defaultCluster}, subsetClusters...)...) {
+				resource := &discovery.Resource{Name: c.Name, Resource: util.MessageToAny(c)}
+				if entry, f := entries[c.Name]; f {
+					configgen.Cache.Add(entry, tokens[c.Name], resource.Resource)
+				}
+				resources = append(resources, resource)
+			}
 		}
 	}
 
-	return clusters
+	return resources
+}
+
+// getCachedClusters looks up the default and subset clusters of a service port in the cache. It returns the cache
+// entries and tokens of the clusters to add them once built, and the cached clusters if all of them were found.
+func (configgen *ConfigGeneratorImpl) getCachedClusters(defaultEntry clusterCache, service *model.Service,
+	port *model.Port) (map[string]clusterCache, map[string]model.CacheToken, []*discovery.Resource, bool) {
+	entries := map[string]clusterCache{defaultEntry.clusterName: defaultEntry}
+	names := []string{defaultEntry.clusterName}
+	if defaultEntry.destinationRule != nil {
+		for _, subset := range defaultEntry.destinationRule.Spec.(*networking.DestinationRule).Subsets {
+			name := model.BuildSubsetKey(model.TrafficDirectionOutbound, subset.Name, service.Hostname, port.Port)
+			entries[name] = defaultEntry.withName(name)
+			names = append(names, name)
+		}
+	}
+	tokens := make(map[string]model.CacheToken, len(names))
+	cached := make([]*discovery.Resource, 0, len(names))
+	allFound := true
+	for _, name := range names {
+		c, token, f := configgen.Cache.Get(entries[name])
+		tokens[name] = token
+		if !f {
+			allFound = false
+			continue
+		}
+		cached = append(cached, &discovery.Resource{Name: name, Resource: c})
+	}
+	return entries, tokens, cached, allFound
 }
 
 var NilClusterPatcher = clusterPatcher{}
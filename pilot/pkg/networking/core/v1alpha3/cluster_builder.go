@@ -16,6 +16,7 @@ package v1alpha3
 
 import (
 	"fmt"
+	"sort"
 
 	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
 	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
@@ -38,6 +39,8 @@ var defaultDestinationRule = networking.DestinationRule{}
 type ClusterBuilder struct {
 	proxy *model.Proxy
 	push  *model.PushContext
+
+	destinationRuleHashes map[*config.Config]string
 }
 
 // NewClusterBuilder builds an instance of ClusterBuilder.
@@ -334,9 +337,17 @@ func (cb *ClusterBuilder) buildLocalityLbEndpoints(proxyNetworkView map[string]b
 		lbEndpoints[locality] = append(lbEndpoints[locality], ep)
 	}
 
+	// Sort the localities, the clusters are cached and must be built deterministically.
+	localities := make([]string, 0, len(lbEndpoints))
+	for locality := range lbEndpoints {
+		localities = append(localities, locality)
+	}
+	sort.Strings(localities)
+
 	localityLbEndpoints := make([]*endpoint.LocalityLbEndpoints, 0, len(lbEndpoints))
 
-	for locality, eps := range lbEndpoints {
+	for _, locality := range localities {
+		eps := lbEndpoints[locality]
 		var weight uint32
 		for _, ep := range eps {
 			weight += ep.LoadBalancingWeight.GetValue()
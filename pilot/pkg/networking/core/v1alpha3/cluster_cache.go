@@ -0,0 +1,149 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha3
+
+import (
+	"sort"
+	"strings"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/networking/util"
+	v3 "istio.io/istio/pilot/pkg/xds/v3"
+	"istio.io/istio/pkg/config"
+	"istio.io/istio/pkg/config/schema/gvk"
+)
+
+var clusterDependentTypes = []config.GroupVersionKind{gvk.PeerAuthentication}
+
+// clusterCache is the XdsCache entry of an outbound cluster. The key includes everything the default and subset
+// clusters of a service port are built from, so that proxies with the same inputs share the clusters.
+type clusterCache struct {
+	clusterName string
+
+	// proxy related cache fields
+	proxyVersion   string
+	proxyType      model.NodeType
+	proxyClusterID string
+	locality       *core.Locality
+	networkView    map[string]bool
+	metadataCerts  []string
+
+	// service and config related cache fields
+	service             *model.Service
+	destinationRule     *config.Config
+	destinationRuleHash string
+	serviceAccounts     []string
+	peerAuthVersion     string
+	envoyFilterKeys     []model.ConfigKey
+	// proxyMetadataPatches is set when EnvoyFilter patches match on proxy metadata, which the key does not include.
+	proxyMetadataPatches bool
+}
+
+func (cb *ClusterBuilder) clusterCache(clusterName string, service *model.Service, port *model.Port, cp clusterPatcher) clusterCache {
+	c := clusterCache{
+		clusterName:          clusterName,
+		proxyVersion:         cb.proxy.Metadata.IstioVersion,
+		proxyType:            cb.proxy.Type,
+		proxyClusterID:       cb.proxy.Metadata.ClusterID,
+		locality:             cb.proxy.Locality,
+		networkView:          model.GetNetworkView(cb.proxy),
+		metadataCerts:        []string{cb.proxy.Metadata.TLSClientCertChain, cb.proxy.Metadata.TLSClientKey, cb.proxy.Metadata.TLSClientRootCert},
+		service:              service,
+		serviceAccounts:      cb.push.ServiceAccounts[service.Hostname][port.Port],
+		envoyFilterKeys:      cp.efw.Keys(networking.EnvoyFilter_CLUSTER),
+		proxyMetadataPatches: cp.efw.MatchesProxyMetadata(networking.EnvoyFilter_CLUSTER),
+	}
+	if cb.push.AuthnPolicies != nil {
+		c.peerAuthVersion = cb.push.AuthnPolicies.AggregateVersion
+	}
+	if dr := cb.push.DestinationRule(cb.proxy, service); dr != nil {
+		c.destinationRule = dr
+		c.destinationRuleHash = cb.destinationRuleHash(dr)
+	}
+	return c
+}
+
+// destinationRuleHash returns the hash of a DestinationRule, which is computed once per build.
+func (cb *ClusterBuilder) destinationRuleHash(dr *config.Config) string {
+	if cb.destinationRuleHashes == nil {
+		cb.destinationRuleHashes = map[*config.Config]string{}
+	}
+	hash, f := cb.destinationRuleHashes[dr]
+	if !f {
+		hash = util.ConfigSpecHash(dr)
+		cb.destinationRuleHashes[dr] = hash
+	}
+	return hash
+}
+
+// withName returns a copy of the entry for another cluster built from the same inputs, e.g. a subset cluster.
+func (t clusterCache) withName(clusterName string) clusterCache {
+	t.clusterName = clusterName
+	return t
+}
+
+func (t clusterCache) Key() string {
+	params := []string{
+		t.clusterName, t.proxyVersion, string(t.proxyType), t.proxyClusterID, util.LocalityToString(t.locality),
+		strings.Join(t.metadataCerts, ","),
+		string(t.service.Hostname) + "/" + t.service.Attributes.Namespace,
+		strings.Join(t.serviceAccounts, ","),
+		t.peerAuthVersion,
+	}
+	if t.destinationRule != nil {
+		params = append(params, t.destinationRule.Name+"/"+t.destinationRule.Namespace+"/"+t.destinationRuleHash)
+	} else {
+		params = append(params, "")
+	}
+	efKeys := make([]string, 0, len(t.envoyFilterKeys))
+	for _, ef := range t.envoyFilterKeys {
+		efKeys = append(efKeys, ef.Namespace+"/"+ef.Name)
+	}
+	params = append(params, strings.Join(efKeys, ","))
+	if t.networkView != nil {
+		nv := make([]string, 0, len(t.networkView))
+		for nw := range t.networkView {
+			nv = append(nv, nw)
+		}
+		sort.Strings(nv)
+		params = append(params, nv...)
+	}
+	return strings.Join(params, "~")
+}
+
+func (t clusterCache) DependentConfigs() []model.ConfigKey {
+	configs := []model.ConfigKey{{Kind: gvk.ServiceEntry, Name: string(t.service.Hostname), Namespace: t.service.Attributes.Namespace}}
+	if t.destinationRule != nil {
+		configs = append(configs, model.ConfigKey{Kind: gvk.DestinationRule, Name: t.destinationRule.Name, Namespace: t.destinationRule.Namespace})
+	}
+	return append(configs, t.envoyFilterKeys...)
+}
+
+func (t clusterCache) DependentTypes() []config.GroupVersionKind {
+	return clusterDependentTypes
+}
+
+func (t clusterCache) Cacheable() bool {
+	return !t.proxyMetadataPatches
+}
+
+func (t clusterCache) TypeURL() string {
+	return v3.ClusterType
+}
+
+var _ model.XdsCacheEntry = clusterCache{}
@@ -0,0 +1,96 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha3
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"istio.io/istio/pilot/pkg/model"
+	v3 "istio.io/istio/pilot/pkg/xds/v3"
+	"istio.io/istio/pkg/config/schema/gvk"
+)
+
+const cacheTestConfig = `
+apiVersion: networking.istio.io/v1alpha3
+kind: ServiceEntry
+metadata:
+  name: se
+  namespace: default
+spec:
+  hosts:
+  - example.com
+  ports:
+  - number: 80
+    name: http
+    protocol: HTTP
+  resolution: STATIC
+  endpoints:
+  - address: 1.1.1.1
+---
+apiVersion: networking.istio.io/v1alpha3
+kind: DestinationRule
+metadata:
+  name: dr
+  namespace: default
+spec:
+  host: example.com
+  subsets:
+  - name: v1
+    labels:
+      version: v1
+`
+
+func TestClusterCache(t *testing.T) {
+	cg := NewConfigGenTest(t, TestOptions{ConfigString: cacheTestConfig})
+	cache := model.NewLenientXdsCache()
+	cg.ConfigGen.Cache = cache
+
+	expectStats := func(want model.CacheStats) {
+		t.Helper()
+		if got := cache.Stats()[v3.ClusterType]; got != want {
+			t.Fatalf("unexpected cluster cache stats: got %+v, want %+v", got, want)
+		}
+	}
+
+	proxy := cg.SetupProxy(nil)
+	clusters := cg.Clusters(proxy)
+	// The default and the subset clusters are cached
+	expectStats(model.CacheStats{Entries: 2, Misses: 2})
+
+	cached := cg.Clusters(proxy)
+	expectStats(model.CacheStats{Entries: 2, Hits: 2, Misses: 2})
+	if diff := cmp.Diff(clusters, cached, protocmp.Transform()); diff != "" {
+		t.Fatalf("cached clusters differ from the built ones: %v", diff)
+	}
+
+	// Proxies of another version don't share the clusters
+	cg.Clusters(cg.SetupProxy(&model.Proxy{Metadata: &model.NodeMetadata{IstioVersion: "1.8.0"}}))
+	expectStats(model.CacheStats{Entries: 4, Hits: 2, Misses: 4})
+
+	// Updating the DestinationRule clears the clusters built from it
+	cache.Clear(map[model.ConfigKey]struct{}{{Kind: gvk.DestinationRule, Name: "dr", Namespace: "default"}: {}})
+	expectStats(model.CacheStats{Entries: 0, Hits: 2, Misses: 4})
+
+	// As do the ServiceEntry and the PeerAuthentications
+	cg.Clusters(proxy)
+	cache.Clear(map[model.ConfigKey]struct{}{{Kind: gvk.ServiceEntry, Name: "example.com", Namespace: "default"}: {}})
+	expectStats(model.CacheStats{Entries: 0, Hits: 2, Misses: 6})
+	cg.Clusters(proxy)
+	cache.Clear(map[model.ConfigKey]struct{}{{Kind: gvk.PeerAuthentication, Name: "default", Namespace: "istio-system"}: {}})
+	expectStats(model.CacheStats{Entries: 0, Hits: 2, Misses: 8})
+}
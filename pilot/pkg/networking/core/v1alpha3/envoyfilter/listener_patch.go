@@ -569,6 +569,19 @@ func doHTTPFilterOperation(patchContext networking.EnvoyFilter_PatchContext,
 	}
 }
 
+// FilterChainMatches returns whether match selects the filter chain of the
+// listener in the given patch context, as it would for a patch applied to
+// the filters of the filter chain.  It's used to place filters which aren't
+// added by EnvoyFilters, e.g. ServiceMeshExtensions.
+func FilterChainMatches(patchContext networking.EnvoyFilter_PatchContext, listener *xdslistener.Listener,
+	fc *xdslistener.FilterChain, match *networking.EnvoyFilter_EnvoyConfigObjectMatch) bool {
+	cp := &model.EnvoyFilterConfigPatchWrapper{
+		ApplyTo: networking.EnvoyFilter_NETWORK_FILTER,
+		Match:   match,
+	}
+	return commonConditionMatch(patchContext, cp) && listenerMatch(listener, cp) && filterChainMatch(listener, fc, cp)
+}
+
 func listenerMatch(listener *xdslistener.Listener, cp *model.EnvoyFilterConfigPatchWrapper) bool {
 	cMatch := cp.Match.GetListener()
 	if cMatch == nil {
@@ -17,8 +17,10 @@ package v1alpha3
 import (
 	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 
+	"istio.io/istio/pilot/pkg/features"
 	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pilot/pkg/networking/core/v1alpha3/envoyfilter"
+	"istio.io/istio/pkg/servicemesh/extension"
 )
 
 // BuildExtensionConfiguration returns the list of extension configuration for the given proxy and list of names.
@@ -26,5 +28,9 @@ import (
 func (configgen *ConfigGeneratorImpl) BuildExtensionConfiguration(
 	proxy *model.Proxy, push *model.PushContext, extensionConfigNames []string) []*core.TypedExtensionConfig {
 	envoyFilterPatches := push.EnvoyFilters(proxy)
-	return envoyfilter.InsertedExtensionConfigurations(envoyFilterPatches, extensionConfigNames)
+	extensionConfigs := envoyfilter.InsertedExtensionConfigurations(envoyFilterPatches, extensionConfigNames)
+	if features.EnableMaistraExtensionSupport {
+		extensionConfigs = append(extensionConfigs, extension.ExtensionConfigurations(proxy, push, extensionConfigNames)...)
+	}
+	return extensionConfigs
 }
@@ -0,0 +1,265 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha3
+
+import (
+	"testing"
+
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	wasm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/wasm/v3"
+	wasmnetwork "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/wasm/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+	"github.com/golang/protobuf/ptypes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1 "maistra.io/api/core/v1"
+
+	"istio.io/istio/pilot/pkg/features"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/test/xdstest"
+	"istio.io/istio/pkg/config/protocol"
+	"istio.io/istio/pkg/servicemesh/controller/extension"
+	maistramodel "istio.io/istio/pkg/servicemesh/model"
+)
+
+func newExtension(name string, ready bool) *v1.ServiceMeshExtension {
+	return &v1.ServiceMeshExtension{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: v1.ServiceMeshExtensionSpec{
+			Image: "quay.io/maistra/" + name,
+		},
+		Status: v1.ServiceMeshExtensionStatus{
+			Phase: v1.FilterPhasePostAuthZ,
+			Deployment: v1.DeploymentStatus{
+				Ready:  ready,
+				URL:    "http://mec.istio-system.svc.cluster.local/" + name,
+				SHA256: name + "-sha256",
+			},
+		},
+	}
+}
+
+func TestServiceMeshExtensionConfiguration(t *testing.T) {
+	defer func(enabled bool) { features.EnableMaistraExtensionSupport = enabled }(features.EnableMaistraExtensionSupport)
+	features.EnableMaistraExtensionSupport = true
+
+	svc := buildServiceWithPort("test.com", 80, protocol.HTTP, tnow)
+	cg := NewConfigGenTest(t, TestOptions{
+		Services: []*model.Service{svc},
+		Instances: []*model.ServiceInstance{{
+			Service:     svc,
+			ServicePort: svc.Ports[0],
+			Endpoint:    &model.IstioEndpoint{Address: "1.1.1.1", EndpointPort: 80},
+		}},
+		ExtensionStore: &extension.FakeController{
+			Extensions: []*v1.ServiceMeshExtension{newExtension("ready", true), newExtension("pending", false)},
+		},
+	})
+	proxy := cg.SetupProxy(nil)
+
+	virtualInbound := xdstest.ExtractListener("virtualInbound", cg.Listeners(proxy))
+	var chains int
+	for _, fc := range virtualInbound.FilterChains {
+		if fc.Name != "0.0.0.0_80" {
+			continue
+		}
+		chains++
+		var referenced []string
+		for _, filter := range xdstest.ExtractHTTPConnectionManager(t, fc).HttpFilters {
+			if filter.GetConfigDiscovery() != nil {
+				referenced = append(referenced, filter.Name)
+			}
+		}
+		if len(referenced) != 1 || referenced[0] != "maistra.io/extension/default/ready" {
+			t.Errorf("expected filter chain to reference the ready extension, got %v", referenced)
+		}
+	}
+	if chains == 0 {
+		t.Fatalf("no filter chain found for the inbound port")
+	}
+
+	configs := cg.ConfigGen.BuildExtensionConfiguration(proxy, cg.PushContext(),
+		[]string{"maistra.io/extension/default/ready", "maistra.io/extension/default/pending"})
+	if len(configs) != 1 || configs[0].Name != "maistra.io/extension/default/ready" {
+		t.Fatalf("expected configuration of the ready extension, got %v", configs)
+	}
+	filter := &wasm.Wasm{}
+	if err := ptypes.UnmarshalAny(configs[0].TypedConfig, filter); err != nil {
+		t.Fatal(err)
+	}
+	remote := filter.Config.GetVmConfig().GetCode().GetRemote()
+	if remote.GetHttpUri().GetUri() != "http://mec.istio-system.svc.cluster.local/ready" || remote.GetSha256() != "ready-sha256" {
+		t.Errorf("expected wasm module of the extension to be fetched, got %v", remote)
+	}
+	if filter.Config.RootId != "ready_root" {
+		t.Errorf("expected root id ready_root, got %s", filter.Config.RootId)
+	}
+}
+
+func TestServiceMeshNetworkExtension(t *testing.T) {
+	defer func(enabled bool) { features.EnableMaistraExtensionSupport = enabled }(features.EnableMaistraExtensionSupport)
+	features.EnableMaistraExtensionSupport = true
+
+	httpSvc := buildServiceWithPort("http.com", 80, protocol.HTTP, tnow)
+	tcpSvc := buildServiceWithPort("tcp.com", 3306, protocol.TCP, tnow)
+	inspector := newExtension("inspector", true)
+	inspector.Annotations = map[string]string{maistramodel.FilterTypeAnnotation: string(maistramodel.FilterTypeNetwork)}
+	cg := NewConfigGenTest(t, TestOptions{
+		Services: []*model.Service{httpSvc, tcpSvc},
+		Instances: []*model.ServiceInstance{
+			{
+				Service:     httpSvc,
+				ServicePort: httpSvc.Ports[0],
+				Endpoint:    &model.IstioEndpoint{Address: "1.1.1.1", EndpointPort: 80},
+			},
+			{
+				Service:     tcpSvc,
+				ServicePort: tcpSvc.Ports[0],
+				Endpoint:    &model.IstioEndpoint{Address: "1.1.1.1", EndpointPort: 3306},
+			},
+		},
+		ExtensionStore: &extension.FakeController{
+			Extensions: []*v1.ServiceMeshExtension{inspector},
+		},
+	})
+	proxy := cg.SetupProxy(nil)
+
+	virtualInbound := xdstest.ExtractListener("virtualInbound", cg.Listeners(proxy))
+	var tcpChains int
+	for _, fc := range virtualInbound.FilterChains {
+		switch fc.Name {
+		case "0.0.0.0_3306":
+			tcpChains++
+			var names []string
+			for _, filter := range fc.Filters {
+				names = append(names, filter.Name)
+			}
+			last := len(names) - 1
+			if last < 1 || names[last] != wellknown.TCPProxy || names[last-1] != "envoy.filters.network.wasm" {
+				t.Errorf("expected wasm filter before tcp_proxy, got %v", names)
+				continue
+			}
+			filter := &wasmnetwork.Wasm{}
+			if err := ptypes.UnmarshalAny(fc.Filters[last-1].GetTypedConfig(), filter); err != nil {
+				t.Fatal(err)
+			}
+			if uri := filter.Config.GetVmConfig().GetCode().GetRemote().GetHttpUri().GetUri(); uri != "http://mec.istio-system.svc.cluster.local/inspector" {
+				t.Errorf("expected wasm module of the extension to be fetched, got %s", uri)
+			}
+		case "0.0.0.0_80":
+			for _, filter := range xdstest.ExtractHTTPConnectionManager(t, fc).HttpFilters {
+				if filter.GetConfigDiscovery() != nil {
+					t.Errorf("expected network extension not to be added to HTTP filters, got %s", filter.Name)
+				}
+			}
+		}
+	}
+	if tcpChains == 0 {
+		t.Fatalf("no filter chain found for the TCP port")
+	}
+
+	if configs := cg.ConfigGen.BuildExtensionConfiguration(proxy, cg.PushContext(),
+		[]string{"maistra.io/extension/default/inspector"}); len(configs) != 0 {
+		t.Errorf("expected network extension not to be served over ECDS, got %v", configs)
+	}
+}
+
+func TestServiceMeshExtensionMatch(t *testing.T) {
+	defer func(enabled bool) { features.EnableMaistraExtensionSupport = enabled }(features.EnableMaistraExtensionSupport)
+	features.EnableMaistraExtensionSupport = true
+
+	local := buildServiceWithPort("local.com", 80, protocol.HTTP, tnow)
+	partner := buildServiceWithPort("partner.com", 8080, protocol.HTTP, tnow)
+	signer := newExtension("signer", true)
+	signer.Annotations = map[string]string{maistramodel.MatchAnnotation: `
+context: SIDECAR_OUTBOUND
+listener:
+  portNumber: 8080
+`}
+	cg := NewConfigGenTest(t, TestOptions{
+		Services: []*model.Service{local, partner},
+		Instances: []*model.ServiceInstance{{
+			Service:     local,
+			ServicePort: local.Ports[0],
+			Endpoint:    &model.IstioEndpoint{Address: "1.1.1.1", EndpointPort: 80},
+		}},
+		ExtensionStore: &extension.FakeController{
+			Extensions: []*v1.ServiceMeshExtension{newExtension("inbound", true), signer},
+		},
+	})
+	listeners := cg.Listeners(cg.SetupProxy(nil))
+
+	referenced := func(l *listener.Listener, fcName string) []string {
+		var names []string
+		for _, fc := range l.FilterChains {
+			if fcName != "" && fc.Name != fcName {
+				continue
+			}
+			for _, filter := range fc.Filters {
+				if filter.Name != wellknown.HTTPConnectionManager {
+					continue
+				}
+				for _, httpFilter := range xdstest.ExtractHTTPConnectionManager(t, fc).HttpFilters {
+					if httpFilter.GetConfigDiscovery() != nil {
+						names = append(names, httpFilter.Name)
+					}
+				}
+			}
+		}
+		return names
+	}
+	if got := referenced(xdstest.ExtractListener("0.0.0.0_8080", listeners), ""); len(got) != 1 || got[0] != "maistra.io/extension/default/signer" {
+		t.Errorf("expected outbound listener on port 8080 to reference the signer extension, got %v", got)
+	}
+	if got := referenced(xdstest.ExtractListener("0.0.0.0_80", listeners), ""); len(got) != 0 {
+		t.Errorf("expected outbound listener on port 80 not to reference extensions, got %v", got)
+	}
+	// both the mTLS and plaintext filter chains reference the inbound extension
+	got := referenced(xdstest.ExtractListener("virtualInbound", listeners), "0.0.0.0_80")
+	if len(got) != 2 || got[0] != "maistra.io/extension/default/inbound" || got[1] != got[0] {
+		t.Errorf("expected inbound filter chains to only reference the inbound extension, got %v", got)
+	}
+}
+
+func TestServiceMeshExtensionRollout(t *testing.T) {
+	defer func(enabled bool) { features.EnableMaistraExtensionSupport = enabled }(features.EnableMaistraExtensionSupport)
+	features.EnableMaistraExtensionSupport = true
+
+	canary := newExtension("canary", true)
+	canary.Annotations = map[string]string{
+		maistramodel.FailurePolicyAnnotation:     string(maistramodel.FailurePolicyFailOpen),
+		maistramodel.RolloutPercentageAnnotation: "0",
+		maistramodel.StableModuleAnnotation:      `{"url": "http://mec.istio-system.svc.cluster.local/stable", "sha256": "stable-sha256"}`,
+	}
+	cg := NewConfigGenTest(t, TestOptions{
+		ExtensionStore: &extension.FakeController{Extensions: []*v1.ServiceMeshExtension{canary}},
+	})
+	proxy := cg.SetupProxy(nil)
+
+	configs := cg.ConfigGen.BuildExtensionConfiguration(proxy, cg.PushContext(), []string{"maistra.io/extension/default/canary"})
+	if len(configs) != 1 {
+		t.Fatalf("expected configuration of the extension, got %v", configs)
+	}
+	filter := &wasm.Wasm{}
+	if err := ptypes.UnmarshalAny(configs[0].TypedConfig, filter); err != nil {
+		t.Fatal(err)
+	}
+	remote := filter.Config.GetVmConfig().GetCode().GetRemote()
+	if remote.GetHttpUri().GetUri() != "http://mec.istio-system.svc.cluster.local/stable" || remote.GetSha256() != "stable-sha256" {
+		t.Errorf("expected proxy outside of the rollout to fetch the stable module, got %v", remote)
+	}
+	if !filter.Config.FailOpen {
+		t.Errorf("expected extension to fail open")
+	}
+}
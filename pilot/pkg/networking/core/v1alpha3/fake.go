@@ -41,6 +41,7 @@ import (
 	"istio.io/istio/pkg/config"
 	"istio.io/istio/pkg/config/mesh"
 	"istio.io/istio/pkg/config/schema/collections"
+	"istio.io/istio/pkg/servicemesh/controller/extension"
 	"istio.io/istio/pkg/test"
 	"istio.io/istio/pkg/test/util/retry"
 )
@@ -69,6 +70,9 @@ type TestOptions struct {
 	// Additional ConfigStoreCache to use
 	ConfigStoreCaches []model.ConfigStoreCache
 
+	// If provided, ServiceMeshExtensions will be read from this store
+	ExtensionStore extension.Controller
+
 	// ConfigGen plugins to use. If not set, all default plugins will be used
 	Plugins []plugin.Plugin
 
@@ -140,6 +144,7 @@ func NewConfigGenTest(t test.Failer, opts TestOptions) *ConfigGenTest {
 	env.ServiceDiscovery = serviceDiscovery
 	env.IstioConfigStore = model.MakeIstioStore(configController)
 	env.NetworksWatcher = opts.NetworksWatcher
+	env.ExtensionStore = opts.ExtensionStore
 
 	if opts.Plugins == nil {
 		opts.Plugins = registry.NewPlugins([]string{plugin.AuthzCustom, plugin.Authn, plugin.Authz})
@@ -234,11 +239,11 @@ func (f *ConfigGenTest) Listeners(p *model.Proxy) []*listener.Listener {
 }
 
 func (f *ConfigGenTest) Clusters(p *model.Proxy) []*cluster.Cluster {
-	return f.ConfigGen.BuildClusters(p, f.PushContext())
+	return xdstest.UnmarshalCluster(f.t, f.ConfigGen.BuildClusters(p, f.PushContext()))
 }
 
 func (f *ConfigGenTest) Routes(p *model.Proxy) []*route.RouteConfiguration {
-	return f.ConfigGen.BuildHTTPRoutes(p, f.PushContext(), xdstest.ExtractRoutesFromListeners(f.Listeners(p)))
+	return xdstest.UnmarshalRouteConfiguration(f.t, f.ConfigGen.BuildHTTPRoutes(p, f.PushContext(), xdstest.ExtractRoutesFromListeners(f.Listeners(p))))
 }
 
 func (f *ConfigGenTest) PushContext() *model.PushContext {
@@ -21,6 +21,7 @@ import (
 
 	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
 	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/any"
 
 	networking "istio.io/api/networking/v1alpha3"
 	"istio.io/istio/pilot/pkg/features"
@@ -42,13 +43,25 @@ const inboundVirtualHostPrefix = string(model.TrafficDirectionInbound) + "|http|
 
 // BuildHTTPRoutes produces a list of routes for the proxy
 func (configgen *ConfigGeneratorImpl) BuildHTTPRoutes(node *model.Proxy, push *model.PushContext,
-	routeNames []string) []*route.RouteConfiguration {
-	routeConfigurations := make([]*route.RouteConfiguration, 0)
+	routeNames []string) model.Resources {
+	routeConfigurations := make(model.Resources, 0)
 
 	switch node.Type {
 	case model.SidecarProxy:
 		vHostCache := make(map[int][]*route.VirtualHost)
+		cacheEnabled := features.EnableRDSCaching && configgen.Cache != nil
 		for _, routeName := range routeNames {
+			var entry *istio_route.Cache
+			var token model.CacheToken
+			if cacheEnabled {
+				entry = sidecarOutboundRouteCache(node, push, routeName)
+				var cached *any.Any
+				var f bool
+				if cached, token, f = configgen.Cache.Get(entry); f && !features.EnableUnsafeAssertions {
+					routeConfigurations = append(routeConfigurations, cached)
+					continue
+				}
+			}
 			rc := configgen.buildSidecarOutboundHTTPRouteConfig(node, push, routeName, vHostCache)
 			if rc != nil {
 				rc = envoyfilter.ApplyRouteConfigurationPatches(networking.EnvoyFilter_SIDECAR_OUTBOUND, node, push, rc)
@@ -59,7 +72,11 @@ func (configgen *ConfigGeneratorImpl) BuildHTTPRoutes(node *model.Proxy, push *m
 					ValidateClusters: proto.BoolFalse,
 				}
 			}
-			routeConfigurations = append(routeConfigurations, rc)
+			resource := util.MessageToAny(rc)
+			if entry != nil {
+				configgen.Cache.Add(entry, token, resource)
+			}
+			routeConfigurations = append(routeConfigurations, resource)
 		}
 	case model.Router:
 		for _, routeName := range routeNames {
@@ -73,12 +90,78 @@ func (configgen *ConfigGeneratorImpl) BuildHTTPRoutes(node *model.Proxy, push *m
 					ValidateClusters: proto.BoolFalse,
 				}
 			}
-			routeConfigurations = append(routeConfigurations, rc)
+			routeConfigurations = append(routeConfigurations, util.MessageToAny(rc))
 		}
 	}
 	return routeConfigurations
 }
 
+// sidecarOutboundRouteCache returns the cache entry of an outbound route configuration. It returns nil for
+// the routes which are not cached, like the HTTP proxy and unix domain socket ones.
+func sidecarOutboundRouteCache(node *model.Proxy, push *model.PushContext, routeName string) *istio_route.Cache {
+	listenerPort, _, err := parseOutboundRouteName(routeName)
+	if err != nil || listenerPort == 0 {
+		return nil
+	}
+	egressListener := node.SidecarScope.GetEgressListenerForRDS(listenerPort, routeName)
+	if egressListener == nil || isHTTPProxyEgressListener(egressListener) {
+		return nil
+	}
+
+	efw := push.EnvoyFilters(node)
+	patchTypes := []networking.EnvoyFilter_ApplyTo{
+		networking.EnvoyFilter_ROUTE_CONFIGURATION, networking.EnvoyFilter_VIRTUAL_HOST, networking.EnvoyFilter_HTTP_ROUTE,
+	}
+	entry := &istio_route.Cache{
+		RouteName:            routeName,
+		ProxyVersion:         node.Metadata.IstioVersion,
+		ClusterID:            node.Metadata.ClusterID,
+		DNSDomain:            node.DNSDomain,
+		DNSCapture:           bool(node.Metadata.DNSCapture),
+		DNSAutoAllocate:      bool(node.Metadata.DNSAutoAllocate),
+		SidecarScope:         node.SidecarScope.Name + "/" + node.SidecarScope.Namespace,
+		ListenerPort:         listenerPort,
+		VirtualServices:      egressListener.VirtualServices(),
+		EnvoyFilterKeys:      efw.Keys(patchTypes...),
+		ProxyMetadataPatches: efw.MatchesProxyMetadata(patchTypes...),
+	}
+	entry.DelegateVirtualServices = push.DelegateVirtualServicesConfigKey(entry.VirtualServices)
+	// The routes only use the services listening on the port, and their DestinationRules for the hash policies.
+	destinationRules := map[*config.Config]struct{}{}
+	for _, svc := range egressListener.Services() {
+		if _, f := svc.Ports.GetByPort(listenerPort); !f {
+			continue
+		}
+		entry.Services = append(entry.Services, svc)
+		if dr := push.DestinationRule(node, svc); dr != nil {
+			if _, f := destinationRules[dr]; !f {
+				destinationRules[dr] = struct{}{}
+				entry.DestinationRules = append(entry.DestinationRules, dr)
+			}
+		}
+	}
+	return entry
+}
+
+// parseOutboundRouteName returns the listener port of an outbound route, and whether the route is for a
+// protocol sniffing listener, in which case the route name is host:port.
+func parseOutboundRouteName(routeName string) (int, bool, error) {
+	if features.EnableProtocolSniffingForOutbound && !strings.HasPrefix(routeName, model.UnixAddressPrefix) {
+		index := strings.IndexRune(routeName, ':')
+		listenerPort, err := strconv.Atoi(routeName[index+1:])
+		return listenerPort, index != -1, err
+	}
+	listenerPort, err := strconv.Atoi(routeName)
+	return listenerPort, false, err
+}
+
+// isHTTPProxyEgressListener returns true for the ports created via the SidecarScope with the HTTP_PROXY
+// protocol, which are treated as HTTP proxy style ports.
+func isHTTPProxyEgressListener(egressListener *model.IstioEgressListenerWrapper) bool {
+	return egressListener.IstioListener != nil && egressListener.IstioListener.Port != nil &&
+		protocol.Parse(egressListener.IstioListener.Port.Protocol) == protocol.HTTP_PROXY
+}
+
 // buildSidecarInboundHTTPRouteConfig builds the route config with a single wildcard virtual host on the inbound path
 // TODO: trace decorators, inbound timeouts
 func (configgen *ConfigGeneratorImpl) buildSidecarInboundHTTPRouteConfig(
@@ -118,20 +201,7 @@ func (configgen *ConfigGeneratorImpl) buildSidecarOutboundHTTPRouteConfig(node *
 	routeName string, vHostCache map[int][]*route.VirtualHost) *route.RouteConfiguration {
 
 	var virtualHosts []*route.VirtualHost
-	listenerPort := 0
-	useSniffing := false
-	var err error
-	if features.EnableProtocolSniffingForOutbound &&
-		!strings.HasPrefix(routeName, model.UnixAddressPrefix) {
-		index := strings.IndexRune(routeName, ':')
-		if index != -1 {
-			useSniffing = true
-		}
-		listenerPort, err = strconv.Atoi(routeName[index+1:])
-	} else {
-		listenerPort, err = strconv.Atoi(routeName)
-	}
-
+	listenerPort, useSniffing, err := parseOutboundRouteName(routeName)
 	if err != nil {
 		// we have a port whose name is http_proxy or unix:///foo/bar
 		// check for both.
@@ -209,8 +279,7 @@ func (configgen *ConfigGeneratorImpl) buildSidecarOutboundVirtualHosts(node *mod
 
 	// When generating RDS for ports created via the SidecarScope, we treat ports as HTTP proxy style ports
 	// if ports protocol is HTTP_PROXY.
-	if egressListener.IstioListener != nil && egressListener.IstioListener.Port != nil &&
-		protocol.Parse(egressListener.IstioListener.Port.Protocol) == protocol.HTTP_PROXY {
+	if isHTTPProxyEgressListener(egressListener) {
 		listenerPort = 0
 	}
 
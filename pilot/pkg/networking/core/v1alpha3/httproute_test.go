@@ -22,12 +22,15 @@ import (
 	"time"
 
 	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
 
 	meshapi "istio.io/api/mesh/v1alpha1"
 	networking "istio.io/api/networking/v1alpha3"
 	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pilot/pkg/networking/plugin"
 	"istio.io/istio/pilot/pkg/serviceregistry"
+	v3 "istio.io/istio/pilot/pkg/xds/v3"
 	"istio.io/istio/pilot/test/xdstest"
 	"istio.io/istio/pkg/config"
 	"istio.io/istio/pkg/config/host"
@@ -1119,3 +1122,71 @@ func buildHTTPService(hostname string, v visibility.Instance, ip, namespace stri
 	service.Ports = Ports
 	return service
 }
+
+func TestSidecarOutboundRouteCache(t *testing.T) {
+	virtualService := `
+---
+apiVersion: networking.istio.io/v1alpha3
+kind: VirtualService
+metadata:
+  name: vs
+  namespace: default
+spec:
+  hosts:
+  - example.com
+  http:
+  - match:
+    - headers:
+        user:
+          exact: test
+%s
+    route:
+    - destination:
+        host: example.com
+        subset: v1
+  - route:
+    - destination:
+        host: example.com
+`
+	t.Run("cached", func(t *testing.T) {
+		cg := NewConfigGenTest(t, TestOptions{ConfigString: cacheTestConfig + fmt.Sprintf(virtualService, "")})
+		cache := model.NewLenientXdsCache()
+		cg.ConfigGen.Cache = cache
+		proxy := cg.SetupProxy(nil)
+
+		routes := cg.Routes(proxy)
+		if len(routes) == 0 {
+			t.Fatalf("expected routes")
+		}
+		stats := cache.Stats()[v3.RouteType]
+		if stats.Entries != len(routes) || stats.Hits != 0 {
+			t.Fatalf("expected all %d routes to be cached, got %+v", len(routes), stats)
+		}
+
+		cached := cg.Routes(proxy)
+		if got := cache.Stats()[v3.RouteType].Hits; got != uint64(len(routes)) {
+			t.Fatalf("expected %d hits, got %d", len(routes), got)
+		}
+		if diff := cmp.Diff(routes, cached, protocmp.Transform()); diff != "" {
+			t.Fatalf("cached routes differ from the built ones: %v", diff)
+		}
+
+		cache.Clear(map[model.ConfigKey]struct{}{{Kind: gvk.VirtualService, Name: "vs", Namespace: "default"}: {}})
+		if got := cache.Stats()[v3.RouteType].Entries; got != 0 {
+			t.Fatalf("expected the routes of the virtual service to be cleared, got %d entries", got)
+		}
+	})
+
+	t.Run("source match", func(t *testing.T) {
+		sourceMatch := `      sourceLabels:
+        app: test`
+		cg := NewConfigGenTest(t, TestOptions{ConfigString: cacheTestConfig + fmt.Sprintf(virtualService, sourceMatch)})
+		cache := model.NewLenientXdsCache()
+		cg.ConfigGen.Cache = cache
+
+		cg.Routes(cg.SetupProxy(nil))
+		if got := cache.Stats()[v3.RouteType].Entries; got != 0 {
+			t.Fatalf("expected the routes which depend on the proxy labels not to be cached, got %d entries", got)
+		}
+	})
+}
@@ -388,24 +388,30 @@ func (lb *ListenerBuilder) patchOneListener(l *listener.Listener, ctx networking
 }
 
 func (lb *ListenerBuilder) patchListeners() {
+	// extensions are applied regardless of whether any EnvoyFilter applies to the proxy
+	if features.EnableMaistraExtensionSupport {
+		if lb.node.Type == model.Router {
+			lb.gatewayListeners = extension.ApplyListenerListPatches(networking.EnvoyFilter_GATEWAY, lb.gatewayListeners, lb.node, lb.push)
+		} else {
+			lb.virtualInboundListener = extension.ApplyListenerPatches(networking.EnvoyFilter_SIDECAR_INBOUND,
+				lb.virtualInboundListener, lb.node, lb.push)
+			lb.outboundListeners = extension.ApplyListenerListPatches(networking.EnvoyFilter_SIDECAR_OUTBOUND,
+				lb.outboundListeners, lb.node, lb.push)
+		}
+	}
+
 	lb.envoyFilterWrapper = lb.push.EnvoyFilters(lb.node)
 	if lb.envoyFilterWrapper == nil {
 		return
 	}
 
 	if lb.node.Type == model.Router {
-		if features.EnableMaistraExtensionSupport {
-			extension.ApplyListenerListPatches(lb.gatewayListeners, lb.node, lb.push, true)
-		}
 		lb.gatewayListeners = envoyfilter.ApplyListenerPatches(networking.EnvoyFilter_GATEWAY, lb.node, lb.push, lb.envoyFilterWrapper,
 			lb.gatewayListeners, false)
 		return
 	}
 
 	lb.virtualOutboundListener = lb.patchOneListener(lb.virtualOutboundListener, networking.EnvoyFilter_SIDECAR_OUTBOUND)
-	if features.EnableMaistraExtensionSupport {
-		lb.virtualInboundListener = extension.ApplyListenerPatches(lb.virtualInboundListener, lb.node, lb.push, false)
-	}
 	lb.virtualInboundListener = lb.patchOneListener(lb.virtualInboundListener, networking.EnvoyFilter_SIDECAR_INBOUND)
 	lb.inboundListeners = envoyfilter.ApplyListenerPatches(networking.EnvoyFilter_SIDECAR_INBOUND, lb.node,
 		lb.push, lb.envoyFilterWrapper, lb.inboundListeners, false)
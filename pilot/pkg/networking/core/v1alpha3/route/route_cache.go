@@ -0,0 +1,146 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package route
+
+import (
+	"crypto/md5"
+	"fmt"
+	"strconv"
+	"strings"
+
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/networking/util"
+	v3 "istio.io/istio/pilot/pkg/xds/v3"
+	"istio.io/istio/pkg/config"
+	"istio.io/istio/pkg/config/schema/gvk"
+)
+
+// The catch all virtual host of the route configurations depends on the outbound traffic policy of the
+// Sidecar, which is only known by the name of the SidecarScope.
+var routeDependentTypes = []config.GroupVersionKind{gvk.Sidecar}
+
+// Cache includes the variables that can influence a sidecar outbound route configuration.
+// Implements XdsCacheEntry interface.
+type Cache struct {
+	RouteName string
+
+	// proxy related cache fields
+	ProxyVersion string
+	// ClusterID of the proxy, which selects the cluster VIPs of the services
+	ClusterID string
+	// DNSDomain of the proxy, which the alternative hostnames of the services are generated from
+	DNSDomain string
+	// DNSCapture and DNSAutoAllocate select the auto allocated addresses of the services
+	DNSCapture      bool
+	DNSAutoAllocate bool
+	// SidecarScope is the name/namespace of the SidecarScope of the proxy
+	SidecarScope string
+
+	ListenerPort            int
+	Services                []*model.Service
+	VirtualServices         []config.Config
+	DelegateVirtualServices []model.ConfigKey
+	DestinationRules        []*config.Config
+	EnvoyFilterKeys         []model.ConfigKey
+	// ProxyMetadataPatches is set when EnvoyFilter patches match on proxy metadata, which the key does not include.
+	ProxyMetadataPatches bool
+
+	key string
+}
+
+func (r *Cache) Cacheable() bool {
+	if r == nil {
+		return false
+	}
+	// HTTP proxy and unix domain socket routes use all the ports of the services.
+	if r.ListenerPort == 0 || r.ProxyMetadataPatches {
+		return false
+	}
+
+	for _, cfg := range r.VirtualServices {
+		vs := cfg.Spec.(*networking.VirtualService)
+		for _, httpRoute := range vs.Http {
+			for _, match := range httpRoute.Match {
+				// The routes of these matches depend on the labels and namespace of each proxy.
+				if len(match.SourceLabels) > 0 || match.SourceNamespace != "" {
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+func (r *Cache) DependentConfigs() []model.ConfigKey {
+	configs := make([]model.ConfigKey, 0, len(r.Services)+len(r.VirtualServices)+
+		len(r.DelegateVirtualServices)+len(r.DestinationRules)+len(r.EnvoyFilterKeys))
+	for _, svc := range r.Services {
+		configs = append(configs, model.ConfigKey{Kind: gvk.ServiceEntry, Name: string(svc.Hostname), Namespace: svc.Attributes.Namespace})
+	}
+	for _, vs := range r.VirtualServices {
+		configs = append(configs, model.ConfigKey{Kind: gvk.VirtualService, Name: vs.Name, Namespace: vs.Namespace})
+	}
+	// add delegate virtual services to dependent configs
+	// so that we can clear the rds cache when delegate virtual services are updated
+	configs = append(configs, r.DelegateVirtualServices...)
+	for _, dr := range r.DestinationRules {
+		configs = append(configs, model.ConfigKey{Kind: gvk.DestinationRule, Name: dr.Name, Namespace: dr.Namespace})
+	}
+	return append(configs, r.EnvoyFilterKeys...)
+}
+
+func (r *Cache) DependentTypes() []config.GroupVersionKind {
+	return routeDependentTypes
+}
+
+func (r *Cache) TypeURL() string {
+	return v3.RouteType
+}
+
+// Key hashes the inputs, as the services and virtual services visible to a sidecar may be a long list.
+// It is computed once, the cache calls it on every Get and Add.
+func (r *Cache) Key() string {
+	if r.key != "" {
+		return r.key
+	}
+	params := []string{
+		r.RouteName, r.ProxyVersion, r.ClusterID, r.DNSDomain,
+		strconv.FormatBool(r.DNSCapture), strconv.FormatBool(r.DNSAutoAllocate),
+		r.SidecarScope, strconv.Itoa(r.ListenerPort),
+	}
+	for _, svc := range r.Services {
+		params = append(params, "svc:"+string(svc.Hostname)+"/"+svc.Attributes.Namespace)
+	}
+	for _, vs := range r.VirtualServices {
+		params = append(params, "vs:"+vs.Name+"/"+vs.Namespace)
+	}
+	for _, vs := range r.DelegateVirtualServices {
+		params = append(params, "delegate:"+vs.Name+"/"+vs.Namespace)
+	}
+	for _, dr := range r.DestinationRules {
+		// PushContext may merge DestinationRules, so the key includes the resulting spec
+		params = append(params, "dr:"+dr.Name+"/"+dr.Namespace+"/"+util.ConfigSpecHash(dr))
+	}
+	for _, ef := range r.EnvoyFilterKeys {
+		params = append(params, "ef:"+ef.Name+"/"+ef.Namespace)
+	}
+
+	r.key = fmt.Sprintf("%x", md5.Sum([]byte(strings.Join(params, "~"))))
+	return r.key
+}
+
+var _ model.XdsCacheEntry = &Cache{}
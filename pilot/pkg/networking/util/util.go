@@ -16,6 +16,7 @@ package util
 
 import (
 	"fmt"
+	"hash/fnv"
 	"net"
 	"sort"
 	"strconv"
@@ -457,6 +458,23 @@ func AddSubsetToMetadata(md *core.Metadata, subset string) *core.Metadata {
 	return updatedMeta
 }
 
+// ConfigSpecHash returns a hash of the spec of a config. Cache keys use it for configs, like DestinationRules,
+// which PushContext may merge into a copy that keeps the metadata of only one of the merged configs.
+func ConfigSpecHash(cfg *config.Config) string {
+	if cfg == nil {
+		return ""
+	}
+	// The JSON encoding sorts the map keys, unlike the gogo binary one.
+	b, err := config.ToJSON(cfg.Spec)
+	if err != nil {
+		log.Warnf("failed to marshal %s %s/%s: %v", cfg.GroupVersionKind.Kind, cfg.Namespace, cfg.Name, err)
+		return ""
+	}
+	h := fnv.New64a()
+	_, _ = h.Write(b)
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
 // IsHTTPFilterChain returns true if the filter chain contains a HTTP connection manager filter
 func IsHTTPFilterChain(filterChain *listener.FilterChain) bool {
 	for _, f := range filterChain.Filters {
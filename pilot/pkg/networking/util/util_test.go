@@ -1273,3 +1273,29 @@ func TestByteCount(t *testing.T) {
 		})
 	}
 }
+
+func TestConfigSpecHash(t *testing.T) {
+	dr := func(labels map[string]string) *config.Config {
+		return &config.Config{
+			Meta: config.Meta{Name: "dr", Namespace: "default"},
+			Spec: &networking.DestinationRule{
+				Host:    "example.com",
+				Subsets: []*networking.Subset{{Name: "v1", Labels: labels}},
+			},
+		}
+	}
+	labels := map[string]string{"app": "example", "version": "v1", "zone": "a"}
+
+	hash := ConfigSpecHash(dr(labels))
+	for i := 0; i < 10; i++ {
+		if got := ConfigSpecHash(dr(labels)); got != hash {
+			t.Fatalf("expected the same hash for the same spec, got %s and %s", hash, got)
+		}
+	}
+	if got := ConfigSpecHash(dr(map[string]string{"app": "example", "version": "v2", "zone": "a"})); got == hash {
+		t.Fatalf("expected a different hash for a different spec")
+	}
+	if got := ConfigSpecHash(nil); got != "" {
+		t.Fatalf("expected no hash for a nil config, got %s", got)
+	}
+}
@@ -19,6 +19,8 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -54,7 +56,9 @@ type Controller struct {
 	egressService        string
 	egressName           string
 	discoveryURL         string
+	discoveryAddress     string
 	discoveryServiceName string
+	discoveryTransport   string
 	useDirectCalls       bool
 	namespace            string
 	localClusterID       string
@@ -62,6 +66,7 @@ type Controller struct {
 	clusterID            string
 	network              string
 	resyncPeriod         time.Duration
+	healthCheckPeriod    time.Duration
 	backoffPolicy        *backoff.ExponentialBackOff
 
 	logger *log.Scope
@@ -77,6 +82,10 @@ type Controller struct {
 	defaultLocality     *v1.ImportedServiceLocality
 	importLocality      *v1.ImportedServiceLocality
 	locality            *v1.ImportedServiceLocality
+	merger              *ImportMerger
+	mergeMode           string
+	importPriority      int
+	health              *peerHealth
 
 	storeLock      sync.RWMutex
 	imports        map[federationmodel.ServiceKey]*existingImport
@@ -85,9 +94,15 @@ type Controller struct {
 	gatewayStore   []*model.Gateway
 	egressGateways []*model.Gateway
 	egressSAs      []string
+	peerState      peerState
+	peerDownSince  time.Time
 
 	lastMessage *federationmodel.ServiceListMessage
-	stopped     int32
+	// epoch and revision of the last watch event or service list processed,
+	// used to resume the watch without performing a full resync
+	lastEpoch    string
+	lastRevision uint64
+	stopped      int32
 }
 
 type existingImport struct {
@@ -100,17 +115,26 @@ type Options struct {
 	EgressService  string
 	EgressName     string
 	UseDirectCalls bool
-	DomainSuffix   string
-	LocalClusterID string
-	LocalNetwork   string
-	ClusterID      string
-	Network        string
-	Namespace      string
-	KubeClient     kube.Client
-	StatusHandler  status.Handler
-	ConfigStore    model.ConfigStoreCache
-	XDSUpdater     model.XDSUpdater
-	ResyncPeriod   time.Duration
+	// DiscoveryTransport is the transport used to discover services, either
+	// common.DiscoveryTransportHTTP (default) or common.DiscoveryTransportGRPC
+	DiscoveryTransport string
+	DomainSuffix       string
+	LocalClusterID     string
+	LocalNetwork       string
+	ClusterID          string
+	Network            string
+	Namespace          string
+	KubeClient         kube.Client
+	StatusHandler      status.Handler
+	ConfigStore        model.ConfigStoreCache
+	XDSUpdater         model.XDSUpdater
+	ResyncPeriod       time.Duration
+	// ImportMerger is used to merge services imported under the same name from
+	// multiple peers.  If unspecified, imported services are not merged.
+	ImportMerger *ImportMerger
+	// HealthCheckPeriod is the interval at which the health of the peer is
+	// evaluated.  Defaults to common.DefaultPeerHealthCheckPeriod.
+	HealthCheckPeriod time.Duration
 }
 
 func defaultDomainSuffixForMesh(mesh *v1.ServiceMeshPeer) string {
@@ -124,6 +148,12 @@ func localDomainSuffix(domainSuffix string) string {
 	return "svc." + domainSuffix
 }
 
+// NewServiceImporter creates the importer naming the services imported from
+// the mesh according to the ImportedServiceSet, for a mesh using domainSuffix.
+func NewServiceImporter(mesh *v1.ServiceMeshPeer, importConfig *v1.ImportedServiceSet, domainSuffix string) *common.ServiceImporter {
+	return common.NewServiceImporter(importConfig, nil, defaultDomainSuffixForMesh(mesh), localDomainSuffix(domainSuffix))
+}
+
 func mergeLocality(locality *v1.ImportedServiceLocality, defaults *v1.ImportedServiceLocality) *v1.ImportedServiceLocality {
 	merged := v1.ImportedServiceLocality{}
 	if defaults == nil {
@@ -164,9 +194,19 @@ func NewController(opt Options, mesh *v1.ServiceMeshPeer, importConfig *v1.Impor
 		importLocality = importConfig.Spec.Locality
 	}
 	locality := mergeLocality(importLocality, defaultLocality)
-	return &Controller{
+	discoveryTransport := opt.DiscoveryTransport
+	if discoveryTransport == "" {
+		discoveryTransport = common.DiscoveryTransportHTTP
+	}
+	healthCheckPeriod := opt.HealthCheckPeriod
+	if healthCheckPeriod == 0 {
+		healthCheckPeriod = common.DefaultPeerHealthCheckPeriod
+	}
+	controller := &Controller{
 		discoveryURL:         fmt.Sprintf("%s://%s:%d", common.DiscoveryScheme, opt.EgressService, common.DefaultDiscoveryPort),
+		discoveryAddress:     fmt.Sprintf("%s:%d", opt.EgressService, common.DefaultDiscoveryPort),
 		discoveryServiceName: common.DiscoveryServiceHostname(mesh),
+		discoveryTransport:   discoveryTransport,
 		egressService:        opt.EgressService,
 		egressName:           opt.EgressName,
 		remote:               opt.Remote.DeepCopy(),
@@ -181,7 +221,11 @@ func NewController(opt Options, mesh *v1.ServiceMeshPeer, importConfig *v1.Impor
 		defaultLocality:      defaultLocality,
 		importLocality:       importLocality,
 		locality:             locality,
-		importNameMapper:     common.NewServiceImporter(importConfig, nil, defaultDomainSuffix, localDomainSuffix),
+		importNameMapper:     NewServiceImporter(mesh, importConfig, opt.DomainSuffix),
+		merger:               opt.ImportMerger,
+		mergeMode:            common.MergeModeForImports(importConfig),
+		importPriority:       common.ImportPriorityForImports(importConfig),
+		health:               newPeerHealth(common.PeerHealthConfigForPeer(mesh)),
 		imports:              map[federationmodel.ServiceKey]*existingImport{},
 		serviceStore:         map[host.Name]*model.Service{},
 		instanceStore:        map[host.Name][]*model.ServiceInstance{},
@@ -190,9 +234,14 @@ func NewController(opt Options, mesh *v1.ServiceMeshPeer, importConfig *v1.Impor
 		configStore:          opt.ConfigStore,
 		xdsUpdater:           opt.XDSUpdater,
 		resyncPeriod:         opt.ResyncPeriod,
+		healthCheckPeriod:    healthCheckPeriod,
 		backoffPolicy:        backoffPolicy,
 		logger:               common.Logger.WithLabels("component", "federation-registry"),
 	}
+	if controller.merger != nil {
+		controller.merger.register(controller.clusterID, controller)
+	}
+	return controller
 }
 
 func (c *Controller) UpdateImportConfig(importConfig *v1.ImportedServiceSet) {
@@ -206,6 +255,8 @@ func (c *Controller) UpdateImportConfig(importConfig *v1.ImportedServiceSet) {
 			c.importLocality = importConfig.Spec.Locality
 		}
 		c.locality = mergeLocality(c.importLocality, c.defaultLocality)
+		c.mergeMode = common.MergeModeForImports(importConfig)
+		c.importPriority = common.ImportPriorityForImports(importConfig)
 	}()
 	c.resync()
 }
@@ -237,38 +288,52 @@ func (c *Controller) Remote() *v1.ServiceMeshPeerRemote {
 	return c.remote
 }
 
-func (c *Controller) pollServices() *federationmodel.ServiceListMessage {
+// ImportStatus returns the services most recently advertised by the peer and
+// the names under which they were imported.
+func (c *Controller) ImportStatus() *federationmodel.PeerImportStatus {
+	c.storeLock.RLock()
+	defer c.storeLock.RUnlock()
+	status := &federationmodel.PeerImportStatus{
+		Peer:         fmt.Sprintf("%s/%s", c.namespace, c.clusterID),
+		DomainSuffix: strings.TrimPrefix(c.localDomainSuffix, "svc."),
+		Imported:     map[string]string{},
+	}
+	if c.lastMessage != nil {
+		status.Advertised = c.lastMessage.Services
+	}
+	for _, existing := range c.imports {
+		status.Imported[existing.Hostname] = existing.localName.Hostname
+	}
+	return status
+}
+
+func (c *Controller) pollServices() (*federationmodel.ServiceListMessage, error) {
 	url := c.discoveryURL + "/services/"
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		c.logger.Errorf("Failed to create request: '%s': %s", url, err)
-		return nil
+		return nil, fmt.Errorf("failed to create request: '%s': %s", url, err)
 	}
-	req.Header.Add("discovery-service", c.discoveryServiceName)
+	req.Header.Add(common.DiscoveryServiceHeader, c.discoveryServiceName)
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		c.logger.Errorf("Failed to GET URL: '%s': %s", url, err)
-		return nil
+		return nil, fmt.Errorf("failed to GET URL: '%s': %s", url, err)
 	}
 	if resp.StatusCode != http.StatusOK {
-		c.logger.Errorf("status code is not OK: %v (%s)", resp.StatusCode, resp.Status)
-		return nil
+		return nil, fmt.Errorf("status code is not OK: %v (%s)", resp.StatusCode, resp.Status)
 	}
 
 	respBytes := []byte{}
 	_, err = resp.Body.Read(respBytes)
 	if err != nil {
-		c.logger.Errorf("Failed to read response body from URL '%s': %s", url, err)
-		return nil
+		return nil, fmt.Errorf("failed to read response body from URL '%s': %s", url, err)
 	}
 
 	var serviceList federationmodel.ServiceListMessage
 	err = json.NewDecoder(resp.Body).Decode(&serviceList)
 	if err != nil {
-		c.logger.Errorf("Failed to unmarshal response bytes: %s", err)
-		return nil
+		return nil, fmt.Errorf("failed to unmarshal response bytes: %s", err)
 	}
-	return &serviceList
+	return &serviceList, nil
 }
 
 func (c *Controller) convertExportedService(s *federationmodel.ServiceMessage) (*model.Service, []*model.ServiceInstance) {
@@ -294,11 +359,7 @@ func (c *Controller) convertToLocalService(s *federationmodel.ServiceMessage,
 	importedName federationmodel.ServiceKey) (*model.Service, []*model.ServiceInstance) {
 
 	serviceName := fmt.Sprintf("%s.%s.%s.local", importedName.Name, importedName.Namespace, c.clusterID)
-	serviceNamespace := importedName.Namespace
-	if !strings.HasSuffix(importedName.Hostname, c.localDomainSuffix) {
-		// not importing as a local service
-		serviceNamespace = c.namespace
-	}
+	serviceNamespace := c.localServiceNamespace(importedName)
 	// XXX: make this configurable
 	serviceVisibility := visibility.Public
 	return c.createService(createServiceOptions{
@@ -315,6 +376,16 @@ func (c *Controller) convertToLocalService(s *federationmodel.ServiceMessage,
 	})
 }
 
+// localServiceNamespace returns the namespace of the local service an exported
+// service is imported as.
+func (c *Controller) localServiceNamespace(importedName federationmodel.ServiceKey) string {
+	if !strings.HasSuffix(importedName.Hostname, c.localDomainSuffix) {
+		// not importing as a local service
+		return c.namespace
+	}
+	return importedName.Namespace
+}
+
 type createServiceOptions struct {
 	service           *federationmodel.ServiceMessage
 	serviceName       string
@@ -364,14 +435,23 @@ func (c *Controller) createService(opts createServiceOptions) (*model.Service, [
 	if !strings.Contains(opts.serviceName, c.clusterID) {
 		baseWorkloadName = fmt.Sprintf("%s-%s", opts.serviceName, c.clusterID)
 	}
+	weight, healthy := endpointWeight(opts.service)
+	if c.peerState != peerHealthy {
+		// the peer has been unreachable for too long
+		healthy = false
+	}
+	if !healthy {
+		c.logger.Debugf("no healthy endpoints for imported service %s", svc.Hostname)
+		return svc, instances
+	}
 	localityLabel := ""
 	if opts.locality != nil && opts.locality.Region != "" {
 		localityLabel = fmt.Sprintf("%s/%s/%s", opts.locality.Region, opts.locality.Zone, opts.locality.Subzone)
 	}
 	for _, port := range svc.Ports {
 		for gatewayIndex, networkGateway := range opts.networkGateways {
-			c.logger.Debugf("adding endpoint for imported service: addr=%s, port=%d, host=%s",
-				networkGateway.Addr, networkGateway.Port, svc.Hostname)
+			c.logger.Debugf("adding endpoint for imported service: addr=%s, port=%d, host=%s, locality=%s, weight=%d",
+				networkGateway.Addr, networkGateway.Port, svc.Hostname, localityLabel, weight)
 			instance := &model.ServiceInstance{
 				Service:     svc,
 				ServicePort: port,
@@ -385,8 +465,10 @@ func (c *Controller) createService(opts createServiceOptions) (*model.Service, [
 					},
 					Network: opts.network,
 					Locality: model.Locality{
+						Label:     localityLabel,
 						ClusterID: opts.clusterID,
 					},
+					LbWeight:        weight,
 					ServicePortName: port.Name,
 					TLSMode:         model.IstioMutualTLSModeLabel,
 					Namespace:       opts.serviceNamespace,
@@ -394,10 +476,10 @@ func (c *Controller) createService(opts createServiceOptions) (*model.Service, [
 				},
 			}
 			if opts.locality != nil {
-				instance.Endpoint.Labels[label.IstioSubZone] = opts.locality.Subzone
-				instance.Endpoint.Labels[corev1.LabelZoneFailureDomainStable] = opts.locality.Zone
-				instance.Endpoint.Labels[corev1.LabelZoneRegionStable] = opts.locality.Region
-				instance.Endpoint.Locality.Label = localityLabel
+				region, zone, subzone := model.SplitLocalityLabel(localityLabel)
+				instance.Endpoint.Labels[label.IstioSubZone] = subzone
+				instance.Endpoint.Labels[corev1.LabelZoneFailureDomainStable] = zone
+				instance.Endpoint.Labels[corev1.LabelZoneRegionStable] = region
 			}
 			instances = append(instances, instance)
 		}
@@ -405,6 +487,36 @@ func (c *Controller) createService(opts createServiceOptions) (*model.Service, [
 	return svc, instances
 }
 
+// endpointWeight returns the load balancing weight of the endpoints of an
+// imported service and whether the service has any healthy endpoints.  If the
+// exporting mesh publishes endpoint summaries, the weight is that of the
+// healthy endpoints of all the published localities, so calls to the remote
+// service are weighed against the other endpoints of the service, e.g. those
+// of other peers.  Otherwise, the service is considered healthy and the
+// default weight is used.
+//
+// The peer only publishes the addresses of its ingress gateways, not those of
+// the gateways of each locality, so the service is imported as a single
+// endpoint per gateway, placed in the locality configured for the import.
+func endpointWeight(svc *federationmodel.ServiceMessage) (uint32, bool) {
+	if len(svc.Endpoints) == 0 {
+		// the peer isn't publishing endpoint summaries
+		return 0, true
+	}
+	var weight uint32
+	for _, summary := range svc.Endpoints {
+		if summary == nil || summary.Healthy == 0 {
+			continue
+		}
+		if summary.Weight == 0 {
+			weight += summary.Healthy
+		} else {
+			weight += summary.Weight
+		}
+	}
+	return weight, weight > 0
+}
+
 func (c *Controller) gatewayForNetworkAddress() []*model.Gateway {
 	var gateways []*model.Gateway
 	remotePort := c.remote.ServicePort
@@ -437,11 +549,20 @@ func (c *Controller) getIPAddrsForHostOrIP(host string) ([]string, error) {
 	return net.LookupHost(host)
 }
 
+// ImportNameForService returns the name under which the service advertised by
+// a peer is imported by the importer, or nil if it is not imported.
+func ImportNameForService(importer common.NameMapper, service *federationmodel.ServiceMessage) *federationmodel.ServiceKey {
+	return importer.NameForService(&model.Service{
+		Hostname:   host.Name(service.Hostname),
+		Attributes: model.ServiceAttributes{Name: service.Name, Namespace: service.Namespace},
+	})
+}
+
 // store has to be Lock()ed
-func (c *Controller) getImportNameForService(exportedService *model.Service) *federationmodel.ServiceKey {
+func (c *Controller) getImportNameForService(service *federationmodel.ServiceMessage) *federationmodel.ServiceKey {
 	// XXX: integrate ServiceImports CRD functionality here
 	// for now, hardcoding values for all services
-	return c.importNameMapper.NameForService(exportedService)
+	return ImportNameForService(c.importNameMapper, service)
 }
 
 func (c *Controller) updateGateways(serviceList *federationmodel.ServiceListMessage) {
@@ -498,7 +619,12 @@ func (c *Controller) convertServices(serviceList *federationmodel.ServiceListMes
 	oldImports := c.imports
 	c.imports = map[federationmodel.ServiceKey]*existingImport{}
 	allUpdatedConfigs := map[model.ConfigKey]struct{}{}
-	for _, s := range serviceList.Services {
+	services := serviceList.Services
+	if c.peerState == peerWithdrawn {
+		// the services are restored from lastMessage when the peer recovers
+		services = nil
+	}
+	for _, s := range services {
 		var updatedConfigs map[model.ConfigKey]struct{}
 		var err error
 		if existing, update := oldImports[s.ServiceKey]; update {
@@ -530,6 +656,7 @@ func (c *Controller) convertServices(serviceList *federationmodel.ServiceListMes
 		c.xdsUpdater.ConfigUpdate(&model.PushRequest{
 			Full:           true,
 			ConfigsUpdated: allUpdatedConfigs,
+			Reason:         []model.TriggerReason{model.ServiceUpdate},
 		})
 	}
 }
@@ -621,6 +748,11 @@ func (c *Controller) GetProxyWorkloadLabels(proxy *model.Proxy) labels.Collectio
 
 // Run starts all the controllers
 func (c *Controller) Run(stop <-chan struct{}) {
+	go c.runHealthChecks(stop)
+	if c.discoveryTransport == common.DiscoveryTransportGRPC {
+		c.runXDS(stop)
+		return
+	}
 	eventCh := make(chan *federationmodel.WatchEvent)
 	refreshTicker := time.NewTicker(c.resyncPeriod)
 	defer refreshTicker.Stop()
@@ -631,6 +763,7 @@ func (c *Controller) Run(stop <-chan struct{}) {
 			err := c.watch(eventCh, stop)
 			if err != nil {
 				c.logger.Errorf("watch failed: %s", err)
+				c.recordDiscoveryResult(err)
 				time.Sleep(c.backoffPolicy.NextBackOff())
 			} else {
 				return
@@ -644,17 +777,24 @@ func (c *Controller) Run(stop <-chan struct{}) {
 			c.stop()
 			return
 		case e := <-eventCh:
-			c.logger.Debugf("watch event received: %s service %s", e.Action, e.Service.Name)
+			if e.Service == nil {
+				c.logger.Debugf("watch event received: %s (revision %d)", e.Action, e.Revision)
+			} else {
+				c.logger.Debugf("watch event received: %s service %s (revision %d)", e.Action, e.Service.Name, e.Revision)
+			}
 			c.handleEvent(e)
 		case <-refreshTicker.C:
 			c.logger.Debugf("performing full resync for cluster %s", c.clusterID)
-			_ = c.resync()
+			_, _, _ = c.resync()
 		}
 	}
 }
 
 func (c *Controller) stop() {
 	atomic.StoreInt32(&c.stopped, 1)
+	if c.merger != nil {
+		c.merger.removeCluster(c.clusterID, c)
+	}
 }
 
 func (c *Controller) hasStopped() bool {
@@ -662,12 +802,8 @@ func (c *Controller) hasStopped() bool {
 }
 
 func (c *Controller) handleEvent(e *federationmodel.WatchEvent) {
-	if e.Service == nil || c.lastMessage == nil {
-		checksum := c.resync()
-		if checksum != e.Checksum {
-			// this shouldn't happen
-			c.logger.Error("checksum mismatch after resync")
-		}
+	if e.Service == nil {
+		c.resyncForEvent(e)
 		return
 	}
 
@@ -680,23 +816,36 @@ func (c *Controller) handleEvent(e *federationmodel.WatchEvent) {
 			c.storeLock.Unlock()
 		}
 	}()
+	if c.lastMessage == nil {
+		unlockIt = false
+		c.storeLock.Unlock()
+		c.resyncForEvent(e)
+		return
+	}
+	if e.Epoch != "" && e.Epoch == c.lastEpoch && e.Revision <= c.lastRevision {
+		// we've already seen this, e.g. the event was sent while we were
+		// performing a full resync
+		c.logger.Debugf("skipping watch event for revision %d, already at revision %d", e.Revision, c.lastRevision)
+		return
+	}
+
 	// verify we're up to date
 	lastReceivedMessage := *c.lastMessage
+	lastReceivedMessage.Services = append([]*federationmodel.ServiceMessage(nil), c.lastMessage.Services...)
 	switch e.Action {
 	case federationmodel.ActionAdd:
-		lastReceivedMessage.Services = append(c.lastMessage.Services, e.Service)
+		lastReceivedMessage.Services = append(lastReceivedMessage.Services, e.Service)
 	case federationmodel.ActionUpdate:
 		for i, s := range lastReceivedMessage.Services {
-			if s.Name == e.Service.Name {
+			if s.ServiceKey == e.Service.ServiceKey {
 				lastReceivedMessage.Services[i] = e.Service
 				break
 			}
 		}
 	case federationmodel.ActionDelete:
 		for i, s := range lastReceivedMessage.Services {
-			if s.Name == e.Service.Name {
-				lastReceivedMessage.Services[i] = c.lastMessage.Services[len(c.lastMessage.Services)-1]
-				lastReceivedMessage.Services = c.lastMessage.Services[:len(c.lastMessage.Services)-1]
+			if s.ServiceKey == e.Service.ServiceKey {
+				lastReceivedMessage.Services = append(lastReceivedMessage.Services[:i], lastReceivedMessage.Services[i+1:]...)
 				break
 			}
 		}
@@ -714,7 +863,16 @@ func (c *Controller) handleEvent(e *federationmodel.WatchEvent) {
 		return
 	}
 
+	lastReceivedMessage.Epoch = e.Epoch
+	lastReceivedMessage.Revision = e.Revision
 	c.lastMessage = &lastReceivedMessage
+	c.lastEpoch = e.Epoch
+	c.lastRevision = e.Revision
+
+	if c.peerState == peerWithdrawn {
+		// the services are restored from lastMessage when the peer recovers
+		return
+	}
 
 	existing := c.imports[e.Service.ServiceKey]
 	var updatedConfigs map[model.ConfigKey]struct{}
@@ -757,10 +915,7 @@ func (c *Controller) updateXDS(hostname, namespace string, instances []*model.Se
 func (c *Controller) addService(service *federationmodel.ServiceMessage) (map[model.ConfigKey]struct{}, error) {
 	c.logger.Debugf("handling new exported service %+v", service.ServiceKey)
 
-	importedName := c.getImportNameForService(&model.Service{
-		Hostname:   host.Name(service.Hostname),
-		Attributes: model.ServiceAttributes{Name: service.Name, Namespace: service.Namespace},
-	})
+	importedName := c.getImportNameForService(service)
 	if importedName == nil {
 		c.statusHandler.ImportAdded(federationmodel.ServiceKey{}, service.Hostname)
 		c.logger.Debugf("skipping import of service %+v, as it does not match an import filter", service.ServiceKey)
@@ -772,8 +927,13 @@ func (c *Controller) addService(service *federationmodel.ServiceMessage) (map[mo
 	exportedService, exportedInstances := c.convertExportedService(service)
 	localService, localInstances := c.convertToLocalService(service, *importedName)
 
-	if err := c.createRoutingResources(service.ServiceKey, *importedName); err != nil {
-		return nil, err
+	if c.updateMergedImport(service, localService) {
+		if err := c.createRoutingResources(service.ServiceKey, *importedName, service.Consumers); err != nil {
+			return nil, err
+		}
+	} else {
+		c.logger.Debugf("service %+v is being provided by another peer", *importedName)
+		localInstances = nil
 	}
 
 	c.imports[service.ServiceKey] = &existingImport{ServiceMessage: service, localName: *importedName}
@@ -802,10 +962,7 @@ func (c *Controller) updateService(service *federationmodel.ServiceMessage, exis
 	c.logger.Debugf("handling update for exported service %+v", service.ServiceKey)
 
 	// XXX: exported service name is name.namespace, while it's namespace is c.namespace
-	importedName := c.getImportNameForService(&model.Service{
-		Hostname:   host.Name(service.Hostname),
-		Attributes: model.ServiceAttributes{Name: service.Name, Namespace: service.Namespace},
-	})
+	importedName := c.getImportNameForService(service)
 	if importedName == nil {
 		c.statusHandler.ImportUpdated(federationmodel.ServiceKey{}, service.Hostname)
 		if existing != nil {
@@ -819,28 +976,54 @@ func (c *Controller) updateService(service *federationmodel.ServiceMessage, exis
 	updatedConfigs := map[model.ConfigKey]struct{}{}
 	exportedService, exportedInstances := c.convertExportedService(service)
 	localService, localInstances := c.convertToLocalService(service, *importedName)
+	active := c.updateMergedImport(service, localService)
+
+	if !active {
+		// another peer is providing the service, make sure we're not routing
+		// to this peer through the egress gateway
+		c.logger.Debugf("service %+v is being provided by another peer", *importedName)
+		localInstances = nil
+		_ = c.deleteRoutingResources(service.ServiceKey)
+	}
 
 	if existing == nil {
 		// this may have been previously filtered out
 		c.logger.Debugf("importing service %+v as %+v", service.ServiceKey, *importedName)
-		if err := c.createRoutingResources(service.ServiceKey, *importedName); err != nil {
-			return nil, err
+		if active {
+			if err := c.createRoutingResources(service.ServiceKey, *importedName, service.Consumers); err != nil {
+				return nil, err
+			}
 		}
 		// TODO: optimize service and instance updates
 	} else if importedName.Hostname != existing.localName.Hostname {
 		c.logger.Debugf("service %+v has been reimported as %+v (was %+v)", service.ServiceKey, *importedName, existing.localName)
 		// update the routing
-		if err := c.createRoutingResources(service.ServiceKey, *importedName); err != nil {
-			return nil, err
+		if active {
+			if err := c.createRoutingResources(service.ServiceKey, *importedName, service.Consumers); err != nil {
+				return nil, err
+			}
 		}
 
 		// delete the old imported service
-		c.removeServiceFromStore(existing.localName)
+		if svc := c.removeServiceFromStore(existing.localName); svc != nil {
+			c.removeMergedImport(svc)
+		}
 		updatedConfigs[model.ConfigKey{
 			Kind:      gvk.ServiceEntry,
 			Name:      existing.localName.Hostname,
 			Namespace: existing.Namespace,
 		}] = struct{}{}
+	} else if active && c.mergeMode != "" {
+		// the routing may have been removed while another peer was providing
+		// the service
+		if err := c.createRoutingResources(service.ServiceKey, *importedName, service.Consumers); err != nil {
+			return nil, err
+		}
+	} else if active {
+		// the consumers allowed to call the service may have changed
+		if err := c.updateConsumerAuthorizationPolicy(service.ServiceKey, *importedName, service.Consumers); err != nil {
+			return nil, err
+		}
 	}
 
 	// TODO: be smart and see if anything changed, so we don't push unnecessarily
@@ -888,7 +1071,9 @@ func (c *Controller) deleteService(service *federationmodel.ServiceMessage, exis
 	}
 
 	if existing != nil {
-		c.removeServiceFromStore(existing.localName)
+		if localSvc := c.removeServiceFromStore(existing.localName); localSvc != nil {
+			c.removeMergedImport(localSvc)
+		}
 		if svc != nil {
 			updatedConfigs[model.ConfigKey{
 				Kind:      gvk.ServiceEntry,
@@ -901,6 +1086,80 @@ func (c *Controller) deleteService(service *federationmodel.ServiceMessage, exis
 	return updatedConfigs
 }
 
+// updateMergedImport registers the imported service with the merger, if the
+// service should be merged with services imported from other peers, returning
+// false if the service is being provided by another peer.
+// store has to be Lock()ed
+func (c *Controller) updateMergedImport(service *federationmodel.ServiceMessage, localService *model.Service) bool {
+	if c.merger == nil {
+		return true
+	}
+	if c.mergeMode == "" {
+		// merging may have been disabled
+		c.removeMergedImport(localService)
+		return true
+	}
+	_, healthy := endpointWeight(service)
+	return c.merger.update(string(localService.Hostname), localService.Attributes.Namespace, &mergeMember{
+		cluster:  c.clusterID,
+		mode:     c.mergeMode,
+		priority: c.importPriority,
+		egress:   fmt.Sprintf("%s/%s", c.namespace, c.egressName),
+		healthy:  healthy,
+	})
+}
+
+// store has to be Lock()ed
+func (c *Controller) removeMergedImport(localService *model.Service) {
+	if c.merger != nil {
+		c.merger.remove(string(localService.Hostname), localService.Attributes.Namespace, c.clusterID)
+	}
+}
+
+// mergedImportChanged is called by the merger when this peer starts or stops
+// providing a merged service.  The import of the merged service is updated, so
+// its endpoints are added to or removed from the service.
+func (c *Controller) mergedImportChanged(hostname, namespace string) {
+	if c.hasStopped() {
+		return
+	}
+	c.storeLock.Lock()
+	defer c.storeLock.Unlock()
+	allUpdatedConfigs := map[model.ConfigKey]struct{}{}
+	for _, existing := range c.imports {
+		if existing.localName.Hostname != hostname || c.localServiceNamespace(existing.localName) != namespace {
+			continue
+		}
+		if c.locality == nil && c.mergeMode == common.MergeImportsLocality {
+			c.logger.Warnf("no locality configured for merged service %s in namespace %s, endpoints will not be ordered by locality", hostname, namespace)
+		}
+		updatedConfigs, err := c.updateService(existing.ServiceMessage, existing)
+		if err != nil {
+			c.logger.Errorf("error updating configuration for merged service %s in namespace %s: %s", hostname, namespace, err)
+			continue
+		}
+		for key, value := range updatedConfigs {
+			allUpdatedConfigs[key] = value
+		}
+	}
+	if len(allUpdatedConfigs) > 0 {
+		c.logger.Debugf("pushing XDS config for merged services: %+v", allUpdatedConfigs)
+		c.xdsUpdater.ConfigUpdate(&model.PushRequest{
+			Full:           true,
+			ConfigsUpdated: allUpdatedConfigs,
+			Reason:         []model.TriggerReason{model.ServiceUpdate},
+		})
+	}
+}
+
+// setPeerAvailable notifies the merger when the peer becomes reachable or
+// unreachable, so merged services can fail over to other peers.
+func (c *Controller) setPeerAvailable(available bool) {
+	if c.merger != nil {
+		c.merger.setAvailable(c.clusterID, available)
+	}
+}
+
 func (c *Controller) addServiceToStore(service *model.Service, instances []*model.ServiceInstance) {
 	c.serviceStore[service.Hostname] = service
 	c.instanceStore[service.Hostname] = instances
@@ -937,15 +1196,41 @@ func (c *Controller) removeServiceFromStore(service federationmodel.ServiceKey)
 	return svc
 }
 
+// resyncForEvent performs a full resync in response to a watch event that
+// could not be applied incrementally.
+func (c *Controller) resyncForEvent(e *federationmodel.WatchEvent) {
+	checksum, epoch, revision := c.resync()
+	if epoch == e.Epoch && revision == e.Revision && checksum != e.Checksum {
+		// this shouldn't happen
+		c.logger.Error("checksum mismatch after resync")
+	}
+}
+
+// watchURL returns the URL used to watch the remote mesh.  If events have
+// already been processed, the URL requests that the watch be resumed from
+// the last revision processed.
+func (c *Controller) watchURL() string {
+	c.storeLock.RLock()
+	defer c.storeLock.RUnlock()
+	watchURL := c.discoveryURL + "/watch"
+	if c.lastEpoch == "" {
+		return watchURL
+	}
+	query := url.Values{}
+	query.Set(federationmodel.WatchParameterEpoch, c.lastEpoch)
+	query.Set(federationmodel.WatchParameterSince, strconv.FormatUint(c.lastRevision, 10))
+	return watchURL + "?" + query.Encode()
+}
+
 func (c *Controller) watch(eventCh chan *federationmodel.WatchEvent, stopCh <-chan struct{}) error {
 	c.statusHandler.WatchInitiated()
-	url := c.discoveryURL + "/watch"
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	watchURL := c.watchURL()
+	req, err := http.NewRequest(http.MethodGet, watchURL, nil)
 	if err != nil {
-		c.logger.Errorf("Failed to create request: '%s': %s", url, err)
+		c.logger.Errorf("Failed to create request: '%s': %s", watchURL, err)
 		return nil
 	}
-	req.Header.Add("discovery-service", c.discoveryServiceName)
+	req.Header.Add(common.DiscoveryServiceHeader, c.discoveryServiceName)
 	resp, err := http.DefaultClient.Do(req)
 	defer func() {
 		status := ""
@@ -964,6 +1249,8 @@ func (c *Controller) watch(eventCh chan *federationmodel.WatchEvent, stopCh <-ch
 	}
 
 	c.statusHandler.Watching()
+	c.recordDiscoveryResult(nil)
+	c.checkPeerHealth()
 
 	// connection was established successfully. reset backoffPolicy
 	c.backoffPolicy.Reset()
@@ -985,18 +1272,38 @@ func (c *Controller) watch(eventCh chan *federationmodel.WatchEvent, stopCh <-ch
 	}
 }
 
-func (c *Controller) resync() uint64 {
+// resync performs a full resync with the remote mesh, returning the checksum,
+// epoch and revision of the service list received.
+func (c *Controller) resync() (uint64, string, uint64) {
 	c.storeLock.Lock()
 	defer c.storeLock.Unlock()
 	c.logger.Debugf("performing full resync")
-	svcList := c.pollServices()
+	var svcList *federationmodel.ServiceListMessage
+	if c.discoveryTransport == common.DiscoveryTransportGRPC {
+		// the full list is streamed to us, so we just need to process the
+		// last list received, e.g. when the import configuration changes
+		svcList = c.lastMessage
+	} else {
+		var err error
+		if svcList, err = c.pollServices(); err != nil {
+			c.logger.Errorf("error retrieving services from %s: %s", c.clusterID, err)
+		}
+		c.recordDiscoveryResult(err)
+	}
+	peerStateChanged := c.updatePeerState()
 	c.updateGateways(svcList)
 	if svcList != nil {
 		c.convertServices(svcList)
+		c.lastMessage = svcList
+		c.lastEpoch = svcList.Epoch
+		c.lastRevision = svcList.Revision
 		c.statusHandler.FullSyncComplete()
-		return svcList.Checksum
+		return svcList.Checksum, svcList.Epoch, svcList.Revision
+	}
+	if peerStateChanged && c.lastMessage != nil {
+		c.convertServices(c.lastMessage)
 	}
-	return 0
+	return 0, "", 0
 }
 
 // HasSynced always returns true so not to stall istiod on a broken federation connection
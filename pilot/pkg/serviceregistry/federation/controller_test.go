@@ -0,0 +1,100 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federation
+
+import (
+	"testing"
+
+	v1 "maistra.io/api/federation/v1"
+
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/servicemesh/federation/common"
+	federationmodel "istio.io/istio/pkg/servicemesh/federation/model"
+)
+
+func TestCreateServiceEndpoints(t *testing.T) {
+	gateways := []*model.Gateway{{Addr: "10.0.0.1", Port: 15443}, {Addr: "10.0.0.2", Port: 15443}}
+	ports := []*federationmodel.ServicePort{{Name: "http", Port: 80, Protocol: "HTTP"}}
+	cases := []struct {
+		name             string
+		endpoints        []*federationmodel.EndpointSummary
+		locality         *v1.ImportedServiceLocality
+		peerState        peerState
+		expectedWeight   uint32
+		expectedLocality string
+		expectedCount    int
+	}{
+		{
+			name:          "no summaries",
+			expectedCount: 2,
+		},
+		{
+			name: "summaries of several localities",
+			endpoints: []*federationmodel.EndpointSummary{
+				{Locality: "region1/zone1/", Healthy: 2, Weight: 3},
+				{Locality: "region1/zone2/", Healthy: 1},
+				{Locality: "region2/zone1/", Healthy: 0},
+			},
+			locality:         &v1.ImportedServiceLocality{Region: "local", Zone: "zone"},
+			expectedWeight:   4,
+			expectedLocality: "local/zone/",
+			expectedCount:    2,
+		},
+		{
+			name:      "no healthy endpoints",
+			endpoints: []*federationmodel.EndpointSummary{{Locality: "region1/zone1/", Healthy: 0}},
+		},
+		{
+			name:      "unhealthy peer",
+			peerState: peerUnhealthy,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &Controller{
+				clusterID: "remote",
+				logger:    common.Logger,
+				peerState: tc.peerState,
+			}
+			_, instances := c.createService(createServiceOptions{
+				service: &federationmodel.ServiceMessage{
+					ServiceKey:   federationmodel.ServiceKey{Name: "svc", Namespace: "ns", Hostname: "svc.ns.svc.remote-imports.local"},
+					ServicePorts: ports,
+					Endpoints:    tc.endpoints,
+				},
+				serviceName:      "svc",
+				serviceNamespace: "ns",
+				hostname:         "svc.ns.svc.remote-imports.local",
+				clusterID:        "remote",
+				networkGateways:  gateways,
+				locality:         tc.locality,
+			})
+			if len(instances) != tc.expectedCount {
+				t.Fatalf("expected %d endpoints, got %d", tc.expectedCount, len(instances))
+			}
+			for index, instance := range instances {
+				if instance.Endpoint.Address != gateways[index].Addr {
+					t.Errorf("expected endpoint %d to be gateway %s, got %s", index, gateways[index].Addr, instance.Endpoint.Address)
+				}
+				if instance.Endpoint.LbWeight != tc.expectedWeight {
+					t.Errorf("expected weight %d, got %d", tc.expectedWeight, instance.Endpoint.LbWeight)
+				}
+				if instance.Endpoint.Locality.Label != tc.expectedLocality {
+					t.Errorf("expected locality %q, got %q", tc.expectedLocality, instance.Endpoint.Locality.Label)
+				}
+			}
+		})
+	}
+}
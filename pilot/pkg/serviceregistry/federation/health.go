@@ -0,0 +1,241 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federation
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "maistra.io/api/federation/v1"
+
+	"istio.io/istio/pkg/servicemesh/federation/common"
+)
+
+const gatewayProbeTimeout = 5 * time.Second
+
+// peerState describes how the services imported from a peer are treated.
+type peerState int
+
+const (
+	// peerHealthy imported services are routed to the peer.
+	peerHealthy peerState = iota
+	// peerUnhealthy imported services are kept, but have no endpoints.
+	peerUnhealthy
+	// peerWithdrawn imported services are removed from the registry.
+	peerWithdrawn
+)
+
+// peerHealth tracks the liveness of a peer.  The peer is considered down
+// from the first failure to reach either its discovery service or its
+// gateways, until it can be reached again.
+type peerHealth struct {
+	mu                 sync.Mutex
+	config             common.PeerHealthConfig
+	discoveryDownSince time.Time
+	discoveryError     string
+	gatewayDownSince   time.Time
+	gatewayError       string
+}
+
+func newPeerHealth(config common.PeerHealthConfig) *peerHealth {
+	return &peerHealth{config: config}
+}
+
+func (h *peerHealth) setConfig(config common.PeerHealthConfig) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.config = config
+	if config.GatewayProbeInterval == 0 {
+		// probing has been disabled, forget any failures
+		h.gatewayDownSince = time.Time{}
+		h.gatewayError = ""
+	}
+}
+
+func (h *peerHealth) gatewayProbeInterval() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.config.GatewayProbeInterval
+}
+
+// discoveryResult records the result of an exchange with the peer's
+// discovery service, e.g. a watch or poll.
+func (h *peerHealth) discoveryResult(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	recordResult(&h.discoveryDownSince, &h.discoveryError, err)
+}
+
+// gatewayResult records the result of probing the peer's gateways.
+func (h *peerHealth) gatewayResult(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	recordResult(&h.gatewayDownSince, &h.gatewayError, err)
+}
+
+func recordResult(downSince *time.Time, lastError *string, err error) {
+	if err == nil {
+		*downSince = time.Time{}
+		*lastError = ""
+		return
+	}
+	if downSince.IsZero() {
+		*downSince = time.Now()
+	}
+	*lastError = err.Error()
+}
+
+// available returns true if nothing has failed since the peer was last
+// reached.
+func (h *peerHealth) available() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.discoveryDownSince.IsZero() && h.gatewayDownSince.IsZero()
+}
+
+// evaluate returns the state of the peer at the specified time, along with
+// the time the peer became unavailable and the reason it's unavailable.
+func (h *peerHealth) evaluate(now time.Time) (peerState, time.Time, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	since := h.discoveryDownSince
+	if since.IsZero() || (!h.gatewayDownSince.IsZero() && h.gatewayDownSince.Before(since)) {
+		since = h.gatewayDownSince
+	}
+	if since.IsZero() {
+		return peerHealthy, since, ""
+	}
+	var reasons []string
+	if h.discoveryError != "" {
+		reasons = append(reasons, h.discoveryError)
+	}
+	if h.gatewayError != "" {
+		reasons = append(reasons, h.gatewayError)
+	}
+	reason := strings.Join(reasons, "; ")
+	down := now.Sub(since)
+	if h.config.WithdrawThreshold > 0 && down >= h.config.WithdrawThreshold {
+		return peerWithdrawn, since, reason
+	}
+	if h.config.UnhealthyThreshold > 0 && down >= h.config.UnhealthyThreshold {
+		return peerUnhealthy, since, reason
+	}
+	return peerHealthy, since, reason
+}
+
+// UpdatePeerHealthConfig updates the thresholds used to determine whether or
+// not the peer is available.
+func (c *Controller) UpdatePeerHealthConfig(config common.PeerHealthConfig) {
+	c.health.setConfig(config)
+	c.checkPeerHealth()
+}
+
+// recordDiscoveryResult records the result of an exchange with the peer's
+// discovery service.  Merged services fail over to other peers immediately,
+// while the thresholds only apply to services imported from this peer.
+func (c *Controller) recordDiscoveryResult(err error) {
+	c.health.discoveryResult(err)
+	c.setPeerAvailable(c.health.available())
+}
+
+// runHealthChecks periodically evaluates the health of the peer until stop is
+// closed.  Gateways are probed on the first check following the expiration
+// of the probe interval.
+func (c *Controller) runHealthChecks(stop <-chan struct{}) {
+	ticker := time.NewTicker(c.healthCheckPeriod)
+	defer ticker.Stop()
+	var lastProbe time.Time
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if interval := c.health.gatewayProbeInterval(); interval > 0 && now.Sub(lastProbe) >= interval {
+				lastProbe = now
+				c.health.gatewayResult(c.probeGateways())
+				c.setPeerAvailable(c.health.available())
+			}
+			c.checkPeerHealth()
+		}
+	}
+}
+
+// probeGateways returns an error if none of the peer's gateways accept a
+// connection.
+func (c *Controller) probeGateways() error {
+	remotePort := c.remote.ServicePort
+	if remotePort == 0 {
+		remotePort = common.DefaultFederationPort
+	}
+	var lastErr error
+	for _, address := range c.remote.Addresses {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(address, strconv.Itoa(int(remotePort))), gatewayProbeTimeout)
+		if err == nil {
+			_ = conn.Close()
+			return nil
+		}
+		c.logger.Debugf("error probing gateway %s for cluster %s: %s", address, c.clusterID, err)
+		lastErr = err
+	}
+	if lastErr != nil {
+		return fmt.Errorf("gateway unreachable: %s", lastErr)
+	}
+	return nil
+}
+
+// checkPeerHealth updates the services imported from the peer if the state
+// of the peer has changed.
+func (c *Controller) checkPeerHealth() {
+	c.storeLock.Lock()
+	defer c.storeLock.Unlock()
+	if c.updatePeerState() && c.lastMessage != nil {
+		c.convertServices(c.lastMessage)
+	}
+}
+
+// updatePeerState updates the state of the peer, returning true if the state
+// has changed, in which case the imported services need to be converted again.
+// The health reported in the status is updated whenever the peer becomes
+// unavailable, even if the thresholds have not been exceeded yet.
+// store has to be Lock()ed
+func (c *Controller) updatePeerState() bool {
+	state, since, reason := c.health.evaluate(time.Now())
+	if state == c.peerState && since.Equal(c.peerDownSince) {
+		return false
+	}
+	stateChanged := state != c.peerState
+	c.peerState = state
+	c.peerDownSince = since
+	if since.IsZero() {
+		c.logger.Infof("peer %s is available", c.clusterID)
+		c.statusHandler.PeerAvailable()
+		return stateChanged
+	}
+	importedServices := v1.ImportedServicesAvailable
+	switch state {
+	case peerUnhealthy:
+		importedServices = v1.ImportedServicesUnhealthy
+	case peerWithdrawn:
+		importedServices = v1.ImportedServicesWithdrawn
+	}
+	c.logger.Warnf("peer %s unavailable since %s, imported services %s: %s",
+		c.clusterID, since.Format(time.RFC3339), strings.ToLower(string(importedServices)), reason)
+	c.statusHandler.PeerUnavailable(since, reason, importedServices)
+	return stateChanged
+}
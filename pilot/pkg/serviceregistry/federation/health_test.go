@@ -0,0 +1,79 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federation
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/istio/pkg/servicemesh/federation/common"
+)
+
+func expectPeerState(t *testing.T, health *peerHealth, now time.Time, expected peerState) string {
+	t.Helper()
+	state, _, reason := health.evaluate(now)
+	if state != expected {
+		t.Errorf("expected peer state %d, got %d", expected, state)
+	}
+	return reason
+}
+
+func TestPeerHealth(t *testing.T) {
+	health := newPeerHealth(common.PeerHealthConfig{
+		UnhealthyThreshold:   time.Minute,
+		WithdrawThreshold:    time.Hour,
+		GatewayProbeInterval: time.Second,
+	})
+	start := time.Now()
+	expectPeerState(t, health, start, peerHealthy)
+
+	health.discoveryResult(fmt.Errorf("watch failed"))
+	if health.available() {
+		t.Errorf("expected peer to be unavailable after watch failure")
+	}
+	expectPeerState(t, health, start.Add(30*time.Second), peerHealthy)
+	if reason := expectPeerState(t, health, start.Add(2*time.Minute), peerUnhealthy); reason != "watch failed" {
+		t.Errorf("unexpected reason: %s", reason)
+	}
+
+	// subsequent failures don't reset the time the peer went down
+	health.discoveryResult(fmt.Errorf("poll failed"))
+	health.gatewayResult(fmt.Errorf("gateway unreachable"))
+	reason := expectPeerState(t, health, start.Add(2*time.Hour), peerWithdrawn)
+	if !strings.Contains(reason, "poll failed") || !strings.Contains(reason, "gateway unreachable") {
+		t.Errorf("expected reason to include discovery and gateway errors: %s", reason)
+	}
+
+	// discovery recovers, but the gateway is still unreachable
+	health.discoveryResult(nil)
+	if health.available() {
+		t.Errorf("expected peer to be unavailable while gateway is unreachable")
+	}
+	expectPeerState(t, health, start.Add(2*time.Hour), peerWithdrawn)
+
+	// disabling probes forgets gateway failures
+	health.setConfig(common.PeerHealthConfig{UnhealthyThreshold: time.Minute})
+	if !health.available() {
+		t.Errorf("expected peer to be available")
+	}
+	expectPeerState(t, health, start.Add(2*time.Hour), peerHealthy)
+
+	// disabled thresholds never change the state
+	health.setConfig(common.PeerHealthConfig{})
+	health.discoveryResult(fmt.Errorf("watch failed"))
+	expectPeerState(t, health, start.Add(24*time.Hour), peerHealthy)
+}
@@ -0,0 +1,212 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federation
+
+import (
+	"sort"
+	"sync"
+
+	"istio.io/istio/pkg/servicemesh/federation/common"
+)
+
+// mergeKey identifies a service imported from multiple peers.
+type mergeKey struct {
+	hostname  string
+	namespace string
+}
+
+// mergeMember is a peer contributing to a merged service.
+type mergeMember struct {
+	cluster  string
+	mode     string
+	priority int
+	// egress is the namespace/name of the egress gateway used to reach the
+	// peer.  Only one peer may be routed through an egress gateway for any
+	// given service.
+	egress string
+	// healthy is false if the peer reported no healthy endpoints for the
+	// service.
+	healthy bool
+}
+
+// mergeListener is notified when a peer starts or stops providing a merged
+// service.
+type mergeListener interface {
+	mergedImportChanged(hostname, namespace string)
+}
+
+// ImportMerger coordinates services imported from multiple peers under the
+// same hostname.  Each peer registers the services it would like to merge and
+// the merger decides which peers are active, i.e. which peers should provide
+// endpoints and egress routing for the service.  Inactive peers withhold their
+// endpoints, so traffic automatically shifts to another peer when the active
+// peer becomes unavailable.
+type ImportMerger struct {
+	mu          sync.Mutex
+	groups      map[mergeKey]map[string]*mergeMember
+	active      map[mergeKey]map[string]bool
+	unavailable map[string]bool
+	listeners   map[string]mergeListener
+}
+
+// NewImportMerger returns a new ImportMerger.
+func NewImportMerger() *ImportMerger {
+	return &ImportMerger{
+		groups:      map[mergeKey]map[string]*mergeMember{},
+		active:      map[mergeKey]map[string]bool{},
+		unavailable: map[string]bool{},
+		listeners:   map[string]mergeListener{},
+	}
+}
+
+// register adds a listener that is notified when the active state of a
+// service imported from the cluster changes.
+func (m *ImportMerger) register(cluster string, listener mergeListener) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners[cluster] = listener
+}
+
+// removeCluster removes the cluster from all merged services.  Nothing is
+// removed if the listener has been replaced, e.g. if the registry for the peer
+// was recreated.
+func (m *ImportMerger) removeCluster(cluster string, listener mergeListener) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.listeners[cluster] != listener {
+		return
+	}
+	delete(m.listeners, cluster)
+	delete(m.unavailable, cluster)
+	for key, members := range m.groups {
+		if _, found := members[cluster]; found {
+			delete(members, cluster)
+			m.recompute(key, "")
+		}
+	}
+}
+
+// setAvailable records whether or not the peer associated with the cluster is
+// reachable.
+func (m *ImportMerger) setAvailable(cluster string, available bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.unavailable[cluster] == !available {
+		return
+	}
+	if available {
+		delete(m.unavailable, cluster)
+	} else {
+		m.unavailable[cluster] = true
+	}
+	for key, members := range m.groups {
+		if _, found := members[cluster]; found {
+			m.recompute(key, "")
+		}
+	}
+}
+
+// update adds or updates the member for the service, returning true if the
+// member is active.
+func (m *ImportMerger) update(hostname, namespace string, member *mergeMember) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := mergeKey{hostname: hostname, namespace: namespace}
+	members := m.groups[key]
+	if members == nil {
+		members = map[string]*mergeMember{}
+		m.groups[key] = members
+	}
+	members[member.cluster] = member
+	m.recompute(key, member.cluster)
+	return m.active[key][member.cluster]
+}
+
+// remove removes the cluster from the merged service.
+func (m *ImportMerger) remove(hostname, namespace, cluster string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := mergeKey{hostname: hostname, namespace: namespace}
+	if _, found := m.groups[key][cluster]; !found {
+		return
+	}
+	delete(m.groups[key], cluster)
+	m.recompute(key, cluster)
+}
+
+// recompute updates the active members of the service, notifying any members
+// whose state has changed, other than the member whose update triggered the
+// recomputation.  m must be locked.
+func (m *ImportMerger) recompute(key mergeKey, updated string) {
+	members := m.groups[key]
+	if len(members) == 0 {
+		delete(m.groups, key)
+		delete(m.active, key)
+		return
+	}
+	active := m.computeActive(members, true)
+	if len(active) == 0 {
+		// none of the peers are available.  keep routing to the preferred
+		// peers, as we may simply be unable to reach their discovery service
+		active = m.computeActive(members, false)
+	}
+	previous := m.active[key]
+	m.active[key] = active
+	for cluster := range members {
+		if cluster == updated || previous[cluster] == active[cluster] {
+			continue
+		}
+		if listener := m.listeners[cluster]; listener != nil {
+			// listeners lock their registry, which may currently be calling
+			// into the merger
+			go listener.mergedImportChanged(key.hostname, key.namespace)
+		}
+	}
+}
+
+func (m *ImportMerger) computeActive(members map[string]*mergeMember, availableOnly bool) map[string]bool {
+	ordered := make([]*mergeMember, 0, len(members))
+	localityMode := true
+	for _, member := range members {
+		// all peers must agree to use locality ordering
+		localityMode = localityMode && member.mode == common.MergeImportsLocality
+		if availableOnly && (!member.healthy || m.unavailable[member.cluster]) {
+			continue
+		}
+		ordered = append(ordered, member)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].priority != ordered[j].priority {
+			return ordered[i].priority < ordered[j].priority
+		}
+		return ordered[i].cluster < ordered[j].cluster
+	})
+	active := map[string]bool{}
+	usedEgress := map[string]bool{}
+	for _, member := range ordered {
+		if !localityMode {
+			// only the preferred peer is used
+			active[member.cluster] = true
+			break
+		}
+		// endpoints are ordered by locality, but each egress gateway can only
+		// route to a single peer
+		if !usedEgress[member.egress] {
+			usedEgress[member.egress] = true
+			active[member.cluster] = true
+		}
+	}
+	return active
+}
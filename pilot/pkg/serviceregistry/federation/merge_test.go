@@ -0,0 +1,175 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federation
+
+import (
+	"testing"
+	"time"
+
+	"istio.io/istio/pkg/servicemesh/federation/common"
+)
+
+const (
+	mergedHostname  = "reviews.bookinfo.svc.cluster.local"
+	mergedNamespace = "bookinfo"
+)
+
+type fakeMergeListener struct {
+	notifications chan string
+}
+
+func (l *fakeMergeListener) mergedImportChanged(hostname, namespace string) {
+	l.notifications <- hostname
+}
+
+func newFakeMergeListener() *fakeMergeListener {
+	return &fakeMergeListener{notifications: make(chan string, 10)}
+}
+
+func (l *fakeMergeListener) expectNotification(t *testing.T) {
+	t.Helper()
+	select {
+	case hostname := <-l.notifications:
+		if hostname != mergedHostname {
+			t.Errorf("unexpected notification for %s", hostname)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for notification")
+	}
+}
+
+func (l *fakeMergeListener) expectNoNotification(t *testing.T) {
+	t.Helper()
+	select {
+	case hostname := <-l.notifications:
+		t.Errorf("unexpected notification for %s", hostname)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func expectActive(t *testing.T, merger *ImportMerger, expected map[string]bool) {
+	t.Helper()
+	merger.mu.Lock()
+	defer merger.mu.Unlock()
+	active := merger.active[mergeKey{hostname: mergedHostname, namespace: mergedNamespace}]
+	for cluster, expectedActive := range expected {
+		if active[cluster] != expectedActive {
+			t.Errorf("expected active=%t for %s, got %t", expectedActive, cluster, active[cluster])
+		}
+	}
+}
+
+func TestImportMergerPriority(t *testing.T) {
+	merger := NewImportMerger()
+	listenerA := newFakeMergeListener()
+	listenerB := newFakeMergeListener()
+	merger.register("mesh-a", listenerA)
+	merger.register("mesh-b", listenerB)
+
+	memberA := &mergeMember{cluster: "mesh-a", mode: common.MergeImportsPriority, priority: 1, egress: "ns/egress", healthy: true}
+	memberB := &mergeMember{cluster: "mesh-b", mode: common.MergeImportsPriority, priority: 0, egress: "ns/egress-b", healthy: true}
+
+	if !merger.update(mergedHostname, mergedNamespace, memberA) {
+		t.Errorf("expected only member to be active")
+	}
+	if !merger.update(mergedHostname, mergedNamespace, memberB) {
+		t.Errorf("expected preferred member to be active")
+	}
+	// a is no longer active
+	listenerA.expectNotification(t)
+	listenerB.expectNoNotification(t)
+	expectActive(t, merger, map[string]bool{"mesh-a": false, "mesh-b": true})
+
+	// peer b becomes unreachable, fail over to a
+	merger.setAvailable("mesh-b", false)
+	listenerA.expectNotification(t)
+	listenerB.expectNotification(t)
+	expectActive(t, merger, map[string]bool{"mesh-a": true, "mesh-b": false})
+
+	// peer a reports no healthy endpoints, but it's the only available peer
+	// so keep routing to the preferred peer
+	memberA.healthy = false
+	if merger.update(mergedHostname, mergedNamespace, memberA) {
+		t.Errorf("expected unavailable member to be inactive")
+	}
+	listenerB.expectNotification(t)
+	expectActive(t, merger, map[string]bool{"mesh-a": false, "mesh-b": true})
+
+	// peer b is reachable again
+	merger.setAvailable("mesh-b", true)
+	listenerA.expectNoNotification(t)
+	listenerB.expectNoNotification(t)
+
+	// removing b leaves a as the only member
+	merger.remove(mergedHostname, mergedNamespace, "mesh-b")
+	listenerA.expectNotification(t)
+	expectActive(t, merger, map[string]bool{"mesh-a": true})
+}
+
+func TestImportMergerLocality(t *testing.T) {
+	merger := NewImportMerger()
+	listenerA := newFakeMergeListener()
+	listenerB := newFakeMergeListener()
+	listenerC := newFakeMergeListener()
+	merger.register("mesh-a", listenerA)
+	merger.register("mesh-b", listenerB)
+	merger.register("mesh-c", listenerC)
+
+	memberA := &mergeMember{cluster: "mesh-a", mode: common.MergeImportsLocality, egress: "ns/egress-a", healthy: true}
+	memberB := &mergeMember{cluster: "mesh-b", mode: common.MergeImportsLocality, egress: "ns/egress-b", healthy: true}
+	memberC := &mergeMember{cluster: "mesh-c", mode: common.MergeImportsLocality, priority: 1, egress: "ns/egress-b", healthy: true}
+
+	merger.update(mergedHostname, mergedNamespace, memberA)
+	merger.update(mergedHostname, mergedNamespace, memberB)
+	if merger.update(mergedHostname, mergedNamespace, memberC) {
+		t.Errorf("expected member sharing an egress gateway with a preferred member to be inactive")
+	}
+	expectActive(t, merger, map[string]bool{"mesh-a": true, "mesh-b": true, "mesh-c": false})
+
+	// c takes over the shared egress gateway
+	merger.setAvailable("mesh-b", false)
+	listenerB.expectNotification(t)
+	listenerC.expectNotification(t)
+	listenerA.expectNoNotification(t)
+	expectActive(t, merger, map[string]bool{"mesh-a": true, "mesh-b": false, "mesh-c": true})
+
+	// mixing modes falls back to priority
+	memberC.mode = common.MergeImportsPriority
+	if merger.update(mergedHostname, mergedNamespace, memberC) {
+		t.Errorf("expected lower priority member to be inactive")
+	}
+	listenerA.expectNoNotification(t)
+	expectActive(t, merger, map[string]bool{"mesh-a": true, "mesh-b": false, "mesh-c": false})
+}
+
+func TestImportMergerRemoveCluster(t *testing.T) {
+	merger := NewImportMerger()
+	oldListener := newFakeMergeListener()
+	newListener := newFakeMergeListener()
+	merger.register("mesh-a", oldListener)
+	merger.update(mergedHostname, mergedNamespace, &mergeMember{cluster: "mesh-a", mode: common.MergeImportsPriority, healthy: true})
+
+	// registry was recreated
+	merger.register("mesh-a", newListener)
+	merger.removeCluster("mesh-a", oldListener)
+	expectActive(t, merger, map[string]bool{"mesh-a": true})
+
+	merger.removeCluster("mesh-a", newListener)
+	merger.mu.Lock()
+	defer merger.mu.Unlock()
+	if len(merger.groups) != 0 {
+		t.Errorf("expected all merged services to be removed")
+	}
+}
@@ -19,9 +19,11 @@ import (
 	"strings"
 	"time"
 
+	gogoproto "github.com/gogo/protobuf/proto"
 	"github.com/pkg/errors"
 
 	rawnetworking "istio.io/api/networking/v1alpha3"
+	rawsecurity "istio.io/api/security/v1beta1"
 	"istio.io/istio/pkg/config"
 	"istio.io/istio/pkg/config/schema/gvk"
 	"istio.io/istio/pkg/servicemesh/federation/common"
@@ -42,11 +44,15 @@ func (c *Controller) deleteRoutingResources(remote federationmodel.ServiceKey) e
 	resourceName := createResourceName(c.clusterID, remote)
 	// Delete() is always successful
 	_ = c.configStore.Delete(gvk.Gateway, resourceName, c.namespace, nil)
+	_ = c.configStore.Delete(gvk.AuthorizationPolicy, resourceName, c.namespace, nil)
 	return c.configStore.Delete(gvk.VirtualService, resourceName, c.namespace, nil)
 }
 
-func (c *Controller) createRoutingResources(remote, local federationmodel.ServiceKey) error {
+func (c *Controller) createRoutingResources(remote, local federationmodel.ServiceKey, consumers []string) error {
 	resourceName := createResourceName(c.clusterID, remote)
+	if err := c.updateConsumerAuthorizationPolicy(remote, local, consumers); err != nil {
+		return errors.Wrapf(err, "error updating AuthorizationPolicy resource")
+	}
 	gateway := c.gatewayForImport(remote, local)
 	if rawGateway := c.configStore.Get(gvk.Gateway, gateway.Name, c.namespace); rawGateway == nil {
 		if gateway != nil {
@@ -87,6 +93,37 @@ func (c *Controller) createRoutingResources(remote, local federationmodel.Servic
 	return nil
 }
 
+// updateConsumerAuthorizationPolicy restricts the workloads allowed to call
+// the imported service through the egress gateway to the consumers advertised
+// by the exporting mesh, which also enforces them on its ingress gateway.
+// Calls are not restricted if there are no consumers.
+func (c *Controller) updateConsumerAuthorizationPolicy(remote, local federationmodel.ServiceKey, consumers []string) error {
+	resourceName := createResourceName(c.clusterID, remote)
+	rawAP := c.configStore.Get(gvk.AuthorizationPolicy, resourceName, c.namespace)
+	if len(consumers) == 0 || c.useDirectCalls {
+		if len(consumers) > 0 {
+			c.logger.Warnf("consumers allowed to call %s cannot be restricted when calling services directly", local.Hostname)
+		}
+		if rawAP != nil {
+			// Delete() is always successful
+			_ = c.configStore.Delete(gvk.AuthorizationPolicy, resourceName, c.namespace, nil)
+		}
+		return nil
+	}
+	ap := common.ConsumerAuthorizationPolicy(resourceName, c.namespace, c.egressName, local.Hostname, consumers)
+	if rawAP == nil {
+		_, err := c.configStore.Create(*ap)
+		return err
+	}
+	if gogoproto.Equal(rawAP.Spec.(*rawsecurity.AuthorizationPolicy), ap.Spec.(*rawsecurity.AuthorizationPolicy)) {
+		// no update required
+		return nil
+	}
+	rawAP.Spec = ap.Spec
+	_, err := c.configStore.Update(*rawAP)
+	return err
+}
+
 func (c *Controller) gatewayForImport(remote, local federationmodel.ServiceKey) *config.Config {
 	resourceName := createResourceName(c.clusterID, remote)
 	mode := rawnetworking.ServerTLSSettings_ISTIO_MUTUAL
@@ -0,0 +1,89 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federation
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	rawsecurity "istio.io/api/security/v1beta1"
+	configmemory "istio.io/istio/pilot/pkg/config/memory"
+	"istio.io/istio/pkg/config/schema/collections"
+	"istio.io/istio/pkg/config/schema/gvk"
+	"istio.io/istio/pkg/servicemesh/federation/common"
+	federationmodel "istio.io/istio/pkg/servicemesh/federation/model"
+)
+
+func TestConsumerAuthorizationPolicy(t *testing.T) {
+	c := &Controller{
+		clusterID:   "mesh-a",
+		namespace:   "istio-system",
+		egressName:  "mesh-a-egress",
+		logger:      common.Logger,
+		configStore: configmemory.NewController(configmemory.Make(collections.Pilot)),
+	}
+	ratings := federationmodel.ServiceKey{Name: "ratings", Namespace: "bookinfo", Hostname: "ratings.bookinfo.svc.mesh-a-exports.local"}
+	details := federationmodel.ServiceKey{Name: "details", Namespace: "bookinfo", Hostname: "details.bookinfo.svc.mesh-a-exports.local"}
+	localName := func(key federationmodel.ServiceKey) federationmodel.ServiceKey {
+		return federationmodel.ServiceKey{Name: key.Name, Namespace: key.Namespace, Hostname: key.Name + ".bookinfo.svc.mesh-a-imports.local"}
+	}
+	expectConsumers := func(t *testing.T, remote federationmodel.ServiceKey, consumers []string) {
+		t.Helper()
+		cfg := c.configStore.Get(gvk.AuthorizationPolicy, createResourceName(c.clusterID, remote), c.namespace)
+		if consumers == nil {
+			if cfg != nil {
+				t.Fatalf("expected no AuthorizationPolicy for %s, got %v", remote.Hostname, cfg.Spec)
+			}
+			return
+		}
+		if cfg == nil {
+			t.Fatalf("expected AuthorizationPolicy for %s", remote.Hostname)
+		}
+		ap := cfg.Spec.(*rawsecurity.AuthorizationPolicy)
+		if ap.Selector.MatchLabels["service.istio.io/canonical-name"] != c.egressName {
+			t.Errorf("expected AuthorizationPolicy for %s to select the egress gateway, got %v", remote.Hostname, ap.Selector)
+		}
+		if ap.Action != rawsecurity.AuthorizationPolicy_DENY || len(ap.Rules) != 1 || len(ap.Rules[0].From) != 1 || len(ap.Rules[0].When) != 1 {
+			t.Fatalf("unexpected AuthorizationPolicy for %s: %v", remote.Hostname, ap)
+		}
+		// calls are matched by the SNI of the local service
+		local := localName(remote).Hostname
+		if diff := cmp.Diff(ap.Rules[0].When[0].Values, []string{local, "*." + local}); diff != "" {
+			t.Errorf("unexpected SNI in AuthorizationPolicy for %s, -got +want:\n%s", remote.Hostname, diff)
+		}
+		if diff := cmp.Diff(ap.Rules[0].From[0].Source.NotPrincipals, consumers); diff != "" {
+			t.Errorf("unexpected principals in AuthorizationPolicy for %s, -got +want:\n%s", remote.Hostname, diff)
+		}
+	}
+
+	if err := c.createRoutingResources(ratings, localName(ratings), []string{"mesh-a.local/ns/bookinfo/sa/reviews"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.createRoutingResources(details, localName(details), []string{"mesh-a.local/ns/bookinfo/sa/productpage"}); err != nil {
+		t.Fatal(err)
+	}
+	expectConsumers(t, ratings, []string{"mesh-a.local/ns/bookinfo/sa/reviews"})
+	expectConsumers(t, details, []string{"mesh-a.local/ns/bookinfo/sa/productpage"})
+
+	if err := c.updateConsumerAuthorizationPolicy(ratings, localName(ratings), nil); err != nil {
+		t.Fatal(err)
+	}
+	expectConsumers(t, ratings, nil)
+	expectConsumers(t, details, []string{"mesh-a.local/ns/bookinfo/sa/productpage"})
+
+	_ = c.deleteRoutingResources(details)
+	expectConsumers(t, details, nil)
+}
@@ -0,0 +1,276 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federation
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	v3 "istio.io/istio/pilot/pkg/xds/v3"
+	"istio.io/istio/pkg/servicemesh/federation/common"
+	federationmodel "istio.io/istio/pkg/servicemesh/federation/model"
+	"istio.io/pkg/version"
+)
+
+// xdsDiscoveryTypes are the types subscribed to when discovering services
+// over xDS.  Network gateways are requested first, as they are required to
+// create the service instances for the imported services.
+var xdsDiscoveryTypes = []string{v3.FederationGatewayType, v3.FederationServiceType}
+
+// runXDS discovers services using an ADS stream, reconnecting until stop is
+// closed.
+func (c *Controller) runXDS(stop <-chan struct{}) {
+	// initialize gateways and process any services we may already know about
+	c.resync()
+	// the resources are kept across reconnections, so the remote only sends
+	// those that changed in the meantime
+	state := newXDSState()
+	for !c.hasStopped() {
+		c.logger.Info("starting xDS watch")
+		err := c.watchXDS(stop, state)
+		select {
+		case <-stop:
+			c.logger.Info("Federation Controller terminated")
+			c.stop()
+			return
+		default:
+		}
+		if err != nil {
+			c.logger.Errorf("xDS watch failed: %s", err)
+			c.recordDiscoveryResult(err)
+		}
+		select {
+		case <-stop:
+			c.logger.Info("Federation Controller terminated")
+			c.stop()
+			return
+		case <-time.After(c.backoffPolicy.NextBackOff()):
+		}
+	}
+}
+
+// xdsState accumulates the resources received over the ADS stream.  Delta
+// responses only contain the resources that changed and the names of those
+// that were removed, which are applied to the resources received before.
+type xdsState struct {
+	services map[string]*federationmodel.ServiceMessage
+	gateways map[string]*federationmodel.ServiceEndpoint
+	// versions holds the versions of the resources received, by type
+	versions map[string]map[string]string
+	// received holds the types for which a response has been received over
+	// the current stream
+	received map[string]bool
+}
+
+func newXDSState() *xdsState {
+	state := &xdsState{
+		services: map[string]*federationmodel.ServiceMessage{},
+		gateways: map[string]*federationmodel.ServiceEndpoint{},
+		versions: map[string]map[string]string{},
+	}
+	for _, typeURL := range xdsDiscoveryTypes {
+		state.versions[typeURL] = map[string]string{}
+	}
+	return state
+}
+
+func (s *xdsState) serviceList() *federationmodel.ServiceListMessage {
+	for _, typeURL := range xdsDiscoveryTypes {
+		if !s.received[typeURL] {
+			return nil
+		}
+	}
+	serviceList := &federationmodel.ServiceListMessage{}
+	for _, name := range sortedKeys(s.versions[v3.FederationGatewayType]) {
+		serviceList.NetworkGatewayEndpoints = append(serviceList.NetworkGatewayEndpoints, s.gateways[name])
+	}
+	for _, name := range sortedKeys(s.versions[v3.FederationServiceType]) {
+		serviceList.Services = append(serviceList.Services, s.services[name])
+	}
+	serviceList.Checksum = serviceList.GenerateChecksum()
+	return serviceList
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// update applies a response to the state.  The response is rejected as a
+// whole if any of its resources is invalid.
+func (s *xdsState) update(resp *discovery.DeltaDiscoveryResponse) error {
+	versions, ok := s.versions[resp.TypeUrl]
+	if !ok {
+		return fmt.Errorf("unexpected type: %s", resp.TypeUrl)
+	}
+	services := map[string]*federationmodel.ServiceMessage{}
+	gateways := map[string]*federationmodel.ServiceEndpoint{}
+	for _, r := range resp.Resources {
+		if r.Resource == nil {
+			return fmt.Errorf("resource %s has no body", r.Name)
+		}
+		var err error
+		switch resp.TypeUrl {
+		case v3.FederationServiceType:
+			services[r.Name], err = federationmodel.ServiceFromResource(r.Resource)
+		case v3.FederationGatewayType:
+			gateways[r.Name], err = federationmodel.NetworkGatewayFromResource(r.Resource)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for name, svc := range services {
+		s.services[name] = svc
+	}
+	for name, gateway := range gateways {
+		s.gateways[name] = gateway
+	}
+	for _, r := range resp.Resources {
+		versions[r.Name] = r.Version
+	}
+	for _, name := range resp.RemovedResources {
+		delete(versions, name)
+		switch resp.TypeUrl {
+		case v3.FederationServiceType:
+			delete(s.services, name)
+		case v3.FederationGatewayType:
+			delete(s.gateways, name)
+		}
+	}
+	s.received[resp.TypeUrl] = true
+	return nil
+}
+
+func (c *Controller) watchXDS(stop <-chan struct{}, state *xdsState) (err error) {
+	c.statusHandler.WatchInitiated()
+	defer func() {
+		status := ""
+		if err != nil {
+			status = err.Error()
+		}
+		c.statusHandler.WatchTerminated(status)
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	// route the request through the egress gateway to the remote mesh
+	ctx = metadata.AppendToOutgoingContext(ctx, common.DiscoveryServiceHeader, c.discoveryServiceName)
+
+	conn, err := grpc.DialContext(ctx, c.discoveryAddress, grpc.WithInsecure())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stream, err := discovery.NewAggregatedDiscoveryServiceClient(conn).DeltaAggregatedResources(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the node is replaced by the remote, which only uses our version
+	node := &core.Node{
+		Id: fmt.Sprintf("%s.%s", c.localClusterID, c.namespace),
+		Metadata: &structpb.Struct{
+			Fields: map[string]*structpb.Value{
+				"ISTIO_VERSION": {Kind: &structpb.Value_StringValue{StringValue: version.Info.Version}},
+			},
+		},
+	}
+	state.received = map[string]bool{}
+	for _, typeURL := range xdsDiscoveryTypes {
+		if err = stream.Send(&discovery.DeltaDiscoveryRequest{
+			Node:                    node,
+			TypeUrl:                 typeURL,
+			InitialResourceVersions: state.versions[typeURL],
+		}); err != nil {
+			return err
+		}
+	}
+
+	c.statusHandler.Watching()
+
+	// connection was established successfully. reset backoffPolicy
+	c.backoffPolicy.Reset()
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() != nil {
+				// we're shutting down
+				return nil
+			}
+			return err
+		}
+		c.statusHandler.WatchEventReceived()
+		c.logger.Debugf("received %s response, version %s, with %d resources and %d removed",
+			v3.GetShortType(resp.TypeUrl), resp.SystemVersionInfo, len(resp.Resources), len(resp.RemovedResources))
+		ack := &discovery.DeltaDiscoveryRequest{
+			TypeUrl:       resp.TypeUrl,
+			ResponseNonce: resp.Nonce,
+		}
+		if err := state.update(resp); err != nil {
+			c.logger.Errorf("rejecting %s response, version %s: %s", v3.GetShortType(resp.TypeUrl), resp.SystemVersionInfo, err)
+			ack.ErrorDetail = &rpcstatus.Status{
+				Code:    int32(codes.InvalidArgument),
+				Message: err.Error(),
+			}
+		} else if serviceList := state.serviceList(); serviceList != nil {
+			c.syncServiceList(serviceList)
+		}
+		if err := stream.Send(ack); err != nil {
+			return err
+		}
+	}
+}
+
+// syncServiceList updates the registry using the full list of services
+// received over the ADS stream.
+func (c *Controller) syncServiceList(serviceList *federationmodel.ServiceListMessage) {
+	c.storeLock.Lock()
+	defer c.storeLock.Unlock()
+	c.recordDiscoveryResult(nil)
+	peerStateChanged := c.updatePeerState()
+	if !peerStateChanged && c.lastMessage != nil && c.lastMessage.Checksum == serviceList.Checksum {
+		// nothing has changed, e.g. the response was triggered by a push
+		// unrelated to our services
+		return
+	}
+	c.updateGateways(serviceList)
+	c.convertServices(serviceList)
+	c.lastMessage = serviceList
+	c.statusHandler.FullSyncComplete()
+}
@@ -0,0 +1,50 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"istio.io/api/meta/v1alpha1"
+	"istio.io/istio/pkg/config"
+)
+
+// SetCondition returns a copy of the status of the config in which the
+// condition of the same type is replaced with the given one, and whether that
+// changed its status, reason or message.  The transition time of the current
+// condition is kept if its status doesn't change.  Other controllers use this
+// to report their own conditions next to the distribution status.
+func SetCondition(current *config.Config, condition *v1alpha1.IstioCondition) (bool, *v1alpha1.IstioStatus) {
+	status, err := GetTypedStatus(current.Status)
+	if err != nil || status == nil {
+		status = &v1alpha1.IstioStatus{}
+	} else {
+		status = status.DeepCopy()
+	}
+
+	for i, c := range status.Conditions {
+		if c.Type != condition.Type {
+			continue
+		}
+		if c.Status == condition.Status && c.Reason == condition.Reason && c.Message == condition.Message {
+			return false, status
+		}
+		if c.Status == condition.Status {
+			condition.LastTransitionTime = c.LastTransitionTime
+		}
+		status.Conditions[i] = condition
+		return true, status
+	}
+	status.Conditions = append(status.Conditions, condition)
+	return true, status
+}
@@ -0,0 +1,64 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"testing"
+
+	"github.com/gogo/protobuf/types"
+
+	"istio.io/api/meta/v1alpha1"
+	"istio.io/istio/pkg/config"
+)
+
+func TestSetCondition(t *testing.T) {
+	transitioned := &types.Timestamp{Seconds: 1}
+	current := &config.Config{Status: &v1alpha1.IstioStatus{
+		Conditions: []*v1alpha1.IstioCondition{
+			{Type: "Reconciled", Status: "True", Message: "1/1 proxies up to date."},
+			{Type: "RoutesAdmitted", Status: "False", Reason: "Rejected", Message: "a.com: rejected", LastTransitionTime: transitioned},
+		},
+	}}
+
+	changed, status := SetCondition(current, &v1alpha1.IstioCondition{Type: "RoutesAdmitted", Status: "False", Reason: "Rejected", Message: "a.com: rejected"})
+	if changed {
+		t.Errorf("expected an identical condition to be left alone")
+	}
+	if len(status.Conditions) != 2 {
+		t.Fatalf("expected 2 conditions, got %v", status.Conditions)
+	}
+
+	changed, status = SetCondition(current, &v1alpha1.IstioCondition{Type: "RoutesAdmitted", Status: "False", Reason: "Rejected", Message: "b.com: rejected"})
+	if !changed || status.Conditions[1].Message != "b.com: rejected" || status.Conditions[1].LastTransitionTime.GetSeconds() != 1 {
+		t.Errorf("expected the message to be replaced, keeping the transition time: %v", status.Conditions[1])
+	}
+	if status.Conditions[0].Type != "Reconciled" {
+		t.Errorf("expected other conditions to be kept: %v", status.Conditions)
+	}
+	if current.Status.(*v1alpha1.IstioStatus).Conditions[1].Message != "a.com: rejected" {
+		t.Errorf("expected the status of the config to be left unmodified")
+	}
+
+	now := types.TimestampNow()
+	changed, status = SetCondition(current, &v1alpha1.IstioCondition{Type: "RoutesAdmitted", Status: "True", LastTransitionTime: now})
+	if !changed || status.Conditions[1].LastTransitionTime != now {
+		t.Errorf("expected the transition time to be updated: %v", status.Conditions[1])
+	}
+
+	changed, status = SetCondition(&config.Config{}, &v1alpha1.IstioCondition{Type: "RoutesAdmitted", Status: "True"})
+	if !changed || len(status.Conditions) != 1 {
+		t.Errorf("expected the condition to be added to an empty status: %v", status)
+	}
+}
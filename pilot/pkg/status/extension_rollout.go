@@ -0,0 +1,161 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+
+	maistramodel "istio.io/istio/pkg/servicemesh/model"
+)
+
+var serviceMeshExtensionsResource = schema.GroupVersionResource{
+	Group:    maistramodel.ServiceMeshExtensionGVK.Group,
+	Version:  maistramodel.ServiceMeshExtensionGVK.Version,
+	Resource: "servicemeshextensions",
+}
+
+// ExtensionRollout is the progress of the rollout of a new module of a
+// ServiceMeshExtension to the proxies connected to one istiod.
+type ExtensionRollout struct {
+	SHA256          string `json:"sha256"`
+	AckedInstances  int    `json:"ackedInstances"`
+	NackedInstances int    `json:"nackedInstances"`
+	// Message is an error reported by a proxy that rejected the module
+	Message string `json:"message,omitempty"`
+}
+
+// extensionRolloutState holds the responses of the proxies receiving the
+// module being rolled out, by connection.  Errors are empty for ACKs.
+type extensionRolloutState struct {
+	sha256    string
+	responses map[string]string
+}
+
+// RegisterExtensionEvent records whether a proxy accepted the module of an
+// extension, by namespace/name, that is being rolled out to it.
+func (r *Reporter) RegisterExtensionEvent(conID string, extension string, sha256 string, errorMessage string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.extensionRollouts == nil {
+		r.extensionRollouts = map[string]*extensionRolloutState{}
+	}
+	state := r.extensionRollouts[extension]
+	if state == nil || state.sha256 != sha256 {
+		state = &extensionRolloutState{sha256: sha256, responses: map[string]string{}}
+		r.extensionRollouts[extension] = state
+	}
+	state.responses[conID] = errorMessage
+	r.dirty = true
+}
+
+// removeExtensionEvents forgets the responses of a disconnected proxy.  Must
+// have write lock before calling.
+func (r *Reporter) removeExtensionEvents(conID string) {
+	for extension, state := range r.extensionRollouts {
+		delete(state.responses, conID)
+		if len(state.responses) == 0 {
+			delete(r.extensionRollouts, extension)
+		}
+	}
+}
+
+// buildExtensionRollouts summarizes the responses to the modules being rolled
+// out.  Must have read lock before calling.
+func (r *Reporter) buildExtensionRollouts() map[string]ExtensionRollout {
+	if len(r.extensionRollouts) == 0 {
+		return nil
+	}
+	out := make(map[string]ExtensionRollout, len(r.extensionRollouts))
+	for extension, state := range r.extensionRollouts {
+		rollout := ExtensionRollout{SHA256: state.sha256}
+		for _, errorMessage := range state.responses {
+			if errorMessage == "" {
+				rollout.AckedInstances++
+			} else {
+				rollout.NackedInstances++
+				rollout.Message = errorMessage
+			}
+		}
+		out[extension] = rollout
+	}
+	return out
+}
+
+// handleExtensionRollouts halts the rollouts of modules that were rejected by
+// a proxy connected to any istiod.  Must have write lock before calling.
+func (c *DistributionController) handleExtensionRollouts(d DistributionReport) {
+	for extension, rollout := range d.ExtensionRollouts {
+		if rollout.NackedInstances == 0 || c.haltedRollouts[extension] == rollout.SHA256 {
+			continue
+		}
+		if c.haltedRollouts == nil {
+			c.haltedRollouts = map[string]string{}
+		}
+		c.haltedRollouts[extension] = rollout.SHA256
+		go func(extension string, rollout ExtensionRollout) {
+			if err := haltExtensionRollout(context.TODO(), c.dynamicClient, extension, rollout); err != nil {
+				scope.Errorf("failed to halt rollout of module %s of ServiceMeshExtension %s: %v", rollout.SHA256, extension, err)
+				c.mu.Lock()
+				delete(c.haltedRollouts, extension)
+				c.mu.Unlock()
+			}
+		}(extension, rollout)
+	}
+}
+
+// haltExtensionRollout sets the RolloutHaltedAnnotation of the extension, by
+// namespace/name, unless the rejected module is no longer being rolled out.
+func haltExtensionRollout(ctx context.Context, client dynamic.Interface, extension string, rollout ExtensionRollout) error {
+	parts := strings.SplitN(extension, "/", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+	resource := client.Resource(serviceMeshExtensionsResource).Namespace(parts[0])
+	current, err := resource.Get(ctx, parts[1], metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	sha256, _, _ := unstructured.NestedString(current.Object, "status", "deployment", "sha256")
+	if sha256 != rollout.SHA256 {
+		return nil
+	}
+	if _, halted := current.GetAnnotations()[maistramodel.RolloutHaltedAnnotation]; halted {
+		return nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				maistramodel.RolloutHaltedAnnotation: rollout.Message,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := resource.Patch(ctx, parts[1], types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return err
+	}
+	scope.Warnf("halted rollout of module %s of ServiceMeshExtension %s, %d proxies rejected it: %s",
+		rollout.SHA256, extension, rollout.NackedInstances, rollout.Message)
+	return nil
+}
@@ -0,0 +1,99 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+
+	"istio.io/istio/pilot/pkg/xds"
+	maistramodel "istio.io/istio/pkg/servicemesh/model"
+)
+
+func TestExtensionRolloutReport(t *testing.T) {
+	RegisterTestingT(t)
+	r := initReporterWithoutStarting()
+	r.RegisterExtensionEvent("conA", "default/example", "v2", "")
+	r.RegisterExtensionEvent("conB", "default/example", "v2", "")
+	r.RegisterExtensionEvent("conC", "default/example", "v2", "failed to load wasm module")
+	r.RegisterExtensionEvent("conA", "default/other", "v1", "")
+	r.RegisterDisconnect("conB", []xds.EventType{""})
+	rpt, _ := r.buildReport()
+	Expect(rpt.ExtensionRollouts).To(Equal(map[string]ExtensionRollout{
+		"default/example": {SHA256: "v2", AckedInstances: 1, NackedInstances: 1, Message: "failed to load wasm module"},
+		"default/other":   {SHA256: "v1", AckedInstances: 1},
+	}))
+
+	// responses to a previous module are discarded
+	r.RegisterExtensionEvent("conA", "default/example", "v3", "")
+	r.RegisterDisconnect("conA", []xds.EventType{""})
+	rpt, _ = r.buildReport()
+	Expect(rpt.ExtensionRollouts).To(BeEmpty())
+}
+
+func newServiceMeshExtension(sha256 string, annotations map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "maistra.io/v1",
+		"kind":       "ServiceMeshExtension",
+		"metadata": map[string]interface{}{
+			"name":        "example",
+			"namespace":   "default",
+			"annotations": annotations,
+		},
+		"status": map[string]interface{}{
+			"deployment": map[string]interface{}{"sha256": sha256},
+		},
+	}}
+}
+
+func TestHaltExtensionRollout(t *testing.T) {
+	RegisterTestingT(t)
+	rollout := ExtensionRollout{SHA256: "v2", NackedInstances: 1, Message: "failed to load wasm module"}
+	testCases := []struct {
+		name     string
+		current  *unstructured.Unstructured
+		expected string
+	}{
+		{
+			name:     "rejected module",
+			current:  newServiceMeshExtension("v2", map[string]interface{}{}),
+			expected: "failed to load wasm module",
+		},
+		{
+			name:    "module replaced",
+			current: newServiceMeshExtension("v3", map[string]interface{}{}),
+		},
+		{
+			name:     "already halted",
+			current:  newServiceMeshExtension("v2", map[string]interface{}{maistramodel.RolloutHaltedAnnotation: "halted"}),
+			expected: "halted",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := fake.NewSimpleDynamicClient(runtime.NewScheme(), tc.current)
+			Expect(haltExtensionRollout(context.TODO(), client, "default/example", rollout)).To(Succeed())
+			updated, err := client.Resource(serviceMeshExtensionsResource).Namespace("default").Get(context.TODO(), "example", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(updated.GetAnnotations()[maistramodel.RolloutHaltedAnnotation]).To(Equal(tc.expected))
+		})
+	}
+}
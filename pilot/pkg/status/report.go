@@ -29,6 +29,8 @@ type DistributionReport struct {
 	Reporter            string         `json:"reporter"`
 	DataPlaneCount      int            `json:"dataPlaneCount"`
 	InProgressResources map[string]int `json:"inProgressResources"`
+	// ExtensionRollouts is the progress of the rollouts of ServiceMeshExtension modules, by namespace/name
+	ExtensionRollouts map[string]ExtensionRollout `json:"extensionRollouts,omitempty" yaml:"extensionrollouts,omitempty"`
 }
 
 func ReportFromYaml(content []byte) (DistributionReport, error) {
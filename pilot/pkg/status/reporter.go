@@ -67,6 +67,8 @@ type Reporter struct {
 	ledger                 ledger.Ledger
 	distributionEventQueue chan distributionEvent
 	controller             *DistributionController
+	// responses of proxies to the modules of extensions being rolled out, by namespace/name
+	extensionRollouts map[string]*extensionRolloutState
 }
 
 var _ xds.DistributionStatusCache = &Reporter{}
@@ -146,6 +148,7 @@ func (r *Reporter) buildReport() (DistributionReport, []Resource) {
 		Reporter:            r.PodName,
 		DataPlaneCount:      len(r.status),
 		InProgressResources: map[string]int{},
+		ExtensionRollouts:   r.buildExtensionRollouts(),
 	}
 	// for every resource in flight
 	for _, ipr := range r.inProgressResources {
@@ -346,6 +349,7 @@ func (r *Reporter) RegisterDisconnect(conID string, types []xds.EventType) {
 		r.deleteKeyFromReverseMap(key)
 		delete(r.status, key)
 	}
+	r.removeExtensionEvents(conID)
 }
 
 func (r *Reporter) SetController(controller *DistributionController) {
@@ -64,6 +64,8 @@ type DistributionController struct {
 	workers         WorkerQueue
 	StaleInterval   time.Duration
 	cmInformer      cache.SharedIndexInformer
+	// modules of extensions whose rollout was halted, by namespace/name
+	haltedRollouts map[string]string
 }
 
 func NewController(restConfig rest.Config, namespace string, cs model.ConfigStore) *DistributionController {
@@ -138,6 +140,7 @@ func (c *DistributionController) handleReport(d DistributionReport) {
 		}
 		c.CurrentState[res][d.Reporter] = Progress{d.InProgressResources[resstr], d.DataPlaneCount}
 	}
+	c.handleExtensionRollouts(d)
 	c.ObservationTime[d.Reporter] = c.clock.Now()
 }
 
@@ -15,6 +15,7 @@
 package xds
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"strconv"
@@ -77,6 +78,9 @@ type Connection struct {
 	// Both ADS and SDS streams implement this interface
 	stream DiscoveryStream
 
+	// deltaStream is set instead of stream for clients of the incremental (delta) ADS protocol
+	deltaStream DeltaDiscoveryStream
+
 	// Original node metadata, to avoid unmarshal/marshal.
 	// This is included in internal events.
 	node *core.Node
@@ -168,13 +172,7 @@ func (s *DiscoveryServer) receive(con *Connection, reqChannel chan *discovery.Di
 				return
 			}
 			adsLog.Infof("ADS: new connection for node:%s", con.ConID)
-			defer func() {
-				s.removeCon(con.ConID)
-				if s.StatusGen != nil {
-					s.StatusGen.OnDisconnect(con)
-				}
-				s.WorkloadEntryController.QueueUnregisterWorkload(con.proxy, con.Connect)
-			}()
+			defer s.closeConnection(con)
 		}
 
 		select {
@@ -186,6 +184,15 @@ func (s *DiscoveryServer) receive(con *Connection, reqChannel chan *discovery.Di
 	}
 }
 
+// closeConnection stops tracking a connection once its client disconnected.
+func (s *DiscoveryServer) closeConnection(con *Connection) {
+	s.removeCon(con.ConID)
+	if s.StatusGen != nil {
+		s.StatusGen.OnDisconnect(con)
+	}
+	s.WorkloadEntryController.QueueUnregisterWorkload(con.proxy, con.Connect)
+}
+
 // processRequest is handling one request. This is currently called from the 'main' thread, which also
 // handles 'push' requests and close - the code will eventually call the 'push' code, and it needs more mutex
 // protection. Original code avoided the mutexes by doing both 'push' and 'process requests' in same thread.
@@ -196,33 +203,38 @@ func (s *DiscoveryServer) processRequest(req *discovery.DiscoveryRequest, con *C
 
 	if s.StatusReporter != nil {
 		s.StatusReporter.RegisterEvent(con.ConID, req.TypeUrl, req.ResponseNonce)
+		s.reportExtensionRollouts(con, req)
 	}
-	shouldRespond := s.shouldRespond(con, req)
+	return s.pushOnRequest(con, req.TypeUrl, s.shouldRespond(con, req))
+}
 
+// pushOnRequest pushes the resources of a type to a connection when its client requested them, or when the client
+// ACKed a previous response of the type while a push was blocked by flow control.
+func (s *DiscoveryServer) pushOnRequest(con *Connection, typeURL string, shouldRespond bool) error {
 	// Check if we have a blocked push. If this was an ACK, we will send it. Either way we remove the blocked push
 	// as we will send a push.
 	con.proxy.Lock()
-	request, haveBlockedPush := con.blockedPushes[req.TypeUrl]
-	delete(con.blockedPushes, req.TypeUrl)
+	request, haveBlockedPush := con.blockedPushes[typeURL]
+	delete(con.blockedPushes, typeURL)
 	con.proxy.Unlock()
 
 	if shouldRespond {
 		// This is a request, trigger a full push for this type
 		// Override the blocked push (if it exists), as this full push is guaranteed to be a superset
 		// of what we would have pushed from the blocked push.
-		request = &model.PushRequest{Full: true}
+		request = &model.PushRequest{Full: true, Reason: []model.TriggerReason{model.ProxyRequest}}
 	} else if !haveBlockedPush {
 		// This is an ACK, no delayed push
 		// Return immediately, no action needed
 		return nil
 	} else {
 		// we have a blocked push which we will use
-		adsLog.Debugf("%s: DEQUEUE for node:%s", v3.GetShortType(req.TypeUrl), con.proxy.ID)
+		adsLog.Debugf("%s: DEQUEUE for node:%s", v3.GetShortType(typeURL), con.proxy.ID)
 	}
 
 	push := s.globalPushContext()
 
-	return s.pushXds(con, push, versionInfo(), con.Watched(req.TypeUrl), request)
+	return s.pushXds(con, push, versionInfo(), con.Watched(typeURL), request)
 }
 
 // StreamAggregatedResources implements the ADS interface.
@@ -231,40 +243,9 @@ func (s *DiscoveryServer) StreamAggregatedResources(stream discovery.AggregatedD
 }
 
 func (s *DiscoveryServer) Stream(stream DiscoveryStream) error {
-	// Check if server is ready to accept clients and process new requests.
-	// Currently ready means caches have been synced and hence can build
-	// clusters correctly. Without this check, InitContext() call below would
-	// initialize with empty config, leading to reconnected Envoys loosing
-	// configuration. This is an additional safety check inaddition to adding
-	// cachesSynced logic to readiness probe to handle cases where kube-proxy
-	// ip tables update latencies.
-	// See https://github.com/istio/istio/issues/25495.
-	if !s.IsServerReady() {
-		return status.Error(codes.Unavailable, "server is not ready to serve discovery information")
-	}
-
-	ctx := stream.Context()
-	peerAddr := "0.0.0.0"
-	if peerInfo, ok := peer.FromContext(ctx); ok {
-		peerAddr = peerInfo.Addr.String()
-	}
-
-	ids, err := s.authenticate(ctx)
+	peerAddr, ids, err := s.acceptStream(stream.Context())
 	if err != nil {
-		return status.Error(codes.Unauthenticated, err.Error())
-	}
-	if ids != nil {
-		adsLog.Debugf("Authenticated XDS: %v with identity %v", peerAddr, ids)
-	} else {
-		adsLog.Debug("Unauthenticated XDS: ", peerAddr)
-	}
-
-	// InitContext returns immediately if the context was already initialized.
-	if err = s.globalPushContext().InitContext(s.Env, nil, nil); err != nil {
-		// Error accessing the data - log and close, maybe a different pilot replica
-		// has more luck
-		adsLog.Warnf("Error reading config %v", err)
-		return status.Error(codes.Unavailable, "error reading config")
+		return err
 	}
 	con := newConnection(peerAddr, stream)
 	con.Identities = ids
@@ -322,6 +303,46 @@ func (s *DiscoveryServer) Stream(stream DiscoveryStream) error {
 	}
 }
 
+// acceptStream checks that the server is ready to serve the new stream of a client, which is authenticated.
+// Returns the address and the identities of the client.
+func (s *DiscoveryServer) acceptStream(ctx context.Context) (string, []string, error) {
+	// Check if server is ready to accept clients and process new requests.
+	// Currently ready means caches have been synced and hence can build
+	// clusters correctly. Without this check, InitContext() call below would
+	// initialize with empty config, leading to reconnected Envoys loosing
+	// configuration. This is an additional safety check inaddition to adding
+	// cachesSynced logic to readiness probe to handle cases where kube-proxy
+	// ip tables update latencies.
+	// See https://github.com/istio/istio/issues/25495.
+	if !s.IsServerReady() {
+		return "", nil, status.Error(codes.Unavailable, "server is not ready to serve discovery information")
+	}
+
+	peerAddr := "0.0.0.0"
+	if peerInfo, ok := peer.FromContext(ctx); ok {
+		peerAddr = peerInfo.Addr.String()
+	}
+
+	ids, err := s.authenticate(ctx)
+	if err != nil {
+		return "", nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if ids != nil {
+		adsLog.Debugf("Authenticated XDS: %v with identity %v", peerAddr, ids)
+	} else {
+		adsLog.Debug("Unauthenticated XDS: ", peerAddr)
+	}
+
+	// InitContext returns immediately if the context was already initialized.
+	if err = s.globalPushContext().InitContext(s.Env, nil, nil); err != nil {
+		// Error accessing the data - log and close, maybe a different pilot replica
+		// has more luck
+		adsLog.Warnf("Error reading config %v", err)
+		return "", nil, status.Error(codes.Unavailable, "error reading config")
+	}
+	return peerAddr, ids, nil
+}
+
 // shouldRespond determines whether this request needs to be responded back. It applies the ack/nack rules as per xds protocol
 // using WatchedResource for previous state and discovery request for the current state.
 func (s *DiscoveryServer) shouldRespond(con *Connection, request *discovery.DiscoveryRequest) bool {
@@ -628,18 +649,6 @@ func (s *DiscoveryServer) preProcessRequest(proxy *model.Proxy, req *discovery.D
 	return true
 }
 
-// DeltaAggregatedResources is not implemented.
-// Instead, Generators may send only updates/add, with Delete indicated by an empty spec.
-// This works if both ends follow this model. For example EDS and the API generator follow this
-// pattern.
-//
-// The delta protocol changes the request, adding unsubscribe/subscribe instead of sending full
-// list of resources. On the response it adds 'removed resources' and sends changes for everything.
-// TODO: we could implement this method if needed, the change is not very big.
-func (s *DiscoveryServer) DeltaAggregatedResources(stream discovery.AggregatedDiscoveryService_DeltaAggregatedResourcesServer) error {
-	return status.Errorf(codes.Unimplemented, "not implemented")
-}
-
 // Compute and send the new configuration for a connection. This is blocking and may be slow
 // for large configs. The method will hold a lock on con.pushMutex.
 func (s *DiscoveryServer) pushConnection(con *Connection, pushEv *Event) error {
@@ -865,6 +874,31 @@ func (s *DiscoveryServer) removeCon(conID string) {
 
 // Send with timeout
 func (conn *Connection) send(res *discovery.DiscoveryResponse) error {
+	err := conn.sendWithTimeout(func() error {
+		return conn.stream.Send(res)
+	})
+	if err == nil {
+		sz := 0
+		for _, rc := range res.Resources {
+			sz += len(rc.Value)
+		}
+		conn.proxy.Lock()
+		if res.Nonce != "" {
+			if conn.proxy.WatchedResources[res.TypeUrl] == nil {
+				conn.proxy.WatchedResources[res.TypeUrl] = &model.WatchedResource{TypeUrl: res.TypeUrl}
+			}
+			conn.proxy.WatchedResources[res.TypeUrl].NonceSent = res.Nonce
+			conn.proxy.WatchedResources[res.TypeUrl].VersionSent = res.VersionInfo
+			conn.proxy.WatchedResources[res.TypeUrl].LastSent = time.Now()
+			conn.proxy.WatchedResources[res.TypeUrl].LastSize = sz
+		}
+		conn.proxy.Unlock()
+	}
+	return err
+}
+
+// sendWithTimeout sends a response to the client of the connection, failing if it doesn't read it in time.
+func (conn *Connection) sendWithTimeout(send func() error) error {
 	errChan := make(chan error, 1)
 
 	// sendTimeout may be modified via environment
@@ -872,7 +906,7 @@ func (conn *Connection) send(res *discovery.DiscoveryResponse) error {
 	go func() {
 		start := time.Now()
 		defer func() { recordSendTime(time.Since(start)) }()
-		errChan <- conn.stream.Send(res)
+		errChan <- send()
 		close(errChan)
 	}()
 
@@ -882,23 +916,6 @@ func (conn *Connection) send(res *discovery.DiscoveryResponse) error {
 		xdsResponseWriteTimeouts.Increment()
 		return status.Errorf(codes.DeadlineExceeded, "timeout sending")
 	case err := <-errChan:
-		if err == nil {
-			sz := 0
-			for _, rc := range res.Resources {
-				sz += len(rc.Value)
-			}
-			conn.proxy.Lock()
-			if res.Nonce != "" {
-				if conn.proxy.WatchedResources[res.TypeUrl] == nil {
-					conn.proxy.WatchedResources[res.TypeUrl] = &model.WatchedResource{TypeUrl: res.TypeUrl}
-				}
-				conn.proxy.WatchedResources[res.TypeUrl].NonceSent = res.Nonce
-				conn.proxy.WatchedResources[res.TypeUrl].VersionSent = res.VersionInfo
-				conn.proxy.WatchedResources[res.TypeUrl].LastSent = time.Now()
-				conn.proxy.WatchedResources[res.TypeUrl].LastSize = sz
-			}
-			conn.proxy.Unlock()
-		}
 		// To ensure the channel is empty after a call to Stop, check the
 		// return value and drain the channel (from Stop docs).
 		if !t.Stop() {
@@ -908,6 +925,14 @@ func (conn *Connection) send(res *discovery.DiscoveryResponse) error {
 	}
 }
 
+// streamContext returns the context of the stream of the connection, whichever protocol it uses.
+func (conn *Connection) streamContext() context.Context {
+	if conn.deltaStream != nil {
+		return conn.deltaStream.Context()
+	}
+	return conn.stream.Context()
+}
+
 // nolint
 // Synced checks if the type has been synced, meaning the most recent push was ACKed
 func (conn *Connection) Synced(typeUrl string) (bool, bool) {
@@ -18,6 +18,7 @@ import (
 	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pkg/config"
 	"istio.io/istio/pkg/config/schema/gvk"
+	fedmodel "istio.io/istio/pkg/servicemesh/federation/model"
 )
 
 // configKindAffectedProxyTypes contains known config types which will affect certain node types.
@@ -25,6 +26,8 @@ var configKindAffectedProxyTypes = map[config.GroupVersionKind][]model.NodeType{
 	gvk.Gateway: {model.Router},
 	gvk.Secret:  {model.Router},
 	gvk.Sidecar: {model.SidecarProxy},
+
+	fedmodel.ExportedServiceGVK: {model.Federation},
 }
 
 // ConfigAffectsProxy checks if a pushEv will affect a specified proxy. That means whether the push will be performed
@@ -66,6 +69,9 @@ func checkProxyDependencies(proxy *model.Proxy, config model.ConfigKey) bool {
 		} else if proxy.PrevSidecarScope != nil && proxy.PrevSidecarScope.DependsOnConfig(config) {
 			return true
 		}
+	case model.Federation:
+		// federation discovery clients only depend on the services exported to meshes in their namespace
+		return config.Kind == fedmodel.ExportedServiceGVK && config.Namespace == proxy.ConfigNamespace
 	default:
 		// TODO We'll add the check for other proxy types later.
 		return true
@@ -22,6 +22,7 @@ import (
 	model "istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pkg/config"
 	"istio.io/istio/pkg/config/schema/gvk"
+	fedmodel "istio.io/istio/pkg/servicemesh/federation/model"
 	"istio.io/istio/pkg/spiffe"
 )
 
@@ -50,6 +51,7 @@ func TestProxyNeedsPush(t *testing.T) {
 		Type: model.SidecarProxy, IPAddresses: []string{"127.0.0.1"}, Metadata: &model.NodeMetadata{},
 		SidecarScope: &model.SidecarScope{Name: generalName, Namespace: nsName, RootNamespace: nsRoot}}
 	gateway := &model.Proxy{Type: model.Router}
+	federation := &model.Proxy{Type: model.Federation, ConfigNamespace: nsName}
 
 	sidecarScopeKindNames := map[config.GroupVersionKind]string{
 		gvk.ServiceEntry: svcName, gvk.VirtualService: vsName, gvk.DestinationRule: drName}
@@ -95,6 +97,19 @@ func TestProxyNeedsPush(t *testing.T) {
 			{Kind: gvk.ServiceEntry, Name: svcName + invalidNameSuffix, Namespace: nsName}:   {},
 		}, false},
 		{"empty configsUpdated for sidecar", sidecar, nil, true},
+		{"exported services for gateway", gateway, map[model.ConfigKey]struct{}{
+			{
+				Kind: fedmodel.ExportedServiceGVK,
+				Name: generalName, Namespace: nsName}: {}}, false},
+		{"exported services for federation client in different namespace", federation, map[model.ConfigKey]struct{}{
+			{
+				Kind: fedmodel.ExportedServiceGVK,
+				Name: generalName, Namespace: "invalid-namespace"}: {}}, false},
+		{"service entry for federation client", federation, map[model.ConfigKey]struct{}{
+			{
+				Kind: gvk.ServiceEntry,
+				Name: svcName, Namespace: nsName}: {}}, false},
+		{"empty configsUpdated for federation client", federation, nil, true},
 	}
 
 	for kind, name := range sidecarScopeKindNames {
@@ -135,8 +150,11 @@ func TestProxyNeedsPush(t *testing.T) {
 	for kind, types := range configKindAffectedProxyTypes {
 		for _, nodeType := range types {
 			proxy := gateway
-			if nodeType == model.SidecarProxy {
+			switch nodeType {
+			case model.SidecarProxy:
 				proxy = sidecar
+			case model.Federation:
+				proxy = federation
 			}
 			cases = append(cases, Case{
 				name:  fmt.Sprintf("kind %s affect %s", kind, nodeType),
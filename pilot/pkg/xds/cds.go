@@ -16,9 +16,10 @@ package xds
 
 import (
 	"istio.io/istio/pilot/pkg/model"
-	"istio.io/istio/pilot/pkg/networking/util"
 	"istio.io/istio/pkg/config"
 	"istio.io/istio/pkg/config/schema/gvk"
+	fedmodel "istio.io/istio/pkg/servicemesh/federation/model"
+	maistramodel "istio.io/istio/pkg/servicemesh/model"
 )
 
 type CdsGenerator struct {
@@ -29,12 +30,15 @@ var _ model.XdsResourceGenerator = &CdsGenerator{}
 
 // Map of all configs that do not impact CDS
 var skippedCdsConfigs = map[config.GroupVersionKind]struct{}{
-	gvk.Gateway:               {},
-	gvk.WorkloadEntry:         {},
-	gvk.WorkloadGroup:         {},
-	gvk.AuthorizationPolicy:   {},
-	gvk.RequestAuthentication: {},
-	gvk.Secret:                {},
+	gvk.Gateway:                                {},
+	gvk.WorkloadEntry:                          {},
+	gvk.WorkloadGroup:                          {},
+	gvk.AuthorizationPolicy:                    {},
+	gvk.RequestAuthentication:                  {},
+	gvk.Secret:                                 {},
+	fedmodel.ExportedServiceGVK:                {},
+	maistramodel.ServiceMeshExtensionGVK:       {},
+	maistramodel.ServiceMeshExtensionConfigGVK: {},
 }
 
 // Map all configs that impacts CDS for gateways.
@@ -73,10 +77,5 @@ func (c CdsGenerator) Generate(proxy *model.Proxy, push *model.PushContext, w *m
 	if !cdsNeedsPush(req, proxy) {
 		return nil, nil
 	}
-	rawClusters := c.Server.ConfigGenerator.BuildClusters(proxy, push)
-	resources := model.Resources{}
-	for _, c := range rawClusters {
-		resources = append(resources, util.MessageToAny(c))
-	}
-	return resources, nil
+	return c.Server.ConfigGenerator.BuildClusters(proxy, push), nil
 }
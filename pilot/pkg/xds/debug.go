@@ -41,6 +41,7 @@ import (
 	v3 "istio.io/istio/pilot/pkg/xds/v3"
 	"istio.io/istio/pkg/config"
 	"istio.io/istio/pkg/config/schema/collection"
+	fedmodel "istio.io/istio/pkg/servicemesh/federation/model"
 	"istio.io/pkg/log"
 )
 
@@ -170,15 +171,17 @@ func (s *DiscoveryServer) AddDebugHandlers(mux *http.ServeMux, enableProfiling b
 	s.addDebugHandler(mux, "/debug/registryz", "Debug support for registry", s.registryz)
 	s.addDebugHandler(mux, "/debug/endpointz", "Debug support for endpoints", s.endpointz)
 	s.addDebugHandler(mux, "/debug/endpointShardz", "Info about the endpoint shards", s.endpointShardz)
-	s.addDebugHandler(mux, "/debug/cachez", "Info about the internal XDS caches", s.cachez)
+	s.addDebugHandler(mux, "/debug/cachez", "Info about the internal XDS caches, use ?stats for the statistics by type", s.cachez)
 	s.addDebugHandler(mux, "/debug/configz", "Debug support for config", s.configz)
 	s.addDebugHandler(mux, "/debug/sidecarz", "Debug sidecar scope for a proxy", s.sidecarz)
 	s.addDebugHandler(mux, "/debug/resourcesz", "Debug support for watched resources", s.resourcez)
 	s.addDebugHandler(mux, "/debug/instancesz", "Debug support for service instances", s.instancesz)
+	s.addDebugHandler(mux, "/debug/federationz", "Services discovered from federated peers", s.federationz)
 
 	s.addDebugHandler(mux, "/debug/authorizationz", "Internal authorization policies", s.Authorizationz)
 	s.addDebugHandler(mux, "/debug/config_dump", "ConfigDump in the form of the Envoy admin config dump API for passed in proxyID", s.ConfigDump)
 	s.addDebugHandler(mux, "/debug/push_status", "Last PushContext Details", s.PushStatusHandler)
+	s.addDebugHandler(mux, "/debug/dryrunz", "Proxies whose configuration would change if the configs posted as YAML were applied", s.dryrunz)
 
 	s.addDebugHandler(mux, "/debug/inject", "Active inject template", s.InjectTemplateHandler(webhook))
 	s.addDebugHandler(mux, "/debug/mesh", "Active mesh config", s.MeshHandler)
@@ -254,10 +257,18 @@ func (s *DiscoveryServer) endpointShardz(w http.ResponseWriter, req *http.Reques
 	_, _ = w.Write(out)
 }
 
+// cachez lists the keys of the XDS cache, or the cache statistics by type with ?stats
 func (s *DiscoveryServer) cachez(w http.ResponseWriter, req *http.Request) {
-	keys := s.Cache.Keys()
-	sort.Strings(keys)
-	bytes, err := json.Marshal(keys)
+	_ = req.ParseForm()
+	var out interface{}
+	if _, f := req.Form["stats"]; f {
+		out = s.Cache.Stats()
+	} else {
+		keys := s.Cache.Keys()
+		sort.Strings(keys)
+		out = keys
+	}
+	bytes, err := json.Marshal(out)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		_, _ = fmt.Fprintf(w, "unable to marshal syncedVersion information: %v", err)
@@ -533,11 +544,7 @@ func (s *DiscoveryServer) configDump(conn *Connection) (*adminapi.ConfigDump, er
 	dynamicActiveClusters := make([]*adminapi.ClustersConfigDump_DynamicCluster, 0)
 	clusters := s.ConfigGenerator.BuildClusters(conn.proxy, s.globalPushContext())
 
-	for _, cs := range clusters {
-		cluster, err := ptypes.MarshalAny(cs)
-		if err != nil {
-			return nil, err
-		}
+	for _, cluster := range clusters {
 		dynamicActiveClusters = append(dynamicActiveClusters, &adminapi.ClustersConfigDump_DynamicCluster{Cluster: cluster})
 	}
 	clustersAny, err := util.MessageToAnyWithError(&adminapi.ClustersConfigDump{
@@ -571,11 +578,7 @@ func (s *DiscoveryServer) configDump(conn *Connection) (*adminapi.ConfigDump, er
 	routeConfigAny := util.MessageToAny(&adminapi.RoutesConfigDump{})
 	if len(routes) > 0 {
 		dynamicRouteConfig := make([]*adminapi.RoutesConfigDump_DynamicRouteConfig, 0)
-		for _, rs := range routes {
-			route, err := ptypes.MarshalAny(rs)
-			if err != nil {
-				return nil, err
-			}
+		for _, route := range routes {
 			dynamicRouteConfig = append(dynamicRouteConfig, &adminapi.RoutesConfigDump_DynamicRouteConfig{RouteConfig: route})
 		}
 		routeConfigAny, err = util.MessageToAnyWithError(&adminapi.RoutesConfigDump{DynamicRouteConfigs: dynamicRouteConfig})
@@ -823,3 +826,39 @@ func (s *DiscoveryServer) instancesz(w http.ResponseWriter, req *http.Request) {
 
 	_, _ = w.Write(by)
 }
+
+// federationRegistry is implemented by the registries of federated peers.
+type federationRegistry interface {
+	ImportStatus() *fedmodel.PeerImportStatus
+}
+
+// federationz dumps the services advertised by each federated peer, along
+// with the names under which they were imported.
+func (s *DiscoveryServer) federationz(w http.ResponseWriter, req *http.Request) {
+	_ = req.ParseForm()
+	peer := req.Form.Get("peer")
+	statuses := []*fedmodel.PeerImportStatus{}
+	if agg, ok := s.Env.ServiceDiscovery.(*aggregate.Controller); ok {
+		for _, registry := range agg.GetRegistries() {
+			if registry.Provider() != serviceregistry.Federation {
+				continue
+			}
+			federation, ok := registry.(federationRegistry)
+			if !ok {
+				continue
+			}
+			if status := federation.ImportStatus(); peer == "" || status.Peer == peer {
+				statuses = append(statuses, status)
+			}
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Peer < statuses[j].Peer })
+	out, err := json.MarshalIndent(statuses, "", "  ")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = fmt.Fprintf(w, "unable to marshal federation information: %v", err)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	_, _ = w.Write(out)
+}
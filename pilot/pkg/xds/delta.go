@@ -0,0 +1,495 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xds
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"time"
+
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/golang/protobuf/ptypes/any"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/networking/util"
+	"istio.io/istio/pilot/pkg/util/sets"
+	v3 "istio.io/istio/pilot/pkg/xds/v3"
+	"istio.io/istio/pkg/config/schema/gvk"
+)
+
+// DeltaDiscoveryStream is the server side of a stream of the incremental (delta) ADS protocol.
+type DeltaDiscoveryStream = discovery.AggregatedDiscoveryService_DeltaAggregatedResourcesServer
+
+// wildcardSubscription is the resource name that subscribes a client of the delta protocol to all the resources of a
+// type, or unsubscribes it.
+const wildcardSubscription = "*"
+
+// namedTypes are the types whose resources hold their name in their first field, which is sent as the name of the
+// resources to clients of the delta protocol. Resources of other types are named after their version.
+var namedTypes = map[string]struct{}{
+	v3.ClusterType:                {},
+	v3.ListenerType:               {},
+	v3.RouteType:                  {},
+	v3.EndpointType:               {},
+	v3.SecretType:                 {},
+	v3.ExtensionConfigurationType: {},
+	v3.FederationServiceType:      {},
+	v3.FederationGatewayType:      {},
+}
+
+func newDeltaConnection(peerAddr string, stream DeltaDiscoveryStream) *Connection {
+	return &Connection{
+		pushChannel:   make(chan *Event),
+		initialized:   make(chan struct{}),
+		stop:          make(chan struct{}),
+		PeerAddr:      peerAddr,
+		Connect:       time.Now(),
+		deltaStream:   stream,
+		blockedPushes: map[string]*model.PushRequest{},
+	}
+}
+
+// DeltaAggregatedResources implements the incremental ADS interface. Clients subscribe to and unsubscribe from
+// resources by name, and only the resources that changed since they were last sent are pushed to them, along with the
+// names of the removed ones. Pushes are otherwise triggered and generated like for state of the world clients.
+func (s *DiscoveryServer) DeltaAggregatedResources(stream DeltaDiscoveryStream) error {
+	peerAddr, ids, err := s.acceptStream(stream.Context())
+	if err != nil {
+		return err
+	}
+	con := newDeltaConnection(peerAddr, stream)
+	con.Identities = ids
+
+	var receiveError error
+	reqChannel := make(chan *discovery.DeltaDiscoveryRequest, 1)
+	go s.receiveDelta(con, reqChannel, &receiveError)
+
+	// Wait for the proxy to be fully initialized before we start serving traffic, see Stream.
+	<-con.initialized
+
+	for {
+		select {
+		case req, ok := <-reqChannel:
+			if !ok {
+				// Remote side closed connection or error processing the request.
+				return receiveError
+			}
+			if err := s.processDeltaRequest(req, con); err != nil {
+				return err
+			}
+
+		case pushEv := <-con.pushChannel:
+			err := s.pushConnection(con, pushEv)
+			pushEv.done()
+			if err != nil {
+				return err
+			}
+		case <-con.stop:
+			return nil
+		}
+	}
+}
+
+func (s *DiscoveryServer) receiveDelta(con *Connection, reqChannel chan *discovery.DeltaDiscoveryRequest, errP *error) {
+	defer func() {
+		close(reqChannel)
+		// Close the initialized channel, if its not already closed, to prevent blocking the stream
+		select {
+		case <-con.initialized:
+		default:
+			close(con.initialized)
+		}
+	}()
+	firstReq := true
+	for {
+		req, err := con.deltaStream.Recv()
+		if err != nil {
+			if isExpectedGRPCError(err) {
+				adsLog.Infof("ADS: %q %s terminated %v", con.PeerAddr, con.ConID, err)
+				return
+			}
+			*errP = err
+			adsLog.Errorf("ADS: %q %s terminated with error: %v", con.PeerAddr, con.ConID, err)
+			totalXDSInternalErrors.Increment()
+			return
+		}
+		// This should be only set for the first request. The node id may not be set - for example malicious clients.
+		if firstReq {
+			firstReq = false
+			if req.Node == nil || req.Node.Id == "" {
+				*errP = status.New(codes.InvalidArgument, "missing node ID").Err()
+				return
+			}
+			if err := s.initConnection(req.Node, con); err != nil {
+				*errP = err
+				return
+			}
+			adsLog.Infof("ADS: new delta connection for node:%s", con.ConID)
+			defer s.closeConnection(con)
+		}
+
+		select {
+		case reqChannel <- req:
+		case <-con.deltaStream.Context().Done():
+			adsLog.Infof("ADS: %q %s terminated with stream closed", con.PeerAddr, con.ConID)
+			return
+		}
+	}
+}
+
+// processDeltaRequest handles one request of a client of the delta protocol, like processRequest.
+func (s *DiscoveryServer) processDeltaRequest(req *discovery.DeltaDiscoveryRequest, con *Connection) error {
+	// health checks, status events and rollouts are reported in terms of state of the world requests
+	sotwReq := sotwRequest(req)
+	if !s.preProcessRequest(con.proxy, sotwReq) {
+		return nil
+	}
+
+	if s.StatusReporter != nil {
+		s.StatusReporter.RegisterEvent(con.ConID, req.TypeUrl, req.ResponseNonce)
+		s.reportExtensionRollouts(con, sotwReq)
+	}
+	return s.pushOnRequest(con, req.TypeUrl, s.shouldRespondDelta(con, req, sotwReq))
+}
+
+// sotwRequest returns the state of the world request equivalent to a delta request, whose resource names are those
+// it subscribes to.
+func sotwRequest(req *discovery.DeltaDiscoveryRequest) *discovery.DiscoveryRequest {
+	return &discovery.DiscoveryRequest{
+		Node:          req.Node,
+		TypeUrl:       req.TypeUrl,
+		ResourceNames: req.ResourceNamesSubscribe,
+		ResponseNonce: req.ResponseNonce,
+		ErrorDetail:   req.ErrorDetail,
+	}
+}
+
+// shouldRespondDelta determines whether a request of a client of the delta protocol needs to be responded to. It
+// applies the ack/nack rules like shouldRespond, and updates the resources the client subscribed to. Unlike state of
+// the world clients, delta clients may change their subscriptions in any request, including ACKs.
+func (s *DiscoveryServer) shouldRespondDelta(con *Connection, request *discovery.DeltaDiscoveryRequest,
+	sotwReq *discovery.DiscoveryRequest) bool {
+	stype := v3.GetShortType(request.TypeUrl)
+
+	if request.ErrorDetail != nil {
+		errCode := codes.Code(request.ErrorDetail.Code)
+		adsLog.Warnf("ADS:%s: ACK ERROR %s %s:%s", stype, con.ConID, errCode.String(), request.ErrorDetail.GetMessage())
+		incrementXDSRejects(request.TypeUrl, con.proxy.ID, errCode.String())
+		if s.StatusGen != nil {
+			s.StatusGen.OnNack(con.proxy, sotwReq)
+		}
+		con.proxy.Lock()
+		if w := con.proxy.WatchedResources[request.TypeUrl]; w != nil {
+			w.NonceNacked = request.ResponseNonce
+		}
+		con.proxy.Unlock()
+		return false
+	}
+
+	con.proxy.Lock()
+	defer con.proxy.Unlock()
+
+	w := con.proxy.WatchedResources[request.TypeUrl]
+	if w == nil {
+		// This is the first request of the type, possibly after a reconnection: the client sends the versions of the
+		// resources it already has, which don't need to be sent again.
+		adsLog.Debugf("ADS:%s: INIT %s %s", stype, con.ConID, request.ResponseNonce)
+		w = &model.WatchedResource{
+			TypeUrl:          request.TypeUrl,
+			Wildcard:         isWildcardTypeURL(request.TypeUrl) && len(request.ResourceNamesSubscribe) == 0,
+			ResourceVersions: map[string]string{},
+		}
+		for name, version := range request.InitialResourceVersions {
+			w.ResourceVersions[name] = version
+		}
+		con.proxy.WatchedResources[request.TypeUrl] = w
+	} else if request.ResponseNonce != "" {
+		if request.ResponseNonce == w.NonceSent {
+			adsLog.Debugf("ADS:%s: ACK %s %s", stype, con.ConID, request.ResponseNonce)
+			w.NonceAcked = request.ResponseNonce
+			w.VersionAcked = w.VersionSent
+			w.NonceNacked = ""
+		} else {
+			adsLog.Debugf("ADS:%s: REQ %s Expired nonce received %s, sent %s", stype,
+				con.ConID, request.ResponseNonce, w.NonceSent)
+			xdsExpiredNonce.With(typeTag.Value(v3.GetMetricType(request.TypeUrl))).Increment()
+			w.NonceNacked = ""
+		}
+	}
+	w.LastRequest = sotwReq
+
+	initial := w.NonceSent == ""
+	if !updateSubscriptions(w, request) && !initial {
+		return false
+	}
+	if !w.Wildcard && len(w.ResourceNames) == 0 {
+		adsLog.Debugf("ADS:%s: UNSUBSCRIBE %s %s", stype, con.ConID, request.ResponseNonce)
+		delete(con.proxy.WatchedResources, request.TypeUrl)
+		return false
+	}
+	adsLog.Debugf("ADS:%s: RESOURCE CHANGE subscribed: %v, unsubscribed: %v %s %s", stype,
+		request.ResourceNamesSubscribe, request.ResourceNamesUnsubscribe, con.ConID, request.ResponseNonce)
+	return true
+}
+
+// updateSubscriptions applies the subscriptions and unsubscriptions of a request to the watched resource of its type,
+// returning whether they changed. The versions of the unsubscribed resources are forgotten, so that they are sent
+// again if the client subscribes to them later.
+func updateSubscriptions(w *model.WatchedResource, request *discovery.DeltaDiscoveryRequest) bool {
+	names := sets.NewSet(w.ResourceNames...)
+	changed := false
+	for _, name := range request.ResourceNamesSubscribe {
+		if name == wildcardSubscription {
+			changed = changed || !w.Wildcard
+			w.Wildcard = true
+			continue
+		}
+		if !names.Contains(name) {
+			names.Insert(name)
+			changed = true
+		}
+	}
+	for _, name := range request.ResourceNamesUnsubscribe {
+		if name == wildcardSubscription {
+			changed = changed || w.Wildcard
+			w.Wildcard = false
+			continue
+		}
+		if names.Contains(name) {
+			names.Delete(name)
+			delete(w.ResourceVersions, name)
+			changed = true
+		}
+	}
+	if changed {
+		w.ResourceNames = names.UnsortedList()
+		sort.Strings(w.ResourceNames)
+	}
+	return changed
+}
+
+// deltaWatchedResource returns a copy of the watched resource whose resources are generated for a push to a client
+// of the delta protocol, or nil if none of them needs to be generated. The generators of wildcard types always
+// generate all the resources. Of the subscribed resources of other types, only those that may have changed are
+// generated: those that were never sent, and those affected by the updated configs.
+func deltaWatchedResource(proxy *model.Proxy, w *model.WatchedResource, req *model.PushRequest) *model.WatchedResource {
+	proxy.RLock()
+	defer proxy.RUnlock()
+	generated := *w
+	generated.ResourceVersions = make(map[string]string, len(w.ResourceVersions))
+	for name, version := range w.ResourceVersions {
+		generated.ResourceVersions[name] = version
+	}
+	if isWildcardTypeURL(w.TypeUrl) || w.NonceSent == "" {
+		return &generated
+	}
+	var updated func(name string) bool
+	if req.IsRequest() {
+		// the client changed its subscriptions, the resources it already has didn't change
+		updated = func(string) bool { return false }
+	} else {
+		updated = updatedResources(w.TypeUrl, req.ConfigsUpdated, proxy.ConfigNamespace)
+	}
+	generated.ResourceNames = make([]string, 0, len(w.ResourceNames))
+	for _, name := range w.ResourceNames {
+		if _, f := w.ResourceVersions[name]; !f || updated == nil || updated(name) {
+			generated.ResourceNames = append(generated.ResourceNames, name)
+		}
+	}
+	if len(generated.ResourceNames) == 0 {
+		return nil
+	}
+	return &generated
+}
+
+// updatedResources returns a function that determines whether a resource of a non wildcard type is affected by the
+// updated configs, or nil if all the resources may be affected.
+func updatedResources(typeURL string, updates model.XdsUpdates, namespace string) func(name string) bool {
+	if len(updates) == 0 {
+		return nil
+	}
+	switch typeURL {
+	case v3.EndpointType:
+		hostnames := model.ConfigNamesOfKind(updates, gvk.ServiceEntry)
+		if len(hostnames) != len(updates) {
+			return nil
+		}
+		return func(name string) bool {
+			_, _, hostname, _ := model.ParseSubsetKey(name)
+			_, f := hostnames[string(hostname)]
+			return f
+		}
+	case v3.SecretType:
+		secrets := model.ConfigsOfKind(updates, gvk.Secret)
+		if len(secrets) != len(updates) {
+			return nil
+		}
+		return func(name string) bool {
+			sr, err := parseResourceName(name, namespace)
+			return err != nil || containsAny(secrets, relatedConfigs(model.ConfigKey{Kind: gvk.Secret, Name: sr.Name, Namespace: sr.Namespace}))
+		}
+	}
+	return nil
+}
+
+// pushDeltaXds sends the generated resources of a type that changed since they were last sent to a client of the
+// delta protocol, and the names of those that were removed. w is the watched resource returned by
+// deltaWatchedResource.
+func (s *DiscoveryServer) pushDeltaXds(con *Connection, push *model.PushContext, currentVersion string,
+	w *model.WatchedResource, res model.Resources) error {
+	subscribed := sets.NewSet(w.ResourceNames...)
+	wildcard := isWildcardTypeURL(w.TypeUrl)
+	filtered := wildcard && !w.Wildcard
+	sent := w.ResourceVersions
+	initial := w.NonceSent == ""
+
+	resp := &discovery.DeltaDiscoveryResponse{
+		TypeUrl:           w.TypeUrl,
+		SystemVersionInfo: currentVersion,
+		Nonce:             nonce(push.LedgerVersion),
+	}
+	generated := sets.NewSet()
+	for _, r := range res {
+		version := resourceVersion(r)
+		name := version
+		if _, f := namedTypes[w.TypeUrl]; f {
+			name = resourceName(r)
+		}
+		// The generators of wildcard types ignore the resource names, only the subscribed resources are sent to
+		// clients that didn't subscribe to all of them.
+		if filtered && !subscribed.Contains(name) {
+			continue
+		}
+		generated.Insert(name)
+		if sent[name] == version {
+			continue
+		}
+		resp.Resources = append(resp.Resources, &discovery.Resource{Name: name, Version: version, Resource: r})
+	}
+	// The generators of wildcard types generate all the resources, and those of other types all the resources they
+	// were asked for, so the resources that were sent but not generated were removed.
+	for name := range sent {
+		if !generated.Contains(name) && (wildcard || subscribed.Contains(name)) {
+			resp.RemovedResources = append(resp.RemovedResources, name)
+		}
+	}
+	sort.Strings(resp.RemovedResources)
+	// The first response of a type is sent even if empty, so that the client doesn't wait for the resources it
+	// already has or that don't exist.
+	if len(resp.Resources) == 0 && len(resp.RemovedResources) == 0 && !initial {
+		adsLog.Debugf("%s: SKIP DELTA for node:%s, no changes", v3.GetShortType(w.TypeUrl), con.proxy.ID)
+		if s.StatusReporter != nil {
+			s.StatusReporter.RegisterEvent(con.ConID, w.TypeUrl, push.LedgerVersion)
+		}
+		return nil
+	}
+
+	if err := con.sendDelta(resp); err != nil {
+		recordSendError(w.TypeUrl, con.ConID, err)
+		return err
+	}
+
+	// Some types handle logs inside Generate, skip them here
+	if _, f := SkipLogTypes[w.TypeUrl]; !f {
+		size := 0
+		for _, r := range resp.Resources {
+			size += len(r.Resource.Value)
+		}
+		adsLog.Infof("%s: PUSH DELTA for node:%s resources:%d removed:%d size:%s",
+			v3.GetShortType(w.TypeUrl), con.proxy.ID, len(resp.Resources), len(resp.RemovedResources), util.ByteCount(size))
+	}
+	return nil
+}
+
+// sendDelta sends a response to a client of the delta protocol, and records the versions of the resources it sent.
+func (conn *Connection) sendDelta(res *discovery.DeltaDiscoveryResponse) error {
+	err := conn.sendWithTimeout(func() error {
+		return conn.deltaStream.Send(res)
+	})
+	if err == nil {
+		sz := 0
+		for _, r := range res.Resources {
+			sz += len(r.Resource.Value)
+		}
+		conn.proxy.Lock()
+		w := conn.proxy.WatchedResources[res.TypeUrl]
+		if w == nil {
+			w = &model.WatchedResource{TypeUrl: res.TypeUrl}
+			conn.proxy.WatchedResources[res.TypeUrl] = w
+		}
+		if w.ResourceVersions == nil {
+			w.ResourceVersions = map[string]string{}
+		}
+		for _, r := range res.Resources {
+			w.ResourceVersions[r.Name] = r.Version
+		}
+		for _, name := range res.RemovedResources {
+			delete(w.ResourceVersions, name)
+		}
+		w.NonceSent = res.Nonce
+		w.VersionSent = res.SystemVersionInfo
+		w.LastSent = time.Now()
+		w.LastSize = sz
+		conn.proxy.Unlock()
+	}
+	return err
+}
+
+// resourceVersion returns the version of a resource sent to clients of the delta protocol, which is the hash of its
+// deterministic serialization. It doesn't change across restarts of istiod, so that reconnecting clients don't
+// receive the resources they already have again. The resources are serialized again, as the order of the entries of
+// their maps isn't stable otherwise.
+func resourceVersion(r *any.Any) string {
+	b := r.Value
+	if m, err := r.UnmarshalNew(); err == nil {
+		if deterministic, err := (proto.MarshalOptions{Deterministic: true}).Marshal(m); err == nil {
+			b = deterministic
+		}
+	}
+	h := fnv.New64a()
+	_, _ = h.Write(b)
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// resourceName returns the name of a resource of one of the namedTypes, read from its first field without
+// unmarshaling it.
+func resourceName(r *any.Any) string {
+	b := r.Value
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return ""
+		}
+		b = b[n:]
+		if num == 1 && typ == protowire.BytesType {
+			name, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return ""
+			}
+			return string(name)
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return ""
+		}
+		b = b[n:]
+	}
+	return ""
+}
@@ -0,0 +1,267 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xds_test
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/xds"
+	v3 "istio.io/istio/pilot/pkg/xds/v3"
+	"istio.io/istio/pkg/config/protocol"
+	"istio.io/istio/pkg/config/schema/gvk"
+)
+
+const (
+	deltaService = "delta.default.svc.cluster.local"
+	deltaCluster = "outbound|2080||delta.default.svc.cluster.local"
+)
+
+// deltaTest is a client of the delta ADS protocol
+type deltaTest struct {
+	t         *testing.T
+	stream    discovery.AggregatedDiscoveryService_DeltaAggregatedResourcesClient
+	responses chan *discovery.DeltaDiscoveryResponse
+}
+
+func connectDelta(t *testing.T, s *xds.FakeDiscoveryServer) *deltaTest {
+	conn, err := grpc.Dial("buffcon", grpc.WithInsecure(), grpc.WithBlock(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return s.Listener.Dial()
+	}))
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		_ = conn.Close()
+	})
+	stream, err := discovery.NewAggregatedDiscoveryServiceClient(conn).DeltaAggregatedResources(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &deltaTest{t: t, stream: stream, responses: make(chan *discovery.DeltaDiscoveryResponse, 10)}
+	go func() {
+		for {
+			resp, err := stream.Recv()
+			if err != nil {
+				close(d.responses)
+				return
+			}
+			d.responses <- resp
+		}
+	}()
+	return d
+}
+
+func (d *deltaTest) request(req *discovery.DeltaDiscoveryRequest) {
+	d.t.Helper()
+	req.Node = &core.Node{Id: "sidecar~1.1.1.1~test.default~default.svc.cluster.local"}
+	if err := d.stream.Send(req); err != nil {
+		d.t.Fatal(err)
+	}
+}
+
+func (d *deltaTest) ack(resp *discovery.DeltaDiscoveryResponse) {
+	d.t.Helper()
+	d.request(&discovery.DeltaDiscoveryRequest{TypeUrl: resp.TypeUrl, ResponseNonce: resp.Nonce})
+}
+
+func (d *deltaTest) expectResponse(typeURL string) *discovery.DeltaDiscoveryResponse {
+	d.t.Helper()
+	select {
+	case <-time.After(time.Second):
+		d.t.Fatalf("did not get %s response in time", typeURL)
+	case resp, ok := <-d.responses:
+		if !ok {
+			d.t.Fatalf("stream closed")
+		}
+		if resp.TypeUrl != typeURL {
+			d.t.Fatalf("expected %s response, got %s", typeURL, resp.TypeUrl)
+		}
+		return resp
+	}
+	return nil
+}
+
+func (d *deltaTest) expectNoResponse() {
+	d.t.Helper()
+	select {
+	case <-time.After(time.Millisecond * 100):
+	case resp := <-d.responses:
+		d.t.Fatalf("got unexpected response: %v", resp)
+	}
+}
+
+func resourceNames(resp *discovery.DeltaDiscoveryResponse) map[string]string {
+	names := map[string]string{}
+	for _, r := range resp.Resources {
+		names[r.Name] = r.Version
+	}
+	return names
+}
+
+func addDeltaService(s *xds.FakeDiscoveryServer) {
+	s.Discovery.MemRegistry.AddService(deltaService, &model.Service{
+		Hostname: deltaService,
+		Address:  "10.11.0.1",
+		Ports: []*model.Port{
+			{
+				Name:     "http-main",
+				Port:     2080,
+				Protocol: protocol.HTTP,
+			},
+		},
+		Attributes: model.ServiceAttributes{
+			Name:      "delta",
+			Namespace: "default",
+		},
+	})
+	s.Discovery.MemRegistry.SetEndpoints(deltaService, "default", newEndpointWithAccount("10.2.0.1", "hello-sa", "v1"))
+}
+
+func TestDeltaAds(t *testing.T) {
+	s := xds.NewFakeDiscoveryServer(t, xds.FakeOptions{})
+	addDeltaService(s)
+	s.Discovery.ConfigUpdate(&model.PushRequest{Full: true})
+	time.Sleep(time.Millisecond * 200)
+
+	d := connectDelta(t, s)
+
+	// Wildcard subscription to the clusters
+	d.request(&discovery.DeltaDiscoveryRequest{TypeUrl: v3.ClusterType})
+	cds := d.expectResponse(v3.ClusterType)
+	if _, f := resourceNames(cds)[deltaCluster]; !f {
+		t.Fatalf("expected cluster %s, got %v", deltaCluster, resourceNames(cds))
+	}
+	d.ack(cds)
+	d.expectNoResponse()
+
+	// On demand subscription to the endpoints of the cluster
+	d.request(&discovery.DeltaDiscoveryRequest{TypeUrl: v3.EndpointType, ResourceNamesSubscribe: []string{deltaCluster}})
+	eds := d.expectResponse(v3.EndpointType)
+	if names := resourceNames(eds); len(names) != 1 || names[deltaCluster] == "" {
+		t.Fatalf("expected endpoints of %s, got %v", deltaCluster, names)
+	}
+	d.ack(eds)
+	d.expectNoResponse()
+
+	// Unchanged resources are not sent again
+	s.Discovery.ConfigUpdate(&model.PushRequest{Full: true})
+	d.expectNoResponse()
+
+	// Only the changed endpoints are sent
+	s.Discovery.MemRegistry.SetEndpoints(deltaService, "default", newEndpointWithAccount("10.2.0.2", "hello-sa", "v1"))
+	eds2 := d.expectResponse(v3.EndpointType)
+	if names := resourceNames(eds2); len(names) != 1 || names[deltaCluster] == eds.Resources[0].Version {
+		t.Fatalf("expected new endpoints of %s, got %v", deltaCluster, names)
+	}
+	d.ack(eds2)
+
+	// Removed clusters are reported
+	s.Discovery.MemRegistry.RemoveService(deltaService)
+	s.Discovery.ConfigUpdate(&model.PushRequest{Full: true, ConfigsUpdated: map[model.ConfigKey]struct{}{
+		{Kind: gvk.ServiceEntry, Name: deltaService, Namespace: "default"}: {},
+	}})
+	removed := false
+	for !removed {
+		select {
+		case resp := <-d.responses:
+			if resp.TypeUrl == v3.ClusterType {
+				if !reflect.DeepEqual(resp.RemovedResources, []string{deltaCluster}) {
+					t.Fatalf("expected removal of %s, got %v", deltaCluster, resp.RemovedResources)
+				}
+				removed = true
+			}
+			d.ack(resp)
+		case <-time.After(time.Second):
+			t.Fatalf("cluster %s was not removed", deltaCluster)
+		}
+	}
+
+	// The endpoints of the removed cluster may be pushed too
+	for drained := false; !drained; {
+		select {
+		case resp := <-d.responses:
+			d.ack(resp)
+		case <-time.After(time.Millisecond * 100):
+			drained = true
+		}
+	}
+
+	// Unsubscribing doesn't trigger a response
+	d.request(&discovery.DeltaDiscoveryRequest{TypeUrl: v3.EndpointType, ResourceNamesUnsubscribe: []string{deltaCluster}})
+	d.expectNoResponse()
+}
+
+func TestDeltaAdsNack(t *testing.T) {
+	s := xds.NewFakeDiscoveryServer(t, xds.FakeOptions{})
+	d := connectDelta(t, s)
+
+	d.request(&discovery.DeltaDiscoveryRequest{TypeUrl: v3.ClusterType})
+	cds := d.expectResponse(v3.ClusterType)
+	d.request(&discovery.DeltaDiscoveryRequest{
+		TypeUrl:       v3.ClusterType,
+		ResponseNonce: cds.Nonce,
+		ErrorDetail:   &status.Status{Message: "Test request NACK"},
+	})
+	d.expectNoResponse()
+}
+
+func TestDeltaAdsReconnect(t *testing.T) {
+	s := xds.NewFakeDiscoveryServer(t, xds.FakeOptions{})
+	addDeltaService(s)
+	s.Discovery.ConfigUpdate(&model.PushRequest{Full: true})
+	time.Sleep(time.Millisecond * 200)
+
+	d := connectDelta(t, s)
+	d.request(&discovery.DeltaDiscoveryRequest{TypeUrl: v3.ClusterType})
+	cds := d.expectResponse(v3.ClusterType)
+	d.ack(cds)
+
+	// The client already has all the clusters, the response is empty
+	d2 := connectDelta(t, s)
+	d2.request(&discovery.DeltaDiscoveryRequest{TypeUrl: v3.ClusterType, InitialResourceVersions: resourceNames(cds)})
+	if resp := d2.expectResponse(v3.ClusterType); len(resp.Resources) != 0 || len(resp.RemovedResources) != 0 {
+		t.Fatalf("expected no changes, got %v", resp)
+	}
+
+	// Clusters the client doesn't have anymore are removed
+	versions := resourceNames(cds)
+	versions["outbound|8080||gone.default.svc.cluster.local"] = "1"
+	delete(versions, deltaCluster)
+	d3 := connectDelta(t, s)
+	d3.request(&discovery.DeltaDiscoveryRequest{TypeUrl: v3.ClusterType, InitialResourceVersions: versions})
+	resp := d3.expectResponse(v3.ClusterType)
+	if names := resourceNames(resp); len(names) != 1 || names[deltaCluster] == "" {
+		t.Fatalf("expected cluster %s, got %v", deltaCluster, names)
+	}
+	if !reflect.DeepEqual(resp.RemovedResources, []string{"outbound|8080||gone.default.svc.cluster.local"}) {
+		t.Fatalf("expected removal of the unknown cluster, got %v", resp.RemovedResources)
+	}
+
+	// State of the world clients are served alongside
+	ads := s.ConnectADS().WithType(v3.ClusterType)
+	ads.RequestResponseAck(nil)
+}
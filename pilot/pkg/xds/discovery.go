@@ -15,6 +15,7 @@
 package xds
 
 import (
+	"math"
 	"strconv"
 	"sync"
 	"time"
@@ -64,6 +65,18 @@ type debounceOptions struct {
 
 	// enableEDSDebounce indicates whether EDS pushes should be debounced.
 	enableEDSDebounce bool
+
+	// adaptive indicates whether the quiet period is chosen by a debounceWindow, for each type of push,
+	// rather than being debounceAfter.
+	adaptive bool
+
+	// adaptiveMin is the quiet period when the mesh is quiet. It is widened by this interval for each
+	// event per second.
+	adaptiveMin time.Duration
+
+	// adaptiveFullMax and adaptiveEDSMax are the maximum quiet periods of the full and EDS pushes.
+	adaptiveFullMax time.Duration
+	adaptiveEDSMax  time.Duration
 }
 
 // DiscoveryServer is Pilot's gRPC implementation for Envoy's xds APIs
@@ -138,6 +151,12 @@ type DiscoveryServer struct {
 
 	instanceID string
 
+	// plugins are the networking plugins of the ConfigGenerator, used to create generators that bypass the Cache
+	plugins []string
+
+	// dryRunLimit allows a single dry run at a time, as dry runs generate the configuration of many proxies
+	dryRunLimit chan struct{}
+
 	// Cache for XDS resources
 	Cache model.XdsCache
 }
@@ -170,6 +189,7 @@ func NewDiscoveryServer(env *model.Environment, plugins []string, instanceID str
 		ProxyNeedsPush:          DefaultProxyNeedsPush,
 		EndpointShardsByService: map[string]map[string]*EndpointShards{},
 		concurrentPushLimit:     make(chan struct{}, features.PushThrottle),
+		dryRunLimit:             make(chan struct{}, 1),
 		InboundUpdates:          atomic.NewInt64(0),
 		CommittedUpdates:        atomic.NewInt64(0),
 		pushChannel:             make(chan *model.PushRequest, 10),
@@ -180,9 +200,14 @@ func NewDiscoveryServer(env *model.Environment, plugins []string, instanceID str
 			debounceAfter:     features.DebounceAfter,
 			debounceMax:       features.DebounceMax,
 			enableEDSDebounce: features.EnableEDSDebounce.Get(),
+			adaptive:          features.EnableAdaptiveDebounce,
+			adaptiveMin:       features.AdaptiveDebounceMin,
+			adaptiveFullMax:   features.AdaptiveDebounceFullMax,
+			adaptiveEDSMax:    features.AdaptiveDebounceEDSMax,
 		},
 		Cache:      model.DisabledCache{},
 		instanceID: instanceID,
+		plugins:    plugins,
 	}
 
 	// Flush cached discovery responses when detecting jwt public key change.
@@ -357,6 +382,25 @@ func debounce(ch chan *model.PushRequest, stopCh <-chan struct{}, opts debounceO
 	free := true
 	freeCh := make(chan struct{}, 1)
 
+	// With adaptive debouncing, full and EDS pushes have their own quiet periods, as their costs differ.
+	fullWindow := newDebounceWindow(debounceWindowFull, opts.adaptiveMin, opts.adaptiveFullMax)
+	edsWindow := newDebounceWindow(debounceWindowEDS, opts.adaptiveMin, opts.adaptiveEDSMax)
+	windowFor := func(full bool) *debounceWindow {
+		if full {
+			return fullWindow
+		}
+		return edsWindow
+	}
+	quietPeriod := func(now time.Time) time.Duration {
+		if !opts.adaptive {
+			return opts.debounceAfter
+		}
+		return windowFor(req != nil && req.Full).quietPeriod(now)
+	}
+	// computeStart is when the computation of the current push started
+	var computeStart time.Time
+	var pushFull bool
+
 	push := func(req *model.PushRequest, debouncedEvents int) {
 		pushFn(req)
 		updateSent.Add(int64(debouncedEvents))
@@ -364,10 +408,12 @@ func debounce(ch chan *model.PushRequest, stopCh <-chan struct{}, opts debounceO
 	}
 
 	pushWorker := func() {
-		eventDelay := time.Since(startDebounce)
-		quietTime := time.Since(lastConfigUpdateTime)
+		now := time.Now()
+		eventDelay := now.Sub(startDebounce)
+		quietTime := now.Sub(lastConfigUpdateTime)
+		debounceAfter := quietPeriod(now)
 		// it has been too long or quiet enough
-		if eventDelay >= opts.debounceMax || quietTime >= opts.debounceAfter {
+		if eventDelay >= opts.debounceMax || quietTime >= debounceAfter {
 			if req != nil {
 				pushCounter++
 				adsLog.Infof("Push debounce stable[%d] %d: %v since last change, %v since last push, full=%v",
@@ -375,12 +421,14 @@ func debounce(ch chan *model.PushRequest, stopCh <-chan struct{}, opts debounceO
 					quietTime, eventDelay, req.Full)
 
 				free = false
+				computeStart = now
+				pushFull = req.Full
 				go push(req, debouncedEvents)
 				req = nil
 				debouncedEvents = 0
 			}
 		} else {
-			timeChan = time.After(opts.debounceAfter - quietTime)
+			timeChan = time.After(debounceAfter - quietTime)
 		}
 	}
 
@@ -388,6 +436,9 @@ func debounce(ch chan *model.PushRequest, stopCh <-chan struct{}, opts debounceO
 		select {
 		case <-freeCh:
 			free = true
+			if opts.adaptive {
+				windowFor(pushFull).computed(time.Since(computeStart))
+			}
 			pushWorker()
 		case r := <-ch:
 			// If reason is not set, record it as an unknown reason
@@ -401,13 +452,15 @@ func debounce(ch chan *model.PushRequest, stopCh <-chan struct{}, opts debounceO
 			}
 
 			lastConfigUpdateTime = time.Now()
+			if opts.adaptive {
+				windowFor(r.Full).event(lastConfigUpdateTime)
+			}
+			req = req.Merge(r)
 			if debouncedEvents == 0 {
-				timeChan = time.After(opts.debounceAfter)
+				timeChan = time.After(quietPeriod(lastConfigUpdateTime))
 				startDebounce = lastConfigUpdateTime
 			}
 			debouncedEvents++
-
-			req = req.Merge(r)
 		case <-timeChan:
 			if free {
 				pushWorker()
@@ -418,6 +471,70 @@ func debounce(ch chan *model.PushRequest, stopCh <-chan struct{}, opts debounceO
 	}
 }
 
+const (
+	debounceWindowFull = "full"
+	debounceWindowEDS  = "eds"
+
+	// debounceRateDecay is the time constant of the event rate. Events older than a few times this
+	// constant barely count, so the quiet period shrinks back within seconds once a storm is over.
+	debounceRateDecay = time.Second
+)
+
+// debounceWindow chooses the quiet period of the adaptive debouncing for a type of push. The quiet period is
+// widened by minQuiet for each event per second, so that storms of events are batched into fewer pushes, and is at
+// least the time taken to compute the last push, so that pushes do not follow each other faster than istiod computes
+// them. The computation of a push covers the initialization of its PushContext and the queueing of the proxies, but
+// not the time taken to send the configuration to the proxies, as the push queue sends it concurrently.
+type debounceWindow struct {
+	pushType string
+	minQuiet time.Duration
+	maxQuiet time.Duration
+
+	// rate is the exponentially decaying average of the events per second, as of lastEvent
+	rate      float64
+	lastEvent time.Time
+	// lastCompute is the time taken to compute the last push
+	lastCompute time.Duration
+}
+
+func newDebounceWindow(pushType string, minQuiet, maxQuiet time.Duration) *debounceWindow {
+	return &debounceWindow{pushType: pushType, minQuiet: minQuiet, maxQuiet: maxQuiet}
+}
+
+// event records an event received at the given time.
+func (w *debounceWindow) event(now time.Time) {
+	w.rate = w.rateAt(now) + 1/debounceRateDecay.Seconds()
+	w.lastEvent = now
+}
+
+// computed records the time taken to compute a push.
+func (w *debounceWindow) computed(d time.Duration) {
+	w.lastCompute = d
+}
+
+func (w *debounceWindow) rateAt(now time.Time) float64 {
+	if w.lastEvent.IsZero() {
+		return 0
+	}
+	return w.rate * math.Exp(-now.Sub(w.lastEvent).Seconds()/debounceRateDecay.Seconds())
+}
+
+// quietPeriod returns the quiet period to wait for at the given time, and records it.
+func (w *debounceWindow) quietPeriod(now time.Time) time.Duration {
+	quiet := time.Duration(w.rateAt(now) * float64(w.minQuiet))
+	if w.lastCompute > quiet {
+		quiet = w.lastCompute
+	}
+	if quiet < w.minQuiet {
+		quiet = w.minQuiet
+	}
+	if quiet > w.maxQuiet {
+		quiet = w.maxQuiet
+	}
+	debounceQuietPeriod.With(typeTag.Value(w.pushType)).Record(quiet.Seconds())
+	return quiet
+}
+
 func doSendPushes(stopCh <-chan struct{}, semaphore chan struct{}, queue *PushQueue) {
 	for {
 		select {
@@ -451,7 +568,7 @@ func doSendPushes(stopCh <-chan struct{}, semaphore chan struct{}, queue *PushQu
 				select {
 				case client.pushChannel <- pushEv:
 					return
-				case <-client.stream.Context().Done(): // grpc stream was closed
+				case <-client.streamContext().Done(): // grpc stream was closed
 					doneFunc()
 					adsLog.Infof("Client closed connection %v", client.ConID)
 				}
@@ -555,7 +672,8 @@ func (s *DiscoveryServer) AllClients() []*Connection {
 func (s *DiscoveryServer) SendResponse(res *discovery.DiscoveryResponse) {
 	pending := []*Connection{}
 	for _, v := range s.Clients() {
-		if v.Watching(res.TypeUrl) {
+		// internal events are only sent to clients of the state of the world protocol
+		if v.stream != nil && v.Watching(res.TypeUrl) {
 			pending = append(pending, v)
 		}
 	}
@@ -24,11 +24,15 @@ import (
 	"time"
 
 	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/golang/protobuf/ptypes/any"
 	uatomic "go.uber.org/atomic"
 	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
 
 	"istio.io/istio/pilot/pkg/model"
 	v3 "istio.io/istio/pilot/pkg/xds/v3"
+	"istio.io/istio/pkg/config/schema/gvk"
 	"istio.io/istio/pkg/test/util/retry"
 	"istio.io/istio/tests/util/leak"
 )
@@ -191,11 +195,15 @@ func TestDebounce(t *testing.T) {
 		debounceAfter:     time.Millisecond * 50,
 		debounceMax:       time.Millisecond * 100,
 		enableEDSDebounce: false,
+		adaptiveMin:       time.Millisecond * 10,
+		adaptiveFullMax:   time.Millisecond * 100,
+		adaptiveEDSMax:    time.Millisecond * 100,
 	}
 
 	tests := []struct {
-		name string
-		test func(updateCh chan *model.PushRequest, expect func(partial, full int32))
+		name     string
+		adaptive bool
+		test     func(updateCh chan *model.PushRequest, expect func(partial, full int32))
 	}{
 		{
 			name: "Should not debounce partial pushes",
@@ -263,10 +271,27 @@ func TestDebounce(t *testing.T) {
 				expect(0, 2)
 			},
 		},
+		{
+			name:     "Should widen the quiet period after a push that is long to compute",
+			adaptive: true,
+			test: func(updateCh chan *model.PushRequest, expect func(partial, full int32)) {
+				// the mesh is quiet, so the first push follows after adaptiveMin
+				updateCh <- &model.PushRequest{Full: true}
+				expect(0, 1)
+				// wait for the push to be computed, which takes longer than adaptiveFullMax
+				time.Sleep(opts.debounceMax*2 + 10*time.Millisecond)
+				updateCh <- &model.PushRequest{Full: true}
+				time.Sleep(opts.adaptiveFullMax / 2)
+				expect(0, 1)
+				expect(0, 2)
+			},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			opts := opts
+			opts.adaptive = tt.adaptive
 			stopCh := make(chan struct{})
 			updateCh := make(chan *model.PushRequest)
 			pushingCh := make(chan struct{}, 1)
@@ -482,3 +507,154 @@ func TestShouldRespond(t *testing.T) {
 		})
 	}
 }
+
+func TestDebounceWindow(t *testing.T) {
+	start := time.Now()
+	w := newDebounceWindow(debounceWindowFull, 10*time.Millisecond, time.Second)
+
+	if got := w.quietPeriod(start); got != 10*time.Millisecond {
+		t.Fatalf("expected the minimum quiet period without events, got %v", got)
+	}
+
+	// A storm of 50 events per second for a few seconds
+	now := start
+	for i := 0; i < 150; i++ {
+		now = now.Add(20 * time.Millisecond)
+		w.event(now)
+	}
+	if got := w.quietPeriod(now); got < 400*time.Millisecond || got > 500*time.Millisecond {
+		t.Fatalf("expected the quiet period to widen with the event rate, got %v", got)
+	}
+
+	// A push that is long to compute widens the quiet period to its duration
+	w.computed(700 * time.Millisecond)
+	if got := w.quietPeriod(now); got != 700*time.Millisecond {
+		t.Fatalf("expected the quiet period to be the push computation time, got %v", got)
+	}
+	w.computed(5 * time.Second)
+	if got := w.quietPeriod(now); got != time.Second {
+		t.Fatalf("expected the maximum quiet period, got %v", got)
+	}
+
+	// Once the mesh is quiet again, the quiet period shrinks back
+	w.computed(time.Millisecond)
+	if got := w.quietPeriod(now.Add(10 * time.Second)); got != 10*time.Millisecond {
+		t.Fatalf("expected the minimum quiet period once quiet, got %v", got)
+	}
+}
+
+func TestDeltaWatchedResource(t *testing.T) {
+	const (
+		cluster1 = "outbound|80||a.default.svc.cluster.local"
+		cluster2 = "outbound|80||b.default.svc.cluster.local"
+		cluster3 = "outbound|80||c.default.svc.cluster.local"
+	)
+	eds := &model.WatchedResource{
+		TypeUrl:          v3.EndpointType,
+		ResourceNames:    []string{cluster1, cluster2, cluster3},
+		NonceSent:        "nonce",
+		ResourceVersions: map[string]string{cluster1: "1", cluster2: "2"},
+	}
+	tests := []struct {
+		name     string
+		w        *model.WatchedResource
+		req      *model.PushRequest
+		expected []string
+	}{
+		{
+			name: "wildcard type",
+			w: &model.WatchedResource{
+				TypeUrl:          v3.ClusterType,
+				Wildcard:         true,
+				NonceSent:        "nonce",
+				ResourceVersions: map[string]string{cluster1: "1"},
+			},
+			req:      &model.PushRequest{Full: true},
+			expected: nil,
+		},
+		{
+			name: "initial push",
+			w: &model.WatchedResource{
+				TypeUrl:          v3.EndpointType,
+				ResourceNames:    []string{cluster1, cluster2},
+				ResourceVersions: map[string]string{cluster1: "1"},
+			},
+			req:      &model.PushRequest{Full: true, Reason: []model.TriggerReason{model.ProxyRequest}},
+			expected: []string{cluster1, cluster2},
+		},
+		{
+			name:     "subscription",
+			w:        eds,
+			req:      &model.PushRequest{Full: true, Reason: []model.TriggerReason{model.ProxyRequest}},
+			expected: []string{cluster3},
+		},
+		{
+			name: "updated service",
+			w:    eds,
+			req: &model.PushRequest{ConfigsUpdated: map[model.ConfigKey]struct{}{
+				{Kind: gvk.ServiceEntry, Name: "b.default.svc.cluster.local", Namespace: "default"}: {},
+			}},
+			expected: []string{cluster2, cluster3},
+		},
+		{
+			name: "updated config",
+			w:    eds,
+			req: &model.PushRequest{Full: true, ConfigsUpdated: map[model.ConfigKey]struct{}{
+				{Kind: gvk.ServiceEntry, Name: "b.default.svc.cluster.local", Namespace: "default"}: {},
+				{Kind: gvk.DestinationRule, Name: "a", Namespace: "default"}:                        {},
+			}},
+			expected: []string{cluster1, cluster2, cluster3},
+		},
+		{
+			name:     "full push",
+			w:        eds,
+			req:      &model.PushRequest{Full: true},
+			expected: []string{cluster1, cluster2, cluster3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generated := deltaWatchedResource(&model.Proxy{}, tt.w, tt.req)
+			if generated == nil {
+				t.Fatalf("expected resources to be generated")
+			}
+			if !reflect.DeepEqual(generated.ResourceNames, tt.expected) {
+				t.Fatalf("expected %v to be generated, got %v", tt.expected, generated.ResourceNames)
+			}
+			if !reflect.DeepEqual(generated.ResourceVersions, tt.w.ResourceVersions) {
+				t.Fatalf("expected versions %v, got %v", tt.w.ResourceVersions, generated.ResourceVersions)
+			}
+		})
+	}
+
+	// nothing is generated if the client already has all the resources it subscribed to
+	w := &model.WatchedResource{
+		TypeUrl:          v3.EndpointType,
+		ResourceNames:    []string{cluster1},
+		NonceSent:        "nonce",
+		ResourceVersions: map[string]string{cluster1: "1"},
+	}
+	if generated := deltaWatchedResource(&model.Proxy{}, w, &model.PushRequest{Full: true, Reason: []model.TriggerReason{model.ProxyRequest}}); generated != nil {
+		t.Fatalf("expected nothing to be generated, got %v", generated.ResourceNames)
+	}
+}
+
+func TestResourceVersion(t *testing.T) {
+	field := func(name string) []byte {
+		b, err := proto.Marshal(&structpb.Struct{Fields: map[string]*structpb.Value{name: structpb.NewBoolValue(true)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	// both are serializations of the same struct, with its map entries in different orders
+	ab := &any.Any{TypeUrl: "type.googleapis.com/google.protobuf.Struct", Value: append(field("a"), field("b")...)}
+	ba := &any.Any{TypeUrl: "type.googleapis.com/google.protobuf.Struct", Value: append(field("b"), field("a")...)}
+	if resourceVersion(ab) != resourceVersion(ba) {
+		t.Fatalf("expected the versions of equal resources to match: %s != %s", resourceVersion(ab), resourceVersion(ba))
+	}
+	c := &any.Any{TypeUrl: "type.googleapis.com/google.protobuf.Struct", Value: append(field("a"), field("c")...)}
+	if resourceVersion(ab) == resourceVersion(c) {
+		t.Fatalf("expected the versions of different resources to differ")
+	}
+}
@@ -0,0 +1,326 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"istio.io/istio/pilot/pkg/config/kube/crd"
+	"istio.io/istio/pilot/pkg/features"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/networking/core"
+	"istio.io/istio/pilot/pkg/util/sets"
+	"istio.io/istio/pilot/pkg/xds/dryrun"
+	"istio.io/istio/pkg/config"
+	"istio.io/istio/pkg/config/labels"
+	"istio.io/istio/pkg/config/schema/collection"
+	"istio.io/istio/pkg/config/schema/gvk"
+)
+
+// maxDryRunConfigSize is the maximum size of the candidate configs of a dry run.
+const maxDryRunConfigSize = 10 * 1024 * 1024
+
+// dryRunKinds are the kinds of config that can be evaluated by a dry run. Other kinds, like ServiceEntries, reach
+// the PushContext through the service registries rather than the config store.
+var dryRunKinds = map[config.GroupVersionKind]struct{}{
+	gvk.VirtualService:        {},
+	gvk.DestinationRule:       {},
+	gvk.Gateway:               {},
+	gvk.Sidecar:               {},
+	gvk.EnvoyFilter:           {},
+	gvk.AuthorizationPolicy:   {},
+	gvk.PeerAuthentication:    {},
+	gvk.RequestAuthentication: {},
+}
+
+// DryRunResponse is the result of the evaluation of candidate configs against the proxies connected to istiod.
+type DryRunResponse struct {
+	// Configs are the candidate configs, as kind/namespace/name
+	Configs []string `json:"configs"`
+	// Proxies is the number of proxies whose configuration was evaluated
+	Proxies int `json:"proxies"`
+	// Changes are the groups of proxies whose configuration would change, sorted by the first proxy ID
+	Changes []ProxyConfigDiff `json:"changes"`
+}
+
+// ProxyConfigDiff is the difference between the current configuration of a group of proxies and the configuration
+// they would get if the candidate configs were applied. Proxies are grouped by SidecarScope and workload labels, and
+// the configuration of a single proxy of each group is generated.
+type ProxyConfigDiff struct {
+	// Proxies are the IDs of the proxies of the group, sorted
+	Proxies []string `json:"proxies"`
+	// SidecarScope is the namespace/name of the SidecarScope the proxies would use
+	SidecarScope string `json:"sidecarScope,omitempty"`
+	dryrun.ProxyConfigDiff
+}
+
+// dryrunz evaluates the candidate configs posted as YAML, returning the proxies whose clusters, listeners or routes
+// would change if they were applied. The namespace parameter is the default namespace of the configs, and the
+// proxies can be restricted with the proxyID and proxyNamespace parameters.
+func (s *DiscoveryServer) dryrunz(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = w.Write([]byte("The candidate configs must be posted as YAML"))
+		return
+	}
+	select {
+	case s.dryRunLimit <- struct{}{}:
+		defer func() { <-s.dryRunLimit }()
+	default:
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("Another dry run is in progress"))
+		return
+	}
+	_ = req.ParseForm()
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxDryRunConfigSize))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprintf(w, "unable to read the candidate configs: %v", err)
+		return
+	}
+	candidates, err := parseDryRunConfigs(string(body), req.Form.Get("namespace"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+
+	out, err := s.dryRun(req.Context(), candidates, req.Form.Get("proxyID"), req.Form.Get("proxyNamespace"))
+	if err != nil {
+		if errors.Is(err, errDryRunTooManyProxies) {
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		_, _ = fmt.Fprintf(w, "unable to evaluate the candidate configs: %v", err)
+		return
+	}
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = fmt.Fprintf(w, "unable to marshal dry run result: %v", err)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
+
+// parseDryRunConfigs parses and validates the candidate configs, defaulting their namespace.
+func parseDryRunConfigs(input, defaultNamespace string) ([]config.Config, error) {
+	configs, others, err := crd.ParseInputs(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(others) > 0 {
+		return nil, fmt.Errorf("unsupported kind %s of %s", others[0].Kind, others[0].Name)
+	}
+	if len(configs) == 0 {
+		return nil, errors.New("no candidate configs were provided")
+	}
+	if defaultNamespace == "" {
+		defaultNamespace = "default"
+	}
+	for i := range configs {
+		if _, f := dryRunKinds[configs[i].GroupVersionKind]; !f {
+			return nil, fmt.Errorf("%s %s cannot be evaluated, only VirtualService, DestinationRule, Gateway, Sidecar, "+
+				"EnvoyFilter and security policies are supported", configs[i].GroupVersionKind.Kind, configs[i].Name)
+		}
+		if configs[i].Namespace == "" {
+			configs[i].Namespace = defaultNamespace
+		}
+	}
+	return configs, nil
+}
+
+var errDryRunTooManyProxies = errors.New("too many proxies")
+
+// dryRunGroup is a group of proxies that are generated the same configuration, other than their addresses.
+type dryRunGroup struct {
+	// proxy is the proxy whose configuration is generated for the group
+	proxy *model.Proxy
+	ids   []string
+}
+
+// dryRunGroupKey returns the key of the group of the proxy. The configuration of a proxy is selected by its type,
+// namespace, SidecarScope and the workload selectors of policies, so proxies of the same workload share it.
+func dryRunGroupKey(proxy *model.Proxy) string {
+	key := []string{string(proxy.Type), proxy.ConfigNamespace, proxy.Metadata.IstioVersion}
+	if scope := proxy.SidecarScope; scope != nil {
+		key = append(key, scope.Namespace+"/"+scope.Name)
+	}
+	return strings.Join(append(key, labels.Instance(proxy.Metadata.Labels).String()), "~")
+}
+
+// dryRun builds a speculative PushContext from the current one with the candidate configs applied, and compares
+// the configuration of the connected proxies generated from both.
+func (s *DiscoveryServer) dryRun(ctx context.Context, candidates []config.Config, proxyID, proxyNamespace string) (*DryRunResponse, error) {
+	current := s.globalPushContext()
+	pushReq := &model.PushRequest{Full: true, ConfigsUpdated: map[model.ConfigKey]struct{}{}}
+	out := &DryRunResponse{Changes: []ProxyConfigDiff{}}
+	for _, cfg := range candidates {
+		pushReq.ConfigsUpdated[model.ConfigKey{Kind: cfg.GroupVersionKind, Name: cfg.Name, Namespace: cfg.Namespace}] = struct{}{}
+		out.Configs = append(out.Configs, cfg.GroupVersionKind.Kind+"/"+cfg.Namespace+"/"+cfg.Name)
+	}
+
+	groups := map[string]*dryRunGroup{}
+	for _, con := range s.Clients() {
+		proxy := con.proxy
+		// proxyID is either the ID of the proxy, i.e. name.namespace, or its name
+		if proxyID != "" && proxy.ID != proxyID && !strings.HasPrefix(proxy.ID, proxyID+".") {
+			continue
+		}
+		if proxyNamespace != "" && proxy.ConfigNamespace != proxyNamespace {
+			continue
+		}
+		out.Proxies++
+		key := dryRunGroupKey(proxy)
+		if group, f := groups[key]; f {
+			group.ids = append(group.ids, proxy.ID)
+		} else {
+			groups[key] = &dryRunGroup{proxy: proxy, ids: []string{proxy.ID}}
+		}
+	}
+	if len(groups) > features.DryRunMaxProxyGroups {
+		return nil, fmt.Errorf("%w: %d groups of proxies would be evaluated, more than the maximum of %d, use the "+
+			"proxyNamespace or proxyID parameters to select fewer proxies", errDryRunTooManyProxies, len(groups), features.DryRunMaxProxyGroups)
+	}
+	if len(groups) == 0 {
+		return out, nil
+	}
+
+	env := *s.Env
+	env.IstioConfigStore = model.MakeIstioStore(newOverlayConfigStore(s.Env.IstioConfigStore, candidates))
+	env.PushContext = nil
+	speculative := model.NewPushContext()
+	if err := speculative.InitContext(&env, current, pushReq); err != nil {
+		return nil, err
+	}
+
+	// The XdsCache holds the configuration of the current PushContext, which must neither be served for the
+	// speculative one, nor be replaced by it.
+	generator := core.NewConfigGenerator(s.plugins, model.DisabledCache{})
+	for _, group := range groups {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		currentConfig, err := dryrun.GenerateProxyConfig(generator, group.proxy.CopyForPush(current), current)
+		if err != nil {
+			return nil, fmt.Errorf("proxy %s: %v", group.proxy.ID, err)
+		}
+		speculativeProxy := group.proxy.CopyForPush(speculative)
+		speculativeConfig, err := dryrun.GenerateProxyConfig(generator, speculativeProxy, speculative)
+		if err != nil {
+			return nil, fmt.Errorf("proxy %s: %v", group.proxy.ID, err)
+		}
+		diff := currentConfig.Diff(speculativeConfig)
+		if diff == nil {
+			continue
+		}
+		sort.Strings(group.ids)
+		change := ProxyConfigDiff{Proxies: group.ids, ProxyConfigDiff: *diff}
+		if scope := speculativeProxy.SidecarScope; scope != nil {
+			change.SidecarScope = scope.Namespace + "/" + scope.Name
+		}
+		out.Changes = append(out.Changes, change)
+	}
+	sort.Slice(out.Changes, func(i, j int) bool { return out.Changes[i].Proxies[0] < out.Changes[j].Proxies[0] })
+	return out, nil
+}
+
+var errDryRunReadOnly = errors.New("unsupported operation: the dry run config store is read-only")
+
+// overlayConfigStore is a read-only view of a config store with candidate configs created or replacing the
+// existing ones.
+type overlayConfigStore struct {
+	model.ConfigStore
+	// overlay holds the candidate configs by kind, then by namespace/name
+	overlay map[config.GroupVersionKind]map[string]config.Config
+}
+
+func newOverlayConfigStore(store model.ConfigStore, candidates []config.Config) model.ConfigStore {
+	overlay := map[config.GroupVersionKind]map[string]config.Config{}
+	for _, cfg := range candidates {
+		if overlay[cfg.GroupVersionKind] == nil {
+			overlay[cfg.GroupVersionKind] = map[string]config.Config{}
+		}
+		overlay[cfg.GroupVersionKind][cfg.Namespace+"/"+cfg.Name] = cfg
+	}
+	return &overlayConfigStore{ConfigStore: store, overlay: overlay}
+}
+
+func (o *overlayConfigStore) Schemas() collection.Schemas {
+	return o.ConfigStore.Schemas()
+}
+
+func (o *overlayConfigStore) Get(typ config.GroupVersionKind, name, namespace string) *config.Config {
+	if cfg, f := o.overlay[typ][namespace+"/"+name]; f {
+		return &cfg
+	}
+	return o.ConfigStore.Get(typ, name, namespace)
+}
+
+func (o *overlayConfigStore) List(typ config.GroupVersionKind, namespace string) ([]config.Config, error) {
+	configs, err := o.ConfigStore.List(typ, namespace)
+	if err != nil || len(o.overlay[typ]) == 0 {
+		return configs, err
+	}
+	out := make([]config.Config, 0, len(configs)+len(o.overlay[typ]))
+	replaced := sets.NewSet()
+	for _, cfg := range configs {
+		key := cfg.Namespace + "/" + cfg.Name
+		if candidate, f := o.overlay[typ][key]; f {
+			// Keep the creation time, which orders the configs, and the revision of the existing config
+			candidate.CreationTimestamp = cfg.CreationTimestamp
+			candidate.ResourceVersion = cfg.ResourceVersion
+			out = append(out, candidate)
+			replaced.Insert(key)
+			continue
+		}
+		out = append(out, cfg)
+	}
+	for key, candidate := range o.overlay[typ] {
+		if !replaced.Contains(key) && (namespace == model.NamespaceAll || candidate.Namespace == namespace) {
+			out = append(out, candidate)
+		}
+	}
+	return out, nil
+}
+
+func (o *overlayConfigStore) Create(config.Config) (string, error) {
+	return "", errDryRunReadOnly
+}
+
+func (o *overlayConfigStore) Update(config.Config) (string, error) {
+	return "", errDryRunReadOnly
+}
+
+func (o *overlayConfigStore) UpdateStatus(config.Config) (string, error) {
+	return "", errDryRunReadOnly
+}
+
+func (o *overlayConfigStore) Patch(config.Config, config.PatchFunc) (string, error) {
+	return "", errDryRunReadOnly
+}
+
+func (o *overlayConfigStore) Delete(config.GroupVersionKind, string, string, *string) error {
+	return errDryRunReadOnly
+}
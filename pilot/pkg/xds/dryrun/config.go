@@ -0,0 +1,161 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dryrun generates the configuration of proxies and compares it, to evaluate the changes that candidate
+// configs would make before they are applied.
+package dryrun
+
+import (
+	"sort"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/networking/core"
+)
+
+// ProxyConfig is the configuration generated for a proxy.
+type ProxyConfig struct {
+	Listeners []*listener.Listener
+	Clusters  []*cluster.Cluster
+	// Routes are the route configurations referenced by the listeners
+	Routes []*route.RouteConfiguration
+}
+
+// GenerateProxyConfig generates the listeners, clusters and routes of the proxy
+// for the PushContext.
+func GenerateProxyConfig(cg core.ConfigGenerator, proxy *model.Proxy, push *model.PushContext) (*ProxyConfig, error) {
+	cfg := &ProxyConfig{
+		Listeners: cg.BuildListeners(proxy, push),
+	}
+	for _, r := range cg.BuildClusters(proxy, push) {
+		c := &cluster.Cluster{}
+		if err := ptypes.UnmarshalAny(r, c); err != nil {
+			return nil, err
+		}
+		cfg.Clusters = append(cfg.Clusters, c)
+	}
+	for _, r := range cg.BuildHTTPRoutes(proxy, push, routeNames(cfg.Listeners)) {
+		rc := &route.RouteConfiguration{}
+		if err := ptypes.UnmarshalAny(r, rc); err != nil {
+			return nil, err
+		}
+		cfg.Routes = append(cfg.Routes, rc)
+	}
+	return cfg, nil
+}
+
+// routeNames returns the names of the route configurations referenced by the
+// HTTP connection managers of the listeners.
+func routeNames(listeners []*listener.Listener) []string {
+	var names []string
+	for _, l := range listeners {
+		for _, fc := range l.FilterChains {
+			for _, filter := range fc.Filters {
+				if filter.Name != wellknown.HTTPConnectionManager {
+					continue
+				}
+				h := &hcm.HttpConnectionManager{}
+				if err := ptypes.UnmarshalAny(filter.GetTypedConfig(), h); err != nil {
+					continue
+				}
+				if rds := h.GetRds(); rds != nil {
+					names = append(names, rds.RouteConfigName)
+				}
+			}
+		}
+	}
+	return names
+}
+
+// ProxyConfigDiff lists the resources of each type that differ between two
+// configurations of a proxy.
+type ProxyConfigDiff struct {
+	Clusters  *ResourceDiff `json:"clusters,omitempty"`
+	Listeners *ResourceDiff `json:"listeners,omitempty"`
+	Routes    *ResourceDiff `json:"routes,omitempty"`
+}
+
+// ResourceDiff lists the names of the resources of a type that were added,
+// removed or modified.
+type ResourceDiff struct {
+	Added    []string `json:"added,omitempty"`
+	Removed  []string `json:"removed,omitempty"`
+	Modified []string `json:"modified,omitempty"`
+}
+
+// Diff compares the configuration with another configuration of the proxy,
+// returning nil if they are the same.
+func (cfg *ProxyConfig) Diff(other *ProxyConfig) *ProxyConfigDiff {
+	clusters := func(cfg *ProxyConfig) map[string]proto.Message {
+		out := make(map[string]proto.Message, len(cfg.Clusters))
+		for _, c := range cfg.Clusters {
+			out[c.Name] = c
+		}
+		return out
+	}
+	listeners := func(cfg *ProxyConfig) map[string]proto.Message {
+		out := make(map[string]proto.Message, len(cfg.Listeners))
+		for _, l := range cfg.Listeners {
+			out[l.Name] = l
+		}
+		return out
+	}
+	routes := func(cfg *ProxyConfig) map[string]proto.Message {
+		out := make(map[string]proto.Message, len(cfg.Routes))
+		for _, rc := range cfg.Routes {
+			out[rc.Name] = rc
+		}
+		return out
+	}
+	diff := &ProxyConfigDiff{
+		Clusters:  diffResources(clusters(cfg), clusters(other)),
+		Listeners: diffResources(listeners(cfg), listeners(other)),
+		Routes:    diffResources(routes(cfg), routes(other)),
+	}
+	if diff.Clusters == nil && diff.Listeners == nil && diff.Routes == nil {
+		return nil
+	}
+	return diff
+}
+
+// diffResources compares resources by name, returning nil if they are the same.
+func diffResources(current, other map[string]proto.Message) *ResourceDiff {
+	diff := &ResourceDiff{}
+	for name, r := range other {
+		if cur, f := current[name]; !f {
+			diff.Added = append(diff.Added, name)
+		} else if !proto.Equal(cur, r) {
+			diff.Modified = append(diff.Modified, name)
+		}
+	}
+	for name := range current {
+		if _, f := other[name]; !f {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+	if len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Modified) == 0 {
+		return nil
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Modified)
+	return diff
+}
@@ -0,0 +1,218 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xds
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"istio.io/istio/pilot/pkg/features"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/xds/dryrun"
+	v3 "istio.io/istio/pilot/pkg/xds/v3"
+)
+
+const dryRunConfig = `
+apiVersion: networking.istio.io/v1alpha3
+kind: ServiceEntry
+metadata:
+  name: se
+  namespace: default
+spec:
+  hosts:
+  - example.com
+  ports:
+  - number: 80
+    name: http
+    protocol: HTTP
+  resolution: STATIC
+  endpoints:
+  - address: 1.1.1.1
+`
+
+func dryRun(t *testing.T, s *DiscoveryServer, method, query, body string) (int, *DryRunResponse) {
+	t.Helper()
+	req := httptest.NewRequest(method, "/debug/dryrunz"+query, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/yaml")
+	rr := httptest.NewRecorder()
+	s.dryrunz(rr, req)
+	if rr.Code != http.StatusOK {
+		return rr.Code, nil
+	}
+	out := &DryRunResponse{}
+	if err := json.Unmarshal(rr.Body.Bytes(), out); err != nil {
+		t.Fatal(err)
+	}
+	return rr.Code, out
+}
+
+func TestDryRun(t *testing.T) {
+	s := NewFakeDiscoveryServer(t, FakeOptions{ConfigString: dryRunConfig})
+	// proxies of the same workload share their configuration
+	s.Connect(nil, nil, []string{v3.ClusterType})
+	s.Connect(&model.Proxy{IPAddresses: []string{"1.1.1.2"}}, nil, []string{v3.ClusterType})
+	s.Connect(&model.Proxy{ConfigNamespace: "other", IPAddresses: []string{"1.1.1.3"}}, nil, []string{v3.ClusterType})
+	proxies := func(changes []ProxyConfigDiff) [][]string {
+		out := [][]string{}
+		for _, change := range changes {
+			out = append(out, change.Proxies)
+		}
+		return out
+	}
+
+	t.Run("subsets", func(t *testing.T) {
+		_, got := dryRun(t, s.Discovery, http.MethodPost, "", `
+apiVersion: networking.istio.io/v1alpha3
+kind: DestinationRule
+metadata:
+  name: dr
+spec:
+  host: example.com
+  subsets:
+  - name: v1
+    labels:
+      version: v1
+`)
+		if got == nil || got.Proxies != 3 {
+			t.Fatalf("expected 3 proxies to be evaluated, got %+v", got)
+		}
+		if want := [][]string{{"test-1.default", "test-1.default"}, {"test-1.other"}}; !reflect.DeepEqual(proxies(got.Changes), want) {
+			t.Fatalf("expected the configuration of proxies %v to change, got %+v", want, got.Changes)
+		}
+		if want := []string{"DestinationRule/default/dr"}; !reflect.DeepEqual(got.Configs, want) {
+			t.Errorf("expected configs %v, got %v", want, got.Configs)
+		}
+		// the metadata of the default cluster refers to the DestinationRule
+		want := &dryrun.ResourceDiff{Added: []string{"outbound|80|v1|example.com"}, Modified: []string{"outbound|80||example.com"}}
+		for _, change := range got.Changes {
+			if !reflect.DeepEqual(change.Clusters, want) {
+				t.Errorf("expected clusters diff %+v, got %+v", want, change.Clusters)
+			}
+			if change.Listeners != nil || change.Routes != nil {
+				t.Errorf("expected only the clusters to change, got %+v", change)
+			}
+		}
+	})
+
+	t.Run("routes", func(t *testing.T) {
+		_, got := dryRun(t, s.Discovery, http.MethodPost, "?namespace=default", `
+apiVersion: networking.istio.io/v1alpha3
+kind: VirtualService
+metadata:
+  name: vs
+spec:
+  hosts:
+  - example.com
+  http:
+  - route:
+    - destination:
+        host: example.com
+    timeout: 5s
+`)
+		if got == nil || len(got.Changes) != 2 {
+			t.Fatalf("expected the configuration of 2 groups of proxies to change, got %+v", got)
+		}
+		for _, change := range got.Changes {
+			if change.Routes == nil || !reflect.DeepEqual(change.Routes.Modified, []string{"80"}) {
+				t.Errorf("expected route 80 to change, got %+v", change)
+			}
+		}
+	})
+
+	t.Run("proxy filter", func(t *testing.T) {
+		dr := `
+apiVersion: networking.istio.io/v1alpha3
+kind: DestinationRule
+metadata:
+  name: dr
+spec:
+  host: example.com
+  trafficPolicy:
+    loadBalancer:
+      simple: RANDOM
+`
+		cases := []struct {
+			query   string
+			proxies int
+		}{
+			{"?proxyNamespace=other", 1},
+			{"?proxyNamespace=none", 0},
+			{"?proxyID=test-1.other", 1},
+			// the name of the proxy matches all its namespaces
+			{"?proxyID=test-1", 3},
+			// but not a prefix of the name
+			{"?proxyID=test", 0},
+		}
+		for _, tc := range cases {
+			_, got := dryRun(t, s.Discovery, http.MethodPost, tc.query, dr)
+			if got == nil || got.Proxies != tc.proxies {
+				t.Errorf("%s: expected %d proxies to be evaluated, got %+v", tc.query, tc.proxies, got)
+			}
+		}
+	})
+
+	t.Run("no changes to the current config", func(t *testing.T) {
+		push := s.Discovery.globalPushContext()
+		if push.DestinationRule(s.SetupProxy(nil), push.ServiceForHostname(nil, "example.com")) != nil {
+			t.Fatalf("expected the dry run not to apply the candidate configs")
+		}
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		vs := `
+apiVersion: networking.istio.io/v1alpha3
+kind: VirtualService
+metadata:
+  name: vs
+spec:
+  hosts:
+  - example.com
+  http:
+  - route:
+    - destination:
+        host: example.com
+`
+		defer func(max int) { features.DryRunMaxProxyGroups = max }(features.DryRunMaxProxyGroups)
+		features.DryRunMaxProxyGroups = 1
+		if code, _ := dryRun(t, s.Discovery, http.MethodPost, "", vs); code != http.StatusBadRequest {
+			t.Errorf("expected too many groups of proxies to be rejected, got %d", code)
+		}
+		if _, got := dryRun(t, s.Discovery, http.MethodPost, "?proxyNamespace=other", vs); got == nil || got.Proxies != 1 {
+			t.Errorf("expected a single group of proxies to be evaluated, got %+v", got)
+		}
+
+		s.Discovery.dryRunLimit <- struct{}{}
+		defer func() { <-s.Discovery.dryRunLimit }()
+		if code, _ := dryRun(t, s.Discovery, http.MethodPost, "", vs); code != http.StatusServiceUnavailable {
+			t.Errorf("expected concurrent dry runs to be rejected, got %d", code)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		if code, _ := dryRun(t, s.Discovery, http.MethodGet, "", ""); code != http.StatusMethodNotAllowed {
+			t.Errorf("expected GET to be rejected, got %d", code)
+		}
+		if code, _ := dryRun(t, s.Discovery, http.MethodPost, "", dryRunConfig); code != http.StatusBadRequest {
+			t.Errorf("expected ServiceEntries to be rejected, got %d", code)
+		}
+		if code, _ := dryRun(t, s.Discovery, http.MethodPost, "", ""); code != http.StatusBadRequest {
+			t.Errorf("expected an empty body to be rejected, got %d", code)
+		}
+	})
+}
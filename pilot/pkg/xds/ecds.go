@@ -18,6 +18,7 @@ import (
 	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pilot/pkg/networking/util"
 	"istio.io/istio/pkg/config/schema/gvk"
+	maistramodel "istio.io/istio/pkg/servicemesh/model"
 )
 
 // EcdsGenerator generates ECDS configuration.
@@ -39,9 +40,10 @@ func ecdsNeedsPush(req *model.PushRequest) bool {
 	if len(req.ConfigsUpdated) == 0 {
 		return true
 	}
-	// Only push if config updates is triggered by EnvoyFilter.
+	// Only push if config updates is triggered by EnvoyFilter or ServiceMeshExtension.
 	for config := range req.ConfigsUpdated {
-		if config.Kind == gvk.EnvoyFilter {
+		switch config.Kind {
+		case gvk.EnvoyFilter, maistramodel.ServiceMeshExtensionGVK, maistramodel.ServiceMeshExtensionConfigGVK:
 			return true
 		}
 	}
@@ -33,6 +33,8 @@ import (
 	"istio.io/istio/pkg/config/labels"
 	"istio.io/istio/pkg/config/protocol"
 	"istio.io/istio/pkg/config/schema/gvk"
+	fedmodel "istio.io/istio/pkg/servicemesh/federation/model"
+	maistramodel "istio.io/istio/pkg/servicemesh/model"
 )
 
 // UpdateServiceShards will list the endpoints and create the shards.
@@ -335,12 +337,15 @@ var _ model.XdsResourceGenerator = &EdsGenerator{}
 
 // Map of all configs that do not impact EDS
 var skippedEdsConfigs = map[config.GroupVersionKind]struct{}{
-	gvk.Gateway:               {},
-	gvk.VirtualService:        {},
-	gvk.WorkloadGroup:         {},
-	gvk.AuthorizationPolicy:   {},
-	gvk.RequestAuthentication: {},
-	gvk.Secret:                {},
+	gvk.Gateway:                                {},
+	gvk.VirtualService:                         {},
+	gvk.WorkloadGroup:                          {},
+	gvk.AuthorizationPolicy:                    {},
+	gvk.RequestAuthentication:                  {},
+	gvk.Secret:                                 {},
+	fedmodel.ExportedServiceGVK:                {},
+	maistramodel.ServiceMeshExtensionGVK:       {},
+	maistramodel.ServiceMeshExtensionConfigGVK: {},
 }
 
 func edsNeedsPush(updates model.XdsUpdates) bool {
@@ -29,6 +29,7 @@ import (
 	"istio.io/istio/pilot/pkg/networking"
 	"istio.io/istio/pilot/pkg/networking/util"
 	"istio.io/istio/pilot/pkg/security/authn/factory"
+	v3 "istio.io/istio/pilot/pkg/xds/v3"
 	"istio.io/istio/pkg/config"
 	"istio.io/istio/pkg/config/host"
 	"istio.io/istio/pkg/config/labels"
@@ -144,6 +145,10 @@ func (b EndpointBuilder) Cacheable() bool {
 	return b.service != nil
 }
 
+func (b EndpointBuilder) TypeURL() string {
+	return v3.EndpointType
+}
+
 func (b EndpointBuilder) DependentConfigs() []model.ConfigKey {
 	configs := []model.ConfigKey{}
 	if b.destinationRule != nil {
@@ -36,4 +36,7 @@ type DistributionStatusCache interface {
 	RegisterEvent(conID string, eventType EventType, nonce string)
 	RegisterDisconnect(s string, types []EventType)
 	QueryLastNonce(conID string, eventType EventType) (noncePrefix string)
+	// RegisterExtensionEvent notifies the implementer whether a proxy receiving the module of a
+	// ServiceMeshExtension that is being rolled out accepted it, and must be non-blocking
+	RegisterExtensionEvent(conID string, extension string, sha256 string, errorMessage string)
 }
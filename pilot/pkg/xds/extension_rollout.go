@@ -0,0 +1,92 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xds
+
+import (
+	"regexp"
+
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+
+	v3 "istio.io/istio/pilot/pkg/xds/v3"
+	maistramodel "istio.io/istio/pkg/servicemesh/model"
+)
+
+// reportExtensionRollouts reports whether a proxy accepted the modules of
+// the ServiceMeshExtensions being rolled out to it.  HTTP extensions are
+// delivered over ECDS and network extensions inside listeners, so only
+// responses to the latest configuration of those types are considered.  A
+// response rejecting the configuration only fails the extensions it names,
+// as the other resources of the response may have been rejected instead.
+func (s *DiscoveryServer) reportExtensionRollouts(con *Connection, req *discovery.DiscoveryRequest) {
+	var filterType maistramodel.FilterType
+	switch req.TypeUrl {
+	case v3.ExtensionConfigurationType:
+		filterType = maistramodel.FilterTypeHTTP
+	case v3.ListenerType:
+		filterType = maistramodel.FilterTypeNetwork
+	default:
+		return
+	}
+	if req.ResponseNonce == "" {
+		return
+	}
+	con.proxy.RLock()
+	wr := con.proxy.WatchedResources[req.TypeUrl]
+	current := wr != nil && wr.NonceSent == req.ResponseNonce
+	subscribed := map[string]bool{}
+	if current && filterType == maistramodel.FilterTypeHTTP {
+		for _, name := range wr.ResourceNames {
+			subscribed[name] = true
+		}
+	}
+	con.proxy.RUnlock()
+	if !current {
+		return
+	}
+
+	var errorMessage string
+	if req.ErrorDetail != nil {
+		errorMessage = req.ErrorDetail.GetMessage()
+		if errorMessage == "" {
+			errorMessage = "configuration rejected"
+		}
+	}
+	for _, extensions := range s.globalPushContext().Extensions(con.proxy) {
+		for _, extension := range extensions {
+			if extension.Rollout == nil || extension.FilterType != filterType || !extension.Canary(con.proxy.ID) {
+				continue
+			}
+			if filterType == maistramodel.FilterTypeHTTP && !subscribed[extension.ResourceName()] {
+				// the module was not part of the response
+				continue
+			}
+			if errorMessage != "" && !rejectedExtension(errorMessage, extension) {
+				continue
+			}
+			s.StatusReporter.RegisterExtensionEvent(con.ConID, extension.Namespace+"/"+extension.Name, extension.SHA256, errorMessage)
+		}
+	}
+}
+
+// rejectedExtension returns whether the error of a proxy rejecting its
+// configuration names the extension, either by its ECDS resource or by its
+// wasm plugin, which is named after the extension and reported by Envoy when
+// it fails to create the filter.
+func rejectedExtension(errorMessage string, extension *maistramodel.ExtensionWrapper) bool {
+	// extensions are named like other Kubernetes resources, so the name must
+	// not be part of a longer name
+	name := regexp.MustCompile(`(^|[^a-z0-9.-])` + regexp.QuoteMeta(extension.Name) + `($|[^a-z0-9.-])`)
+	return name.MatchString(errorMessage)
+}
@@ -0,0 +1,140 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xds
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1 "maistra.io/api/core/v1"
+
+	"istio.io/istio/pilot/pkg/features"
+	"istio.io/istio/pilot/pkg/model"
+	v3 "istio.io/istio/pilot/pkg/xds/v3"
+	"istio.io/istio/pkg/servicemesh/controller/extension"
+	maistramodel "istio.io/istio/pkg/servicemesh/model"
+)
+
+type fakeExtensionStatusReporter struct {
+	DistributionStatusCache
+	mu     sync.Mutex
+	events map[string]string
+}
+
+func (r *fakeExtensionStatusReporter) RegisterExtensionEvent(conID string, extension string, sha256 string, errorMessage string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events[conID+"/"+extension+"/"+sha256] = errorMessage
+}
+
+func newRolloutExtension(name string) *v1.ServiceMeshExtension {
+	return &v1.ServiceMeshExtension{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Annotations: map[string]string{
+				maistramodel.RolloutPercentageAnnotation: "100",
+				maistramodel.StableModuleAnnotation:      `{"url": "http://mec/` + name + `-stable", "sha256": "stable"}`,
+			},
+		},
+		Spec: v1.ServiceMeshExtensionSpec{
+			Image: "quay.io/maistra/" + name,
+		},
+		Status: v1.ServiceMeshExtensionStatus{
+			Phase: v1.FilterPhasePostAuthZ,
+			Deployment: v1.DeploymentStatus{
+				Ready:  true,
+				URL:    "http://mec/" + name,
+				SHA256: "new",
+			},
+		},
+	}
+}
+
+func TestReportExtensionRollouts(t *testing.T) {
+	defer func(enabled bool) { features.EnableMaistraExtensionSupport = enabled }(features.EnableMaistraExtensionSupport)
+	features.EnableMaistraExtensionSupport = true
+
+	s := NewFakeDiscoveryServer(t, FakeOptions{
+		ExtensionStore: &extension.FakeController{
+			Extensions: []*v1.ServiceMeshExtension{newRolloutExtension("alpha"), newRolloutExtension("beta")},
+		},
+	})
+	reporter := &fakeExtensionStatusReporter{}
+	s.Discovery.StatusReporter = reporter
+
+	cases := []struct {
+		name     string
+		error    string
+		expected map[string]string
+	}{
+		{
+			name: "ack",
+			expected: map[string]string{
+				"con/default/alpha/new": "",
+				"con/default/beta/new":  "",
+			},
+		},
+		{
+			name:  "nack of one extension",
+			error: "Unable to create Wasm HTTP filter beta",
+			expected: map[string]string{
+				"con/default/beta/new": "Unable to create Wasm HTTP filter beta",
+			},
+		},
+		{
+			name:  "nack of one ECDS resource",
+			error: "Error adding or updating extension config maistra.io/extension/default/alpha",
+			expected: map[string]string{
+				"con/default/alpha/new": "Error adding or updating extension config maistra.io/extension/default/alpha",
+			},
+		},
+		{
+			name:     "nack of another resource",
+			error:    "Unable to create Wasm HTTP filter alphabet",
+			expected: map[string]string{},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			reporter.events = map[string]string{}
+			con := newConnection("", nil)
+			con.ConID = "con"
+			con.proxy = s.SetupProxy(&model.Proxy{})
+			con.proxy.WatchedResources = map[string]*model.WatchedResource{
+				v3.ExtensionConfigurationType: {
+					TypeUrl:       v3.ExtensionConfigurationType,
+					ResourceNames: []string{"maistra.io/extension/default/alpha", "maistra.io/extension/default/beta"},
+					NonceSent:     "nonce",
+				},
+			}
+			req := &discovery.DiscoveryRequest{
+				TypeUrl:       v3.ExtensionConfigurationType,
+				ResponseNonce: "nonce",
+			}
+			if tt.error != "" {
+				req.ErrorDetail = &status.Status{Message: tt.error}
+			}
+			s.Discovery.reportExtensionRollouts(con, req)
+			if !reflect.DeepEqual(reporter.events, tt.expected) {
+				t.Errorf("expected events %v, got %v", tt.expected, reporter.events)
+			}
+		})
+	}
+}
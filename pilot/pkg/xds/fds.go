@@ -0,0 +1,67 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xds
+
+import (
+	"istio.io/istio/pilot/pkg/model"
+	v3 "istio.io/istio/pilot/pkg/xds/v3"
+	fedmodel "istio.io/istio/pkg/servicemesh/federation/model"
+)
+
+// FdsGenerator generates the services and network gateways exported to
+// federated meshes discovering services over xDS.
+type FdsGenerator struct {
+	ServiceListProvider fedmodel.ServiceListProvider
+}
+
+var _ model.XdsResourceGenerator = &FdsGenerator{}
+
+func fdsNeedsPush(req *model.PushRequest) bool {
+	if req == nil {
+		return true
+	}
+
+	// changes to the exported services are pushed incrementally, as they
+	// don't require a new PushContext
+	for config := range req.ConfigsUpdated {
+		if config.Kind == fedmodel.ExportedServiceGVK {
+			return true
+		}
+	}
+	return req.Full && len(req.ConfigsUpdated) == 0
+}
+
+// Generate returns the exported services or network gateways for the
+// federated mesh associated with the proxy
+func (e *FdsGenerator) Generate(proxy *model.Proxy, push *model.PushContext, w *model.WatchedResource, req *model.PushRequest) (model.Resources, error) {
+	if !fdsNeedsPush(req) {
+		return nil, nil
+	}
+	if e.ServiceListProvider == nil {
+		return nil, nil
+	}
+	serviceList := e.ServiceListProvider.ServiceListForClient(proxy.ID)
+	if serviceList == nil {
+		// not a federation discovery client
+		return nil, nil
+	}
+	switch w.TypeUrl {
+	case v3.FederationServiceType:
+		return serviceList.ServiceResources()
+	case v3.FederationGatewayType:
+		return serviceList.NetworkGatewayResources()
+	}
+	return nil, nil
+}
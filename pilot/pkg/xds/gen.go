@@ -102,6 +102,18 @@ func (s *DiscoveryServer) pushXds(con *Connection, push *model.PushContext,
 
 	t0 := time.Now()
 
+	if con.deltaStream != nil {
+		// only the resources that may have changed are generated for clients of the delta protocol
+		generated := deltaWatchedResource(con.proxy, w, req)
+		if generated == nil {
+			if s.StatusReporter != nil {
+				s.StatusReporter.RegisterEvent(con.ConID, w.TypeUrl, push.LedgerVersion)
+			}
+			return nil
+		}
+		w = generated
+	}
+
 	res, err := gen.Generate(con.proxy, push, w, req)
 	if err != nil || res == nil {
 		// If we have nothing to send, report that we got an ACK for this version.
@@ -112,6 +124,10 @@ func (s *DiscoveryServer) pushXds(con *Connection, push *model.PushContext,
 	}
 	defer func() { recordPushTime(w.TypeUrl, time.Since(t0)) }()
 
+	if con.deltaStream != nil {
+		return s.pushDeltaXds(con, push, currentVersion, w, res)
+	}
+
 	resp := &discovery.DiscoveryResponse{
 		TypeUrl:     w.TypeUrl,
 		VersionInfo: currentVersion,
@@ -19,6 +19,8 @@ import (
 	"istio.io/istio/pilot/pkg/networking/util"
 	"istio.io/istio/pkg/config"
 	"istio.io/istio/pkg/config/schema/gvk"
+	fedmodel "istio.io/istio/pkg/servicemesh/federation/model"
+	maistramodel "istio.io/istio/pkg/servicemesh/model"
 )
 
 type LdsGenerator struct {
@@ -29,9 +31,11 @@ var _ model.XdsResourceGenerator = &LdsGenerator{}
 
 // Map of all configs that do not impact LDS
 var skippedLdsConfigs = map[config.GroupVersionKind]struct{}{
-	gvk.DestinationRule: {},
-	gvk.WorkloadGroup:   {},
-	gvk.Secret:          {},
+	gvk.DestinationRule:         {},
+	gvk.WorkloadGroup:           {},
+	gvk.Secret:                  {},
+	fedmodel.ExportedServiceGVK: {},
+	maistramodel.ServiceMeshExtensionConfigGVK: {},
 }
 
 func ldsNeedsPush(req *model.PushRequest) bool {
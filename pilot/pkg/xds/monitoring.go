@@ -30,6 +30,7 @@ var (
 	nodeTag    = monitoring.MustCreateLabel("node")
 	typeTag    = monitoring.MustCreateLabel("type")
 	versionTag = monitoring.MustCreateLabel("version")
+	classTag   = monitoring.MustCreateLabel("class")
 
 	// pilot_total_xds_rejects should be used instead. This is for backwards compatibility
 	cdsReject = monitoring.NewGauge(
@@ -138,6 +139,25 @@ var (
 		[]float64{.1, .5, 1, 3, 5, 10, 20, 30},
 	)
 
+	proxiesQueueWaitTime = monitoring.NewDistribution(
+		"pilot_proxy_queue_wait_time",
+		"Time in seconds, a proxy waits in the push queue before being dequeued, labeled by priority class.",
+		[]float64{.1, .5, 1, 3, 5, 10, 20, 30},
+		monitoring.WithLabels(classTag),
+	)
+
+	proxiesQueuePending = monitoring.NewGauge(
+		"pilot_proxy_queue_pending",
+		"Number of proxies waiting in the push queue, labeled by priority class.",
+		monitoring.WithLabels(classTag),
+	)
+
+	debounceQuietPeriod = monitoring.NewGauge(
+		"pilot_debounce_quiet_period",
+		"Quiet period in seconds chosen by the adaptive push debouncing, labeled by type of push (full or eds).",
+		monitoring.WithLabels(typeTag),
+	)
+
 	pushTriggers = monitoring.NewSum(
 		"pilot_push_triggers",
 		"Total number of times a push was triggered, labeled by reason for the push.",
@@ -249,6 +269,9 @@ func init() {
 		pushTime,
 		proxiesConvergeDelay,
 		proxiesQueueTime,
+		proxiesQueueWaitTime,
+		proxiesQueuePending,
+		debounceQuietPeriod,
 		pushContextErrors,
 		totalXDSInternalErrors,
 		inboundUpdates,
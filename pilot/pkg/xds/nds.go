@@ -19,6 +19,8 @@ import (
 	"istio.io/istio/pilot/pkg/networking/util"
 	"istio.io/istio/pkg/config"
 	"istio.io/istio/pkg/config/schema/gvk"
+	fedmodel "istio.io/istio/pkg/servicemesh/federation/model"
+	maistramodel "istio.io/istio/pkg/servicemesh/model"
 )
 
 // Nds stands for Name Discovery Service. Istio agents send NDS requests to istiod
@@ -34,15 +36,18 @@ var _ model.XdsResourceGenerator = &NdsGenerator{}
 
 // Map of all configs that do not impact NDS
 var skippedNdsConfigs = map[config.GroupVersionKind]struct{}{
-	gvk.Gateway:               {},
-	gvk.VirtualService:        {},
-	gvk.DestinationRule:       {},
-	gvk.EnvoyFilter:           {},
-	gvk.WorkloadEntry:         {},
-	gvk.WorkloadGroup:         {},
-	gvk.AuthorizationPolicy:   {},
-	gvk.RequestAuthentication: {},
-	gvk.PeerAuthentication:    {},
+	gvk.Gateway:                                {},
+	gvk.VirtualService:                         {},
+	gvk.DestinationRule:                        {},
+	gvk.EnvoyFilter:                            {},
+	gvk.WorkloadEntry:                          {},
+	gvk.WorkloadGroup:                          {},
+	gvk.AuthorizationPolicy:                    {},
+	gvk.RequestAuthentication:                  {},
+	gvk.PeerAuthentication:                     {},
+	fedmodel.ExportedServiceGVK:                {},
+	maistramodel.ServiceMeshExtensionGVK:       {},
+	maistramodel.ServiceMeshExtensionConfigGVK: {},
 }
 
 func ndsNeedsPush(req *model.PushRequest) bool {
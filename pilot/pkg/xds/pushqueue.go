@@ -15,20 +15,160 @@
 package xds
 
 import (
+	"strings"
 	"sync"
+	"time"
 
+	"istio.io/istio/pilot/pkg/features"
 	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/util/sets"
+	"istio.io/istio/pkg/config/labels"
 )
 
+// PushPriority is the class of a proxy in the push queue. The classes take turns, and each turn of a class pushes
+// up to its weight in pushPriorityWeights, so that the proxies of the classes after it are pushed sooner but still
+// progress while it is busy.
+type PushPriority int
+
+const (
+	// GatewayPushPriority is the class of the gateways, whose staleness is the most visible to users.
+	GatewayPushPriority PushPriority = iota
+	// HighPushPriority is the class of the sidecars selected by the PushPriorityPolicy.
+	HighPushPriority
+	// DefaultPushPriority is the class of all the other proxies.
+	DefaultPushPriority
+
+	numPushPriorities
+)
+
+// pushPriorityWeights are the number of proxies of each class pushed in a turn.
+var pushPriorityWeights = [numPushPriorities]int{
+	GatewayPushPriority: 4,
+	HighPushPriority:    2,
+	DefaultPushPriority: 1,
+}
+
+func (p PushPriority) String() string {
+	switch p {
+	case GatewayPushPriority:
+		return "gateway"
+	case HighPushPriority:
+		return "high"
+	default:
+		return "default"
+	}
+}
+
+// PushPriorityPolicy selects the sidecars which are pushed before the others, by namespace or by labels.
+type PushPriorityPolicy struct {
+	// Namespaces whose sidecars have a high priority.
+	Namespaces sets.Set
+	// Labels the sidecars with a high priority have. Empty labels don't select any sidecar.
+	Labels labels.Instance
+}
+
+// NewPushPriorityPolicy returns the policy for a comma separated list of namespaces and a comma separated list of
+// key=value labels, as configured by PILOT_PUSH_PRIORITY_NAMESPACES and PILOT_PUSH_PRIORITY_SELECTOR.
+func NewPushPriorityPolicy(namespaces, selector string) PushPriorityPolicy {
+	policy := PushPriorityPolicy{Namespaces: sets.NewSet()}
+	for _, ns := range strings.Split(namespaces, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			policy.Namespaces.Insert(ns)
+		}
+	}
+	for _, label := range strings.Split(selector, ",") {
+		if label = strings.TrimSpace(label); label == "" {
+			continue
+		}
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) != 2 {
+			adsLog.Warnf("ignoring invalid push priority label %q, expected key=value", label)
+			continue
+		}
+		if policy.Labels == nil {
+			policy.Labels = labels.Instance{}
+		}
+		policy.Labels[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return policy
+}
+
+// priority returns the class of the proxy of a connection.
+func (pp PushPriorityPolicy) priority(con *Connection) PushPriority {
+	proxy := con.proxy
+	if proxy == nil {
+		return DefaultPushPriority
+	}
+	if proxy.Type == model.Router {
+		return GatewayPushPriority
+	}
+	if pp.Namespaces.Contains(proxy.ConfigNamespace) {
+		return HighPushPriority
+	}
+	if len(pp.Labels) > 0 && proxy.Metadata != nil && pp.Labels.SubsetOf(proxy.Metadata.Labels) {
+		return HighPushPriority
+	}
+	return DefaultPushPriority
+}
+
+// queuedPush is a pending push of a connection.
+type queuedPush struct {
+	request   *model.PushRequest
+	priority  PushPriority
+	namespace string
+	// enqueued is the time the connection was added to the queue, to report how long it waited.
+	enqueued time.Time
+}
+
+// fairQueue is the queue of a priority class. The connections of each namespace are kept in order, and the
+// namespaces take turns, so that a namespace with many proxies does not delay the proxies of the others.
+type fairQueue struct {
+	// namespaces is the order in which the namespaces with queued connections take turns
+	namespaces []string
+	queues     map[string][]*Connection
+	size       int
+}
+
+func (q *fairQueue) push(namespace string, con *Connection) {
+	if q.queues == nil {
+		q.queues = map[string][]*Connection{}
+	}
+	if len(q.queues[namespace]) == 0 {
+		q.namespaces = append(q.namespaces, namespace)
+	}
+	q.queues[namespace] = append(q.queues[namespace], con)
+	q.size++
+}
+
+func (q *fairQueue) pop() *Connection {
+	namespace := q.namespaces[0]
+	q.namespaces = q.namespaces[1:]
+	queue := q.queues[namespace]
+	con := queue[0]
+	if len(queue) == 1 {
+		delete(q.queues, namespace)
+	} else {
+		q.queues[namespace] = queue[1:]
+		// The namespace takes its next turn after the others
+		q.namespaces = append(q.namespaces, namespace)
+	}
+	q.size--
+	return con
+}
+
 type PushQueue struct {
 	cond *sync.Cond
 
 	// pending stores all connections in the queue. If the same connection is enqueued again,
 	// the PushRequest will be merged.
-	pending map[*Connection]*model.PushRequest
+	pending map[*Connection]*queuedPush
 
-	// queue maintains ordering of the queue
-	queue []*Connection
+	// queues maintain the ordering of the queue, with one queue per priority class
+	queues [numPushPriorities]fairQueue
+	policy PushPriorityPolicy
+	// turn is the class taking its turn, which has pushed turnPushes proxies in it
+	turn       PushPriority
+	turnPushes int
 
 	// processing stores all connections that have been Dequeue(), but not MarkDone().
 	// The value stored will be initially be nil, but may be populated if the connection is Enqueue().
@@ -38,10 +178,20 @@ type PushQueue struct {
 	shuttingDown bool
 }
 
+// NewPushQueue returns a queue with the priority policy configured by PILOT_PUSH_PRIORITY_NAMESPACES and
+// PILOT_PUSH_PRIORITY_SELECTOR.
 func NewPushQueue() *PushQueue {
+	return NewPushQueueWithPolicy(NewPushPriorityPolicy(features.PushPriorityNamespaces, features.PushPrioritySelector))
+}
+
+// NewPushQueueWithPolicy returns a queue which favors the gateways, then the sidecars selected by the policy, over
+// all the other proxies, by weighted turns between the classes. Within each class, the namespaces share the pushes
+// fairly.
+func NewPushQueueWithPolicy(policy PushPriorityPolicy) *PushQueue {
 	return &PushQueue{
-		pending:    make(map[*Connection]*model.PushRequest),
+		pending:    make(map[*Connection]*queuedPush),
 		processing: make(map[*Connection]*model.PushRequest),
+		policy:     policy,
 		cond:       sync.NewCond(&sync.Mutex{}),
 	}
 }
@@ -62,13 +212,27 @@ func (p *PushQueue) Enqueue(con *Connection, pushRequest *model.PushRequest) {
 		return
 	}
 
-	if request, f := p.pending[con]; f {
-		p.pending[con] = request.Merge(pushRequest)
+	if queued, f := p.pending[con]; f {
+		queued.request = queued.request.Merge(pushRequest)
 		return
 	}
 
-	p.pending[con] = pushRequest
-	p.queue = append(p.queue, con)
+	p.add(con, pushRequest)
+}
+
+// add queues a connection in the queue of its class. Must be called with the lock held.
+func (p *PushQueue) add(con *Connection, pushRequest *model.PushRequest) {
+	queued := &queuedPush{
+		request:  pushRequest,
+		priority: p.policy.priority(con),
+		enqueued: time.Now(),
+	}
+	if con.proxy != nil {
+		queued.namespace = con.proxy.ConfigNamespace
+	}
+	p.pending[con] = queued
+	p.queues[queued.priority].push(queued.namespace, con)
+	proxiesQueuePending.With(classTag.Value(queued.priority.String())).Record(float64(p.queues[queued.priority].size))
 	// Signal waiters on Dequeue that a new item is available
 	p.cond.Signal()
 }
@@ -79,24 +243,38 @@ func (p *PushQueue) Dequeue() (con *Connection, request *model.PushRequest, shut
 	defer p.cond.L.Unlock()
 
 	// Block until there is one to remove. Enqueue will signal when one is added.
-	for len(p.queue) == 0 && !p.shuttingDown {
+	for p.size() == 0 && !p.shuttingDown {
 		p.cond.Wait()
 	}
 
-	if len(p.queue) == 0 {
+	if p.size() == 0 {
 		// We must be shutting down.
 		return nil, nil, true
 	}
 
-	con, p.queue = p.queue[0], p.queue[1:]
+	con = p.queues[p.nextPriority()].pop()
 
-	request = p.pending[con]
+	queued := p.pending[con]
 	delete(p.pending, con)
+	class := classTag.Value(queued.priority.String())
+	proxiesQueueWaitTime.With(class).Record(time.Since(queued.enqueued).Seconds())
+	proxiesQueuePending.With(class).Record(float64(p.queues[queued.priority].size))
 
 	// Mark the connection as in progress
 	p.processing[con] = nil
 
-	return con, request, false
+	return con, queued.request, false
+}
+
+// nextPriority returns the class to push a proxy of, taking turns between the classes with queued proxies. Must be
+// called with the lock held and proxies queued.
+func (p *PushQueue) nextPriority() PushPriority {
+	for p.queues[p.turn].size == 0 || p.turnPushes >= pushPriorityWeights[p.turn] {
+		p.turn = (p.turn + 1) % numPushPriorities
+		p.turnPushes = 0
+	}
+	p.turnPushes++
+	return p.turn
 }
 
 func (p *PushQueue) MarkDone(con *Connection) {
@@ -108,17 +286,24 @@ func (p *PushQueue) MarkDone(con *Connection) {
 	// If the info is present, that means Enqueue was called while connection was not yet marked done.
 	// This means we need to add it back to the queue.
 	if request != nil {
-		p.pending[con] = request
-		p.queue = append(p.queue, con)
-		p.cond.Signal()
+		p.add(con, request)
+	}
+}
+
+// size returns the number of queued connections. Must be called with the lock held.
+func (p *PushQueue) size() int {
+	size := 0
+	for priority := range p.queues {
+		size += p.queues[priority].size
 	}
+	return size
 }
 
 // Get number of pending proxies
 func (p *PushQueue) Pending() int {
 	p.cond.L.Lock()
 	defer p.cond.L.Unlock()
-	return len(p.queue)
+	return p.size()
 }
 
 // ShutDown will cause queue to ignore all new items added to it. As soon as the
@@ -22,6 +22,8 @@ import (
 	"time"
 
 	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/util/sets"
+	"istio.io/istio/pkg/config/labels"
 	"istio.io/istio/pkg/config/schema/gvk"
 	"istio.io/istio/tests/util/leak"
 )
@@ -293,3 +295,115 @@ func TestProxyQueue(t *testing.T) {
 		}
 	})
 }
+
+func TestProxyQueuePriority(t *testing.T) {
+	leak.Check(t)
+	proxy := func(id string, nodeType model.NodeType, namespace string, labels map[string]string) *Connection {
+		return &Connection{ConID: id, proxy: &model.Proxy{
+			Type:            nodeType,
+			ConfigNamespace: namespace,
+			Metadata:        &model.NodeMetadata{Labels: labels},
+		}}
+	}
+
+	t.Run("gateways and selected sidecars first", func(t *testing.T) {
+		t.Parallel()
+		p := NewPushQueueWithPolicy(NewPushPriorityPolicy("critical", "tier=frontend"))
+		defer p.ShutDown()
+
+		sidecar := proxy("sidecar", model.SidecarProxy, "default", nil)
+		labeled := proxy("labeled", model.SidecarProxy, "default", map[string]string{"tier": "frontend", "app": "a"})
+		critical := proxy("critical", model.SidecarProxy, "critical", nil)
+		gateway := proxy("gateway", model.Router, "istio-system", nil)
+		p.Enqueue(sidecar, &model.PushRequest{})
+		p.Enqueue(labeled, &model.PushRequest{})
+		p.Enqueue(critical, &model.PushRequest{})
+		p.Enqueue(gateway, &model.PushRequest{})
+		// Merging keeps the position in the queue
+		p.Enqueue(sidecar, &model.PushRequest{Full: true})
+
+		ExpectDequeue(t, p, gateway)
+		ExpectDequeue(t, p, labeled)
+		ExpectDequeue(t, p, critical)
+		_, request, _ := p.Dequeue()
+		if !request.Full {
+			t.Fatalf("expected the requests of the sidecar to be merged")
+		}
+		ExpectTimeout(t, p)
+	})
+
+	t.Run("namespaces share the queue", func(t *testing.T) {
+		t.Parallel()
+		p := NewPushQueueWithPolicy(PushPriorityPolicy{})
+		defer p.ShutDown()
+
+		a1 := proxy("a1", model.SidecarProxy, "a", nil)
+		a2 := proxy("a2", model.SidecarProxy, "a", nil)
+		a3 := proxy("a3", model.SidecarProxy, "a", nil)
+		b1 := proxy("b1", model.SidecarProxy, "b", nil)
+		c1 := proxy("c1", model.SidecarProxy, "c", nil)
+		for _, con := range []*Connection{a1, a2, a3, b1, c1} {
+			p.Enqueue(con, &model.PushRequest{})
+		}
+		if got := p.Pending(); got != 5 {
+			t.Fatalf("expected 5 pending proxies, got %d", got)
+		}
+
+		ExpectDequeue(t, p, a1)
+		ExpectDequeue(t, p, b1)
+		ExpectDequeue(t, p, c1)
+		ExpectDequeue(t, p, a2)
+		ExpectDequeue(t, p, a3)
+		ExpectTimeout(t, p)
+	})
+}
+
+func TestProxyQueueStarvation(t *testing.T) {
+	proxy := func(id string, nodeType model.NodeType) *Connection {
+		return &Connection{ConID: id, proxy: &model.Proxy{Type: nodeType, ConfigNamespace: "default"}}
+	}
+	p := NewPushQueueWithPolicy(PushPriorityPolicy{})
+	defer p.ShutDown()
+
+	gateways := []*Connection{proxy("gateway-1", model.Router), proxy("gateway-2", model.Router)}
+	sidecars := []*Connection{proxy("sidecar-1", model.SidecarProxy), proxy("sidecar-2", model.SidecarProxy)}
+	for _, con := range append(gateways, sidecars...) {
+		p.Enqueue(con, &model.PushRequest{})
+	}
+
+	// The gateways are pushed again as soon as they are done, so their class is never empty
+	pushed := map[*Connection]int{}
+	for i := 0; i < 2*(pushPriorityWeights[GatewayPushPriority]+pushPriorityWeights[DefaultPushPriority]); i++ {
+		con := getWithTimeout(p)
+		if con == nil {
+			t.Fatalf("timed out")
+		}
+		pushed[con]++
+		p.MarkDone(con)
+		if con.proxy.Type == model.Router {
+			p.Enqueue(con, &model.PushRequest{})
+		}
+	}
+
+	for _, sidecar := range sidecars {
+		if pushed[sidecar] != 1 {
+			t.Fatalf("expected %s to be pushed once under constant gateway load, got %d", sidecar.ConID, pushed[sidecar])
+		}
+	}
+	if got := pushed[gateways[0]] + pushed[gateways[1]]; got != 2*pushPriorityWeights[GatewayPushPriority] {
+		t.Fatalf("expected the gateways to be pushed %d times, got %d", 2*pushPriorityWeights[GatewayPushPriority], got)
+	}
+}
+
+func TestNewPushPriorityPolicy(t *testing.T) {
+	policy := NewPushPriorityPolicy(" foo, ,bar", "app=test, invalid ,tier = frontend")
+	if !reflect.DeepEqual(policy.Namespaces, sets.NewSet("foo", "bar")) {
+		t.Errorf("unexpected namespaces %v", policy.Namespaces)
+	}
+	if !reflect.DeepEqual(policy.Labels, labels.Instance{"app": "test", "tier": "frontend"}) {
+		t.Errorf("unexpected labels %v", policy.Labels)
+	}
+	if empty := NewPushPriorityPolicy("", ""); len(empty.Namespaces) != 0 || empty.Labels != nil {
+		t.Errorf("expected an empty policy, got %+v", empty)
+	}
+}
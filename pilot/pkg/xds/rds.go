@@ -16,9 +16,10 @@ package xds
 
 import (
 	"istio.io/istio/pilot/pkg/model"
-	"istio.io/istio/pilot/pkg/networking/util"
 	"istio.io/istio/pkg/config"
 	"istio.io/istio/pkg/config/schema/gvk"
+	fedmodel "istio.io/istio/pkg/servicemesh/federation/model"
+	maistramodel "istio.io/istio/pkg/servicemesh/model"
 )
 
 type RdsGenerator struct {
@@ -29,12 +30,15 @@ var _ model.XdsResourceGenerator = &RdsGenerator{}
 
 // Map of all configs that do not impact RDS
 var skippedRdsConfigs = map[config.GroupVersionKind]struct{}{
-	gvk.WorkloadEntry:         {},
-	gvk.WorkloadGroup:         {},
-	gvk.AuthorizationPolicy:   {},
-	gvk.RequestAuthentication: {},
-	gvk.PeerAuthentication:    {},
-	gvk.Secret:                {},
+	gvk.WorkloadEntry:                          {},
+	gvk.WorkloadGroup:                          {},
+	gvk.AuthorizationPolicy:                    {},
+	gvk.RequestAuthentication:                  {},
+	gvk.PeerAuthentication:                     {},
+	gvk.Secret:                                 {},
+	fedmodel.ExportedServiceGVK:                {},
+	maistramodel.ServiceMeshExtensionGVK:       {},
+	maistramodel.ServiceMeshExtensionConfigGVK: {},
 }
 
 func rdsNeedsPush(req *model.PushRequest) bool {
@@ -61,10 +65,5 @@ func (c RdsGenerator) Generate(proxy *model.Proxy, push *model.PushContext, w *m
 	if !rdsNeedsPush(req) {
 		return nil, nil
 	}
-	rawRoutes := c.Server.ConfigGenerator.BuildHTTPRoutes(proxy, push, w.ResourceNames)
-	resources := model.Resources{}
-	for _, c := range rawRoutes {
-		resources = append(resources, util.MessageToAny(c))
-	}
-	return resources, nil
+	return c.Server.ConfigGenerator.BuildHTTPRoutes(proxy, push, w.ResourceNames), nil
 }
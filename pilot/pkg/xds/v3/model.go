@@ -33,6 +33,9 @@ const (
 
 	// maistra xDS
 	TrustBundleType = "type.googleapis.com/maistra.security.v1.TrustBundleResponse"
+	// federation discovery
+	FederationServiceType = "type.googleapis.com/maistra.federation.v1.ExportedService"
+	FederationGatewayType = "type.googleapis.com/maistra.federation.v1.NetworkGateway"
 )
 
 // GetShortType returns an abbreviated form of a type, useful for logging or human friendly messages
@@ -52,6 +55,10 @@ func GetShortType(typeURL string) string {
 		return "NDS"
 	case TrustBundleType:
 		return "TBDS"
+	case FederationServiceType:
+		return "FDS"
+	case FederationGatewayType:
+		return "FGDS"
 	default:
 		return typeURL
 	}
@@ -74,6 +81,10 @@ func GetMetricType(typeURL string) string {
 		return "nds"
 	case TrustBundleType:
 		return "tbds"
+	case FederationServiceType:
+		return "fds"
+	case FederationGatewayType:
+		return "fgds"
 	default:
 		return typeURL
 	}
@@ -25,6 +25,7 @@ import (
 	"go.uber.org/atomic"
 
 	"istio.io/istio/pilot/pkg/model"
+	v3 "istio.io/istio/pilot/pkg/xds/v3"
 	"istio.io/istio/pkg/config"
 	"istio.io/istio/pkg/config/schema/gvk"
 	"istio.io/istio/pkg/test/util/retry"
@@ -215,4 +216,23 @@ func TestXdsCache(t *testing.T) {
 			t.Fatalf("expected no keys, got: %v", c.Keys())
 		}
 	})
+
+	t.Run("stats", func(t *testing.T) {
+		c := model.NewLenientXdsCache()
+		secret := SecretResource{Name: "foo", Namespace: "default", ResourceName: "kubernetes://foo"}
+		addWithToken(c, ep1, any1)
+		addWithToken(c, secret, any2)
+		c.Get(ep1)
+		c.Get(ep2)
+		// Cleared entries are not evictions
+		c.Clear(map[model.ConfigKey]struct{}{{Kind: gvk.ServiceEntry, Name: "foo.com"}: {}})
+
+		want := map[string]model.CacheStats{
+			v3.EndpointType: {Entries: 0, Hits: 1, Misses: 2},
+			v3.SecretType:   {Entries: 1, Misses: 1},
+		}
+		if got := c.Stats(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("unexpected stats: %+v, want %+v", got, want)
+		}
+	})
 }
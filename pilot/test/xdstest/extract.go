@@ -258,6 +258,18 @@ func ExtractTLSSecrets(t test.Failer, secrets []*any.Any) map[string]*tls.Secret
 	return res
 }
 
+func UnmarshalCluster(t test.Failer, resp []*any.Any) []*cluster.Cluster {
+	un := make([]*cluster.Cluster, 0, len(resp))
+	for _, r := range resp {
+		u := &cluster.Cluster{}
+		if err := ptypes.UnmarshalAny(r, u); err != nil {
+			t.Fatal(err)
+		}
+		un = append(un, u)
+	}
+	return un
+}
+
 func UnmarshalRouteConfiguration(t test.Failer, resp []*any.Any) []*route.RouteConfiguration {
 	un := make([]*route.RouteConfiguration, 0, len(resp))
 	for _, r := range resp {
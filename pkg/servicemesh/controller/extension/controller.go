@@ -41,6 +41,8 @@ type serviceMeshExtensionController struct {
 
 type Controller interface {
 	GetExtensions() []*v1.ServiceMeshExtension
+	// HasSynced returns whether the extensions of all namespaces were listed
+	HasSynced() bool
 	RegisterEventHandler(handler cache.ResourceEventHandler)
 	Start(<-chan struct{})
 }
@@ -131,6 +133,10 @@ func (ec *serviceMeshExtensionController) GetExtensions() []*v1.ServiceMeshExten
 	return ret
 }
 
+func (ec *serviceMeshExtensionController) HasSynced() bool {
+	return ec.informer.HasSynced()
+}
+
 func (ec *serviceMeshExtensionController) Start(stopChan <-chan struct{}) {
 	go ec.informer.Run(stopChan)
 }
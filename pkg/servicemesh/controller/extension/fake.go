@@ -0,0 +1,44 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package extension
+
+import (
+	"k8s.io/client-go/tools/cache"
+	v1 "maistra.io/api/core/v1"
+)
+
+// FakeController is a Controller serving a fixed set of extensions, for use
+// in tests.
+type FakeController struct {
+	Extensions []*v1.ServiceMeshExtension
+}
+
+var _ Controller = &FakeController{}
+
+func (c *FakeController) GetExtensions() []*v1.ServiceMeshExtension {
+	ret := make([]*v1.ServiceMeshExtension, 0, len(c.Extensions))
+	for _, extension := range c.Extensions {
+		ret = append(ret, extension.DeepCopy())
+	}
+	return ret
+}
+
+func (c *FakeController) HasSynced() bool {
+	return true
+}
+
+func (c *FakeController) RegisterEventHandler(cache.ResourceEventHandler) {}
+
+func (c *FakeController) Start(<-chan struct{}) {}
@@ -15,19 +15,19 @@
 package extension
 
 import (
-	"encoding/json"
 	"fmt"
 
-	udpa "github.com/cncf/xds/go/udpa/type/v1"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	xdslistener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
 	hcm_filter "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	wasmnetwork "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/wasm/v3"
 	xdsutil "github.com/envoyproxy/go-control-plane/pkg/wellknown"
-	structpb "github.com/golang/protobuf/ptypes/struct"
 	v1 "maistra.io/api/core/v1"
-	v1alpha1 "maistra.io/api/core/v1alpha1"
 
+	networking "istio.io/api/networking/v1alpha3"
 	"istio.io/istio/istioctl/pkg/authz"
 	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/networking/core/v1alpha3/envoyfilter"
 	"istio.io/istio/pilot/pkg/networking/util"
 	maistramodel "istio.io/istio/pkg/servicemesh/model"
 	"istio.io/pkg/log"
@@ -36,6 +36,8 @@ import (
 const (
 	DefaultCacheCluster = "outbound|80||mec.istio-system.svc.cluster.local"
 	defaultRuntime      = "envoy.wasm.runtime.v8"
+
+	wasmNetworkFilterName = "envoy.filters.network.wasm"
 )
 
 var (
@@ -45,12 +47,14 @@ var (
 	Runtime = defaultRuntime
 )
 
-// ApplyListenerPatches adds extensions to listener filterChains
+// ApplyListenerPatches adds extensions to listener filterChains.  Inbound
+// listeners are only patched on filter chains for the ports of the proxy's
+// service instances.
 func ApplyListenerPatches(
+	patchContext networking.EnvoyFilter_PatchContext,
 	listener *xdslistener.Listener,
 	proxy *model.Proxy,
 	push *model.PushContext,
-	patchAll bool,
 ) *xdslistener.Listener {
 	if listener == nil {
 		return nil
@@ -60,22 +64,17 @@ func ApplyListenerPatches(
 		return listener
 	}
 
+	httpExtensions, networkExtensions := splitByFilterType(extensionsMap)
+
 	var relevantFilterChains []string
-	if len(proxy.ServiceInstances) > 0 {
-		relevantFilterChains = []string{fmt.Sprintf("0.0.0.0_%d", proxy.ServiceInstances[0].Endpoint.EndpointPort)}
-		for _, si := range proxy.ServiceInstances {
-			relevantFilterChains = append(relevantFilterChains, fmt.Sprintf("%s_%d", si.Endpoint.Address, si.Endpoint.EndpointPort))
-		}
+	for _, si := range proxy.ServiceInstances {
+		relevantFilterChains = append(relevantFilterChains,
+			fmt.Sprintf("0.0.0.0_%d", si.Endpoint.EndpointPort),
+			fmt.Sprintf("%s_%d", si.Endpoint.Address, si.Endpoint.EndpointPort))
 	}
 
 	for fcIndex, fc := range listener.FilterChains {
-		//copy extensions map
-		extensions := make(map[v1.FilterPhase][]*maistramodel.ExtensionWrapper)
-		for k, v := range extensionsMap {
-			extensions[k] = []*maistramodel.ExtensionWrapper{}
-			extensions[k] = append(extensions[k], v...)
-		}
-		if !patchAll {
+		if patchContext == networking.EnvoyFilter_SIDECAR_INBOUND {
 			isRelevant := false
 			for _, relevant := range relevantFilterChains {
 				if fc.Name == relevant {
@@ -97,6 +96,13 @@ func ApplyListenerPatches(
 			}
 		}
 		if hcm == nil {
+			if extensions := matchingExtensions(networkExtensions, patchContext, listener, fc); len(extensions) > 0 {
+				fc.Filters = applyNetworkFilterPatches(fc.Filters, extensions)
+			}
+			continue
+		}
+		extensions := matchingExtensions(httpExtensions, patchContext, listener, fc)
+		if len(extensions) == 0 {
 			continue
 		}
 		newHTTPFilters := make([]*hcm_filter.HttpFilter, 0)
@@ -145,14 +151,96 @@ func ApplyListenerPatches(
 	return listener
 }
 
+// applyNetworkFilterPatches inserts network filter extensions into the
+// filters of a TCP filter chain.  Phases are relative to the network RBAC
+// and stats filters, and any extensions left are inserted before the filter
+// handling the protocol, e.g. tcp_proxy or mongo_proxy.
+func applyNetworkFilterPatches(filters []*xdslistener.Filter,
+	extensions map[v1.FilterPhase][]*maistramodel.ExtensionWrapper) []*xdslistener.Filter {
+	newFilters := make([]*xdslistener.Filter, 0, len(filters))
+	for _, filter := range filters {
+		switch filter.Name {
+		case xdsutil.RoleBasedAccessControl:
+			newFilters = popAppendNetwork(newFilters, extensions, v1.FilterPhasePreAuthN)
+			newFilters = popAppendNetwork(newFilters, extensions, v1.FilterPhasePostAuthN)
+			newFilters = popAppendNetwork(newFilters, extensions, v1.FilterPhasePreAuthZ)
+			newFilters = append(newFilters, filter)
+			newFilters = popAppendNetwork(newFilters, extensions, v1.FilterPhasePostAuthZ)
+		case "istio.stats":
+			newFilters = popAppendNetwork(newFilters, extensions, v1.FilterPhasePreAuthN)
+			newFilters = popAppendNetwork(newFilters, extensions, v1.FilterPhasePostAuthN)
+			newFilters = popAppendNetwork(newFilters, extensions, v1.FilterPhasePreAuthZ)
+			newFilters = popAppendNetwork(newFilters, extensions, v1.FilterPhasePostAuthZ)
+			newFilters = popAppendNetwork(newFilters, extensions, v1.FilterPhasePreStats)
+			newFilters = append(newFilters, filter)
+			newFilters = popAppendNetwork(newFilters, extensions, v1.FilterPhasePostStats)
+		case xdsutil.TCPProxy, xdsutil.MongoProxy, xdsutil.MySQLProxy, xdsutil.RedisProxy, xdsutil.ThriftProxy:
+			newFilters = popAppendNetwork(newFilters, extensions, v1.FilterPhasePreAuthN)
+			newFilters = popAppendNetwork(newFilters, extensions, v1.FilterPhasePostAuthN)
+			newFilters = popAppendNetwork(newFilters, extensions, v1.FilterPhasePreAuthZ)
+			newFilters = popAppendNetwork(newFilters, extensions, v1.FilterPhasePostAuthZ)
+			newFilters = popAppendNetwork(newFilters, extensions, v1.FilterPhasePreStats)
+			newFilters = popAppendNetwork(newFilters, extensions, v1.FilterPhasePostStats)
+			newFilters = append(newFilters, filter)
+		default:
+			newFilters = append(newFilters, filter)
+		}
+	}
+	return newFilters
+}
+
+// splitByFilterType splits extensions into HTTP and network filters.
+func splitByFilterType(extensionsMap map[v1.FilterPhase][]*maistramodel.ExtensionWrapper) (
+	httpExtensions, networkExtensions map[v1.FilterPhase][]*maistramodel.ExtensionWrapper) {
+	httpExtensions = make(map[v1.FilterPhase][]*maistramodel.ExtensionWrapper)
+	networkExtensions = make(map[v1.FilterPhase][]*maistramodel.ExtensionWrapper)
+	for phase, extensions := range extensionsMap {
+		for _, extension := range extensions {
+			if extension.FilterType == maistramodel.FilterTypeNetwork {
+				networkExtensions[phase] = append(networkExtensions[phase], extension)
+			} else {
+				httpExtensions[phase] = append(httpExtensions[phase], extension)
+			}
+		}
+	}
+	return httpExtensions, networkExtensions
+}
+
+// matchingExtensions returns the extensions applied to the filter chain, as
+// a new map, since extensions are removed from it once inserted.
+func matchingExtensions(extensionsMap map[v1.FilterPhase][]*maistramodel.ExtensionWrapper,
+	patchContext networking.EnvoyFilter_PatchContext, listener *xdslistener.Listener,
+	fc *xdslistener.FilterChain) map[v1.FilterPhase][]*maistramodel.ExtensionWrapper {
+	extensions := make(map[v1.FilterPhase][]*maistramodel.ExtensionWrapper)
+	for phase, phaseExtensions := range extensionsMap {
+		for _, extension := range phaseExtensions {
+			if extensionMatches(extension, patchContext, listener, fc) {
+				extensions[phase] = append(extensions[phase], extension)
+			}
+		}
+	}
+	return extensions
+}
+
+// extensionMatches returns whether the extension is applied to the filter
+// chain, using the semantics of EnvoyFilter matches.  Extensions without a
+// match are applied to the inbound traffic of sidecars and to gateways.
+func extensionMatches(extension *maistramodel.ExtensionWrapper, patchContext networking.EnvoyFilter_PatchContext,
+	listener *xdslistener.Listener, fc *xdslistener.FilterChain) bool {
+	if extension.Match == nil {
+		return patchContext == networking.EnvoyFilter_SIDECAR_INBOUND || patchContext == networking.EnvoyFilter_GATEWAY
+	}
+	return envoyfilter.FilterChainMatches(patchContext, listener, fc, extension.Match)
+}
+
 func ApplyListenerListPatches(
+	patchContext networking.EnvoyFilter_PatchContext,
 	listeners []*xdslistener.Listener,
 	proxy *model.Proxy,
 	push *model.PushContext,
-	patchAll bool,
 ) (out []*xdslistener.Listener) {
 	for _, listener := range listeners {
-		out = append(out, ApplyListenerPatches(listener, proxy, push, patchAll))
+		out = append(out, ApplyListenerPatches(patchContext, listener, proxy, push))
 	}
 	return out
 }
@@ -169,57 +257,56 @@ func popAppend(list []*hcm_filter.HttpFilter,
 	return list
 }
 
-func toEnvoyHTTPFilter(extension *maistramodel.ExtensionWrapper) *hcm_filter.HttpFilter {
-	var configuration string
-
-	if rawV1Alpha1Config, ok := extension.Config.Data[v1alpha1.RawV1Alpha1Config]; ok {
-		// Extension uses old config format (string), so push it as a raw string
-		configuration = rawV1Alpha1Config.(string)
-	} else {
-		// Otherwise convert the struct (json) to string and push it as string, since Envoy doesn't handle well protobuf.StructValue
-		rawBytes, err := json.Marshal(extension.Config.Data)
-		if err != nil {
-			log.Errorf("invalid configuration for extension %s: %v", extension.Name, err)
-			return nil
+func popAppendNetwork(list []*xdslistener.Filter,
+	filterMap map[v1.FilterPhase][]*maistramodel.ExtensionWrapper,
+	phase v1.FilterPhase) []*xdslistener.Filter {
+	for _, ext := range filterMap[phase] {
+		if filter := toEnvoyNetworkFilter(ext); filter != nil {
+			list = append(list, filter)
 		}
-		configuration = string(rawBytes)
 	}
+	filterMap[phase] = []*maistramodel.ExtensionWrapper{}
+	return list
+}
+
+// toEnvoyNetworkFilter returns a wasm network filter running the extension.
+// Unlike HTTP filters, its configuration is inlined, as Envoy doesn't support
+// discovering the configuration of network filters.
+func toEnvoyNetworkFilter(extension *maistramodel.ExtensionWrapper) *xdslistener.Filter {
+	config, err := toPluginConfig(extension)
+	if err != nil {
+		log.Errorf("invalid configuration for extension %s/%s: %v", extension.Namespace, extension.Name, err)
+		return nil
+	}
+	return &xdslistener.Filter{
+		Name: wasmNetworkFilterName,
+		ConfigType: &xdslistener.Filter_TypedConfig{
+			TypedConfig: util.MessageToAny(&wasmnetwork.Wasm{Config: config}),
+		},
+	}
+}
 
+// toEnvoyHTTPFilter returns a filter referencing the extension's
+// configuration, which is served over ECDS, so that changes to the
+// configuration or module of an extension don't require updating listeners.
+func toEnvoyHTTPFilter(extension *maistramodel.ExtensionWrapper) *hcm_filter.HttpFilter {
+	if _, err := pluginConfiguration(extension); err != nil {
+		// there's no configuration to reference
+		log.Errorf("invalid configuration for extension %s/%s: %v", extension.Namespace, extension.Name, err)
+		return nil
+	}
 	return &hcm_filter.HttpFilter{
-		Name: "envoy.filters.http.wasm",
-		ConfigType: &hcm_filter.HttpFilter_TypedConfig{
-			TypedConfig: util.MessageToAny(&udpa.TypedStruct{
-				TypeUrl: "type.googleapis.com/envoy.extensions.filters.http.wasm.v3.Wasm",
-				Value: &structpb.Struct{
-					Fields: map[string]*structpb.Value{
-						"config": {Kind: &structpb.Value_StructValue{StructValue: &structpb.Struct{Fields: map[string]*structpb.Value{
-							"name":   {Kind: &structpb.Value_StringValue{StringValue: extension.Name}},
-							"rootId": {Kind: &structpb.Value_StringValue{StringValue: extension.Name + "_root"}},
-							"configuration": {Kind: &structpb.Value_StructValue{StructValue: &structpb.Struct{Fields: map[string]*structpb.Value{
-								"@type": {Kind: &structpb.Value_StringValue{StringValue: "type.googleapis.com/google.protobuf.StringValue"}},
-								"value": {Kind: &structpb.Value_StringValue{StringValue: configuration}},
-							}}}},
-							"vmConfig": {Kind: &structpb.Value_StructValue{StructValue: &structpb.Struct{Fields: map[string]*structpb.Value{
-								"code": {Kind: &structpb.Value_StructValue{StructValue: &structpb.Struct{Fields: map[string]*structpb.Value{
-									"remote": {Kind: &structpb.Value_StructValue{StructValue: &structpb.Struct{Fields: map[string]*structpb.Value{
-										"httpUri": {Kind: &structpb.Value_StructValue{StructValue: &structpb.Struct{Fields: map[string]*structpb.Value{
-											"uri":     {Kind: &structpb.Value_StringValue{StringValue: extension.FilterURL}},
-											"cluster": {Kind: &structpb.Value_StringValue{StringValue: CacheCluster}},
-											"timeout": {Kind: &structpb.Value_StructValue{StructValue: &structpb.Struct{Fields: map[string]*structpb.Value{
-												"seconds": {Kind: &structpb.Value_NumberValue{NumberValue: 30}},
-											}}}},
-										}}}},
-										"sha256": {Kind: &structpb.Value_StringValue{StringValue: extension.SHA256}},
-										"retryPolicy": {Kind: &structpb.Value_StructValue{StructValue: &structpb.Struct{Fields: map[string]*structpb.Value{
-											"numRetries": {Kind: &structpb.Value_NumberValue{NumberValue: 2}},
-										}}}},
-									}}}},
-								}}}},
-								"runtime": {Kind: &structpb.Value_StringValue{StringValue: Runtime}},
-							}}}},
-						}}}},
+		Name: extension.ResourceName(),
+		ConfigType: &hcm_filter.HttpFilter_ConfigDiscovery{
+			ConfigDiscovery: &core.ExtensionConfigSource{
+				ConfigSource: &core.ConfigSource{
+					ConfigSourceSpecifier: &core.ConfigSource_Ads{
+						Ads: &core.AggregatedConfigSource{},
 					},
-				}}),
+					ResourceApiVersion: core.ApiVersion_V3,
+				},
+				TypeUrls: []string{wasmHTTPFilterType},
+			},
 		},
 	}
 }
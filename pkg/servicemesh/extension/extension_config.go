@@ -0,0 +1,132 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package extension
+
+import (
+	"encoding/json"
+	"time"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	wasmfilter "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/wasm/v3"
+	wasm "github.com/envoyproxy/go-control-plane/envoy/extensions/wasm/v3"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	v1alpha1 "maistra.io/api/core/v1alpha1"
+
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/networking/util"
+	maistramodel "istio.io/istio/pkg/servicemesh/model"
+	"istio.io/pkg/log"
+)
+
+const (
+	wasmHTTPFilterType = "type.googleapis.com/envoy.extensions.filters.http.wasm.v3.Wasm"
+
+	moduleFetchTimeout = 30 * time.Second
+	moduleFetchRetries = 2
+)
+
+// ExtensionConfigurations returns the filter configurations of the proxy's
+// extensions with the given names.  This is the part of the ECDS output
+// generated for ServiceMeshExtensions.
+func ExtensionConfigurations(proxy *model.Proxy, push *model.PushContext, names []string) []*core.TypedExtensionConfig {
+	extensionsByName := map[string]*maistramodel.ExtensionWrapper{}
+	for _, extensions := range push.Extensions(proxy) {
+		for _, extension := range extensions {
+			if extension.FilterType == maistramodel.FilterTypeHTTP {
+				extensionsByName[extension.ResourceName()] = extension
+			}
+		}
+	}
+	var result []*core.TypedExtensionConfig
+	for _, name := range names {
+		extension, ok := extensionsByName[name]
+		if !ok {
+			continue
+		}
+		if ec := toExtensionConfig(extension); ec != nil {
+			result = append(result, ec)
+		}
+	}
+	return result
+}
+
+// pluginConfiguration returns the configuration passed to the extension's
+// plugin.
+func pluginConfiguration(extension *maistramodel.ExtensionWrapper) (string, error) {
+	if rawV1Alpha1Config, ok := extension.Config.Data[v1alpha1.RawV1Alpha1Config]; ok {
+		// Extension uses old config format (string), so push it as a raw string
+		return rawV1Alpha1Config.(string), nil
+	}
+	// Otherwise convert the struct (json) to string and push it as string, since Envoy doesn't handle well protobuf.StructValue
+	rawBytes, err := json.Marshal(extension.Config.Data)
+	if err != nil {
+		return "", err
+	}
+	return string(rawBytes), nil
+}
+
+// toExtensionConfig returns the wasm filter of the extension.  The filter is
+// typed rather than wrapped in a TypedStruct, as the agent only rewrites
+// remote modules in the latter, and the module must be fetched by Envoy
+// through the cache cluster, which authenticates to MEC.
+func toExtensionConfig(extension *maistramodel.ExtensionWrapper) *core.TypedExtensionConfig {
+	config, err := toPluginConfig(extension)
+	if err != nil {
+		log.Errorf("invalid configuration for extension %s/%s: %v", extension.Namespace, extension.Name, err)
+		return nil
+	}
+	return &core.TypedExtensionConfig{
+		Name:        extension.ResourceName(),
+		TypedConfig: util.MessageToAny(&wasmfilter.Wasm{Config: config}),
+	}
+}
+
+// toPluginConfig returns the configuration of the wasm plugin running the
+// extension, which is shared by HTTP and network filters.
+func toPluginConfig(extension *maistramodel.ExtensionWrapper) (*wasm.PluginConfig, error) {
+	configuration, err := pluginConfiguration(extension)
+	if err != nil {
+		return nil, err
+	}
+	return &wasm.PluginConfig{
+		Name:          extension.Name,
+		RootId:        extension.Name + "_root",
+		Configuration: util.MessageToAny(&wrappers.StringValue{Value: configuration}),
+		FailOpen:      extension.FailurePolicy == maistramodel.FailurePolicyFailOpen,
+		Vm: &wasm.PluginConfig_VmConfig{
+			VmConfig: &wasm.VmConfig{
+				Runtime: Runtime,
+				Code: &core.AsyncDataSource{
+					Specifier: &core.AsyncDataSource_Remote{
+						Remote: &core.RemoteDataSource{
+							HttpUri: &core.HttpUri{
+								Uri: extension.FilterURL,
+								HttpUpstreamType: &core.HttpUri_Cluster{
+									Cluster: CacheCluster,
+								},
+								Timeout: ptypes.DurationProto(moduleFetchTimeout),
+							},
+							Sha256: extension.SHA256,
+							RetryPolicy: &core.RetryPolicy{
+								NumRetries: &wrappers.UInt32Value{Value: moduleFetchRetries},
+							},
+						},
+					},
+				},
+			},
+		},
+	}, nil
+}
@@ -28,6 +28,72 @@ const (
 	DefaultFederationRootCertName = "root-cert.pem"
 )
 
+const (
+	// DiscoveryTransportAnnotation may be added to a ServiceMeshPeer to select
+	// the transport used to discover the services exported by the peer.  If
+	// unspecified, DiscoveryTransportHTTP is used.
+	DiscoveryTransportAnnotation = "federation.maistra.io/discovery-transport"
+	// DiscoveryTransportHTTP discovers services using the JSON /services and
+	// /watch endpoints.
+	DiscoveryTransportHTTP = "http"
+	// DiscoveryTransportGRPC discovers services using an ADS (xDS) stream.
+	DiscoveryTransportGRPC = "grpc"
+
+	// ForwardedClientCertHeader is set by the ingress gateway on discovery
+	// requests to the details of the client certificate it verified, which
+	// identifies the mesh making gRPC discovery requests.
+	ForwardedClientCertHeader = "x-forwarded-client-cert"
+	// DiscoveryServiceHeader is used to route discovery requests through the
+	// egress gateway to the correct remote mesh.
+	DiscoveryServiceHeader = "discovery-service"
+)
+
+// ExportEndpointSummariesAnnotation may be set to "true" on a ServiceMeshPeer
+// to include the locality and health of the endpoints backing each exported
+// service in the discovery responses sent to the peer.
+const ExportEndpointSummariesAnnotation = "federation.maistra.io/export-endpoint-summaries"
+
+const (
+	// MergeImportsAnnotation may be added to an ImportedServiceSet to merge
+	// services imported under the same hostname from multiple peers into a
+	// single service, allowing traffic to fail over between the peers.
+	MergeImportsAnnotation = "federation.maistra.io/merge-imports"
+	// MergeImportsPriority routes all traffic for a merged service through
+	// the available peer with the lowest ImportPriorityAnnotation.
+	MergeImportsPriority = "priority"
+	// MergeImportsLocality uses the locality of the imported services to
+	// order the endpoints of a merged service, so proxies fail over between
+	// peers using locality load balancing.  Peers sharing an egress gateway
+	// are ordered by priority.
+	MergeImportsLocality = "locality"
+
+	// ImportPriorityAnnotation specifies the priority of the services imported
+	// by an ImportedServiceSet when merging imports.  Lower values are
+	// preferred.  Defaults to 0.
+	ImportPriorityAnnotation = "federation.maistra.io/import-priority"
+)
+
+const (
+	DefaultPeerUnhealthyThreshold = 5 * time.Minute
+	DefaultPeerHealthCheckPeriod  = 10 * time.Second
+)
+
+const (
+	// FetchTrustBundleAnnotation may be set to "true" on a ServiceMeshPeer to
+	// periodically fetch the peer's root certificates from its discovery
+	// service.  The fetched roots are trusted in addition to the roots
+	// configured for the peer, allowing the peer to rotate its root without
+	// requiring configuration changes in this mesh.
+	FetchTrustBundleAnnotation = "federation.maistra.io/fetch-trust-bundle"
+
+	// AdditionalRootCertPrefix is the prefix of the entries containing
+	// additional roots for a peer, e.g. root-cert-new.pem.  Roots may also be
+	// concatenated in the DefaultFederationRootCertName entry.
+	AdditionalRootCertPrefix = "root-cert-"
+
+	DefaultTrustBundleRefreshPeriod = 5 * time.Minute
+)
+
 var (
 	Logger = log.RegisterScope("federation", "federation", 0)
 )
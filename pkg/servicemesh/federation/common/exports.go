@@ -15,6 +15,7 @@
 package common
 
 import (
+	"fmt"
 	"sync"
 
 	v1 "maistra.io/api/federation/v1"
@@ -45,59 +46,109 @@ func convertServiceExportsToNameMapper(serviceExports *v1.ExportedServiceSet, do
 	if serviceExports == nil {
 		return nil
 	}
-	var exportConfig []NameMapper
 	for index, rule := range serviceExports.Spec.ExportRules {
-		switch rule.Type {
-		case v1.LabelSelectorType:
-			if rule.LabelSelector == nil {
-				Logger.Errorf("skipping rule %d in ServiceExports %s/%s: null labelSelector", index, serviceExports.Namespace, serviceExports.Name)
-				continue
-			}
-			if matcher, err := newLabelMatcher(rule.LabelSelector, domainSuffix); err != nil {
-				Logger.Errorf("skipping rule %d in ServiceExports %s/%s: error creating matcher: %s",
-					index, serviceExports.Namespace, serviceExports.Name, err)
-				continue
-			} else {
-				exportConfig = append(exportConfig, matcher)
-			}
-		case v1.NameSelectorType:
-			if rule.NameSelector == nil {
-				Logger.Errorf("skipping rule %d in ServiceExports %s/%s: null nameSelector", index, serviceExports.Namespace, serviceExports.Name)
-				continue
+		if rule.Consumers == nil {
+			continue
+		}
+		for _, principal := range rule.Consumers.Principals {
+			if principal == "" {
+				// don't export anything rather than exporting services
+				// without the intended restrictions
+				Logger.Errorf("not exporting any services for ServiceExports %s/%s: empty consumer principal in rule %d",
+					serviceExports.Namespace, serviceExports.Name, index)
+				return nil
 			}
-			exportConfig = append(exportConfig, newNameMatcher(rule.NameSelector, domainSuffix))
-		default:
-			// unknown selector type
-			Logger.Errorf("skipping rule %d in ServiceExports %s/%s: unknown selector type %s",
-				rule.Type, index, serviceExports.Namespace, serviceExports.Name)
 		}
 	}
+	var exportConfig []NameMapper
+	for index, rule := range serviceExports.Spec.ExportRules {
+		matcher := convertExportRule(serviceExports, fmt.Sprintf("rule %d", index), &rule, domainSuffix)
+		if matcher == nil {
+			continue
+		}
+		if rule.Consumers != nil {
+			matcher = &authorizedMatcher{NameMapper: matcher, principals: rule.Consumers.Principals}
+		}
+		exportConfig = append(exportConfig, matcher)
+	}
 	return exportConfig
 }
 
+func convertExportRule(serviceExports *v1.ExportedServiceSet, ruleName string, rule *v1.ExportedServiceRule, domainSuffix string) NameMapper {
+	switch rule.Type {
+	case v1.LabelSelectorType:
+		if rule.LabelSelector == nil {
+			Logger.Errorf("skipping %s in ServiceExports %s/%s: null labelSelector", ruleName, serviceExports.Namespace, serviceExports.Name)
+			return nil
+		}
+		matcher, err := newLabelMatcher(rule.LabelSelector, domainSuffix)
+		if err != nil {
+			Logger.Errorf("skipping %s in ServiceExports %s/%s: error creating matcher: %s",
+				ruleName, serviceExports.Namespace, serviceExports.Name, err)
+			return nil
+		}
+		return matcher
+	case v1.NameSelectorType:
+		if rule.NameSelector == nil {
+			Logger.Errorf("skipping %s in ServiceExports %s/%s: null nameSelector", ruleName, serviceExports.Namespace, serviceExports.Name)
+			return nil
+		}
+		return newNameMatcher(rule.NameSelector, domainSuffix)
+	default:
+		// unknown selector type
+		Logger.Errorf("skipping %s in ServiceExports %s/%s: unknown selector type %s",
+			ruleName, serviceExports.Namespace, serviceExports.Name, rule.Type)
+		return nil
+	}
+}
+
+// authorizedMatcher restricts the remote principals that may call the services
+// matched by the wrapped NameMapper.
+type authorizedMatcher struct {
+	NameMapper
+	principals []string
+}
+
 func (se *ServiceExporter) NameForService(svc *model.Service) *federationmodel.ServiceKey {
+	name, _ := se.ExportForService(svc)
+	return name
+}
+
+// ExportForService returns the name under which the service is exported, along
+// with the remote principals allowed to call the service.  A nil list of
+// principals indicates that access to the service is not restricted.
+func (se *ServiceExporter) ExportForService(svc *model.Service) (*federationmodel.ServiceKey, []string) {
 	if se == nil {
-		return nil
+		return nil, nil
 	}
 	// don't reexport federated services
 	if serviceregistry.ProviderID(svc.Attributes.ServiceRegistry) == serviceregistry.Federation || svc.MeshExternal {
-		return nil
+		return nil, nil
 	}
 	se.mu.RLock()
 	defer se.mu.RUnlock()
 	for _, matcher := range se.exportConfig {
 		if name := matcher.NameForService(svc); name != nil {
 			setHostname(name, se.domainSuffix)
-			return name
+			if authorized, ok := matcher.(*authorizedMatcher); ok {
+				// never nil, so an empty list denies all callers
+				return name, append([]string{}, authorized.principals...)
+			}
+			return name, nil
 		}
 	}
 	if se.defaultMapper != nil {
-		if name := se.defaultMapper.NameForService(svc); name != nil {
+		if exporter, ok := se.defaultMapper.(*ServiceExporter); ok {
+			if name, principals := exporter.ExportForService(svc); name != nil {
+				setHostname(name, se.domainSuffix)
+				return name, principals
+			}
+		} else if name := se.defaultMapper.NameForService(svc); name != nil {
 			setHostname(name, se.domainSuffix)
-			return name
+			return name, nil
 		}
 	}
-	return nil
+	return nil, nil
 }
 
 func (se *ServiceExporter) UpdateDefaultMapper(defaults NameMapper) {
@@ -16,11 +16,17 @@ package common
 
 import (
 	"fmt"
+	"strconv"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	kubelabels "k8s.io/apimachinery/pkg/labels"
 	v1 "maistra.io/api/federation/v1"
 
+	rawsecurity "istio.io/api/security/v1beta1"
+	rawtype "istio.io/api/type/v1beta1"
+	"istio.io/istio/pkg/config"
+	"istio.io/istio/pkg/config/schema/gvk"
 	"istio.io/istio/pkg/kube"
 	"istio.io/istio/pkg/spiffe"
 )
@@ -37,6 +43,157 @@ func DefaultFederationCARootResourceName(instance *v1.ServiceMeshPeer) string {
 	return fmt.Sprintf("%s-ca-root-cert", instance.Name)
 }
 
+// DiscoveryTransportForPeer returns the transport that should be used for
+// discovering services exported by the peer.
+func DiscoveryTransportForPeer(instance *v1.ServiceMeshPeer) string {
+	switch transport := instance.Annotations[DiscoveryTransportAnnotation]; transport {
+	case "", DiscoveryTransportHTTP:
+		return DiscoveryTransportHTTP
+	case DiscoveryTransportGRPC:
+		return DiscoveryTransportGRPC
+	default:
+		Logger.Warnf("unknown discovery transport %q specified for ServiceMeshPeer %s/%s, using %s",
+			transport, instance.Namespace, instance.Name, DiscoveryTransportHTTP)
+		return DiscoveryTransportHTTP
+	}
+}
+
+// ExportEndpointSummariesForPeer returns true if endpoint summaries should be
+// included in the services exported to the peer.
+func ExportEndpointSummariesForPeer(instance *v1.ServiceMeshPeer) bool {
+	return instance.Annotations[ExportEndpointSummariesAnnotation] == "true"
+}
+
+// FetchTrustBundleForPeer returns true if the peer's root certificates should
+// be fetched from its discovery service.
+func FetchTrustBundleForPeer(instance *v1.ServiceMeshPeer) bool {
+	return instance.Annotations[FetchTrustBundleAnnotation] == "true"
+}
+
+// MergeModeForImports returns the mode used to merge the services imported
+// using the ImportedServiceSet with services imported from other peers, or an
+// empty string if the services should not be merged.
+func MergeModeForImports(instance *v1.ImportedServiceSet) string {
+	if instance == nil {
+		return ""
+	}
+	switch mode := instance.Annotations[MergeImportsAnnotation]; mode {
+	case "", MergeImportsPriority, MergeImportsLocality:
+		return mode
+	default:
+		Logger.Warnf("unknown merge mode %q specified for ImportedServiceSet %s/%s, using %s",
+			mode, instance.Namespace, instance.Name, MergeImportsPriority)
+		return MergeImportsPriority
+	}
+}
+
+// ImportPriorityForImports returns the priority of the services imported using
+// the ImportedServiceSet when merging imports.
+func ImportPriorityForImports(instance *v1.ImportedServiceSet) int {
+	if instance == nil || instance.Annotations[ImportPriorityAnnotation] == "" {
+		return 0
+	}
+	priority, err := strconv.Atoi(instance.Annotations[ImportPriorityAnnotation])
+	if err != nil {
+		Logger.Warnf("invalid import priority %q specified for ImportedServiceSet %s/%s, using 0",
+			instance.Annotations[ImportPriorityAnnotation], instance.Namespace, instance.Name)
+		return 0
+	}
+	return priority
+}
+
+// ConsumerAuthorizationPolicy returns a DENY AuthorizationPolicy for the
+// federation gateway selected by gatewayName, which denies connections to the
+// hostname from any identity other than the principals.  Federated services
+// are proxied by the gateways as TLS, where HTTP attributes such as the host
+// are not available, so connections are matched by SNI.  An empty list of
+// principals denies all connections to the hostname.
+func ConsumerAuthorizationPolicy(name, namespace, gatewayName, hostname string, principals []string) *config.Config {
+	rule := &rawsecurity.Rule{
+		To: []*rawsecurity.Rule_To{
+			{
+				Operation: &rawsecurity.Operation{
+					Ports: []string{
+						strconv.FormatInt(DefaultFederationPort, 10),
+					},
+				},
+			},
+		},
+		When: []*rawsecurity.Condition{
+			{
+				// the gateways accept outbound_.<port>_.<subset>_.<hostname>
+				Key: "connection.sni",
+				Values: []string{
+					hostname,
+					"*." + hostname,
+				},
+			},
+		},
+	}
+	if len(principals) > 0 {
+		// no From denies all connections
+		rule.From = []*rawsecurity.Rule_From{
+			{
+				Source: &rawsecurity.Source{
+					NotPrincipals: append([]string(nil), principals...),
+				},
+			},
+		}
+	}
+	return &config.Config{
+		Meta: config.Meta{
+			GroupVersionKind: gvk.AuthorizationPolicy,
+			Name:             name,
+			Namespace:        namespace,
+		},
+		Spec: &rawsecurity.AuthorizationPolicy{
+			Selector: &rawtype.WorkloadSelector{
+				MatchLabels: map[string]string{
+					"service.istio.io/canonical-name": gatewayName,
+				},
+			},
+			Action: rawsecurity.AuthorizationPolicy_DENY,
+			Rules:  []*rawsecurity.Rule{rule},
+		},
+	}
+}
+
+// PeerHealthConfig specifies when a peer is considered unavailable and what
+// happens to the services imported from it.
+type PeerHealthConfig struct {
+	// UnhealthyThreshold is the time after which the imported services are
+	// marked unhealthy.  Zero disables the threshold.
+	UnhealthyThreshold time.Duration
+	// WithdrawThreshold is the time after which the imported services are
+	// withdrawn.  Zero disables the threshold.
+	WithdrawThreshold time.Duration
+	// GatewayProbeInterval is the interval at which the peer's gateways are
+	// probed.  Zero disables probing.
+	GatewayProbeInterval time.Duration
+}
+
+// PeerHealthConfigForPeer returns the health checking configuration for the
+// peer.
+func PeerHealthConfigForPeer(instance *v1.ServiceMeshPeer) PeerHealthConfig {
+	config := PeerHealthConfig{
+		UnhealthyThreshold: DefaultPeerUnhealthyThreshold,
+	}
+	health := instance.Spec.Health
+	if health == nil {
+		return config
+	}
+	if health.UnhealthyThreshold != nil {
+		config.UnhealthyThreshold = health.UnhealthyThreshold.Duration
+	}
+	if health.WithdrawThreshold != nil {
+		config.WithdrawThreshold = health.WithdrawThreshold.Duration
+	}
+	if health.GatewayProbeInterval != nil {
+		config.GatewayProbeInterval = health.GatewayProbeInterval.Duration
+	}
+	return config
+}
+
 // EndpointsForService returns the Endpoints for the named service.
 func EndpointsForService(client kube.Client, name, namespace string) (*corev1.Endpoints, error) {
 	return client.KubeInformer().Core().V1().Endpoints().Lister().Endpoints(namespace).Get(name)
@@ -50,6 +50,10 @@ type Options struct {
 	StatusManager     status.Manager
 	LocalNetwork      string
 	LocalClusterID    string
+	// TrustBundleRefreshPeriod is the interval at which trust bundles are
+	// fetched from peers configured with common.FetchTrustBundleAnnotation.
+	// Defaults to common.DefaultTrustBundleRefreshPeriod.
+	TrustBundleRefreshPeriod time.Duration
 }
 
 type Controller struct {
@@ -65,7 +69,10 @@ type Controller struct {
 	xds               model.XDSUpdater
 	mu                sync.Mutex
 	stopChannels      map[string]chan struct{}
-	trustBundles      map[string]string
+	// trust bundles keyed by peer name
+	trustBundles             map[string]*peerTrustBundle
+	trustBundleRefreshPeriod time.Duration
+	importMerger             *federationregistry.ImportMerger
 }
 
 var _ model.ConfigStore = (*Controller)(nil)
@@ -78,6 +85,10 @@ func NewController(opt Options) (*Controller, error) {
 	}
 
 	logger := common.Logger.WithLabels("component", controllerName)
+	trustBundleRefreshPeriod := opt.TrustBundleRefreshPeriod
+	if trustBundleRefreshPeriod == 0 {
+		trustBundleRefreshPeriod = common.DefaultTrustBundleRefreshPeriod
+	}
 
 	controller := &Controller{
 		ConfigStoreCache:  opt.ConfigStore,
@@ -90,7 +101,10 @@ func NewController(opt Options) (*Controller, error) {
 		xds:               opt.XDSUpdater,
 		federationManager: opt.FederationManager,
 		statusManager:     opt.StatusManager,
-		trustBundles:      map[string]string{},
+		trustBundles:      map[string]*peerTrustBundle{},
+		importMerger:      federationregistry.NewImportMerger(),
+
+		trustBundleRefreshPeriod: trustBundleRefreshPeriod,
 	}
 	internalController := kubecontroller.NewController(kubecontroller.Options{
 		Informer:     opt.ResourceManager.PeerInformer().Informer(),
@@ -165,11 +179,13 @@ func (c *Controller) update(ctx context.Context, instance *v1.ServiceMeshPeer) e
 		instance.Spec.Gateways.Egress.Name, instance.Namespace, c.env.GetDomainSuffix())
 
 	if instance.Spec.Security.TrustDomain != "" && instance.Spec.Security.TrustDomain != c.env.Mesh().GetTrustDomain() {
-		rootCert, err := c.getRootCertForMesh(instance)
+		roots, err := c.getRootCertsForMesh(instance)
 		if err != nil {
 			return err
 		}
-		c.updateRootCert(instance.Spec.Security.TrustDomain, rootCert)
+		c.updateConfiguredRoots(instance, roots)
+	} else {
+		c.deleteTrustBundle(instance.Name)
 	}
 
 	// check for existing registry
@@ -188,6 +204,7 @@ func (c *Controller) update(ctx context.Context, instance *v1.ServiceMeshPeer) e
 				// TODO: support updates
 				c.Logger.Warnf("updating NetworkAddress for ServiceMeshPeer (%s) is not supported", instance.Name)
 			}
+			federationRegistry.UpdatePeerHealthConfig(common.PeerHealthConfigForPeer(instance))
 		} else {
 			return fmt.Errorf("registry %s is not a Federation registry (type=%T)", instance.Name, registry)
 		}
@@ -218,21 +235,23 @@ func (c *Controller) update(ctx context.Context, instance *v1.ServiceMeshPeer) e
 		c.Logger.Infof("Initializing Federation service registry for %q at %s", instance.Name, instance.Spec.Remote.Addresses)
 		// create a registry instance
 		options := federationregistry.Options{
-			Remote:         instance.Spec.Remote.DeepCopy(),
-			EgressName:     instance.Spec.Gateways.Egress.Name,
-			EgressService:  egressGatewayService,
-			Namespace:      instance.Namespace,
-			UseDirectCalls: instance.Spec.Security.AllowDirectOutbound,
-			KubeClient:     c.rm.KubeClient(),
-			ConfigStore:    c.ConfigStoreCache,
-			StatusHandler:  statusHandler,
-			XDSUpdater:     c.xds,
-			ResyncPeriod:   time.Minute * 5,
-			DomainSuffix:   c.env.GetDomainSuffix(),
-			LocalClusterID: c.localClusterID,
-			LocalNetwork:   c.localNetwork,
-			ClusterID:      instance.Name,
-			Network:        fmt.Sprintf("network-%s", instance.Name),
+			Remote:             instance.Spec.Remote.DeepCopy(),
+			EgressName:         instance.Spec.Gateways.Egress.Name,
+			EgressService:      egressGatewayService,
+			Namespace:          instance.Namespace,
+			UseDirectCalls:     instance.Spec.Security.AllowDirectOutbound,
+			DiscoveryTransport: common.DiscoveryTransportForPeer(instance),
+			KubeClient:         c.rm.KubeClient(),
+			ConfigStore:        c.ConfigStoreCache,
+			StatusHandler:      statusHandler,
+			XDSUpdater:         c.xds,
+			ResyncPeriod:       time.Minute * 5,
+			DomainSuffix:       c.env.GetDomainSuffix(),
+			LocalClusterID:     c.localClusterID,
+			LocalNetwork:       c.localNetwork,
+			ClusterID:          instance.Name,
+			Network:            fmt.Sprintf("network-%s", instance.Name),
+			ImportMerger:       c.importMerger,
 		}
 		registry = federationregistry.NewController(options, instance, importConfig)
 		// register the new instance
@@ -240,11 +259,15 @@ func (c *Controller) update(ctx context.Context, instance *v1.ServiceMeshPeer) e
 
 		stopCh := make(chan struct{})
 		c.mu.Lock()
-		defer c.mu.Unlock()
 		c.stopChannels[instance.Name] = stopCh
+		c.mu.Unlock()
 		go registry.Run(stopCh)
+		go c.runTrustBundleFetcher(types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace},
+			fmt.Sprintf("%s://%s:%d/trustbundle", common.DiscoveryScheme, egressGatewayService, common.DefaultDiscoveryPort), stopCh)
 	}
 
+	c.checkTrustBundleExpiry(instance)
+
 	return nil
 }
 
@@ -254,9 +277,7 @@ func (c *Controller) delete(ctx context.Context, instance *v1.ServiceMeshPeer) e
 	c.federationManager.DeletePeer(instance.Name)
 
 	// delete trust bundle
-	if instance.Spec.Security.TrustDomain != "" {
-		c.updateRootCert(instance.Spec.Security.TrustDomain, "")
-	}
+	c.deleteTrustBundle(instance.Name)
 
 	// delete the registry
 	registry := c.getRegistry(instance.Name)
@@ -321,54 +342,24 @@ func (opt Options) validate() error {
 	return utilerrors.NewAggregate(allErrors)
 }
 
-func (c *Controller) getRootCertForMesh(instance *v1.ServiceMeshPeer) (string, error) {
-	if instance == nil {
-		return "", nil
-	}
-	name := instance.Spec.Security.CertificateChain.Name
-	if name == "" {
-		name = common.DefaultFederationCARootResourceName(instance)
-	}
-	entryKey := common.DefaultFederationRootCertName
-	switch instance.Spec.Security.CertificateChain.Kind {
-	case "", "ConfigMap":
-		cm, err := c.rm.KubeClient().KubeInformer().Core().V1().ConfigMaps().Lister().ConfigMaps(instance.Namespace).Get(name)
-		if err != nil {
-			return "", err
-		}
-		if cert, exists := cm.Data[entryKey]; exists {
-			return cert, nil
-		}
-		return "", fmt.Errorf("missing entry %s in ConfigMap %s/%s", entryKey, instance.Namespace, name)
-	default:
-		return "", fmt.Errorf("unknown Kind for CertificateChain object reference: %s", instance.Spec.Security.CertificateChain.Kind)
-	}
-}
-
-func (c *Controller) updateRootCert(trustDomain string, rootCert string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if rootCert == "" {
-		delete(c.trustBundles, trustDomain)
-	} else if existingCert, ok := c.trustBundles[trustDomain]; !ok || existingCert != rootCert {
-		c.trustBundles[trustDomain] = rootCert
-	} else {
-		// we didn't update the trust bundles, so we return early without pushing
-		return
-	}
-	c.xds.ConfigUpdate(&model.PushRequest{
-		Full:   true,
-		Reason: []model.TriggerReason{model.GlobalUpdate},
-	})
-}
-
 func (c *Controller) GetTrustBundles() map[string]string {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	// make a copy
+	// peers sharing a trust domain trust each other's roots
+	roots := map[string][]rootCert{}
+	now := time.Now()
+	for _, bundle := range c.trustBundles {
+		if bundle.trustDomain == "" {
+			continue
+		}
+		roots[bundle.trustDomain] = append(roots[bundle.trustDomain], bundle.roots(now)...)
+	}
 	ret := map[string]string{}
-	for td, cert := range c.trustBundles {
-		ret[td] = cert
+	for td, tdRoots := range roots {
+		if len(tdRoots) == 0 {
+			continue
+		}
+		ret[td] = encodeRoots((&peerTrustBundle{configured: tdRoots}).roots(now))
 	}
 	return ret
 }
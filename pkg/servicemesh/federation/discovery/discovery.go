@@ -498,7 +498,7 @@ func (c *Controller) discoveryEgressVirtualService(instance *v1.ServiceMeshPeer)
 						{
 							Port: uint32(discoveryPort),
 							Headers: map[string]*rawnetworking.StringMatch{
-								"discovery-service": {
+								common.DiscoveryServiceHeader: {
 									MatchType: &rawnetworking.StringMatch_Exact{
 										Exact: discoveryService,
 									},
@@ -618,6 +618,70 @@ func (c *Controller) discoveryIngressVirtualService(
 						},
 					},
 				},
+				{
+					// inbound trust bundle requests
+					Name: fmt.Sprintf("%s-ingress-trustbundle", name),
+					Match: []*rawnetworking.HTTPMatchRequest{
+						{
+							Gateways: []string{
+								ingressGatewayName,
+							},
+							Port: uint32(discoveryPort),
+							Uri: &rawnetworking.StringMatch{
+								MatchType: &rawnetworking.StringMatch_Exact{
+									Exact: "/trustbundle",
+								},
+							},
+						},
+					},
+					Rewrite: &rawnetworking.HTTPRewrite{
+						Authority: istiodService,
+						Uri:       "/trustbundle/" + instance.Name,
+					},
+					Route: []*rawnetworking.HTTPRouteDestination{
+						{
+							Destination: &rawnetworking.Destination{
+								Host: istiodService,
+								Port: &rawnetworking.PortSelector{
+									Number: uint32(discoveryPort),
+								},
+							},
+						},
+					},
+				},
+				{
+					// inbound discovery requests over gRPC
+					Name: fmt.Sprintf("%s-ingress-grpc", name),
+					Match: []*rawnetworking.HTTPMatchRequest{
+						{
+							Gateways: []string{
+								ingressGatewayName,
+							},
+							Port: uint32(discoveryPort),
+							Uri: &rawnetworking.StringMatch{
+								MatchType: &rawnetworking.StringMatch_Prefix{
+									Prefix: "/envoy.service.discovery.v3.AggregatedDiscoveryService/",
+								},
+							},
+						},
+					},
+					Rewrite: &rawnetworking.HTTPRewrite{
+						Authority: istiodService,
+					},
+					// the mesh is identified using the client certificate,
+					// which the gateway forwards in place of any value
+					// specified by the client
+					Route: []*rawnetworking.HTTPRouteDestination{
+						{
+							Destination: &rawnetworking.Destination{
+								Host: istiodService,
+								Port: &rawnetworking.PortSelector{
+									Number: uint32(discoveryPort),
+								},
+							},
+						},
+					},
+				},
 			},
 		},
 	}
@@ -664,5 +728,14 @@ func (c *Controller) discoveryDestinationRule(instance *v1.ServiceMeshPeer) *con
 			},
 		},
 	}
+	if common.DiscoveryTransportForPeer(instance) == common.DiscoveryTransportGRPC {
+		// gRPC requires http2 to the remote mesh
+		dr.Spec.(*rawnetworking.DestinationRule).Subsets[0].TrafficPolicy.PortLevelSettings[0].ConnectionPool =
+			&rawnetworking.ConnectionPoolSettings{
+				Http: &rawnetworking.ConnectionPoolSettings_HTTPSettings{
+					H2UpgradePolicy: rawnetworking.ConnectionPoolSettings_HTTPSettings_UPGRADE,
+				},
+			}
+	}
 	return dr
 }
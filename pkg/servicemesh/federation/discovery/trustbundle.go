@@ -0,0 +1,322 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	v1 "maistra.io/api/federation/v1"
+
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/servicemesh/federation/common"
+	federationmodel "istio.io/istio/pkg/servicemesh/federation/model"
+)
+
+const (
+	// roots expiring within this period are logged as warnings
+	trustBundleExpiryWarningPeriod = 30 * 24 * time.Hour
+	// trustBundleFetchTimeout bounds the time spent fetching the trust bundle
+	// from the peer, so an unresponsive peer doesn't block the fetcher
+	trustBundleFetchTimeout = 30 * time.Second
+)
+
+var trustBundleClient = &http.Client{Timeout: trustBundleFetchTimeout}
+
+// rootCert is a root certificate trusted for a peer.
+type rootCert struct {
+	pem string
+	// notAfter is zero if the certificate could not be parsed
+	notAfter time.Time
+}
+
+// parseRootCerts splits the PEM encoded data into individual certificates.
+// Data that is not PEM encoded is returned as a single root, as is.
+func parseRootCerts(data string) []rootCert {
+	var roots []rootCert
+	rest := []byte(data)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		root := rootCert{pem: string(pem.EncodeToMemory(block))}
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			root.notAfter = cert.NotAfter
+		}
+		roots = append(roots, root)
+	}
+	if len(roots) == 0 && strings.TrimSpace(data) != "" {
+		roots = append(roots, rootCert{pem: data})
+	}
+	return roots
+}
+
+// peerTrustBundle contains the roots trusted for a peer.  Roots are
+// configured using the peer's CertificateChain and may also be fetched from
+// the peer.  Both sets of roots are trusted, so the peer may introduce a new
+// root before it starts issuing certificates with it.
+type peerTrustBundle struct {
+	trustDomain string
+	configured  []rootCert
+	fetched     []rootCert
+}
+
+// roots returns the roots trusted at the specified time.  Expired roots are
+// dropped once an unexpired root is available.
+func (b *peerTrustBundle) roots(now time.Time) []rootCert {
+	var all, valid []rootCert
+	seen := map[string]bool{}
+	for _, roots := range [][]rootCert{b.configured, b.fetched} {
+		for _, root := range roots {
+			if seen[root.pem] {
+				continue
+			}
+			seen[root.pem] = true
+			all = append(all, root)
+			if root.notAfter.IsZero() || root.notAfter.After(now) {
+				valid = append(valid, root)
+			}
+		}
+	}
+	if len(valid) == 0 {
+		return all
+	}
+	return valid
+}
+
+// trusts returns true if any of the roots is currently trusted.
+func (b *peerTrustBundle) trusts(roots []rootCert, now time.Time) bool {
+	for _, trusted := range b.roots(now) {
+		for _, root := range roots {
+			if root.pem == trusted.pem {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func encodeRoots(roots []rootCert) string {
+	pems := make([]string, 0, len(roots))
+	for _, root := range roots {
+		pems = append(pems, root.pem)
+	}
+	return strings.Join(pems, "")
+}
+
+// earliestExpiry returns the earliest expiration time of the roots, or zero if
+// the expiration time of the roots is unknown.
+func earliestExpiry(roots []rootCert) time.Time {
+	var expiry time.Time
+	for _, root := range roots {
+		if !root.notAfter.IsZero() && (expiry.IsZero() || root.notAfter.Before(expiry)) {
+			expiry = root.notAfter
+		}
+	}
+	return expiry
+}
+
+func (c *Controller) getRootCertsForMesh(instance *v1.ServiceMeshPeer) ([]rootCert, error) {
+	if instance == nil {
+		return nil, nil
+	}
+	name := instance.Spec.Security.CertificateChain.Name
+	if name == "" {
+		name = common.DefaultFederationCARootResourceName(instance)
+	}
+	entryKey := common.DefaultFederationRootCertName
+	switch instance.Spec.Security.CertificateChain.Kind {
+	case "", "ConfigMap":
+		cm, err := c.rm.KubeClient().KubeInformer().Core().V1().ConfigMaps().Lister().ConfigMaps(instance.Namespace).Get(name)
+		if err != nil {
+			return nil, err
+		}
+		cert, exists := cm.Data[entryKey]
+		if !exists {
+			return nil, fmt.Errorf("missing entry %s in ConfigMap %s/%s", entryKey, instance.Namespace, name)
+		}
+		roots := parseRootCerts(cert)
+		// additional roots, e.g. a new root being introduced by the peer
+		var additionalKeys []string
+		for key := range cm.Data {
+			if strings.HasPrefix(key, common.AdditionalRootCertPrefix) && strings.HasSuffix(key, ".pem") {
+				additionalKeys = append(additionalKeys, key)
+			}
+		}
+		sort.Strings(additionalKeys)
+		for _, key := range additionalKeys {
+			roots = append(roots, parseRootCerts(cm.Data[key])...)
+		}
+		return roots, nil
+	default:
+		return nil, fmt.Errorf("unknown Kind for CertificateChain object reference: %s", instance.Spec.Security.CertificateChain.Kind)
+	}
+}
+
+// updateTrustBundle applies the update to the trust bundle for the peer,
+// pushing the trust bundles to the proxies if the trusted roots have changed.
+func (c *Controller) updateTrustBundle(instance *v1.ServiceMeshPeer, update func(bundle *peerTrustBundle) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	bundle := c.trustBundles[instance.Name]
+	if bundle == nil {
+		bundle = &peerTrustBundle{}
+		c.trustBundles[instance.Name] = bundle
+	}
+	now := time.Now()
+	oldTrustDomain, oldRoots := bundle.trustDomain, encodeRoots(bundle.roots(now))
+	if err := update(bundle); err != nil {
+		return err
+	}
+	bundle.trustDomain = instance.Spec.Security.TrustDomain
+	if bundle.trustDomain == oldTrustDomain && encodeRoots(bundle.roots(now)) == oldRoots {
+		// we didn't update the trust bundles, so we return early without pushing
+		return nil
+	}
+	c.xds.ConfigUpdate(&model.PushRequest{
+		Full:   true,
+		Reason: []model.TriggerReason{model.GlobalUpdate},
+	})
+	return nil
+}
+
+func (c *Controller) updateConfiguredRoots(instance *v1.ServiceMeshPeer, roots []rootCert) {
+	_ = c.updateTrustBundle(instance, func(bundle *peerTrustBundle) error {
+		bundle.configured = roots
+		return nil
+	})
+}
+
+// updateFetchedRoots verifies the trust bundle fetched from the peer and adds
+// its roots to the roots trusted for the peer.  Rotations must overlap, i.e.
+// the fetched roots must include a root that is currently trusted.
+func (c *Controller) updateFetchedRoots(instance *v1.ServiceMeshPeer, message *federationmodel.TrustBundleMessage) error {
+	if message.TrustDomain != instance.Spec.Security.TrustDomain {
+		return fmt.Errorf("trust bundle is for trust domain %s, expected %s", message.TrustDomain, instance.Spec.Security.TrustDomain)
+	}
+	roots := parseRootCerts(message.RootCerts)
+	if len(roots) == 0 {
+		return fmt.Errorf("trust bundle does not contain any root certificates")
+	}
+	return c.updateTrustBundle(instance, func(bundle *peerTrustBundle) error {
+		if !bundle.trusts(roots, time.Now()) {
+			return fmt.Errorf("trust bundle does not contain any of the roots currently trusted for the peer")
+		}
+		bundle.fetched = roots
+		return nil
+	})
+}
+
+func (c *Controller) clearFetchedRoots(instance *v1.ServiceMeshPeer) {
+	_ = c.updateTrustBundle(instance, func(bundle *peerTrustBundle) error {
+		bundle.fetched = nil
+		return nil
+	})
+}
+
+func (c *Controller) deleteTrustBundle(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.trustBundles[name]; !exists {
+		return
+	}
+	delete(c.trustBundles, name)
+	c.xds.ConfigUpdate(&model.PushRequest{
+		Full:   true,
+		Reason: []model.TriggerReason{model.GlobalUpdate},
+	})
+}
+
+// checkTrustBundleExpiry warns if a root trusted for the peer expires soon.
+func (c *Controller) checkTrustBundleExpiry(instance *v1.ServiceMeshPeer) {
+	var roots []rootCert
+	func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if bundle := c.trustBundles[instance.Name]; bundle != nil {
+			roots = bundle.roots(time.Now())
+		}
+	}()
+	expiry := earliestExpiry(roots)
+	if !expiry.IsZero() && time.Until(expiry) < trustBundleExpiryWarningPeriod {
+		c.Logger.Warnf("root certificate trusted for ServiceMeshPeer %s/%s expires at %s",
+			instance.Namespace, instance.Name, expiry.Format(time.RFC3339))
+	}
+}
+
+// fetchTrustBundle retrieves the trust bundle from the peer's discovery
+// service.  The request is routed through the egress gateway, which
+// authenticates the peer using the roots currently trusted for the peer.
+func fetchTrustBundle(url, discoveryServiceName string) (*federationmodel.TrustBundleMessage, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add(common.DiscoveryServiceHeader, discoveryServiceName)
+	resp, err := trustBundleClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code is not OK: %v (%s)", resp.StatusCode, resp.Status)
+	}
+	var message federationmodel.TrustBundleMessage
+	if err := json.NewDecoder(resp.Body).Decode(&message); err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// runTrustBundleFetcher periodically fetches the trust bundle from the peer,
+// if enabled, until stopCh is closed.
+func (c *Controller) runTrustBundleFetcher(mesh types.NamespacedName, url string, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(c.trustBundleRefreshPeriod)
+	defer ticker.Stop()
+	for {
+		if instance, err := c.rm.PeerInformer().Lister().ServiceMeshPeers(mesh.Namespace).Get(mesh.Name); err == nil &&
+			instance.Spec.Security.TrustDomain != "" && instance.Spec.Security.TrustDomain != c.env.Mesh().GetTrustDomain() {
+			if common.FetchTrustBundleForPeer(instance) {
+				message, err := fetchTrustBundle(url, common.DiscoveryServiceHostname(instance))
+				if err == nil {
+					err = c.updateFetchedRoots(instance, message)
+				}
+				if err != nil {
+					c.Logger.Warnf("error updating trust bundle for ServiceMeshPeer %s: %s", mesh, err)
+				}
+			} else {
+				c.clearFetchedRoots(instance)
+			}
+			c.checkTrustBundleExpiry(instance)
+		}
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
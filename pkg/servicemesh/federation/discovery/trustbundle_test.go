@@ -0,0 +1,171 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1 "maistra.io/api/federation/v1"
+
+	"istio.io/istio/pilot/pkg/networking/core/v1alpha3"
+	federationmodel "istio.io/istio/pkg/servicemesh/federation/model"
+)
+
+func newRootCertPEM(t *testing.T, name string, notAfter time.Time) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating certificate: %s", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestParseRootCerts(t *testing.T) {
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+	oldRoot := newRootCertPEM(t, "old", expiry)
+	newRoot := newRootCertPEM(t, "new", expiry.Add(time.Hour))
+
+	roots := parseRootCerts(oldRoot + newRoot)
+	if len(roots) != 2 {
+		t.Fatalf("expected 2 roots, got %d", len(roots))
+	}
+	if roots[0].pem != oldRoot || roots[1].pem != newRoot {
+		t.Errorf("roots were not split correctly")
+	}
+	if !earliestExpiry(roots).Equal(expiry) {
+		t.Errorf("expected expiry %s, got %s", expiry, earliestExpiry(roots))
+	}
+
+	roots = parseRootCerts("dummy-cert-pem")
+	if len(roots) != 1 || roots[0].pem != "dummy-cert-pem" || !roots[0].notAfter.IsZero() {
+		t.Errorf("expected data that is not PEM encoded to be returned as is, got %v", roots)
+	}
+	if roots := parseRootCerts(""); len(roots) != 0 {
+		t.Errorf("expected no roots, got %v", roots)
+	}
+}
+
+func TestPeerTrustBundleRoots(t *testing.T) {
+	now := time.Now()
+	expired := parseRootCerts(newRootCertPEM(t, "expired", now.Add(-time.Hour)))
+	valid := parseRootCerts(newRootCertPEM(t, "valid", now.Add(time.Hour)))
+
+	bundle := &peerTrustBundle{configured: expired}
+	if roots := bundle.roots(now); len(roots) != 1 {
+		t.Errorf("expected expired root to be kept while no other root is available")
+	}
+	bundle.fetched = append(valid, expired...)
+	roots := bundle.roots(now)
+	if len(roots) != 1 || roots[0].pem != valid[0].pem {
+		t.Errorf("expected only the valid root to be trusted, got %v", roots)
+	}
+}
+
+func TestUpdateFetchedRoots(t *testing.T) {
+	now := time.Now()
+	oldRoot := newRootCertPEM(t, "old", now.Add(time.Hour))
+	newRoot := newRootCertPEM(t, "new", now.Add(2*time.Hour))
+	otherRoot := newRootCertPEM(t, "other", now.Add(2*time.Hour))
+
+	xdsUpdater := &v1alpha3.FakeXdsUpdater{}
+	controller := &Controller{
+		xds:          xdsUpdater,
+		trustBundles: map[string]*peerTrustBundle{},
+	}
+	instance := &v1.ServiceMeshPeer{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "test"},
+		Spec: v1.ServiceMeshPeerSpec{
+			Security: v1.ServiceMeshPeerSecurity{TrustDomain: "test.local"},
+		},
+	}
+	controller.updateConfiguredRoots(instance, parseRootCerts(oldRoot))
+
+	testCases := []struct {
+		name    string
+		message *federationmodel.TrustBundleMessage
+	}{
+		{
+			name:    "wrong-trust-domain",
+			message: &federationmodel.TrustBundleMessage{TrustDomain: "other.local", RootCerts: oldRoot + newRoot},
+		},
+		{
+			name:    "empty",
+			message: &federationmodel.TrustBundleMessage{TrustDomain: "test.local"},
+		},
+		{
+			name:    "untrusted",
+			message: &federationmodel.TrustBundleMessage{TrustDomain: "test.local", RootCerts: otherRoot},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := controller.updateFetchedRoots(instance, tc.message); err == nil {
+				t.Errorf("expected trust bundle to be rejected")
+			}
+			if bundles := controller.GetTrustBundles(); bundles["test.local"] != oldRoot {
+				t.Errorf("unexpected trust bundles: %v", bundles)
+			}
+		})
+	}
+
+	// rotation overlapping the current root
+	if err := controller.updateFetchedRoots(instance, &federationmodel.TrustBundleMessage{
+		TrustDomain: "test.local",
+		RootCerts:   oldRoot + newRoot,
+	}); err != nil {
+		t.Fatalf("unexpected error updating trust bundle: %s", err)
+	}
+	if bundles := controller.GetTrustBundles(); bundles["test.local"] != oldRoot+newRoot {
+		t.Errorf("expected old and new roots to be trusted, got %v", bundles)
+	}
+
+	// the peer has stopped advertising the old root, but it's still configured
+	if err := controller.updateFetchedRoots(instance, &federationmodel.TrustBundleMessage{
+		TrustDomain: "test.local",
+		RootCerts:   newRoot,
+	}); err != nil {
+		t.Fatalf("unexpected error updating trust bundle: %s", err)
+	}
+	if bundles := controller.GetTrustBundles(); bundles["test.local"] != oldRoot+newRoot {
+		t.Errorf("expected old and new roots to be trusted, got %v", bundles)
+	}
+
+	controller.deleteTrustBundle(instance.Name)
+	if bundles := controller.GetTrustBundles(); len(bundles) != 0 {
+		t.Errorf("expected no trust bundles, got %v", bundles)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"time"
 
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/errors"
 	maistraclient "maistra.io/api/client/versioned"
@@ -32,6 +33,7 @@ import (
 	"istio.io/istio/pkg/servicemesh/federation/discovery"
 	"istio.io/istio/pkg/servicemesh/federation/exports"
 	"istio.io/istio/pkg/servicemesh/federation/imports"
+	federationmodel "istio.io/istio/pkg/servicemesh/federation/model"
 	"istio.io/istio/pkg/servicemesh/federation/server"
 	"istio.io/istio/pkg/servicemesh/federation/status"
 	"istio.io/pkg/log"
@@ -63,11 +65,14 @@ type Options struct {
 	BindAddress         string
 	Env                 *model.Environment
 	XDSUpdater          model.XDSUpdater
+	ADSServer           discoveryv3.AggregatedDiscoveryServiceServer
 	ServiceController   *aggregate.Controller
 	LocalNetwork        string
 	LocalClusterID      string
 	IstiodNamespace     string
 	IstiodPodName       string
+	// RootCerts returns the PEM encoded roots advertised to peers.
+	RootCerts func() []byte
 }
 
 type Federation struct {
@@ -109,6 +114,9 @@ func internalNew(opt Options, cs maistraclient.Interface) (*Federation, error) {
 		Env:         opt.Env,
 		Network:     opt.LocalNetwork,
 		ConfigStore: configStore,
+		ADSServer:   opt.ADSServer,
+		XDSUpdater:  opt.XDSUpdater,
+		RootCerts:   opt.RootCerts,
 	})
 	if err != nil {
 		return nil, err
@@ -167,6 +175,23 @@ func (f *Federation) RegisterServiceHandlers(serviceController *aggregate.Contro
 	serviceController.AppendServiceHandler(f.server.UpdateService)
 }
 
+// WrapXDSUpdater returns an XDSUpdater forwarding to updater, which also
+// updates the endpoint summaries exported to peers when the endpoints of a
+// service change.
+func (f *Federation) WrapXDSUpdater(updater model.XDSUpdater) model.XDSUpdater {
+	return &endpointsUpdater{XDSUpdater: updater, server: f.server}
+}
+
+type endpointsUpdater struct {
+	model.XDSUpdater
+	server *server.Server
+}
+
+func (u *endpointsUpdater) EDSUpdate(shard, hostname string, namespace string, entry []*model.IstioEndpoint) {
+	u.XDSUpdater.EDSUpdate(shard, hostname, namespace, entry)
+	u.server.UpdateEndpoints(hostname, namespace)
+}
+
 func (f *Federation) StartControllers(stopCh <-chan struct{}) {
 	go f.leaderElection.Run(stopCh)
 	go f.exportController.Start(stopCh)
@@ -186,6 +211,10 @@ func (f *Federation) GetTrustBundles() map[string]string {
 	return f.discoveryController.GetTrustBundles()
 }
 
+func (f *Federation) ServiceListForClient(clientID string) *federationmodel.ServiceListMessage {
+	return f.server.ServiceListForClient(clientID)
+}
+
 func (opt Options) validate() error {
 	var allErrors []error
 	if opt.KubeClient == nil {
@@ -26,6 +26,8 @@ type ServiceKey struct {
 
 type ServiceListMessage struct {
 	Checksum                uint64             `json:"checksum" hash:"ignore"`
+	Epoch                   string             `json:"epoch,omitempty" hash:"ignore"`
+	Revision                uint64             `json:"revision,omitempty" hash:"ignore"`
 	NetworkGatewayEndpoints []*ServiceEndpoint `json:"networkGatewayEndpoints,omitempty" hash:"set"`
 	Services                []*ServiceMessage  `json:"services,omitempty" hash:"set"`
 }
@@ -34,6 +36,27 @@ type ServiceMessage struct {
 	ServiceKey      `json:",inline"`
 	ServicePorts    []*ServicePort `json:"servicePorts,omitempty"`
 	ServiceAccounts []string       `json:"serviceAccounts,omitempty"`
+	// Endpoints summarizes the endpoints backing the service, by locality.
+	// It is only populated if the exporting mesh has been configured to
+	// publish endpoint summaries to the importing mesh.
+	Endpoints []*EndpointSummary `json:"endpoints,omitempty"`
+	// Consumers are the principals of the workloads in the importing mesh
+	// allowed to call the service.  The exporting mesh denies calls from
+	// other workloads on its ingress gateway, and the importing mesh on its
+	// egress gateway.  Calls are not restricted if empty.
+	Consumers []string `json:"consumers,omitempty"`
+}
+
+// EndpointSummary aggregates the healthy endpoints of a service within a
+// single locality.
+type EndpointSummary struct {
+	// Locality is the "/" separated region/zone/subzone of the endpoints.
+	Locality string `json:"locality,omitempty"`
+	// Healthy is the number of endpoints able to receive traffic.
+	Healthy uint32 `json:"healthy"`
+	// Weight is the sum of the load balancing weights of the healthy
+	// endpoints.
+	Weight uint32 `json:"weight,omitempty"`
 }
 
 type ServicePort struct {
@@ -47,12 +70,28 @@ type ServiceEndpoint struct {
 	Hostname string `json:"hostname,omitempty"`
 }
 
+// WatchEvent is a single change sent over the watch stream.  Revision is
+// incremented for every event sent by the exporting mesh and is only
+// meaningful within the stream identified by Epoch.  A client reconnecting to
+// the watch may pass the last Epoch and Revision it processed to have missed
+// events replayed instead of performing a full resync.
 type WatchEvent struct {
 	Action   string          `json:"action,omitempty"`
 	Service  *ServiceMessage `json:"service,omitempty"`
 	Checksum uint64          `json:"checksum"`
+	Epoch    string          `json:"epoch,omitempty"`
+	Revision uint64          `json:"revision,omitempty"`
 }
 
+const (
+	// WatchParameterEpoch is the query parameter used to specify the epoch of
+	// the last event processed by a client resuming a watch.
+	WatchParameterEpoch = "epoch"
+	// WatchParameterSince is the query parameter used to specify the revision
+	// of the last event processed by a client resuming a watch.
+	WatchParameterSince = "since"
+)
+
 var (
 	ActionAdd    = "add"
 	ActionUpdate = "update"
@@ -75,6 +114,31 @@ func (s *ServiceMessage) GenerateChecksum() uint64 {
 	return checksum
 }
 
+// TrustBundleMessage contains the root certificates used to validate the
+// identities of workloads in a mesh.
+type TrustBundleMessage struct {
+	TrustDomain string `json:"trustDomain"`
+	// RootCerts contains the PEM encoded root certificates.  Multiple roots
+	// are returned while a root is being rotated.
+	RootCerts string `json:"rootCerts"`
+}
+
+// PeerImportStatus describes the services discovered from a peer and how they
+// were imported.  It is served by istiod's debug endpoints to help diagnose
+// services that are not visible across meshes.
+type PeerImportStatus struct {
+	// Peer is the namespace/name of the ServiceMeshPeer.
+	Peer string `json:"peer"`
+	// DomainSuffix is the domain suffix of this mesh, which services imported
+	// as local services are named with.
+	DomainSuffix string `json:"domainSuffix,omitempty"`
+	// Advertised are the services the peer most recently advertised.
+	Advertised []*ServiceMessage `json:"advertised"`
+	// Imported maps the hostname of each imported service, as advertised by
+	// the peer, to its hostname in this mesh.
+	Imported map[string]string `json:"imported"`
+}
+
 type TrustBundleProvider interface {
 	GetTrustBundles() map[string]string
 }
@@ -0,0 +1,192 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/ptypes"
+	golangany "github.com/golang/protobuf/ptypes/any"
+
+	"istio.io/istio/pkg/config"
+	fedproto "istio.io/istio/pkg/servicemesh/federation/proto"
+)
+
+// ExportedServiceGVK is used to identify push requests triggered by changes to
+// the services exported to a federated mesh.
+var ExportedServiceGVK = config.GroupVersionKind{
+	Group:   "federation.maistra.io",
+	Version: "v1",
+	Kind:    "ExportedService",
+}
+
+// ServiceListProvider provides the services exported to federated meshes
+// discovering services over xDS.
+type ServiceListProvider interface {
+	// ServiceListForClient returns the services exported to the mesh associated
+	// with the discovery client, or nil if the client is not associated with a
+	// federated mesh.
+	ServiceListForClient(clientID string) *ServiceListMessage
+}
+
+// Discovery resources are sent as the messages defined in the federation
+// proto, which carry the same information as the JSON used by the HTTP
+// discovery API.  The first field of each message is the name of the
+// resource, which is used by the delta protocol.
+
+// ServiceResources returns the services in the list as discovery resources.
+func (s *ServiceListMessage) ServiceResources() ([]*golangany.Any, error) {
+	resources := make([]*golangany.Any, 0, len(s.Services))
+	for _, svc := range s.Services {
+		resource, err := ptypes.MarshalAny(svc.toProto())
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling service %s: %s", svc.Hostname, err)
+		}
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
+
+// NetworkGatewayResources returns the network gateways in the list as
+// discovery resources.
+func (s *ServiceListMessage) NetworkGatewayResources() ([]*golangany.Any, error) {
+	resources := make([]*golangany.Any, 0, len(s.NetworkGatewayEndpoints))
+	for _, gateway := range s.NetworkGatewayEndpoints {
+		resource, err := ptypes.MarshalAny(gateway.toProto())
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling network gateway %s: %s", gateway.name(), err)
+		}
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
+
+// ServicesFromResources converts discovery resources created using
+// ServiceResources() back into ServiceMessages.
+func ServicesFromResources(resources []*golangany.Any) ([]*ServiceMessage, error) {
+	services := make([]*ServiceMessage, 0, len(resources))
+	for _, resource := range resources {
+		svc, err := ServiceFromResource(resource)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, svc)
+	}
+	return services, nil
+}
+
+// ServiceFromResource converts a discovery resource created using
+// ServiceResources() back into a ServiceMessage.
+func ServiceFromResource(resource *golangany.Any) (*ServiceMessage, error) {
+	svc := &fedproto.ExportedService{}
+	if err := ptypes.UnmarshalAny(resource, svc); err != nil {
+		return nil, fmt.Errorf("error unmarshaling service: %s", err)
+	}
+	if svc.Hostname == "" {
+		return nil, fmt.Errorf("service %s/%s has no hostname", svc.Namespace, svc.Name)
+	}
+	return serviceFromProto(svc), nil
+}
+
+// NetworkGatewaysFromResources converts discovery resources created using
+// NetworkGatewayResources() back into ServiceEndpoints.
+func NetworkGatewaysFromResources(resources []*golangany.Any) ([]*ServiceEndpoint, error) {
+	gateways := make([]*ServiceEndpoint, 0, len(resources))
+	for _, resource := range resources {
+		gateway, err := NetworkGatewayFromResource(resource)
+		if err != nil {
+			return nil, err
+		}
+		gateways = append(gateways, gateway)
+	}
+	return gateways, nil
+}
+
+// NetworkGatewayFromResource converts a discovery resource created using
+// NetworkGatewayResources() back into a ServiceEndpoint.
+func NetworkGatewayFromResource(resource *golangany.Any) (*ServiceEndpoint, error) {
+	gateway := &fedproto.NetworkGateway{}
+	if err := ptypes.UnmarshalAny(resource, gateway); err != nil {
+		return nil, fmt.Errorf("error unmarshaling network gateway: %s", err)
+	}
+	return &ServiceEndpoint{
+		Hostname: gateway.Hostname,
+		Port:     int(gateway.Port),
+	}, nil
+}
+
+func (s *ServiceMessage) toProto() *fedproto.ExportedService {
+	svc := &fedproto.ExportedService{
+		Hostname:        s.Hostname,
+		Name:            s.Name,
+		Namespace:       s.Namespace,
+		ServiceAccounts: s.ServiceAccounts,
+		Consumers:       s.Consumers,
+	}
+	for _, port := range s.ServicePorts {
+		svc.Ports = append(svc.Ports, &fedproto.ServicePort{
+			Name:     port.Name,
+			Port:     uint32(port.Port),
+			Protocol: port.Protocol,
+		})
+	}
+	for _, summary := range s.Endpoints {
+		svc.Endpoints = append(svc.Endpoints, &fedproto.EndpointSummary{
+			Locality: summary.Locality,
+			Healthy:  summary.Healthy,
+			Weight:   summary.Weight,
+		})
+	}
+	return svc
+}
+
+func serviceFromProto(svc *fedproto.ExportedService) *ServiceMessage {
+	msg := &ServiceMessage{
+		ServiceKey: ServiceKey{
+			Name:      svc.Name,
+			Namespace: svc.Namespace,
+			Hostname:  svc.Hostname,
+		},
+		ServiceAccounts: svc.ServiceAccounts,
+		Consumers:       svc.Consumers,
+	}
+	for _, port := range svc.Ports {
+		msg.ServicePorts = append(msg.ServicePorts, &ServicePort{
+			Name:     port.Name,
+			Port:     int(port.Port),
+			Protocol: port.Protocol,
+		})
+	}
+	for _, summary := range svc.Endpoints {
+		msg.Endpoints = append(msg.Endpoints, &EndpointSummary{
+			Locality: summary.Locality,
+			Healthy:  summary.Healthy,
+			Weight:   summary.Weight,
+		})
+	}
+	return msg
+}
+
+func (e *ServiceEndpoint) name() string {
+	return fmt.Sprintf("%s:%d", e.Hostname, e.Port)
+}
+
+func (e *ServiceEndpoint) toProto() *fedproto.NetworkGateway {
+	return &fedproto.NetworkGateway{
+		Name:     e.name(),
+		Hostname: e.Hostname,
+		Port:     uint32(e.Port),
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/google/uuid"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/servicemesh/federation/common"
+	federationmodel "istio.io/istio/pkg/servicemesh/federation/model"
+	"istio.io/istio/pkg/spiffe"
+)
+
+// federationADS accepts ADS streams from federated meshes, delegating the
+// handling of the stream to the istiod ADS server.  Clients are identified
+// using the client certificate verified by the ingress gateway and the node
+// sent by the client is replaced, so clients cannot masquerade as other
+// proxies.
+type federationADS struct {
+	server *Server
+}
+
+var _ discovery.AggregatedDiscoveryServiceServer = &federationADS{}
+
+func (a *federationADS) StreamAggregatedResources(stream discovery.AggregatedDiscoveryService_StreamAggregatedResourcesServer) error {
+	return a.serve(stream.Context(), func(discoveryStream *meshDiscoveryStream) error {
+		return a.server.adsServer.StreamAggregatedResources(&meshSotWStream{
+			AggregatedDiscoveryService_StreamAggregatedResourcesServer: stream,
+			meshDiscoveryStream: discoveryStream,
+		})
+	})
+}
+
+func (a *federationADS) DeltaAggregatedResources(stream discovery.AggregatedDiscoveryService_DeltaAggregatedResourcesServer) error {
+	return a.serve(stream.Context(), func(discoveryStream *meshDiscoveryStream) error {
+		return a.server.adsServer.DeltaAggregatedResources(&meshDeltaStream{
+			AggregatedDiscoveryService_DeltaAggregatedResourcesServer: stream,
+			meshDiscoveryStream: discoveryStream,
+		})
+	})
+}
+
+// serve registers a discovery client for the mesh making the request for the
+// lifetime of the stream.
+func (a *federationADS) serve(ctx context.Context, handle func(*meshDiscoveryStream) error) error {
+	mesh, err := a.server.getMeshServerForStream(ctx)
+	if err != nil {
+		a.server.logger.Errorf("error handling discovery stream: %s", err)
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	// the node id is of the form name.namespace, which allows the config
+	// namespace to be inferred from the id
+	clientID := fmt.Sprintf("%s.%s", uuid.New().String(), mesh.mesh.Namespace)
+	a.server.discoveryClients.Store(clientID, mesh)
+	defer a.server.discoveryClients.Delete(clientID)
+	atomic.AddInt32(&mesh.xdsClients, 1)
+	defer atomic.AddInt32(&mesh.xdsClients, -1)
+
+	connection := getStreamConnectionKey(ctx)
+	mesh.statusHandler.RemoteWatchAccepted(connection)
+	defer mesh.statusHandler.RemoteWatchTerminated(connection)
+
+	return handle(&meshDiscoveryStream{
+		mesh:       mesh,
+		nodeID:     fmt.Sprintf("%s~%s~%s~%s.svc.%s", model.Federation, "0.0.0.0", clientID, mesh.mesh.Namespace, a.server.env.GetDomainSuffix()),
+		connection: connection,
+	})
+}
+
+// getMeshServerForStream returns the mesh whose ClientID is the identity of
+// the client certificate verified by the ingress gateway.  The gateway
+// replaces any x-forwarded-client-cert header sent by the client.
+func (s *Server) getMeshServerForStream(ctx context.Context) (*meshServer, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get(common.ForwardedClientCertHeader)) == 0 {
+		return nil, fmt.Errorf("no client certificate")
+	}
+	headers := md.Get(common.ForwardedClientCertHeader)
+	clientID, err := forwardedClientID(headers[len(headers)-1])
+	if err != nil {
+		return nil, err
+	}
+	var mesh *meshServer
+	s.meshes.Range(func(_, value interface{}) bool {
+		if ms := value.(*meshServer); ms.mesh.Spec.Security.ClientID == clientID {
+			mesh = ms
+			return false
+		}
+		return true
+	})
+	if mesh == nil {
+		return nil, fmt.Errorf("no mesh for client %s", clientID)
+	}
+	return mesh, nil
+}
+
+// forwardedClientID returns the identity of the client certificate in an
+// x-forwarded-client-cert header, e.g. for
+// By=...;Hash=...;Subject="";URI=spiffe://cluster.local/ns/foo/sa/bar, returns
+// cluster.local/ns/foo/sa/bar.  The last element of the header describes the
+// certificate verified by the closest proxy.
+func forwardedClientID(header string) (string, error) {
+	elements := splitQuoted(header, ',')
+	for _, pair := range splitQuoted(elements[len(elements)-1], ';') {
+		keyValue := strings.SplitN(pair, "=", 2)
+		if len(keyValue) != 2 || !strings.EqualFold(strings.TrimSpace(keyValue[0]), "URI") {
+			continue
+		}
+		uri := strings.Trim(keyValue[1], `"`)
+		if strings.HasPrefix(uri, spiffe.URIPrefix) {
+			return strings.TrimPrefix(uri, spiffe.URIPrefix), nil
+		}
+	}
+	return "", fmt.Errorf("no SPIFFE identity in client certificate")
+}
+
+// splitQuoted splits s around sep, ignoring separators in quoted values.
+func splitQuoted(s string, sep rune) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// ServiceListForClient returns the services exported to the mesh associated
+// with the discovery client.
+func (s *Server) ServiceListForClient(clientID string) *federationmodel.ServiceListMessage {
+	untypedMesh, ok := s.discoveryClients.Load(clientID)
+	if !ok || untypedMesh == nil {
+		return nil
+	}
+	mesh := untypedMesh.(*meshServer)
+	mesh.RLock()
+	defer mesh.RUnlock()
+	return mesh.getServiceListMessage()
+}
+
+func getStreamConnectionKey(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if forwardedFor := md.Get("x-forwarded-for"); len(forwardedFor) > 0 {
+			return strings.TrimSpace(strings.Split(forwardedFor[0], ",")[0])
+		}
+	}
+	if peerInfo, ok := peer.FromContext(ctx); ok {
+		return peerInfo.Addr.String()
+	}
+	return ""
+}
+
+// meshDiscoveryStream replaces the node sent by the client and reports
+// responses sent to the client to the status handler.
+type meshDiscoveryStream struct {
+	mesh       *meshServer
+	nodeID     string
+	connection string
+}
+
+func (s *meshDiscoveryStream) received(node *core.Node, typeURL, nonce string, errorDetail *rpcstatus.Status) *core.Node {
+	if errorDetail != nil {
+		s.mesh.logger.Warnf("discovery client %s rejected %s (nonce %s): %s",
+			s.connection, typeURL, nonce, errorDetail.GetMessage())
+	}
+	if node == nil {
+		return nil
+	}
+	return s.node(node)
+}
+
+func (s *meshDiscoveryStream) sent() {
+	s.mesh.statusHandler.FullSyncSent(s.connection)
+}
+
+// node returns the node used to represent the client.  Only the version is
+// copied from the node sent by the client, as the rest of the metadata could
+// be used to influence how the client is processed by the ADS server.
+func (s *meshDiscoveryStream) node(clientNode *core.Node) *core.Node {
+	node := &core.Node{
+		Id: s.nodeID,
+		Metadata: &structpb.Struct{
+			Fields: map[string]*structpb.Value{},
+		},
+	}
+	if version, ok := clientNode.GetMetadata().GetFields()["ISTIO_VERSION"]; ok {
+		node.Metadata.Fields["ISTIO_VERSION"] = version
+	}
+	return node
+}
+
+// meshSotWStream is a meshDiscoveryStream using the state of the world
+// protocol.
+type meshSotWStream struct {
+	discovery.AggregatedDiscoveryService_StreamAggregatedResourcesServer
+	*meshDiscoveryStream
+}
+
+func (s *meshSotWStream) Recv() (*discovery.DiscoveryRequest, error) {
+	req, err := s.AggregatedDiscoveryService_StreamAggregatedResourcesServer.Recv()
+	if err != nil {
+		return nil, err
+	}
+	req.Node = s.received(req.Node, req.TypeUrl, req.ResponseNonce, req.ErrorDetail)
+	return req, nil
+}
+
+func (s *meshSotWStream) Send(resp *discovery.DiscoveryResponse) error {
+	if err := s.AggregatedDiscoveryService_StreamAggregatedResourcesServer.Send(resp); err != nil {
+		return err
+	}
+	s.sent()
+	return nil
+}
+
+// meshDeltaStream is a meshDiscoveryStream using the delta protocol.
+type meshDeltaStream struct {
+	discovery.AggregatedDiscoveryService_DeltaAggregatedResourcesServer
+	*meshDiscoveryStream
+}
+
+func (s *meshDeltaStream) Recv() (*discovery.DeltaDiscoveryRequest, error) {
+	req, err := s.AggregatedDiscoveryService_DeltaAggregatedResourcesServer.Recv()
+	if err != nil {
+		return nil, err
+	}
+	req.Node = s.received(req.Node, req.TypeUrl, req.ResponseNonce, req.ErrorDetail)
+	return req, nil
+}
+
+func (s *meshDeltaStream) Send(resp *discovery.DeltaDiscoveryResponse) error {
+	if err := s.AggregatedDiscoveryService_DeltaAggregatedResourcesServer.Send(resp); err != nil {
+		return err
+	}
+	s.sent()
+	return nil
+}
@@ -16,9 +16,11 @@ package server
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"time"
 
+	gogoproto "github.com/gogo/protobuf/proto"
 	"github.com/pkg/errors"
 
 	rawnetworking "istio.io/api/networking/v1alpha3"
@@ -194,6 +196,111 @@ func (s *meshServer) createOrUpdateAuthorizationPolicy(target *federationmodel.S
 	return nil
 }
 
+func consumersResourceName(mesh string) string {
+	return fmt.Sprintf("federation-exports-%s-consumers", mesh)
+}
+
+// updateConsumersAuthorizationPolicy restricts the remote principals allowed
+// to call the exported services whose consumers are restricted, using an ALLOW
+// policy on the ingress gateway.  The consumers are also advertised to the
+// peer, whose egress gateway denies calls from other workloads.
+// s has to be Lock()ed
+func (s *meshServer) updateConsumersAuthorizationPolicy() error {
+	gvk := collections.IstioSecurityV1Beta1Authorizationpolicies.Resource().GroupVersionKind()
+	name := consumersResourceName(s.mesh.Name)
+	rawAP := s.configStore.Get(gvk, name, s.mesh.Namespace)
+	ap := s.consumersAuthorizationPolicy()
+	if ap == nil {
+		if rawAP != nil {
+			// Delete() is always successful
+			_ = s.configStore.Delete(gvk, name, s.mesh.Namespace, nil)
+		}
+		return nil
+	}
+	if rawAP == nil {
+		_, err := s.configStore.Create(*ap)
+		return err
+	}
+	if gogoproto.Equal(rawAP.Spec.(*rawsecurity.AuthorizationPolicy), ap.Spec.(*rawsecurity.AuthorizationPolicy)) {
+		// no update required
+		return nil
+	}
+	rawAP.Spec = ap.Spec
+	_, err := s.configStore.Update(*rawAP)
+	return err
+}
+
+// consumersAuthorizationPolicy returns the ALLOW AuthorizationPolicy for the
+// ingress gateway, or nil if no exported service restricts its consumers.
+// Exported services are proxied by the gateway as TLS, where HTTP attributes
+// such as the host are not available, so connections are matched by SNI.  A
+// workload is denied any connection not allowed by an ALLOW policy, so a
+// single policy is generated for all the exported services, with a last rule
+// allowing connections to the services whose consumers are not restricted.
+// s has to be Lock()ed
+func (s *meshServer) consumersAuthorizationPolicy() *config.Config {
+	var restricted []*federationmodel.ServiceMessage
+	for _, svc := range s.currentServices {
+		if svc.Consumers != nil {
+			restricted = append(restricted, svc)
+		}
+	}
+	if len(restricted) == 0 {
+		return nil
+	}
+	sort.Slice(restricted, func(i, j int) bool { return restricted[i].Hostname < restricted[j].Hostname })
+	var rules []*rawsecurity.Rule
+	var restrictedHosts []string
+	for _, svc := range restricted {
+		// the gateway accepts outbound_.<port>_.<subset>_.<hostname>
+		hosts := []string{svc.Hostname, "*." + svc.Hostname}
+		restrictedHosts = append(restrictedHosts, hosts...)
+		if len(svc.Consumers) == 0 {
+			// no rule denies all connections
+			continue
+		}
+		rules = append(rules, &rawsecurity.Rule{
+			From: []*rawsecurity.Rule_From{
+				{
+					Source: &rawsecurity.Source{
+						Principals: append([]string(nil), svc.Consumers...),
+					},
+				},
+			},
+			When: []*rawsecurity.Condition{
+				{
+					Key:    "connection.sni",
+					Values: hosts,
+				},
+			},
+		})
+	}
+	rules = append(rules, &rawsecurity.Rule{
+		When: []*rawsecurity.Condition{
+			{
+				Key:       "connection.sni",
+				NotValues: restrictedHosts,
+			},
+		},
+	})
+	return &config.Config{
+		Meta: config.Meta{
+			GroupVersionKind: collections.IstioSecurityV1Beta1Authorizationpolicies.Resource().GroupVersionKind(),
+			Name:             consumersResourceName(s.mesh.Name),
+			Namespace:        s.mesh.Namespace,
+		},
+		Spec: &rawsecurity.AuthorizationPolicy{
+			Selector: &rawtype.WorkloadSelector{
+				MatchLabels: map[string]string{
+					"service.istio.io/canonical-name": s.mesh.Spec.Gateways.Ingress.Name,
+				},
+			},
+			Action: rawsecurity.AuthorizationPolicy_ALLOW,
+			Rules:  rules,
+		},
+	}
+}
+
 func (s *meshServer) gatewayForExport(source federationmodel.ServiceKey, target *federationmodel.ServiceMessage) *config.Config {
 	resourceName := createResourceName(s.mesh.Name, source)
 	mode := rawnetworking.ServerTLSSettings_ISTIO_MUTUAL
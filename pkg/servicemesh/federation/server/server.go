@@ -21,12 +21,19 @@ import (
 	"net"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	hashstructure "github.com/mitchellh/hashstructure/v2"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
 	"k8s.io/apimachinery/pkg/util/errors"
 	v1 "maistra.io/api/federation/v1"
 
@@ -40,6 +47,10 @@ import (
 
 const (
 	meshURLParameter = "mesh"
+
+	// defaultWatchHistorySize is the number of watch events retained for each
+	// mesh, which may be replayed to clients resuming a watch.
+	defaultWatchHistorySize = 100
 )
 
 type Options struct {
@@ -47,6 +58,24 @@ type Options struct {
 	Env         *model.Environment
 	Network     string
 	ConfigStore model.ConfigStoreCache
+	// WatchHistorySize is the number of watch events retained for resuming
+	// watches.  Defaults to 100, if unspecified.
+	WatchHistorySize int
+	// ADSServer handles discovery requests made over gRPC.  If unspecified,
+	// discovery is only available using the HTTP API.
+	ADSServer discovery.AggregatedDiscoveryServiceServer
+	// XDSUpdater is used to trigger pushes to clients discovering services
+	// over gRPC when exported services change.
+	XDSUpdater model.XDSUpdater
+	// EndpointRefreshPeriod is the interval at which endpoint summaries are
+	// recomputed for peers configured to receive them, in addition to the
+	// updates made by UpdateEndpoints.  Defaults to
+	// common.DefaultResyncPeriod, if unspecified.
+	EndpointRefreshPeriod time.Duration
+	// RootCerts returns the PEM encoded root certificates of the local mesh,
+	// which are served to peers so they can follow root rotations in this
+	// mesh.  If unspecified, the trust bundle is not served.
+	RootCerts func() []byte
 }
 
 type FederationManager interface {
@@ -64,6 +93,11 @@ type Server struct {
 	env        *model.Environment
 	listener   net.Listener
 	httpServer *http.Server
+	grpcServer *grpc.Server
+
+	adsServer        discovery.AggregatedDiscoveryServiceServer
+	xdsUpdater       model.XDSUpdater
+	discoveryClients *sync.Map
 
 	configStore model.ConfigStoreCache
 
@@ -76,6 +110,10 @@ type Server struct {
 	// routing config for each mesh.  This may or may not be possible.
 	network string
 
+	watchHistorySize      int
+	endpointRefreshPeriod time.Duration
+	rootCerts             func() []byte
+
 	currentGatewayEndpoints []*federationmodel.ServiceEndpoint
 }
 
@@ -89,22 +127,53 @@ func NewServer(opt Options) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
+	watchHistorySize := opt.WatchHistorySize
+	if watchHistorySize <= 0 {
+		watchHistorySize = defaultWatchHistorySize
+	}
+	endpointRefreshPeriod := opt.EndpointRefreshPeriod
+	if endpointRefreshPeriod <= 0 {
+		endpointRefreshPeriod = common.DefaultResyncPeriod
+	}
 	fed := &Server{
 		logger: common.Logger.WithLabels("component", "federation-server"),
 		env:    opt.Env,
 		httpServer: &http.Server{
-			ReadTimeout:    10 * time.Second,
-			MaxHeaderBytes: 1 << 20,
+			// ReadTimeout would be applied to h2c connections, which
+			// would terminate long-lived discovery streams
+			ReadHeaderTimeout: 10 * time.Second,
+			MaxHeaderBytes:    1 << 20,
 		},
 		configStore: opt.ConfigStore,
 		meshes:      &sync.Map{},
 		network:     opt.Network,
 		listener:    listener,
+
+		adsServer:        opt.ADSServer,
+		xdsUpdater:       opt.XDSUpdater,
+		discoveryClients: &sync.Map{},
+
+		watchHistorySize:      watchHistorySize,
+		endpointRefreshPeriod: endpointRefreshPeriod,
+		rootCerts:             opt.RootCerts,
 	}
 	mux := mux.NewRouter()
 	mux.HandleFunc("/services/{mesh}", fed.handleServiceList)
 	mux.HandleFunc("/watch/{mesh}", fed.handleWatch)
-	fed.httpServer.Handler = mux
+	mux.HandleFunc("/trustbundle/{mesh}", fed.handleTrustBundle)
+	if fed.adsServer != nil {
+		fed.grpcServer = grpc.NewServer()
+		discovery.RegisterAggregatedDiscoveryServiceServer(fed.grpcServer, &federationADS{server: fed})
+	}
+	// h2c is used so gateways may use http2 for both the HTTP API and gRPC
+	fed.httpServer.Handler = h2c.NewHandler(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if fed.grpcServer != nil && request.ProtoMajor == 2 &&
+			strings.HasPrefix(request.Header.Get("Content-Type"), "application/grpc") {
+			fed.grpcServer.ServeHTTP(response, request)
+			return
+		}
+		mux.ServeHTTP(response, request)
+	}), &http2.Server{})
 	return fed, nil
 }
 
@@ -116,12 +185,28 @@ func exportDomainSuffix(mesh string) string {
 	return fmt.Sprintf("svc.%s-exports.local", mesh)
 }
 
-func (s *Server) ingressServiceName(mesh *v1.ServiceMeshPeer) string {
-	return fmt.Sprintf("%s.%s.svc.%s", mesh.Spec.Gateways.Ingress.Name, mesh.Namespace, s.env.GetDomainSuffix())
+// NewServiceExporter creates the exporter naming the services exported to the
+// mesh according to the ExportedServiceSet.
+func NewServiceExporter(mesh string, exports *v1.ExportedServiceSet) *common.ServiceExporter {
+	return common.NewServiceExporter(exports, nil, exportDomainSuffix(mesh))
+}
+
+// IngressServiceName returns the hostname of the ingress gateway service
+// through which the services exported to the mesh are reached.
+func IngressServiceName(mesh *v1.ServiceMeshPeer, domainSuffix string) string {
+	return fmt.Sprintf("%s.%s.svc.%s", mesh.Spec.Gateways.Ingress.Name, mesh.Namespace, domainSuffix)
+}
+
+// IsExportable returns whether the service may be exported at all, before
+// the export rules are applied.  Services without a name or namespace,
+// external services and the ingress gateway service are never exported.
+func IsExportable(svc *model.Service, ingressService string) bool {
+	return svc.Attributes.Name != "" && svc.Attributes.Namespace != "" && !svc.External() &&
+		svc.Hostname != host.Name(ingressService)
 }
 
 func (s *Server) AddPeer(mesh *v1.ServiceMeshPeer, exports *v1.ExportedServiceSet, statusHandler status.Handler) error {
-	exportConfig := common.NewServiceExporter(exports, nil, exportDomainSuffix(mesh.Name))
+	exportConfig := NewServiceExporter(mesh.Name, exports)
 
 	untypedMeshServer, ok := s.meshes.Load(mesh.Name)
 	if untypedMeshServer != nil && ok {
@@ -135,8 +220,11 @@ func (s *Server) AddPeer(mesh *v1.ServiceMeshPeer, exports *v1.ExportedServiceSe
 		exportConfig:             exportConfig,
 		statusHandler:            statusHandler,
 		configStore:              s.configStore,
-		ingressService:           s.ingressServiceName(mesh),
+		ingressService:           IngressServiceName(mesh, s.env.GetDomainSuffix()),
+		xdsUpdater:               s.xdsUpdater,
 		currentServices:          make(map[federationmodel.ServiceKey]*federationmodel.ServiceMessage),
+		epoch:                    uuid.New().String(),
+		historySize:              s.watchHistorySize,
 	}
 	if _, loaded := s.meshes.LoadOrStore(mesh.Name, meshServer); !loaded {
 		meshServer.resync()
@@ -158,7 +246,7 @@ func (s *Server) UpdateExportsForMesh(exports *v1.ExportedServiceSet) error {
 	if untypedMeshServer == nil || !ok {
 		return fmt.Errorf("cannot update exporter for non-existent federation: %s", exports.Name)
 	}
-	untypedMeshServer.(*meshServer).updateExportConfig(common.NewServiceExporter(exports, nil, exportDomainSuffix(exports.Name)))
+	untypedMeshServer.(*meshServer).updateExportConfig(NewServiceExporter(exports.Name, exports))
 	return nil
 }
 
@@ -192,7 +280,11 @@ func (s *Server) handleServiceList(response http.ResponseWriter, request *http.R
 		response.WriteHeader(400)
 		return
 	}
-	ret := mesh.getServiceListMessage()
+	ret := func() *federationmodel.ServiceListMessage {
+		mesh.RLock()
+		defer mesh.RUnlock()
+		return mesh.getServiceListMessage()
+	}()
 
 	respBytes, err := json.Marshal(ret)
 	if err != nil {
@@ -220,13 +312,67 @@ func (s *Server) handleWatch(response http.ResponseWriter, request *http.Request
 	mesh.handleWatch(response, request)
 }
 
+func (s *Server) handleTrustBundle(response http.ResponseWriter, request *http.Request) {
+	if _, err := s.getMeshServerForRequest(request); err != nil {
+		s.logger.Errorf("error handling /trustbundle request: %s", err)
+		response.WriteHeader(400)
+		return
+	}
+	if s.rootCerts == nil {
+		response.WriteHeader(404)
+		return
+	}
+	rootCerts := s.rootCerts()
+	if len(rootCerts) == 0 {
+		s.logger.Warnf("unable to serve /trustbundle request: no root certificates available")
+		response.WriteHeader(503)
+		return
+	}
+	respBytes, err := json.Marshal(&federationmodel.TrustBundleMessage{
+		TrustDomain: s.env.Mesh().GetTrustDomain(),
+		RootCerts:   string(rootCerts),
+	})
+	if err != nil {
+		s.logger.Errorf("failed to marshal to json: %s", err)
+		response.WriteHeader(500)
+		return
+	}
+	if _, err = response.Write(respBytes); err != nil {
+		s.logger.Errorf("failed to send response: %s", err)
+	}
+}
+
 func (s *Server) Run(stopCh <-chan struct{}) {
 	s.logger.Infof("starting federation service discovery at %s", s.Addr())
 	go func() {
 		_ = s.httpServer.Serve(s.listener)
 	}()
+	go s.refreshEndpointSummaries(stopCh)
 	<-stopCh
 	_ = s.httpServer.Shutdown(context.TODO())
+	if s.grpcServer != nil {
+		s.grpcServer.Stop()
+	}
+}
+
+// refreshEndpointSummaries periodically resyncs the meshes publishing
+// endpoint summaries, in case an endpoint update was missed.
+func (s *Server) refreshEndpointSummaries(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(s.endpointRefreshPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			s.meshes.Range(func(_, value interface{}) bool {
+				if ms := value.(*meshServer); common.ExportEndpointSummariesForPeer(ms.mesh) {
+					ms.resync()
+				}
+				return true
+			})
+		}
+	}
 }
 
 func (s *Server) GetGatewayEndpoints() []*federationmodel.ServiceEndpoint {
@@ -273,7 +419,10 @@ func (s *Server) UpdateService(svc *model.Service, event model.Event) {
 				return true
 			})
 			s.meshes.Range(func(_, value interface{}) bool {
-				value.(*meshServer).pushWatchEvent(&federationmodel.WatchEvent{
+				ms := value.(*meshServer)
+				ms.Lock()
+				defer ms.Unlock()
+				ms.pushWatchEvent(&federationmodel.WatchEvent{
 					Action:  federationmodel.ActionUpdate,
 					Service: nil,
 				})
@@ -287,6 +436,26 @@ func (s *Server) UpdateService(svc *model.Service, event model.Event) {
 	})
 }
 
+// UpdateEndpoints updates the endpoint summaries of the service published to
+// the peers receiving them, which are notified if they changed.  Endpoint
+// changes are not propagated through service events.
+func (s *Server) UpdateEndpoints(hostname, namespace string) {
+	var svc *model.Service
+	s.meshes.Range(func(_, value interface{}) bool {
+		ms := value.(*meshServer)
+		if !common.ExportEndpointSummariesForPeer(ms.mesh) {
+			return true
+		}
+		if svc == nil {
+			if svc, _ = s.env.GetService(host.Name(hostname)); svc == nil || svc.Attributes.Namespace != namespace {
+				return false
+			}
+		}
+		ms.endpointsUpdated(svc)
+		return true
+	})
+}
+
 // resync ensures the export lists are current.  used for testing
 func (s *Server) resync() {
 	_, _ = s.resyncNetworkGateways()
@@ -321,6 +490,10 @@ type meshServer struct {
 
 	statusHandler status.Handler
 	configStore   model.ConfigStoreCache
+	xdsUpdater    model.XDSUpdater
+	// xdsClients is the number of clients discovering services over xDS.
+	// Accessed atomically.
+	xdsClients int32
 
 	ingressService  string
 	gatewaySAs      []string
@@ -328,6 +501,14 @@ type meshServer struct {
 
 	watchMut       sync.RWMutex
 	currentWatches []chan *federationmodel.WatchEvent
+
+	// epoch identifies the stream of watch events produced by this server.
+	// revisions are only comparable within the same epoch, e.g. a client
+	// reconnecting to a different istiod instance must perform a full resync.
+	epoch       string
+	revision    uint64
+	history     []*federationmodel.WatchEvent
+	historySize int
 }
 
 func (s *meshServer) updateExportConfig(exportConfig *common.ServiceExporter) {
@@ -341,13 +522,15 @@ func (s *meshServer) getServiceHostName(exportedName *v1.ServiceName) string {
 	return fmt.Sprintf("%s.%s.svc.%s-exports.local", exportedName.Name, exportedName.Namespace, s.mesh.Name)
 }
 
-func (s *meshServer) getServiceMessage(svc *model.Service, exportedName *federationmodel.ServiceKey) *federationmodel.ServiceMessage {
+func (s *meshServer) getServiceMessage(svc *model.Service, exportedName *federationmodel.ServiceKey,
+	principals []string) *federationmodel.ServiceMessage {
 	if svc == nil || exportedName == nil {
 		return nil
 	}
 	ret := &federationmodel.ServiceMessage{
 		ServiceKey:   *exportedName,
 		ServicePorts: make([]*federationmodel.ServicePort, 0),
+		Consumers:    principals,
 	}
 	addServiceSAs := s.mesh.Spec.Security.AllowDirectInbound
 	if addServiceSAs {
@@ -367,6 +550,46 @@ func (s *meshServer) getServiceMessage(svc *model.Service, exportedName *federat
 			}
 		}
 	}
+	if common.ExportEndpointSummariesForPeer(s.mesh) {
+		ret.Endpoints = s.getEndpointSummaries(svc)
+	}
+	return ret
+}
+
+// getEndpointSummaries aggregates the endpoints of the service by locality.
+// Endpoints are identified by address, so a workload exposing multiple ports
+// is only counted once.  The registry only contains endpoints that are ready,
+// so all endpoints are considered healthy.
+func (s *meshServer) getEndpointSummaries(svc *model.Service) []*federationmodel.EndpointSummary {
+	summaries := map[string]*federationmodel.EndpointSummary{}
+	seen := map[string]struct{}{}
+	for _, port := range svc.Ports {
+		for _, si := range s.env.InstancesByPort(svc, port.Port, nil) {
+			if _, found := seen[si.Endpoint.Address]; found {
+				continue
+			}
+			seen[si.Endpoint.Address] = struct{}{}
+			summary, found := summaries[si.Endpoint.Locality.Label]
+			if !found {
+				summary = &federationmodel.EndpointSummary{Locality: si.Endpoint.Locality.Label}
+				summaries[si.Endpoint.Locality.Label] = summary
+			}
+			summary.Healthy++
+			if si.Endpoint.LbWeight > 0 {
+				summary.Weight += si.Endpoint.LbWeight
+			} else {
+				summary.Weight++
+			}
+		}
+	}
+	if len(summaries) == 0 {
+		return nil
+	}
+	ret := make([]*federationmodel.EndpointSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		ret = append(ret, summary)
+	}
+	sort.Slice(ret, func(i, j int) bool { return strings.Compare(ret[i].Locality, ret[j].Locality) < 0 })
 	return ret
 }
 
@@ -381,6 +604,8 @@ func (s *meshServer) getServiceListMessage() *federationmodel.ServiceListMessage
 	}
 	sort.Slice(ret.Services, func(i, j int) bool { return strings.Compare(ret.Services[i].Hostname, ret.Services[j].Hostname) < 0 })
 	ret.Checksum = ret.GenerateChecksum()
+	ret.Epoch = s.epoch
+	ret.Revision = s.revision
 	return ret
 }
 
@@ -414,10 +639,22 @@ func getClientConnectionKey(request *http.Request) string {
 }
 
 func (s *meshServer) handleWatch(response http.ResponseWriter, request *http.Request) {
+	resume := false
+	var since uint64
+	query := request.URL.Query()
+	epoch := query.Get(federationmodel.WatchParameterEpoch)
+	if sinceParam := query.Get(federationmodel.WatchParameterSince); sinceParam != "" {
+		var err error
+		if since, err = strconv.ParseUint(sinceParam, 10, 64); err != nil {
+			s.logger.Errorf("invalid %s parameter specified for watch: %s", federationmodel.WatchParameterSince, err)
+			response.WriteHeader(400)
+			return
+		}
+		resume = true
+	}
+
 	watch := make(chan *federationmodel.WatchEvent)
-	s.watchMut.Lock()
-	s.currentWatches = append(s.currentWatches, watch)
-	s.watchMut.Unlock()
+	backlog := s.addWatch(watch, resume, epoch, since)
 	connection := getClientConnectionKey(request)
 	s.statusHandler.RemoteWatchAccepted(connection)
 	defer func() {
@@ -440,6 +677,14 @@ func (s *meshServer) handleWatch(response http.ResponseWriter, request *http.Req
 		panic("expected http.ResponseWriter to be an http.Flusher")
 	}
 	flusher.Flush()
+	for _, event := range backlog {
+		if err := s.writeWatchEvent(response, event); err != nil {
+			s.logger.Errorf("failed to write http response: %s", err)
+			return
+		}
+		flusher.Flush()
+		s.statusHandler.WatchEventSent(connection)
+	}
 	for {
 		var event *federationmodel.WatchEvent
 		select {
@@ -447,18 +692,11 @@ func (s *meshServer) handleWatch(response http.ResponseWriter, request *http.Req
 		case <-request.Context().Done():
 			return
 		}
-		respBytes, err := json.Marshal(event)
-		if err != nil {
-			s.logger.Errorf("error marshaling watch event: %s", err)
+		if event == nil {
+			// watch was closed
 			return
 		}
-		_, err = response.Write(respBytes)
-		if err != nil {
-			s.logger.Errorf("failed to write http response: %s", err)
-			return
-		}
-		_, err = response.Write([]byte("\r\n"))
-		if err != nil {
+		if err := s.writeWatchEvent(response, event); err != nil {
 			s.logger.Errorf("failed to write http response: %s", err)
 			return
 		}
@@ -467,6 +705,66 @@ func (s *meshServer) handleWatch(response http.ResponseWriter, request *http.Req
 	}
 }
 
+// addWatch registers the watch and returns the events that should be sent to
+// the client before any new events.  If the client is resuming a watch and its
+// revision is still available in the history, the missed events are returned.
+// If the history has been compacted (or the client is resuming from a
+// different epoch), a single event notifying the client that it must perform
+// a full resync is returned.
+func (s *meshServer) addWatch(watch chan *federationmodel.WatchEvent, resume bool, epoch string, since uint64) []*federationmodel.WatchEvent {
+	// hold the lock while registering the watch, so no events are pushed
+	// between computing the backlog and registering the watch
+	s.RLock()
+	defer s.RUnlock()
+	var backlog []*federationmodel.WatchEvent
+	if resume {
+		var ok bool
+		if backlog, ok = s.eventsSince(epoch, since); !ok {
+			s.logger.Debugf("cannot resume watch from revision %d of epoch %s, client must resync", since, epoch)
+			backlog = []*federationmodel.WatchEvent{{
+				Action:   federationmodel.ActionUpdate,
+				Service:  nil,
+				Checksum: s.getServiceListMessage().Checksum,
+				Epoch:    s.epoch,
+				Revision: s.revision,
+			}}
+		} else {
+			s.logger.Debugf("resuming watch from revision %d, replaying %d events", since, len(backlog))
+		}
+	}
+	s.watchMut.Lock()
+	s.currentWatches = append(s.currentWatches, watch)
+	s.watchMut.Unlock()
+	return backlog
+}
+
+// s has to be RLock()ed
+func (s *meshServer) eventsSince(epoch string, revision uint64) ([]*federationmodel.WatchEvent, bool) {
+	if epoch != s.epoch || revision > s.revision {
+		return nil, false
+	}
+	if revision == s.revision {
+		return nil, true
+	}
+	if len(s.history) == 0 || s.history[0].Revision > revision+1 {
+		// the history has been compacted
+		return nil, false
+	}
+	return append([]*federationmodel.WatchEvent(nil), s.history[revision+1-s.history[0].Revision:]...), true
+}
+
+func (s *meshServer) writeWatchEvent(response http.ResponseWriter, event *federationmodel.WatchEvent) error {
+	respBytes, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error marshaling watch event: %s", err)
+	}
+	if _, err = response.Write(respBytes); err != nil {
+		return err
+	}
+	_, err = response.Write([]byte("\r\n"))
+	return err
+}
+
 func (s *meshServer) resync() {
 	s.Lock()
 	defer s.Unlock()
@@ -477,14 +775,12 @@ func (s *meshServer) resync() {
 	}
 	s.updateGatewayServiceAccounts()
 	for _, svc := range services {
-		if svc.Attributes.Name == "" || svc.Attributes.Namespace == "" {
-			s.logger.Debugf("skipping service with no Namespace/Name: %s", svc.Hostname)
-			continue
-		} else if svc.External() {
-			s.logger.Debugf("skipping external service: %s", svc.Hostname)
+		if !IsExportable(svc, s.ingressService) {
+			s.logger.Debugf("skipping service %s, which cannot be exported", svc.Hostname)
 			continue
 		}
-		svcMessage := s.getServiceMessage(svc, s.exportConfig.NameForService(svc))
+		exportedName, principals := s.exportConfig.ExportForService(svc)
+		svcMessage := s.getServiceMessage(svc, exportedName, principals)
 		if svcMessage == nil {
 			s.logger.Debugf("skipping export of service %+v, as it does not match any export filter", serviceKeyForService(svc))
 			continue
@@ -492,6 +788,8 @@ func (s *meshServer) resync() {
 		svcKey := serviceKeyForService(svc)
 		if existingSvc, found := s.currentServices[svcKey]; found {
 			if existingSvc.GenerateChecksum() == svcMessage.GenerateChecksum() {
+				// denying all consumers doesn't change the checksum
+				s.currentServices[svcKey] = svcMessage
 				continue
 			}
 			if existingSvc.Name != svcMessage.Name || existingSvc.Namespace != svcMessage.Namespace {
@@ -507,6 +805,9 @@ func (s *meshServer) resync() {
 			s.addService(svcKey, svcMessage)
 		}
 	}
+	if err := s.updateConsumersAuthorizationPolicy(); err != nil {
+		s.logger.Errorf("error updating consumers allowed to call services exported to mesh %s: %s", s.mesh.Name, err)
+	}
 	if err := s.statusHandler.Flush(); err != nil {
 		s.logger.Errorf("error updating federation export status for mesh %s: %s", s.mesh.Name, err)
 	}
@@ -562,7 +863,8 @@ func (s *meshServer) serviceUpdated(svc *model.Service, event model.Event) {
 	var svcMessage *federationmodel.ServiceMessage
 	switch event {
 	case model.EventAdd:
-		svcMessage = s.getServiceMessage(svc, s.exportConfig.NameForService(svc))
+		exportedName, principals := s.exportConfig.ExportForService(svc)
+		svcMessage = s.getServiceMessage(svc, exportedName, principals)
 		if svcMessage != nil {
 			s.logger.Debugf("exporting service %+v as %+v", serviceKeyForService(svc), svcMessage.ServiceKey)
 			s.addService(serviceKeyForService(svc), svcMessage)
@@ -570,7 +872,8 @@ func (s *meshServer) serviceUpdated(svc *model.Service, event model.Event) {
 			s.logger.Debugf("skipping export of service %+v, as it does not match any export filter", serviceKeyForService(svc))
 		}
 	case model.EventUpdate:
-		svcMessage = s.getServiceMessage(svc, s.exportConfig.NameForService(svc))
+		exportedName, principals := s.exportConfig.ExportForService(svc)
+		svcMessage = s.getServiceMessage(svc, exportedName, principals)
 		svcKey := serviceKeyForService(svc)
 		if svcMessage != nil {
 			if existingSvc, found := s.currentServices[svcKey]; found {
@@ -601,6 +904,27 @@ func (s *meshServer) serviceUpdated(svc *model.Service, event model.Event) {
 	}
 }
 
+// endpointsUpdated updates the endpoint summaries of an exported service,
+// notifying the watches if they changed.  Other changes to the export of the
+// service are handled with the service events.
+func (s *meshServer) endpointsUpdated(svc *model.Service) {
+	s.Lock()
+	defer s.Unlock()
+	svcKey := serviceKeyForService(svc)
+	existingSvc, found := s.currentServices[svcKey]
+	if !found {
+		return
+	}
+	exportedName, principals := s.exportConfig.ExportForService(svc)
+	svcMessage := s.getServiceMessage(svc, exportedName, principals)
+	if svcMessage == nil || svcMessage.ServiceKey != existingSvc.ServiceKey ||
+		svcMessage.GenerateChecksum() == existingSvc.GenerateChecksum() {
+		return
+	}
+	s.logger.Debugf("endpoints of service %+v exported as %+v changed", svcKey, svcMessage.ServiceKey)
+	s.updateService(svcKey, svcMessage)
+}
+
 // s has to be Lock()ed
 func (s *meshServer) addService(svc federationmodel.ServiceKey, msg *federationmodel.ServiceMessage) {
 	if err := s.createExportResources(svc, msg); err != nil {
@@ -608,6 +932,9 @@ func (s *meshServer) addService(svc federationmodel.ServiceKey, msg *federationm
 		return
 	}
 	s.currentServices[svc] = msg
+	if err := s.updateConsumersAuthorizationPolicy(); err != nil {
+		s.logger.Errorf("error updating consumers allowed to call exported service %s => %s: %s", svc.Hostname, msg.Hostname, err)
+	}
 	e := &federationmodel.WatchEvent{
 		Action:  federationmodel.ActionAdd,
 		Service: msg,
@@ -618,8 +945,12 @@ func (s *meshServer) addService(svc federationmodel.ServiceKey, msg *federationm
 
 // s has to be Lock()ed
 func (s *meshServer) updateService(svc federationmodel.ServiceKey, msg *federationmodel.ServiceMessage) {
-	// resources used to configure export are all based on names, so we don't need to update them
+	// resources used to configure export are all based on names, so we don't
+	// need to update them, other than the consumers allowed to call the service
 	s.currentServices[svc] = msg
+	if err := s.updateConsumersAuthorizationPolicy(); err != nil {
+		s.logger.Errorf("error updating consumers allowed to call exported service %s => %s: %s", svc.Hostname, msg.Hostname, err)
+	}
 	e := &federationmodel.WatchEvent{
 		Action:  federationmodel.ActionUpdate,
 		Service: msg,
@@ -635,6 +966,9 @@ func (s *meshServer) deleteService(svc federationmodel.ServiceKey, msg *federati
 		// let the deletion go through, so the other mesh won't try to call us
 	}
 	delete(s.currentServices, svc)
+	if err := s.updateConsumersAuthorizationPolicy(); err != nil {
+		s.logger.Errorf("error updating consumers allowed to call exported services: %s", err)
+	}
 	e := &federationmodel.WatchEvent{
 		Action:  federationmodel.ActionDelete,
 		Service: msg,
@@ -646,12 +980,32 @@ func (s *meshServer) deleteService(svc federationmodel.ServiceKey, msg *federati
 // s has to be Lock()ed
 func (s *meshServer) pushWatchEvent(e *federationmodel.WatchEvent) {
 	list := s.getServiceListMessage()
+	s.revision++
 	e.Checksum = list.Checksum
+	e.Epoch = s.epoch
+	e.Revision = s.revision
+	s.history = append(s.history, e)
+	if len(s.history) > s.historySize {
+		s.history = s.history[len(s.history)-s.historySize:]
+	}
 	s.watchMut.RLock()
 	defer s.watchMut.RUnlock()
 	for _, w := range s.currentWatches {
 		w <- e
 	}
+	if s.xdsUpdater != nil && atomic.LoadInt32(&s.xdsClients) > 0 {
+		// notify clients discovering services over gRPC.  The push is not
+		// full, as the exported services are not part of the PushContext.
+		s.xdsUpdater.ConfigUpdate(&model.PushRequest{
+			Full: false,
+			ConfigsUpdated: map[model.ConfigKey]struct{}{{
+				Kind:      federationmodel.ExportedServiceGVK,
+				Name:      s.mesh.Name,
+				Namespace: s.mesh.Namespace,
+			}: {}},
+			Reason: []model.TriggerReason{model.ConfigUpdate},
+		})
+	}
 }
 
 func (s *meshServer) stop() {
@@ -15,25 +15,49 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	golangany "github.com/golang/protobuf/ptypes/any"
+	structpb "github.com/golang/protobuf/ptypes/struct"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	v1 "maistra.io/api/federation/v1"
 
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	rawsecurity "istio.io/api/security/v1beta1"
 	configmemory "istio.io/istio/pilot/pkg/config/memory"
 	"istio.io/istio/pilot/pkg/model"
 	serviceregistrymemory "istio.io/istio/pilot/pkg/serviceregistry/memory"
+	v3 "istio.io/istio/pilot/pkg/xds/v3"
+	"istio.io/istio/pkg/config/host"
+	"istio.io/istio/pkg/config/mesh"
 	"istio.io/istio/pkg/config/protocol"
+	"istio.io/istio/pkg/config/schema/collections"
+	"istio.io/istio/pkg/servicemesh/federation/common"
 	federationmodel "istio.io/istio/pkg/servicemesh/federation/model"
 )
 
 var (
 	ignoreChecksum = cmp.FilterPath(func(p cmp.Path) bool { return p.String() == "Checksum" }, cmp.Ignore())
+	ignoreEpoch    = cmp.FilterPath(func(p cmp.Path) bool { return p.String() == "Epoch" }, cmp.Ignore())
+	ignoreRevision = cmp.FilterPath(func(p cmp.Path) bool { return p.String() == "Revision" }, cmp.Ignore())
 )
 
 type fakeStatusHandler struct{}
@@ -54,6 +78,13 @@ func (m *fakeStatusHandler) FullSyncComplete() {
 func (m *fakeStatusHandler) WatchTerminated(status string) {
 }
 
+// Peer health
+func (m *fakeStatusHandler) PeerUnavailable(since time.Time, reason string, importedServices v1.ImportedServicesState) {
+}
+
+func (m *fakeStatusHandler) PeerAvailable() {
+}
+
 // Inbound connections
 func (m *fakeStatusHandler) RemoteWatchAccepted(source string) {
 }
@@ -437,7 +468,7 @@ func TestServiceList(t *testing.T) {
 			if tc.expectedMessage.Checksum != serviceList.GenerateChecksum() {
 				t.Errorf("checksums don't match")
 			}
-			if diff := cmp.Diff(serviceList, tc.expectedMessage); diff != "" {
+			if diff := cmp.Diff(serviceList, tc.expectedMessage, ignoreEpoch, ignoreRevision); diff != "" {
 				t.Fatalf("comparison failed, -got +want:\n%s", diff)
 			}
 		})
@@ -555,7 +586,8 @@ func TestWatch(t *testing.T) {
 			},
 			expectedWatchEvents: []*federationmodel.WatchEvent{
 				{
-					Action: federationmodel.ActionAdd,
+					Action:   federationmodel.ActionAdd,
+					Revision: 1,
 					Service: &federationmodel.ServiceMessage{
 						ServiceKey: federationmodel.ServiceKey{
 							Name:      "service",
@@ -572,7 +604,8 @@ func TestWatch(t *testing.T) {
 					},
 				},
 				{
-					Action: federationmodel.ActionDelete,
+					Action:   federationmodel.ActionDelete,
+					Revision: 2,
 					Service: &federationmodel.ServiceMessage{
 						ServiceKey: federationmodel.ServiceKey{
 							Name:      "service",
@@ -650,7 +683,8 @@ func TestWatch(t *testing.T) {
 			serviceEvents: nil,
 			expectedWatchEvents: []*federationmodel.WatchEvent{
 				{
-					Action: federationmodel.ActionDelete,
+					Action:   federationmodel.ActionDelete,
+					Revision: 2,
 					Service: &federationmodel.ServiceMessage{
 						ServiceKey: federationmodel.ServiceKey{
 							Name:      "service",
@@ -667,7 +701,8 @@ func TestWatch(t *testing.T) {
 					},
 				},
 				{
-					Action: federationmodel.ActionAdd,
+					Action:   federationmodel.ActionAdd,
+					Revision: 3,
 					Service: &federationmodel.ServiceMessage{
 						ServiceKey: federationmodel.ServiceKey{
 							Name:      "service",
@@ -723,8 +758,9 @@ func TestWatch(t *testing.T) {
 			},
 			expectedWatchEvents: []*federationmodel.WatchEvent{
 				{
-					Action:  federationmodel.ActionUpdate,
-					Service: nil,
+					Action:   federationmodel.ActionUpdate,
+					Revision: 2,
+					Service:  nil,
 				},
 			},
 		},
@@ -787,7 +823,7 @@ func TestWatch(t *testing.T) {
 					}
 					t.Fatal(err)
 				}
-				if diff := cmp.Diff(&e, tc.expectedWatchEvents[i], ignoreChecksum); diff != "" {
+				if diff := cmp.Diff(&e, tc.expectedWatchEvents[i], ignoreChecksum, ignoreEpoch); diff != "" {
 					t.Fatalf("comparison failed, -got +want:\n%s", diff)
 				}
 
@@ -817,3 +853,815 @@ func TestWatch(t *testing.T) {
 		})
 	}
 }
+
+func TestWatchResume(t *testing.T) {
+	federation := &v1.ServiceMeshPeer{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-remote",
+			Namespace: "istio-system-test",
+		},
+		Spec: v1.ServiceMeshPeerSpec{
+			Security: v1.ServiceMeshPeerSecurity{
+				ClientID: "federation-egress.other-mesh.svc.cluster.local",
+			},
+		},
+	}
+	exportAllServices := &v1.ExportedServiceSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-remote",
+			Namespace: "istio-system-test",
+		},
+		Spec: v1.ExportedServiceSetSpec{
+			ExportRules: []v1.ExportedServiceRule{
+				{
+					Type:         v1.NameSelectorType,
+					NameSelector: &v1.ServiceNameMapping{},
+				},
+			},
+		},
+	}
+	newService := func(name string) *model.Service {
+		return &model.Service{
+			Hostname: host.Name(name + ".bookinfo.svc.cluster.local"),
+			Attributes: model.ServiceAttributes{
+				Name:      name,
+				Namespace: "bookinfo",
+			},
+			Ports: model.PortList{
+				&model.Port{
+					Name:     "https",
+					Protocol: protocol.HTTPS,
+					Port:     443,
+				},
+			},
+		}
+	}
+	type expectedEvent struct {
+		revision uint64
+		resync   bool
+	}
+	testCases := []struct {
+		name             string
+		historySize      int
+		since            uint64
+		wrongEpoch       bool
+		expectedRevision []expectedEvent
+	}{
+		{
+			name:             "replay from history",
+			historySize:      10,
+			since:            1,
+			expectedRevision: []expectedEvent{{revision: 2}, {revision: 3}, {revision: 4}},
+		},
+		{
+			name:             "up to date",
+			historySize:      10,
+			since:            3,
+			expectedRevision: []expectedEvent{{revision: 4}},
+		},
+		{
+			name:             "history compacted",
+			historySize:      2,
+			since:            0,
+			expectedRevision: []expectedEvent{{revision: 3, resync: true}, {revision: 4}},
+		},
+		{
+			name:             "different epoch",
+			historySize:      10,
+			since:            1,
+			wrongEpoch:       true,
+			expectedRevision: []expectedEvent{{revision: 3, resync: true}, {revision: 4}},
+		},
+		{
+			name:             "unknown revision",
+			historySize:      10,
+			since:            5,
+			expectedRevision: []expectedEvent{{revision: 3, resync: true}, {revision: 4}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			serviceDiscovery := serviceregistrymemory.NewServiceDiscovery(nil)
+			env := &model.Environment{
+				ServiceDiscovery: serviceDiscovery,
+			}
+
+			s, _ := NewServer(Options{
+				BindAddress:      "127.0.0.1:0",
+				Env:              env,
+				Network:          "network1",
+				ConfigStore:      configmemory.NewController(configmemory.Make(Schemas)),
+				WatchHistorySize: tc.historySize,
+			})
+			stopCh := make(chan struct{})
+			go s.Run(stopCh)
+			defer close(stopCh)
+			s.resyncNetworkGateways()
+			s.AddPeer(federation, exportAllServices, &fakeStatusHandler{})
+			for _, name := range []string{"productpage", "ratings", "reviews"} {
+				s.UpdateService(newService(name), model.EventAdd)
+			}
+
+			serviceList := getServiceList(t, s.Addr(), "test-remote")
+			if serviceList.Revision != 3 {
+				t.Fatalf("unexpected revision for service list: expected 3, got %d", serviceList.Revision)
+			}
+			epoch := serviceList.Epoch
+			if tc.wrongEpoch {
+				epoch = "some-other-epoch"
+			}
+
+			req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/watch/test-remote?%s=%s&%s=%d", s.Addr(),
+				federationmodel.WatchParameterEpoch, epoch, federationmodel.WatchParameterSince, tc.since), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Status code is not OK: %v (%s)", resp.StatusCode, resp.Status)
+			}
+			go s.UpdateService(newService("details"), model.EventAdd)
+
+			dec := json.NewDecoder(resp.Body)
+			for _, expected := range tc.expectedRevision {
+				var e federationmodel.WatchEvent
+				if err := dec.Decode(&e); err != nil {
+					t.Fatal(err)
+				}
+				if e.Epoch != serviceList.Epoch {
+					t.Errorf("unexpected epoch: expected %s, got %s", serviceList.Epoch, e.Epoch)
+				}
+				if e.Revision != expected.revision {
+					t.Errorf("unexpected revision: expected %d, got %d", expected.revision, e.Revision)
+				}
+				if expected.resync != (e.Service == nil) {
+					t.Errorf("unexpected event: expected resync=%t, got %+v", expected.resync, e)
+				}
+			}
+		})
+	}
+}
+
+type fakeADSServer struct {
+	server *Server
+	nodes  chan *core.Node
+}
+
+func (a *fakeADSServer) StreamAggregatedResources(stream discovery.AggregatedDiscoveryService_StreamAggregatedResourcesServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	a.nodes <- req.Node
+	serviceList := a.server.ServiceListForClient(strings.Split(req.Node.Id, "~")[2])
+	if serviceList == nil {
+		return fmt.Errorf("no services for node %s", req.Node.Id)
+	}
+	resources, err := serviceList.ServiceResources()
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&discovery.DiscoveryResponse{
+		TypeUrl:   req.TypeUrl,
+		Resources: resources,
+		Nonce:     "1",
+	}); err != nil {
+		return err
+	}
+	// wait for ack
+	_, err = stream.Recv()
+	return err
+}
+
+func (a *fakeADSServer) DeltaAggregatedResources(stream discovery.AggregatedDiscoveryService_DeltaAggregatedResourcesServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	a.nodes <- req.Node
+	serviceList := a.server.ServiceListForClient(strings.Split(req.Node.Id, "~")[2])
+	if serviceList == nil {
+		return fmt.Errorf("no services for node %s", req.Node.Id)
+	}
+	resources, err := serviceList.ServiceResources()
+	if err != nil {
+		return err
+	}
+	resp := &discovery.DeltaDiscoveryResponse{
+		TypeUrl: req.TypeUrl,
+		Nonce:   "1",
+	}
+	for _, resource := range resources {
+		resp.Resources = append(resp.Resources, &discovery.Resource{Resource: resource})
+	}
+	if err := stream.Send(resp); err != nil {
+		return err
+	}
+	// wait for ack
+	_, err = stream.Recv()
+	return err
+}
+
+func TestDiscoveryStream(t *testing.T) {
+	federation := &v1.ServiceMeshPeer{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-remote",
+			Namespace: "istio-system-test",
+		},
+		Spec: v1.ServiceMeshPeerSpec{
+			Security: v1.ServiceMeshPeerSecurity{
+				ClientID: "federation-egress.other-mesh.svc.cluster.local",
+			},
+		},
+	}
+	exportAllServices := &v1.ExportedServiceSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-remote",
+			Namespace: "istio-system-test",
+		},
+		Spec: v1.ExportedServiceSetSpec{
+			ExportRules: []v1.ExportedServiceRule{
+				{
+					Type:         v1.NameSelectorType,
+					NameSelector: &v1.ServiceNameMapping{},
+				},
+			},
+		},
+	}
+	testCases := []struct {
+		name          string
+		clientCert    string
+		delta         bool
+		expectedCode  codes.Code
+		expectedHosts []string
+	}{
+		{
+			name:          "known mesh",
+			clientCert:    `Hash=abc;Subject="";URI=spiffe://federation-egress.other-mesh.svc.cluster.local`,
+			expectedCode:  codes.OK,
+			expectedHosts: []string{"productpage.bookinfo.svc.test-remote-exports.local"},
+		},
+		{
+			name:          "known mesh using delta",
+			clientCert:    `Hash=abc;Subject="";URI=spiffe://federation-egress.other-mesh.svc.cluster.local`,
+			delta:         true,
+			expectedCode:  codes.OK,
+			expectedHosts: []string{"productpage.bookinfo.svc.test-remote-exports.local"},
+		},
+		{
+			name: "forwarded by another proxy",
+			clientCert: `By=spiffe://cluster.local/ns/istio-system/sa/ingress;URI=spiffe://spoofed,` +
+				`Hash=abc;Subject="CN=a,O=b";URI=spiffe://federation-egress.other-mesh.svc.cluster.local`,
+			expectedCode:  codes.OK,
+			expectedHosts: []string{"productpage.bookinfo.svc.test-remote-exports.local"},
+		},
+		{
+			name:         "unknown mesh",
+			clientCert:   `Hash=abc;Subject="";URI=spiffe://federation-egress.unknown-mesh.svc.cluster.local`,
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name:         "no identity",
+			clientCert:   `Hash=abc;Subject=""`,
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name:         "no client certificate",
+			expectedCode: codes.PermissionDenied,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			serviceDiscovery := serviceregistrymemory.NewServiceDiscovery(nil)
+			env := &model.Environment{
+				ServiceDiscovery: serviceDiscovery,
+			}
+			ads := &fakeADSServer{nodes: make(chan *core.Node, 1)}
+			s, _ := NewServer(Options{
+				BindAddress: "127.0.0.1:0",
+				Env:         env,
+				Network:     "network1",
+				ConfigStore: configmemory.NewController(configmemory.Make(Schemas)),
+				ADSServer:   ads,
+			})
+			ads.server = s
+			stopCh := make(chan struct{})
+			go s.Run(stopCh)
+			defer close(stopCh)
+			s.resyncNetworkGateways()
+			s.AddPeer(federation, exportAllServices, &fakeStatusHandler{})
+			s.UpdateService(&model.Service{
+				Hostname: "productpage.bookinfo.svc.cluster.local",
+				Attributes: model.ServiceAttributes{
+					Name:      "productpage",
+					Namespace: "bookinfo",
+				},
+				Ports: model.PortList{
+					&model.Port{
+						Name:     "https",
+						Protocol: protocol.HTTPS,
+						Port:     443,
+					},
+				},
+			}, model.EventAdd)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if tc.clientCert != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, common.ForwardedClientCertHeader, tc.clientCert)
+			}
+			conn, err := grpc.DialContext(ctx, s.Addr(), grpc.WithInsecure())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			client := discovery.NewAggregatedDiscoveryServiceClient(conn)
+			node := &core.Node{
+				Id: "sidecar~10.0.0.1~spoofed.default~default.svc.cluster.local",
+				Metadata: &structpb.Struct{
+					Fields: map[string]*structpb.Value{
+						"ISTIO_VERSION": {Kind: &structpb.Value_StringValue{StringValue: "1.9.0"}},
+						"NAMESPACE":     {Kind: &structpb.Value_StringValue{StringValue: "default"}},
+					},
+				},
+			}
+			var resources []*golangany.Any
+			var ack func() error
+			if tc.delta {
+				stream, err := client.DeltaAggregatedResources(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := stream.Send(&discovery.DeltaDiscoveryRequest{TypeUrl: v3.FederationServiceType, Node: node}); err != nil {
+					t.Fatal(err)
+				}
+				resp, err := stream.Recv()
+				if status.Code(err) != tc.expectedCode {
+					t.Fatalf("unexpected error: expected code %s, got %v", tc.expectedCode, err)
+				}
+				if tc.expectedCode != codes.OK {
+					return
+				}
+				for _, resource := range resp.Resources {
+					resources = append(resources, resource.Resource)
+				}
+				ack = func() error {
+					return stream.Send(&discovery.DeltaDiscoveryRequest{TypeUrl: resp.TypeUrl, ResponseNonce: resp.Nonce})
+				}
+			} else {
+				stream, err := client.StreamAggregatedResources(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := stream.Send(&discovery.DiscoveryRequest{TypeUrl: v3.FederationServiceType, Node: node}); err != nil {
+					t.Fatal(err)
+				}
+				resp, err := stream.Recv()
+				if status.Code(err) != tc.expectedCode {
+					t.Fatalf("unexpected error: expected code %s, got %v", tc.expectedCode, err)
+				}
+				if tc.expectedCode != codes.OK {
+					return
+				}
+				resources = resp.Resources
+				ack = func() error {
+					return stream.Send(&discovery.DiscoveryRequest{TypeUrl: resp.TypeUrl, ResponseNonce: resp.Nonce})
+				}
+			}
+
+			node = <-ads.nodes
+			idParts := strings.Split(node.Id, "~")
+			if len(idParts) != 4 || idParts[0] != "federation" || idParts[1] != "0.0.0.0" ||
+				!strings.HasSuffix(idParts[2], ".istio-system-test") || idParts[3] != "istio-system-test.svc."+env.GetDomainSuffix() {
+				t.Errorf("unexpected node id: %s", node.Id)
+			}
+			if len(node.Metadata.Fields) != 1 || node.Metadata.Fields["ISTIO_VERSION"].GetStringValue() != "1.9.0" {
+				t.Errorf("unexpected node metadata: %v", node.Metadata)
+			}
+
+			services, err := federationmodel.ServicesFromResources(resources)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var hosts []string
+			for _, svc := range services {
+				hosts = append(hosts, svc.Hostname)
+			}
+			if diff := cmp.Diff(tc.expectedHosts, hosts); diff != "" {
+				t.Errorf("unexpected services: %s", diff)
+			}
+			if err := ack(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestEndpointSummaries(t *testing.T) {
+	federation := &v1.ServiceMeshPeer{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-remote",
+			Namespace: "istio-system-test",
+			Annotations: map[string]string{
+				common.ExportEndpointSummariesAnnotation: "true",
+			},
+		},
+		Spec: v1.ServiceMeshPeerSpec{
+			Security: v1.ServiceMeshPeerSecurity{
+				ClientID: "federation-egress.other-mesh.svc.cluster.local",
+			},
+		},
+	}
+	exportAllServices := &v1.ExportedServiceSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-remote",
+			Namespace: "istio-system-test",
+		},
+		Spec: v1.ExportedServiceSetSpec{
+			ExportRules: []v1.ExportedServiceRule{
+				{
+					Type:         v1.NameSelectorType,
+					NameSelector: &v1.ServiceNameMapping{},
+				},
+			},
+		},
+	}
+	svc := &model.Service{
+		Hostname: "productpage.bookinfo.svc.cluster.local",
+		Attributes: model.ServiceAttributes{
+			Name:      "productpage",
+			Namespace: "bookinfo",
+		},
+		Ports: model.PortList{
+			&model.Port{
+				Name:     "http",
+				Protocol: protocol.HTTP,
+				Port:     80,
+			},
+			&model.Port{
+				Name:     "grpc",
+				Protocol: protocol.GRPC,
+				Port:     9090,
+			},
+		},
+	}
+	addInstance := func(sd *serviceregistrymemory.ServiceDiscovery, port *model.Port, address, locality string, weight uint32) {
+		sd.AddInstance(svc.Hostname, &model.ServiceInstance{
+			ServicePort: port,
+			Endpoint: &model.IstioEndpoint{
+				Address:         address,
+				ServicePortName: port.Name,
+				EndpointPort:    uint32(port.Port),
+				Locality:        model.Locality{Label: locality},
+				LbWeight:        weight,
+			},
+		})
+	}
+
+	serviceDiscovery := serviceregistrymemory.NewServiceDiscovery([]*model.Service{svc})
+	// a workload listening on both ports is only counted once
+	addInstance(serviceDiscovery, svc.Ports[0], "10.0.0.1", "region1/zone1/subzone1", 0)
+	addInstance(serviceDiscovery, svc.Ports[1], "10.0.0.1", "region1/zone1/subzone1", 0)
+	addInstance(serviceDiscovery, svc.Ports[0], "10.0.0.2", "region1/zone1/subzone1", 0)
+	addInstance(serviceDiscovery, svc.Ports[0], "10.0.0.3", "region2/zone1/subzone1", 5)
+	env := &model.Environment{
+		ServiceDiscovery: serviceDiscovery,
+	}
+
+	s, _ := NewServer(Options{
+		BindAddress:           "127.0.0.1:0",
+		Env:                   env,
+		Network:               "network1",
+		ConfigStore:           configmemory.NewController(configmemory.Make(Schemas)),
+		EndpointRefreshPeriod: time.Hour,
+	})
+	stopCh := make(chan struct{})
+	go s.Run(stopCh)
+	defer close(stopCh)
+	s.resyncNetworkGateways()
+	s.AddPeer(federation, exportAllServices, &fakeStatusHandler{})
+
+	serviceList := getServiceList(t, s.Addr(), federation.Name)
+	if len(serviceList.Services) != 1 {
+		t.Fatalf("expected a single exported service, got %d", len(serviceList.Services))
+	}
+	expectedEndpoints := []*federationmodel.EndpointSummary{
+		{
+			Locality: "region1/zone1/subzone1",
+			Healthy:  2,
+			Weight:   2,
+		},
+		{
+			Locality: "region2/zone1/subzone1",
+			Healthy:  1,
+			Weight:   5,
+		},
+	}
+	if diff := cmp.Diff(serviceList.Services[0].Endpoints, expectedEndpoints); diff != "" {
+		t.Fatalf("comparison failed, -got +want:\n%s", diff)
+	}
+
+	// endpoint changes do not trigger service events, so they are picked up
+	// from the endpoint updates, well before the periodic refresh
+	addInstance(serviceDiscovery, svc.Ports[1], "10.0.0.4", "region2/zone1/subzone1", 0)
+	s.UpdateEndpoints(string(svc.Hostname), svc.Attributes.Namespace)
+	expectedEndpoints[1].Healthy = 2
+	expectedEndpoints[1].Weight = 6
+	serviceList = getServiceList(t, s.Addr(), federation.Name)
+	if diff := cmp.Diff(serviceList.Services[0].Endpoints, expectedEndpoints); diff != "" {
+		t.Fatalf("endpoint summaries were not updated, -got +want:\n%s", diff)
+	}
+}
+
+func TestTrustBundle(t *testing.T) {
+	federation := &v1.ServiceMeshPeer{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-remote",
+			Namespace: "istio-system-test",
+		},
+		Spec: v1.ServiceMeshPeerSpec{
+			Security: v1.ServiceMeshPeerSecurity{
+				ClientID: "federation-egress.other-mesh.svc.cluster.local",
+			},
+		},
+	}
+	testCases := []struct {
+		name         string
+		remoteName   string
+		rootCerts    func() []byte
+		expectedCode int
+	}{
+		{
+			name:         "roots",
+			remoteName:   "test-remote",
+			rootCerts:    func() []byte { return []byte("old-root-pem\nnew-root-pem\n") },
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "unknown mesh",
+			remoteName:   "unknown",
+			rootCerts:    func() []byte { return []byte("old-root-pem\n") },
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "not served",
+			remoteName:   "test-remote",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "no roots",
+			remoteName:   "test-remote",
+			rootCerts:    func() []byte { return nil },
+			expectedCode: http.StatusServiceUnavailable,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := &model.Environment{
+				ServiceDiscovery: serviceregistrymemory.NewServiceDiscovery(nil),
+				Watcher:          mesh.NewFixedWatcher(&meshconfig.MeshConfig{TrustDomain: "local.domain"}),
+			}
+			s, _ := NewServer(Options{
+				BindAddress: "127.0.0.1:0",
+				Env:         env,
+				Network:     "network1",
+				ConfigStore: configmemory.NewController(configmemory.Make(Schemas)),
+				RootCerts:   tc.rootCerts,
+			})
+			stopCh := make(chan struct{})
+			go s.Run(stopCh)
+			defer close(stopCh)
+			s.AddPeer(federation, nil, &fakeStatusHandler{})
+
+			resp, err := http.Get("http://" + s.Addr() + "/trustbundle/" + tc.remoteName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tc.expectedCode {
+				t.Fatalf("expected status code %d, got %d", tc.expectedCode, resp.StatusCode)
+			}
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+			trustBundle := federationmodel.TrustBundleMessage{}
+			if err := json.NewDecoder(resp.Body).Decode(&trustBundle); err != nil {
+				t.Fatal(err)
+			}
+			expected := federationmodel.TrustBundleMessage{
+				TrustDomain: "local.domain",
+				RootCerts:   string(tc.rootCerts()),
+			}
+			if diff := cmp.Diff(trustBundle, expected); diff != "" {
+				t.Errorf("comparison failed, -got +want:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExportAuthorization(t *testing.T) {
+	federation := &v1.ServiceMeshPeer{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-remote",
+			Namespace: "istio-system-test",
+		},
+		Spec: v1.ServiceMeshPeerSpec{
+			Gateways: v1.ServiceMeshPeerGateways{
+				Ingress: corev1.LocalObjectReference{
+					Name: "test-ingress",
+				},
+			},
+			Security: v1.ServiceMeshPeerSecurity{
+				ClientID: "other-mesh.local/ns/other-mesh/sa/egress-service-account",
+			},
+		},
+	}
+	exportsWithConsumers := func(rules ...v1.ExportedServiceRule) *v1.ExportedServiceSet {
+		return &v1.ExportedServiceSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-remote",
+				Namespace: "istio-system-test",
+			},
+			Spec: v1.ExportedServiceSetSpec{
+				ExportRules: append(rules, v1.ExportedServiceRule{
+					Type: v1.NameSelectorType,
+					NameSelector: &v1.ServiceNameMapping{
+						ServiceName: v1.ServiceName{
+							Namespace: "bookinfo",
+							Name:      v1.MatchAny,
+						},
+					},
+				}),
+			},
+		}
+	}
+	newService := func(name string) *model.Service {
+		return &model.Service{
+			Hostname: host.Name(name + ".bookinfo.svc.cluster.local"),
+			Attributes: model.ServiceAttributes{
+				Name:      name,
+				Namespace: "bookinfo",
+			},
+			Ports: model.PortList{
+				&model.Port{
+					Name:     "http",
+					Protocol: protocol.HTTP,
+					Port:     9080,
+				},
+			},
+		}
+	}
+	consumersRule := func(name string, principals ...string) v1.ExportedServiceRule {
+		return v1.ExportedServiceRule{
+			Type: v1.NameSelectorType,
+			NameSelector: &v1.ServiceNameMapping{
+				ServiceName: v1.ServiceName{
+					Namespace: "bookinfo",
+					Name:      name,
+				},
+			},
+			Consumers: &v1.ExportedServiceConsumers{
+				Principals: principals,
+			},
+		}
+	}
+	exportedHosts := func(name string) []string {
+		hostname := name + ".bookinfo.svc.test-remote-exports.local"
+		return []string{hostname, "*." + hostname}
+	}
+	// expectAllowed verifies the ALLOW policy of the ingress gateway, which
+	// allows the principals of each restricted service and any principal to
+	// call the other services.
+	expectAllowed := func(t *testing.T, s *Server, allowed map[string][]string, denied ...string) {
+		t.Helper()
+		cfg := s.configStore.Get(collections.IstioSecurityV1Beta1Authorizationpolicies.Resource().GroupVersionKind(),
+			consumersResourceName("test-remote"), "istio-system-test")
+		if len(allowed) == 0 && len(denied) == 0 {
+			if cfg != nil {
+				t.Fatalf("expected no AuthorizationPolicy for consumers, got %v", cfg.Spec)
+			}
+			return
+		}
+		if cfg == nil {
+			t.Fatalf("expected AuthorizationPolicy for consumers")
+		}
+		ap := cfg.Spec.(*rawsecurity.AuthorizationPolicy)
+		if ap.Action != rawsecurity.AuthorizationPolicy_ALLOW {
+			t.Fatalf("unexpected action in AuthorizationPolicy for consumers: %v", ap.Action)
+		}
+		if ap.Selector.MatchLabels["service.istio.io/canonical-name"] != "test-ingress" {
+			t.Errorf("unexpected selector in AuthorizationPolicy for consumers: %v", ap.Selector)
+		}
+		var names []string
+		for name := range allowed {
+			names = append(names, name)
+		}
+		names = append(names, denied...)
+		sort.Strings(names)
+		var expectedRules []*rawsecurity.Rule
+		var restrictedHosts []string
+		for _, name := range names {
+			restrictedHosts = append(restrictedHosts, exportedHosts(name)...)
+			if principals, ok := allowed[name]; ok {
+				// the gateway proxies TLS, so the policy must match the SNI, not HTTP hosts
+				expectedRules = append(expectedRules, &rawsecurity.Rule{
+					From: []*rawsecurity.Rule_From{{Source: &rawsecurity.Source{Principals: principals}}},
+					When: []*rawsecurity.Condition{{Key: "connection.sni", Values: exportedHosts(name)}},
+				})
+			}
+		}
+		expectedRules = append(expectedRules, &rawsecurity.Rule{
+			When: []*rawsecurity.Condition{{Key: "connection.sni", NotValues: restrictedHosts}},
+		})
+		if diff := cmp.Diff(ap.Rules, expectedRules, cmpopts.IgnoreUnexported(rawsecurity.Rule{}, rawsecurity.Rule_From{},
+			rawsecurity.Source{}, rawsecurity.Condition{})); diff != "" {
+			t.Errorf("unexpected rules in AuthorizationPolicy for consumers, -got +want:\n%s", diff)
+		}
+	}
+	expectConsumers := func(t *testing.T, s *Server, expected map[string][]string) {
+		t.Helper()
+		consumers := map[string][]string{}
+		for _, svc := range getServiceList(t, s.Addr(), "test-remote").Services {
+			consumers[svc.Name] = svc.Consumers
+		}
+		if diff := cmp.Diff(consumers, expected, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("unexpected consumers advertised, -got +want:\n%s", diff)
+		}
+	}
+
+	serviceDiscovery := serviceregistrymemory.NewServiceDiscovery([]*model.Service{
+		newService("ratings"), newService("details"), newService("productpage"),
+	})
+	env := &model.Environment{
+		ServiceDiscovery: serviceDiscovery,
+	}
+	s, _ := NewServer(Options{
+		BindAddress: "127.0.0.1:0",
+		Env:         env,
+		Network:     "network1",
+		ConfigStore: configmemory.NewController(configmemory.Make(Schemas)),
+	})
+	stopCh := make(chan struct{})
+	go s.Run(stopCh)
+	defer close(stopCh)
+	s.AddPeer(federation, exportsWithConsumers(consumersRule("ratings", "other-mesh.local/ns/bookinfo/sa/reviews"),
+		consumersRule("details", "other-mesh.local/ns/bookinfo/sa/productpage")), &fakeStatusHandler{})
+
+	// each export advertises its own consumers, which are enforced by both meshes
+	expectConsumers(t, s, map[string][]string{
+		"ratings":     {"other-mesh.local/ns/bookinfo/sa/reviews"},
+		"details":     {"other-mesh.local/ns/bookinfo/sa/productpage"},
+		"productpage": nil,
+	})
+	expectAllowed(t, s, map[string][]string{
+		"ratings": {"other-mesh.local/ns/bookinfo/sa/reviews"},
+		"details": {"other-mesh.local/ns/bookinfo/sa/productpage"},
+	})
+
+	// the allowed consumers are updated without affecting other exports
+	s.UpdateExportsForMesh(exportsWithConsumers(consumersRule("ratings",
+		"other-mesh.local/ns/bookinfo/sa/reviews", "other-mesh.local/ns/bookinfo/sa/productpage"),
+		consumersRule("details", "other-mesh.local/ns/bookinfo/sa/productpage")))
+	expectConsumers(t, s, map[string][]string{
+		"ratings":     {"other-mesh.local/ns/bookinfo/sa/reviews", "other-mesh.local/ns/bookinfo/sa/productpage"},
+		"details":     {"other-mesh.local/ns/bookinfo/sa/productpage"},
+		"productpage": nil,
+	})
+	expectAllowed(t, s, map[string][]string{
+		"ratings": {"other-mesh.local/ns/bookinfo/sa/reviews", "other-mesh.local/ns/bookinfo/sa/productpage"},
+		"details": {"other-mesh.local/ns/bookinfo/sa/productpage"},
+	})
+
+	// no principals denies all consumers of that service only
+	s.UpdateExportsForMesh(exportsWithConsumers(consumersRule("ratings"),
+		consumersRule("details", "other-mesh.local/ns/bookinfo/sa/productpage")))
+	expectConsumers(t, s, map[string][]string{
+		"ratings":     nil,
+		"details":     {"other-mesh.local/ns/bookinfo/sa/productpage"},
+		"productpage": nil,
+	})
+	expectAllowed(t, s, map[string][]string{
+		"details": {"other-mesh.local/ns/bookinfo/sa/productpage"},
+	}, "ratings")
+
+	// services are exported without restrictions
+	s.UpdateExportsForMesh(exportsWithConsumers())
+	expectConsumers(t, s, map[string][]string{
+		"ratings":     nil,
+		"details":     nil,
+		"productpage": nil,
+	})
+	expectAllowed(t, s, nil)
+
+	// invalid principals don't export anything
+	s.UpdateExportsForMesh(exportsWithConsumers(consumersRule("ratings", "")))
+	reviews := newService("reviews")
+	serviceDiscovery.AddService(reviews.Hostname, reviews)
+	s.UpdateService(reviews, model.EventAdd)
+	if services := getServiceList(t, s.Addr(), "test-remote").Services; len(services) != 3 {
+		t.Errorf("expected 3 exported services, got %v", services)
+	}
+}
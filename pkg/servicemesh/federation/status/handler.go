@@ -162,6 +162,52 @@ func (h *handler) WatchTerminated(status string) {
 	}
 }
 
+// Peer health
+func (h *handler) PeerUnavailable(since time.Time, reason string, importedServices v1.ImportedServicesState) {
+	h.logger.Debugf("%s.PeerUnavailable(%s, %s, %s)", h.mesh, since, reason, importedServices)
+
+	func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		h.discoveryStatus.Health = &v1.PeerHealthStatus{
+			Available:        false,
+			UnavailableSince: metav1.NewTime(since),
+			Reason:           reason,
+			ImportedServices: importedServices,
+		}
+
+		h.watchDirty = true
+	}()
+
+	if err := h.Flush(); err != nil {
+		h.logger.Errorf("error updating status for ServiceMeshPeer %s: %s", h.mesh, err)
+	}
+}
+
+func (h *handler) PeerAvailable() {
+	h.logger.Debugf("%s.PeerAvailable()", h.mesh)
+
+	func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if h.discoveryStatus.Health != nil && h.discoveryStatus.Health.Available {
+			return
+		}
+		h.discoveryStatus.Health = &v1.PeerHealthStatus{
+			Available:        true,
+			ImportedServices: v1.ImportedServicesAvailable,
+		}
+
+		h.watchDirty = true
+	}()
+
+	if err := h.Flush(); err != nil {
+		h.logger.Errorf("error updating status for ServiceMeshPeer %s: %s", h.mesh, err)
+	}
+}
+
 // Inbound connections
 func (h *handler) RemoteWatchAccepted(source string) {
 	h.logger.Debugf("%s.RemoteWatchAccepted(%s)", h.mesh, source)
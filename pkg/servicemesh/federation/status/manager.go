@@ -16,6 +16,7 @@ package status
 
 import (
 	"sync"
+	"time"
 
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/errors"
@@ -63,6 +64,10 @@ type Handler interface {
 	FullSyncComplete()
 	WatchTerminated(status string)
 
+	// Peer health
+	PeerUnavailable(since time.Time, reason string, importedServices v1.ImportedServicesState)
+	PeerAvailable()
+
 	// Inbound connections
 	RemoteWatchAccepted(source string)
 	WatchEventSent(source string)
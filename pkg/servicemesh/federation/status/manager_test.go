@@ -38,7 +38,7 @@ import (
 var (
 	ignoreTimestamps = cmp.FilterPath(func(p cmp.Path) bool {
 		switch p.Last().String() {
-		case ".LastConnected", ".LastDisconnect", ".LastEvent", ".LastFullSync":
+		case ".LastConnected", ".LastDisconnect", ".LastEvent", ".LastFullSync", ".UnavailableSince":
 			return true
 		}
 		return false
@@ -224,6 +224,99 @@ func TestStatusManager(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "watch-peer-unavailable",
+			mesh: types.NamespacedName{Namespace: namespace, Name: name},
+			events: []func(h Handler){
+				func(h Handler) {
+					h.WatchInitiated()
+					h.WatchTerminated("connection refused")
+				},
+				func(h Handler) {
+					h.PeerUnavailable(time.Now(), "connection refused", v1.ImportedServicesWithdrawn)
+				},
+				func(h Handler) {
+					h.PeerAvailable()
+				},
+			},
+			assertions: []func(t *testing.T, status *v1.ServiceMeshPeerStatus){
+				nil,
+				nil,
+				nil,
+			},
+			status: []struct {
+				peer    v1.ServiceMeshPeerStatus
+				exports v1.ExportedServiceSetStatus
+				imports v1.ImportedServiceSetStatus
+			}{
+				{
+					peer: v1.ServiceMeshPeerStatus{
+						DiscoveryStatus: v1.ServiceMeshPeerDiscoveryStatus{
+							Inactive: []v1.PodPeerDiscoveryStatus{
+								{
+									Pod: istiodName.Name,
+									PeerDiscoveryStatus: v1.PeerDiscoveryStatus{
+										Watch: v1.DiscoveryWatchStatus{
+											DiscoveryConnectionStatus: v1.DiscoveryConnectionStatus{
+												Connected:            false,
+												LastDisconnectStatus: "connection refused",
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+				{
+					peer: v1.ServiceMeshPeerStatus{
+						DiscoveryStatus: v1.ServiceMeshPeerDiscoveryStatus{
+							Inactive: []v1.PodPeerDiscoveryStatus{
+								{
+									Pod: istiodName.Name,
+									PeerDiscoveryStatus: v1.PeerDiscoveryStatus{
+										Watch: v1.DiscoveryWatchStatus{
+											DiscoveryConnectionStatus: v1.DiscoveryConnectionStatus{
+												Connected:            false,
+												LastDisconnectStatus: "connection refused",
+											},
+										},
+										Health: &v1.PeerHealthStatus{
+											Available:        false,
+											Reason:           "connection refused",
+											ImportedServices: v1.ImportedServicesWithdrawn,
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+				{
+					peer: v1.ServiceMeshPeerStatus{
+						DiscoveryStatus: v1.ServiceMeshPeerDiscoveryStatus{
+							Inactive: []v1.PodPeerDiscoveryStatus{
+								{
+									Pod: istiodName.Name,
+									PeerDiscoveryStatus: v1.PeerDiscoveryStatus{
+										Watch: v1.DiscoveryWatchStatus{
+											DiscoveryConnectionStatus: v1.DiscoveryConnectionStatus{
+												Connected:            false,
+												LastDisconnectStatus: "connection refused",
+											},
+										},
+										Health: &v1.PeerHealthStatus{
+											Available:        true,
+											ImportedServices: v1.ImportedServicesAvailable,
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
 		{
 			name: "watch-remote",
 			mesh: types.NamespacedName{Namespace: namespace, Name: name},
@@ -15,14 +15,86 @@
 package model
 
 import (
+	"fmt"
+
 	v1 "maistra.io/api/core/v1"
 
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pkg/config"
 	"istio.io/istio/pkg/config/labels"
+	"istio.io/istio/pkg/util/gogoprotomarshal"
+)
+
+// ServiceMeshExtensionGVK is used to identify push requests triggered by
+// changes to the ServiceMeshExtensions applied to proxies, i.e. extensions
+// being added, removed, reordered or retargeted, which require listeners to
+// be updated.
+var ServiceMeshExtensionGVK = config.GroupVersionKind{
+	Group:   "maistra.io",
+	Version: "v1",
+	Kind:    "ServiceMeshExtension",
+}
+
+// ServiceMeshExtensionConfigGVK is used to identify push requests triggered
+// by changes that only affect the filter configuration of an extension, e.g.
+// its configuration or module, which are delivered over ECDS without
+// updating listeners.
+var ServiceMeshExtensionConfigGVK = config.GroupVersionKind{
+	Group:   "maistra.io",
+	Version: "v1",
+	Kind:    "ServiceMeshExtensionConfig",
+}
+
+// FilterTypeAnnotation selects the type of filter an extension is deployed
+// as.  Extensions are HTTP filters, unless the annotation is set to network.
+const FilterTypeAnnotation = "extensions.maistra.io/filter-type"
+
+// MatchAnnotation restricts the traffic an extension is applied to.  Its
+// value is the match of an EnvoyFilter patch, in YAML or JSON, e.g.
+//
+//	context: SIDECAR_OUTBOUND
+//	listener:
+//	  portNumber: 443
+//	  filterChain:
+//	    sni: api.example.com
+//
+// which supports the same context, listener and filter chain conditions.
+// Without it, extensions are applied to inbound traffic of sidecars and all
+// traffic of gateways.
+const MatchAnnotation = "extensions.maistra.io/match"
+
+// FailurePolicyAnnotation selects how traffic is handled when the module of
+// an extension can't be loaded, or its VM fails: FailClose rejects the
+// traffic, which is the default, while FailOpen lets it bypass the extension.
+const FailurePolicyAnnotation = "extensions.maistra.io/failure-policy"
+
+// FailurePolicy is the behaviour of an extension that failed
+type FailurePolicy string
+
+const (
+	FailurePolicyFailClose FailurePolicy = "FailClose"
+	FailurePolicyFailOpen  FailurePolicy = "FailOpen"
+)
+
+// FilterType is the type of Envoy filter an extension is deployed as
+type FilterType string
+
+const (
+	// FilterTypeHTTP extensions are inserted into the HTTP filters of
+	// http_connection_manager
+	FilterTypeHTTP FilterType = "http"
+	// FilterTypeNetwork extensions are inserted into the network filters of
+	// filter chains proxying TCP, e.g. before tcp_proxy or mongo_proxy
+	FilterTypeNetwork FilterType = "network"
 )
 
 // ExtensionWrapper is a wrapper around extensions
 type ExtensionWrapper struct {
 	Name             string
+	Namespace        string
+	FilterType       FilterType
+	Match            *networking.EnvoyFilter_EnvoyConfigObjectMatch
+	FailurePolicy    FailurePolicy
 	WorkloadSelector labels.Instance
 	Config           *v1.ServiceMeshExtensionConfig
 	Image            string
@@ -30,11 +102,36 @@ type ExtensionWrapper struct {
 	SHA256           string
 	Phase            v1.FilterPhase
 	Priority         int
+	// Rollout is set while a new module is being rolled out to a subset of
+	// the proxies
+	Rollout *Rollout
+}
+
+// ResourceName returns the name of the extension configuration resource
+// holding the filter of the extension, which is also the name of the filter.
+func (w *ExtensionWrapper) ResourceName() string {
+	return fmt.Sprintf("maistra.io/extension/%s/%s", w.Namespace, w.Name)
 }
 
-func ToWrapper(extension *v1.ServiceMeshExtension) *ExtensionWrapper {
+func ToWrapper(extension *v1.ServiceMeshExtension) (*ExtensionWrapper, error) {
+	match, err := parseMatch(extension)
+	if err != nil {
+		return nil, err
+	}
+	failurePolicy, err := parseFailurePolicy(extension)
+	if err != nil {
+		return nil, err
+	}
+	rollout, err := parseRollout(extension)
+	if err != nil {
+		return nil, err
+	}
 	return &ExtensionWrapper{
 		Name:             extension.Name,
+		Namespace:        extension.Namespace,
+		FilterType:       filterType(extension),
+		Match:            match,
+		FailurePolicy:    failurePolicy,
 		WorkloadSelector: extension.Spec.WorkloadSelector.Labels,
 		Config:           extension.Spec.Config.DeepCopy(),
 		Image:            extension.Spec.Image,
@@ -42,5 +139,61 @@ func ToWrapper(extension *v1.ServiceMeshExtension) *ExtensionWrapper {
 		SHA256:           extension.Status.Deployment.SHA256,
 		Phase:            extension.Status.Phase,
 		Priority:         extension.Status.Priority,
+		Rollout:          rollout,
+	}, nil
+}
+
+func parseMatch(extension *v1.ServiceMeshExtension) (*networking.EnvoyFilter_EnvoyConfigObjectMatch, error) {
+	value, ok := extension.Annotations[MatchAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	match := &networking.EnvoyFilter_EnvoyConfigObjectMatch{}
+	if err := gogoprotomarshal.ApplyYAMLStrict(value, match); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", MatchAnnotation, err)
+	}
+	if match.GetRouteConfiguration() != nil || match.GetCluster() != nil {
+		return nil, fmt.Errorf("invalid %s annotation: only listeners can be matched", MatchAnnotation)
+	}
+	if match.GetListener().GetFilterChain().GetFilter() != nil {
+		return nil, fmt.Errorf("invalid %s annotation: filters can't be matched, extensions are placed by phase", MatchAnnotation)
+	}
+	return match, nil
+}
+
+func parseFailurePolicy(extension *v1.ServiceMeshExtension) (FailurePolicy, error) {
+	switch policy := FailurePolicy(extension.Annotations[FailurePolicyAnnotation]); policy {
+	case "", FailurePolicyFailClose:
+		return FailurePolicyFailClose, nil
+	case FailurePolicyFailOpen:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid %s annotation: %q is neither %s nor %s",
+			FailurePolicyAnnotation, policy, FailurePolicyFailClose, FailurePolicyFailOpen)
+	}
+}
+
+func filterType(extension *v1.ServiceMeshExtension) FilterType {
+	if FilterType(extension.Annotations[FilterTypeAnnotation]) == FilterTypeNetwork {
+		return FilterTypeNetwork
+	}
+	return FilterTypeHTTP
+}
+
+// ExtensionUpdateGVK returns the kind identifying the push request for an
+// update of an extension.  Changes to the filter chain position, the
+// traffic or workloads matched, or the readiness of the extension require listeners to be
+// updated, while any other change only affects its filter configuration.
+// Network filters are always updated through listeners, as Envoy doesn't
+// support discovering their configuration.
+func ExtensionUpdateGVK(oldExtension, newExtension *v1.ServiceMeshExtension) config.GroupVersionKind {
+	if filterType(oldExtension) == FilterTypeNetwork || filterType(newExtension) == FilterTypeNetwork ||
+		oldExtension.Annotations[MatchAnnotation] != newExtension.Annotations[MatchAnnotation] ||
+		oldExtension.Status.Phase != newExtension.Status.Phase ||
+		oldExtension.Status.Priority != newExtension.Status.Priority ||
+		oldExtension.Status.Deployment.Ready != newExtension.Status.Deployment.Ready ||
+		!labels.Instance(oldExtension.Spec.WorkloadSelector.Labels).Equals(newExtension.Spec.WorkloadSelector.Labels) {
+		return ServiceMeshExtensionGVK
 	}
+	return ServiceMeshExtensionConfigGVK
 }
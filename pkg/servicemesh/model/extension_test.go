@@ -0,0 +1,167 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"strings"
+	"testing"
+
+	v1 "maistra.io/api/core/v1"
+)
+
+func TestExtensionUpdateGVK(t *testing.T) {
+	base := &v1.ServiceMeshExtension{
+		Spec: v1.ServiceMeshExtensionSpec{
+			Image:            "quay.io/maistra/example:1.0",
+			WorkloadSelector: v1.WorkloadSelector{Labels: map[string]string{"app": "productpage"}},
+		},
+		Status: v1.ServiceMeshExtensionStatus{
+			Phase:    v1.FilterPhasePostAuthZ,
+			Priority: 10,
+			Deployment: v1.DeploymentStatus{
+				Ready:  true,
+				SHA256: "abc",
+			},
+		},
+	}
+	testCases := []struct {
+		name     string
+		update   func(*v1.ServiceMeshExtension)
+		expected string
+	}{
+		{
+			name: "module",
+			update: func(e *v1.ServiceMeshExtension) {
+				e.Spec.Image = "quay.io/maistra/example:1.1"
+				e.Status.Deployment.SHA256 = "def"
+			},
+			expected: ServiceMeshExtensionConfigGVK.Kind,
+		},
+		{
+			name:     "phase",
+			update:   func(e *v1.ServiceMeshExtension) { e.Status.Phase = v1.FilterPhasePreAuthN },
+			expected: ServiceMeshExtensionGVK.Kind,
+		},
+		{
+			name:     "priority",
+			update:   func(e *v1.ServiceMeshExtension) { e.Status.Priority = 20 },
+			expected: ServiceMeshExtensionGVK.Kind,
+		},
+		{
+			name:     "readiness",
+			update:   func(e *v1.ServiceMeshExtension) { e.Status.Deployment.Ready = false },
+			expected: ServiceMeshExtensionGVK.Kind,
+		},
+		{
+			name:     "workload selector",
+			update:   func(e *v1.ServiceMeshExtension) { e.Spec.WorkloadSelector.Labels = map[string]string{"app": "reviews"} },
+			expected: ServiceMeshExtensionGVK.Kind,
+		},
+		{
+			// network filters are inlined into listeners
+			name:     "network filter",
+			update:   func(e *v1.ServiceMeshExtension) { e.Annotations = map[string]string{FilterTypeAnnotation: "network"} },
+			expected: ServiceMeshExtensionGVK.Kind,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			updated := base.DeepCopy()
+			tc.update(updated)
+			if kind := ExtensionUpdateGVK(base, updated).Kind; kind != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, kind)
+			}
+		})
+	}
+}
+
+func TestToWrapperMatch(t *testing.T) {
+	testCases := []struct {
+		name          string
+		match         string
+		expectedError string
+	}{
+		{
+			name:  "outbound port",
+			match: `{"context": "SIDECAR_OUTBOUND", "listener": {"portNumber": 443, "filterChain": {"sni": "api.example.com"}}}`,
+		},
+		{
+			name:          "cluster",
+			match:         `{"cluster": {"service": "api.example.com"}}`,
+			expectedError: "only listeners can be matched",
+		},
+		{
+			name:          "filter",
+			match:         `{"listener": {"filterChain": {"filter": {"name": "envoy.filters.network.tcp_proxy"}}}}`,
+			expectedError: "filters can't be matched",
+		},
+		{
+			name:          "unknown field",
+			match:         `{"listener": {"port": 443}}`,
+			expectedError: "invalid",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			extension := &v1.ServiceMeshExtension{}
+			extension.Annotations = map[string]string{MatchAnnotation: tc.match}
+			wrapper, err := ToWrapper(extension)
+			if tc.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if wrapper.Match.GetListener().GetFilterChain().GetSni() != "api.example.com" {
+				t.Errorf("expected match to be parsed, got %v", wrapper.Match)
+			}
+		})
+	}
+}
+
+func TestToWrapperFailurePolicy(t *testing.T) {
+	testCases := []struct {
+		annotation string
+		expected   FailurePolicy
+		valid      bool
+	}{
+		{annotation: "", expected: FailurePolicyFailClose, valid: true},
+		{annotation: "FailClose", expected: FailurePolicyFailClose, valid: true},
+		{annotation: "FailOpen", expected: FailurePolicyFailOpen, valid: true},
+		{annotation: "Ignore"},
+	}
+	for _, tc := range testCases {
+		extension := &v1.ServiceMeshExtension{}
+		if tc.annotation != "" {
+			extension.Annotations = map[string]string{FailurePolicyAnnotation: tc.annotation}
+		}
+		wrapper, err := ToWrapper(extension)
+		if !tc.valid {
+			if err == nil {
+				t.Errorf("expected failure policy %q to be rejected", tc.annotation)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if wrapper.FailurePolicy != tc.expected {
+			t.Errorf("expected failure policy %s for %q, got %s", tc.expected, tc.annotation, wrapper.FailurePolicy)
+		}
+	}
+}
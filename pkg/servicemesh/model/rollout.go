@@ -0,0 +1,142 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	v1 "maistra.io/api/core/v1"
+)
+
+// RolloutPercentageAnnotation stages the rollout of new modules of an
+// extension.  When the module of an extension with this annotation changes,
+// e.g. because its image was updated, only the given percentage of the
+// proxies it applies to receive the new module, while the others keep the
+// module it replaces.  The rollout completes once the annotation is raised to
+// 100 or removed.
+const RolloutPercentageAnnotation = "extensions.maistra.io/rollout-percentage"
+
+// StableModuleAnnotation records the module replaced by the one being rolled
+// out, in JSON.  It's maintained by MEC, which keeps serving the module until
+// the rollout completes.
+const StableModuleAnnotation = "extensions.maistra.io/stable-module"
+
+// RolloutHaltedAnnotation halts a rollout, sending the stable module to all
+// proxies.  istiod sets it to the error reported by a proxy that rejected the
+// new module, if distribution status is enabled.  Removing it resumes the
+// rollout, and MEC removes it when the module changes again.
+const RolloutHaltedAnnotation = "extensions.maistra.io/rollout-halted"
+
+// Module identifies a wasm module served by MEC
+type Module struct {
+	URL             string `json:"url"`
+	SHA256          string `json:"sha256"`
+	ContainerSHA256 string `json:"containerSHA256,omitempty"`
+}
+
+// Rollout is the state of the staged rollout of a new module
+type Rollout struct {
+	// Percentage of the proxies receiving the new module
+	Percentage int
+	// Stable is the module received by the other proxies
+	Stable Module
+	// Halted is the reason the rollout was halted, if it was
+	Halted string
+}
+
+// RolloutPercentage returns the percentage of the proxies that new modules
+// of the extension are sent to.
+func RolloutPercentage(extension *v1.ServiceMeshExtension) (int, error) {
+	value, ok := extension.Annotations[RolloutPercentageAnnotation]
+	if !ok {
+		return 100, nil
+	}
+	percentage, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(value), "%"))
+	if err != nil || percentage < 0 || percentage > 100 {
+		return 0, fmt.Errorf("invalid %s annotation: %q is not a percentage", RolloutPercentageAnnotation, value)
+	}
+	return percentage, nil
+}
+
+// StableModule returns the module recorded in the StableModuleAnnotation of
+// the extension, or nil if there is none.
+func StableModule(extension *v1.ServiceMeshExtension) (*Module, error) {
+	value, ok := extension.Annotations[StableModuleAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	module := &Module{}
+	if err := json.Unmarshal([]byte(value), module); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", StableModuleAnnotation, err)
+	}
+	if module.URL == "" || module.SHA256 == "" {
+		return nil, fmt.Errorf("invalid %s annotation: url and sha256 are required", StableModuleAnnotation)
+	}
+	return module, nil
+}
+
+func parseRollout(extension *v1.ServiceMeshExtension) (*Rollout, error) {
+	percentage, err := RolloutPercentage(extension)
+	if err != nil {
+		return nil, err
+	}
+	stable, err := StableModule(extension)
+	if err != nil {
+		return nil, err
+	}
+	deployment := extension.Status.Deployment
+	if stable == nil || !deployment.Ready || stable.SHA256 == deployment.SHA256 {
+		return nil, nil
+	}
+	return &Rollout{
+		Percentage: percentage,
+		Stable:     *stable,
+		Halted:     extension.Annotations[RolloutHaltedAnnotation],
+	}, nil
+}
+
+// Canary returns whether the proxy receives the module being rolled out, if
+// there is one.  Proxies are selected by the hash of their ID, so raising the
+// percentage only adds proxies.  The module is part of the hash, so each
+// rollout starts with different proxies.
+func (w *ExtensionWrapper) Canary(proxyID string) bool {
+	if w.Rollout == nil {
+		return true
+	}
+	if w.Rollout.Halted != "" {
+		return false
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(w.Namespace + "/" + w.Name + "/" + w.SHA256 + "/" + proxyID))
+	return int(h.Sum32()%100) < w.Rollout.Percentage
+}
+
+// ForProxy returns the extension with the module received by the proxy.
+// Proxies keeping the stable module aren't part of the rollout, so the
+// extension returned for them has none.
+func (w *ExtensionWrapper) ForProxy(proxyID string) *ExtensionWrapper {
+	if w.Canary(proxyID) {
+		return w
+	}
+	out := *w
+	out.FilterURL = w.Rollout.Stable.URL
+	out.SHA256 = w.Rollout.Stable.SHA256
+	out.Rollout = nil
+	return &out
+}
@@ -0,0 +1,111 @@
+// Copyright Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"fmt"
+	"testing"
+
+	v1 "maistra.io/api/core/v1"
+)
+
+func newRolloutExtension(annotations map[string]string) *v1.ServiceMeshExtension {
+	extension := &v1.ServiceMeshExtension{}
+	extension.Name = "example"
+	extension.Namespace = "default"
+	extension.Annotations = map[string]string{
+		StableModuleAnnotation: `{"url": "http://mec.istio-system.svc.cluster.local/stable", "sha256": "stable"}`,
+	}
+	for name, value := range annotations {
+		extension.Annotations[name] = value
+	}
+	extension.Status.Deployment = v1.DeploymentStatus{
+		Ready:  true,
+		URL:    "http://mec.istio-system.svc.cluster.local/new",
+		SHA256: "new",
+	}
+	return extension
+}
+
+func canaries(t *testing.T, extension *v1.ServiceMeshExtension) map[string]bool {
+	t.Helper()
+	wrapper, err := ToWrapper(extension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		proxyID := fmt.Sprintf("productpage-%d.default", i)
+		forProxy := wrapper.ForProxy(proxyID)
+		if forProxy.SHA256 == "new" {
+			out[proxyID] = true
+		} else if forProxy.SHA256 != "stable" || forProxy.FilterURL != "http://mec.istio-system.svc.cluster.local/stable" || forProxy.Rollout != nil {
+			t.Fatalf("expected proxy %s to receive the stable module, got %+v", proxyID, forProxy)
+		}
+	}
+	return out
+}
+
+func TestExtensionRollout(t *testing.T) {
+	if got := len(canaries(t, newRolloutExtension(nil))); got != 1000 {
+		t.Errorf("expected all proxies to receive the new module once the rollout completes, got %d", got)
+	}
+	if got := len(canaries(t, newRolloutExtension(map[string]string{RolloutPercentageAnnotation: "0"}))); got != 0 {
+		t.Errorf("expected no proxy to receive the new module at 0%%, got %d", got)
+	}
+
+	ten := canaries(t, newRolloutExtension(map[string]string{RolloutPercentageAnnotation: "10"}))
+	if len(ten) < 50 || len(ten) > 150 {
+		t.Errorf("expected about 100 proxies to receive the new module at 10%%, got %d", len(ten))
+	}
+	fifty := canaries(t, newRolloutExtension(map[string]string{RolloutPercentageAnnotation: "50%"}))
+	for proxyID := range ten {
+		if !fifty[proxyID] {
+			t.Errorf("expected proxy %s to keep the new module when raising the percentage", proxyID)
+		}
+	}
+
+	halted := newRolloutExtension(map[string]string{RolloutPercentageAnnotation: "50", RolloutHaltedAnnotation: "failed to load"})
+	if got := len(canaries(t, halted)); got != 0 {
+		t.Errorf("expected no proxy to receive the new module once the rollout is halted, got %d", got)
+	}
+
+	// the stable module has been rolled back to
+	restored := newRolloutExtension(map[string]string{RolloutPercentageAnnotation: "10"})
+	restored.Status.Deployment.SHA256 = "stable"
+	if wrapper, err := ToWrapper(restored); err != nil || wrapper.Rollout != nil {
+		t.Errorf("expected no rollout when the stable module is deployed, got %v, %v", wrapper, err)
+	}
+}
+
+func TestRolloutPercentage(t *testing.T) {
+	for value, expected := range map[string]int{"0": 0, "25": 25, " 25% ": 25, "100": 100} {
+		extension := &v1.ServiceMeshExtension{}
+		extension.Annotations = map[string]string{RolloutPercentageAnnotation: value}
+		if percentage, err := RolloutPercentage(extension); err != nil || percentage != expected {
+			t.Errorf("expected %q to be parsed as %d, got %d, %v", value, expected, percentage, err)
+		}
+	}
+	for _, value := range []string{"-1", "101", "half"} {
+		extension := &v1.ServiceMeshExtension{}
+		extension.Annotations = map[string]string{RolloutPercentageAnnotation: value}
+		if _, err := RolloutPercentage(extension); err == nil {
+			t.Errorf("expected %q to be rejected", value)
+		}
+	}
+	if percentage, err := RolloutPercentage(&v1.ServiceMeshExtension{}); err != nil || percentage != 100 {
+		t.Errorf("expected modules to be sent to all proxies by default, got %d, %v", percentage, err)
+	}
+}